@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// The gRPC metadata key carrying the Basic Auth credentials.
+const AuthorizationMetadataKey = "authorization"
+
+// Per-RPC credentials that send a username and password with every call to the
+// gRPC EventService, the same credentials the HTTP API accepts via Basic Auth.
+type basicAuth struct {
+	username string
+	password string
+
+	requireTLS bool
+}
+
+// Returns per-RPC credentials for the gRPC EventService, to be passed to
+// grpc.WithPerRPCCredentials. The credentials are sent in plain text, so
+// they're only sent over TLS connections unless allowInsecure is true, e.g.
+// between services on a private network.
+func BasicAuth(username, password string, allowInsecure bool) credentials.PerRPCCredentials {
+	return basicAuth{username: username, password: password, requireTLS: !allowInsecure}
+}
+
+func (a basicAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token := base64.StdEncoding.EncodeToString([]byte(a.username + ":" + a.password))
+
+	return map[string]string{AuthorizationMetadataKey: "Basic " + token}, nil
+}
+
+func (a basicAuth) RequireTransportSecurity() bool {
+	return a.requireTLS
+}
@@ -1,16 +1,92 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/4lch4/shion-api/internal/config"
 	"github.com/4lch4/shion-api/internal/server"
 )
 
 func main() {
-	server := server.NewServer()
+	check := flag.Bool("check", false, "validate the configuration and exit without serving")
+	flag.Parse()
 
-	err := server.ListenAndServe()
+	// Every invalid setting is reported at once so they can all be fixed
+	// before trying again.
+	cfg, err := config.Load()
 	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+
+	if *check {
+		fmt.Println("Configuration is valid")
+		return
+	}
+
+	server := server.NewServer(cfg)
+
+	// The listeners are only bound once the database is reachable, so an
+	// orchestrator sees the process crash and restart rather than one that
+	// looks alive but can't serve anything.
+	if err := server.Connect(); err != nil {
+		fmt.Fprintln(os.Stderr, "Cannot connect to the database:", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	// The gRPC server only listens when GRPC_PORT is set, otherwise this
+	// returns nil straight away.
+	grpcErr := make(chan error, 1)
+	go func() {
+		grpcErr <- server.ListenAndServeGRPC()
+	}()
+
+	fmt.Println("Server is ready to accept requests")
+
+wait:
+	for {
+		select {
+		case err := <-serveErr:
+			panic(fmt.Sprintf("cannot start server: %s", err))
+		case err := <-grpcErr:
+			if err != nil {
+				panic(fmt.Sprintf("cannot start gRPC server: %s", err))
+			}
+
+			grpcErr = nil
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	gracePeriod := cfg.Server.ShutdownGracePeriod
+	fmt.Printf("Shutting down server, waiting up to %s...\n", gracePeriod)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Println("Error shutting down server:", err)
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Println("Error from server:", err)
+	}
+
+	fmt.Println("Server shut down")
 }
@@ -0,0 +1,965 @@
+// Package config loads every setting the server reads from the environment in
+// one place, applying the documented defaults and validating the values so a
+// misconfigured server fails at startup instead of silently falling back.
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Every setting of the server, as loaded by Load. Each field names the
+// environment variable it's read from and its default.
+type Config struct {
+	Server      Server
+	Database    Database
+	WebSocket   WebSocket
+	Webhooks    Webhooks
+	Jobs        Jobs
+	NATS        NATS
+	Kafka       Kafka
+	Forward     Forward
+	Redis       Redis
+	Outbox      Outbox
+	Retention   Retention
+	RateLimit   RateLimit
+	WarmUp      WarmUp
+	Maintenance Maintenance
+	Seed        Seed
+	Features    FeatureFlags
+	Log         Log
+	Metrics     Metrics
+	Tracing     Tracing
+}
+
+// Settings of the HTTP and gRPC servers and the API they serve.
+type Server struct {
+	// The port the HTTP server listens on, from API_PORT. Defaults to 8080.
+	Port int
+
+	// Where the HTTP server listens instead of API_PORT, from LISTEN_ADDR.
+	// Either a TCP address such as 127.0.0.1:8080, or a Unix domain socket
+	// such as unix:///var/run/shion.sock. Defaults to every interface on
+	// API_PORT.
+	ListenAddr string
+
+	// The permissions the Unix domain socket is created with, from
+	// LISTEN_SOCKET_MODE, in octal. Defaults to 0660.
+	SocketMode os.FileMode
+
+	// The port the gRPC server listens on, from GRPC_PORT. Defaults to 0,
+	// which disables the gRPC server.
+	GRPCPort int
+
+	// The basic authentication credentials, from API_USERNAME and
+	// API_PASSWORD. Required unless HMACSecret is set.
+	APIUsername string
+	APIPassword string
+
+	// The admin credentials, from ADMIN_USERNAME and ADMIN_PASSWORD. Admin
+	// access is disabled when both are unset.
+	AdminUsername string
+	AdminPassword string
+
+	// The shared secret requests are signed with, from HMAC_SECRET. When set,
+	// HMAC signatures are required instead of basic authentication.
+	HMACSecret string
+
+	// The shared secret admin requests are signed with instead, from
+	// HMAC_ADMIN_SECRET. Requests signed with it may use the admin endpoints.
+	// Admin access is disabled for signed requests when it's unset.
+	HMACAdminSecret string
+
+	// Whether HTTP/2 is served, from HTTP2_ENABLED. Defaults to true.
+	HTTP2Enabled bool
+
+	// The most concurrent streams per HTTP/2 connection, from
+	// HTTP2_MAX_CONCURRENT_STREAMS. Defaults to 250.
+	HTTP2MaxConcurrentStreams uint32
+
+	// The most events a single GET /events request can return, from
+	// MAX_EVENTS_LIMIT. Defaults to 1000.
+	MaxEventsLimit int
+
+	// Whether DELETE /events/all may delete events, from ALLOW_PURGE.
+	// Defaults to false.
+	AllowPurge bool
+
+	// How old an event's timestamp may be before it's rejected as a possible
+	// replay, from REPLAY_WINDOW_SECONDS. Defaults to 0, which disables replay
+	// protection.
+	ReplayWindow time.Duration
+
+	// Whether POST /event answers with a 200 and the existing event, rather
+	// than a 409, when an event with the same unique_key already exists, from
+	// UNIQUE_KEY_RETURNS_EXISTING. Defaults to false.
+	UniqueKeyReturnsExisting bool
+
+	// How often a keepalive comment is sent to idle SSE clients, from
+	// SSE_KEEPALIVE_INTERVAL. Defaults to 15s.
+	SSEKeepaliveInterval time.Duration
+
+	// The longest a long-poll request may wait, from LONG_POLL_MAX_TIMEOUT.
+	// Defaults to 60s.
+	LongPollMaxTimeout time.Duration
+
+	// How long a request may take before it's cancelled and answered with a
+	// 504, from REQUEST_TIMEOUT. Streaming routes such as SSE, WebSockets,
+	// long polling, and backups are exempt. It should be shorter than
+	// HTTP_WRITE_TIMEOUT, or the connection may be dropped before the 504 is
+	// sent. Defaults to 20s, and zero disables it.
+	RequestTimeout time.Duration
+
+	// How long the /health routes may take, from HEALTH_REQUEST_TIMEOUT, so a
+	// slow dependency can't leave the health checks hanging. Defaults to 5s,
+	// and zero disables it.
+	HealthRequestTimeout time.Duration
+
+	// How long POST /events may take to store a batch of events, from
+	// INGEST_REQUEST_TIMEOUT. Defaults to 10s, and zero disables it.
+	IngestRequestTimeout time.Duration
+
+	// Whether request and response bodies are logged, from DEBUG_LOG_BODIES.
+	// Defaults to false.
+	DebugLogBodies bool
+
+	// Whether the pprof and expvar endpoints are served under /debug, from
+	// ENABLE_DEBUG_ENDPOINTS. They require the admin credentials, so those
+	// must be set too. Defaults to false.
+	DebugEndpoints bool
+
+	// How long in-flight requests have to finish after SIGINT or SIGTERM, from
+	// SHUTDOWN_GRACE_PERIOD. Defaults to 30s.
+	ShutdownGracePeriod time.Duration
+
+	// The http.Server timeouts, from HTTP_READ_TIMEOUT,
+	// HTTP_READ_HEADER_TIMEOUT, HTTP_WRITE_TIMEOUT, and HTTP_IDLE_TIMEOUT.
+	// Default to 10s, 0, 30s, and 1m. Zero disables the read and write
+	// timeouts, and makes the read header and idle timeouts fall back to the
+	// read timeout. Streaming endpoints such as SSE clear their own write
+	// deadline.
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// The timezone event timestamps are returned in, from
+	// RETURN_TIMESTAMPS_IN, e.g. America/Chicago. Defaults to UTC. Clients can
+	// ask for another one with the X-Timezone header.
+	ReturnTimestampsIn *time.Location
+
+	// The most bytes of request headers the server reads, from
+	// HTTP_MAX_HEADER_BYTES. Defaults to 1 MB.
+	MaxHeaderBytes int
+
+	// The mode Gin runs in, which is debug, release, or test. It's read from
+	// GIN_MODE_OVERRIDE, then GIN_MODE, or otherwise from APP_ENV, where dev or
+	// development means debug and anything else release. Defaults to release.
+	GinMode string
+
+	// When v1 of the API stops being served, from API_V1_SUNSET, a date such
+	// as 2027-01-01. Now that v2 is live, v1 responses always say they're
+	// deprecated, and also carry a Sunset header with this date once it's
+	// set. Defaults to unset.
+	APIV1Sunset time.Time
+
+	// The IP addresses and CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For headers are trusted to give the client's IP, from
+	// TRUSTED_PROXIES, e.g. 10.0.0.0/8. Requests from anywhere else are
+	// attributed to the address they came from, so clients can't spoof their
+	// IP. Defaults to none.
+	TrustedProxies []string
+
+	// Whether the X-Real-IP header sent by trusted proxies is also used when
+	// there's no X-Forwarded-For header, from TRUST_X_REAL_IP. Defaults to
+	// false.
+	TrustXRealIP bool
+}
+
+// The modes Server.GinMode may be set to, matching gin's.
+const (
+	GinDebugMode   = "debug"
+	GinReleaseMode = "release"
+	GinTestMode    = "test"
+)
+
+// The drivers Database.Driver may be set to.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Settings of the database connection.
+type Database struct {
+	// The database driver, from DB_DRIVER. Either sqlite, the default, or
+	// postgres.
+	Driver string
+
+	// The database to connect to. For sqlite it's read from
+	// TURSO_DATABASE_URL and may be a remote libsql:// database or a local
+	// file: URL, and for postgres it's read from DATABASE_URL.
+	URL string
+
+	// The timeouts for reads, single writes, batch writes, and compacting the
+	// database, from DB_DEFAULT_QUERY_TIMEOUT_MS, DB_WRITE_TIMEOUT_MS,
+	// DB_BATCH_WRITE_TIMEOUT_MS, and DB_VACUUM_TIMEOUT_MS. Default to 1s, 2s,
+	// 10s, and 10m.
+	QueryTimeout      time.Duration
+	WriteTimeout      time.Duration
+	BatchWriteTimeout time.Duration
+	VacuumTimeout     time.Duration
+
+	// How slow a ping may be before the database is reported as degraded, from
+	// DB_HEALTH_DEGRADED_LATENCY_MS. Defaults to 500ms.
+	DegradedLatency time.Duration
+
+	// How long a query may take before it's logged as slow, along with the ID
+	// of the request it was run for, from DB_SLOW_QUERY_MS. Defaults to 500ms.
+	SlowQueryThreshold time.Duration
+
+	// The number of events inserted per transaction, from DB_BATCH_CHUNK_SIZE.
+	// Defaults to 500.
+	BatchChunkSize int
+
+	// What to do when a chunk of a batch fails, from DB_BATCH_ROLLBACK. Either
+	// all, the default, or chunk.
+	BatchRollback string
+
+	// The SQLite pragmas applied to local file: databases, from
+	// DB_JOURNAL_MODE, DB_SYNCHRONOUS, and DB_BUSY_TIMEOUT_MS. Default to WAL,
+	// NORMAL, and 5000ms.
+	JournalMode string
+	Synchronous string
+	BusyTimeout time.Duration
+
+	// How many times a write that found the SQLite database locked is
+	// retried, from DB_BUSY_RETRIES, and how long to wait before the first
+	// retry, from DB_BUSY_RETRY_BACKOFF_MS, which doubles with every retry
+	// after that. Default to 3 and 50ms, which are also used when they're set
+	// to zero. Writes that are still locked out fail with a 503.
+	BusyRetries      int
+	BusyRetryBackoff time.Duration
+
+	// Whether events are written to the outbox as they're created, from
+	// OUTBOX_ENABLED. Defaults to false. The relay delivering them is
+	// configured by Outbox.
+	Outbox bool
+
+	// The timezone event timestamps without a UTC offset are taken to be in,
+	// from DEFAULT_TIMEZONE, e.g. America/Chicago. Defaults to UTC. Every
+	// timestamp is stored in UTC either way.
+	DefaultTimezone *time.Location
+}
+
+// The overflow policies WebSocket.OverflowPolicy may be set to.
+const (
+	OverflowDropOldest = "drop-oldest"
+	OverflowDisconnect = "disconnect"
+)
+
+// Settings of WebSocket connections.
+type WebSocket struct {
+	// The number of events buffered per client, from WS_SEND_BUFFER_SIZE.
+	// Defaults to 256.
+	SendBufferSize int
+
+	// What happens when a client's buffer is full, from WS_OVERFLOW_POLICY.
+	// Either drop-oldest, the default, or disconnect.
+	OverflowPolicy string
+
+	// The most connections in total and per IP, from WS_MAX_CONNECTIONS and
+	// WS_MAX_CONNECTIONS_PER_IP. Default to 0, which means unlimited.
+	MaxConnections      int
+	MaxConnectionsPerIP int
+
+	// How often clients are pinged, how long they have to answer, and how
+	// long a write may take, from WS_PING_INTERVAL, WS_PONG_TIMEOUT, and
+	// WS_WRITE_TIMEOUT. Default to 54s, 60s, and 10s.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// How long a connection may stay open, from WS_MAX_LIFETIME. Defaults to
+	// 0, which means forever.
+	MaxLifetime time.Duration
+
+	// How long clients have to disconnect after being told the server is
+	// shutting down, from WS_SHUTDOWN_GRACE_PERIOD. Defaults to 5s.
+	ShutdownGracePeriod time.Duration
+
+	// The largest message a client may send in bytes, from
+	// WS_MAX_MESSAGE_BYTES. Clients sending a larger one are disconnected.
+	// Defaults to 64 KB.
+	MaxMessageBytes int
+}
+
+// Settings of webhook delivery.
+type Webhooks struct {
+	// The number of deliveries made at once, from WEBHOOK_WORKERS. Defaults
+	// to 4.
+	Workers int
+
+	// How long a delivery may take, from WEBHOOK_TIMEOUT. Defaults to 10s.
+	Timeout time.Duration
+
+	// How many times a delivery is attempted, from WEBHOOK_MAX_ATTEMPTS.
+	// Defaults to 5.
+	MaxAttempts int
+
+	// The backoff between attempts, from WEBHOOK_INITIAL_BACKOFF and
+	// WEBHOOK_MAX_BACKOFF. Default to 1s and 5m.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// How many deliveries in a row may fail before a subscription is
+	// disabled, from WEBHOOK_MAX_FAILURES. Defaults to 20.
+	MaxFailures int
+}
+
+// Settings of the queue POST /events?async=true submits jobs to.
+type Jobs struct {
+	// The number of jobs run at once and queued, from JOB_WORKERS and
+	// JOB_QUEUE_SIZE. Default to 2 and 100.
+	Workers   int
+	QueueSize int
+}
+
+// Settings of mirroring events onto NATS.
+type NATS struct {
+	// The server to connect to, from NATS_URL. NATS is disabled when unset.
+	URL string
+
+	// The prefix of the subjects events are published to, from
+	// NATS_SUBJECT_PREFIX. Defaults to shion.events.
+	SubjectPrefix string
+
+	// The number of events buffered for publishing, from NATS_BUFFER_SIZE.
+	// Defaults to 1024.
+	BufferSize int
+}
+
+// The delivery modes Kafka.DeliveryMode may be set to.
+const (
+	KafkaBestEffort = "best-effort"
+	KafkaBlocking   = "blocking"
+)
+
+// Settings of producing events to Kafka.
+type Kafka struct {
+	// The brokers to connect to, from the comma-separated KAFKA_BROKERS. Kafka
+	// is disabled when unset.
+	Brokers []string
+
+	// The topic events are produced to, from KAFKA_TOPIC. Defaults to
+	// shion.events.
+	Topic string
+
+	// The number of events buffered for producing, from KAFKA_BUFFER_SIZE.
+	// Defaults to 1024.
+	BufferSize int
+
+	// Whether requests wait for Kafka to acknowledge their events, from
+	// KAFKA_DELIVERY_MODE. Either best-effort, the default, or blocking.
+	DeliveryMode string
+
+	// How long a blocking request waits for the acknowledgement, from
+	// KAFKA_ACK_TIMEOUT. Defaults to 10s.
+	AckTimeout time.Duration
+}
+
+// Settings of forwarding events to another Shion API.
+type Forward struct {
+	// The base URL of the API events are forwarded to, from FORWARD_URL.
+	// Forwarding is disabled when unset.
+	URL string
+
+	// The credentials of that API, from FORWARD_USERNAME and
+	// FORWARD_PASSWORD.
+	Username string
+	Password string
+
+	// The number of forwards made at once and buffered, from FORWARD_WORKERS
+	// and FORWARD_BUFFER_SIZE. Default to 4 and 10000.
+	Workers    int
+	BufferSize int
+
+	// The backoff between attempts, from FORWARD_RETRY_BACKOFF. Defaults to
+	// 1s.
+	RetryBackoff time.Duration
+}
+
+// Settings of sharing events with other instances through Redis.
+type Redis struct {
+	// The server to connect to, from REDIS_URL. Redis is disabled when unset.
+	URL string
+
+	// The channel events are published to, from REDIS_CHANNEL. Defaults to
+	// shion:events.
+	Channel string
+}
+
+// Settings of the relay delivering the events in the outbox, which is only
+// started when Database.Outbox is true.
+type Outbox struct {
+	// How often the outbox is checked and how many entries are taken at once,
+	// from OUTBOX_POLL_INTERVAL and OUTBOX_BATCH_SIZE. Default to 1s and 100.
+	PollInterval time.Duration
+	BatchSize    int
+
+	// How long a delivery may take, from OUTBOX_TIMEOUT. Defaults to 10s.
+	Timeout time.Duration
+
+	// How many times a delivery is attempted, from OUTBOX_MAX_ATTEMPTS.
+	// Defaults to 10.
+	MaxAttempts int
+
+	// The backoff between attempts, from OUTBOX_INITIAL_BACKOFF and
+	// OUTBOX_MAX_BACKOFF. Default to 1s and 5m.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Settings of deleting expired events.
+type Retention struct {
+	// How old events may get, from RETENTION_MAX_AGE. Defaults to 0, which
+	// keeps them forever.
+	MaxAge time.Duration
+
+	// Overrides MaxAge for some event types, from RETENTION_MAX_AGE_BY_TYPE as
+	// a comma-separated list of type=duration pairs, e.g. debug=24h.
+	MaxAgeByType map[string]time.Duration
+
+	// The most events that are kept, from RETENTION_MAX_COUNT. Defaults to 0,
+	// which means unlimited.
+	MaxCount int
+
+	// How often expired events are deleted and how many per transaction, from
+	// RETENTION_INTERVAL and RETENTION_BATCH_SIZE. Default to 1h and 1000.
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Settings of rate limiting requests per IP.
+type RateLimit struct {
+	// The requests allowed per second, from RATE_LIMIT_RPS. Defaults to 0,
+	// which disables rate limiting.
+	RPS float64
+
+	// The most requests allowed at once, from RATE_LIMIT_BURST. Defaults to
+	// RPS rounded up.
+	Burst int
+}
+
+// Settings of waiting for the database to become reachable at startup.
+type WarmUp struct {
+	// How many times the database is pinged, from DB_WARMUP_ATTEMPTS.
+	// Defaults to 30.
+	Attempts int
+
+	// The backoff between pings, from DB_WARMUP_INTERVAL and
+	// DB_WARMUP_MAX_INTERVAL. Default to 500ms and 10s.
+	Interval    time.Duration
+	MaxInterval time.Duration
+
+	// How long the warm-up may take in total, from DB_WARMUP_TIMEOUT.
+	// Defaults to 2m.
+	Timeout time.Duration
+}
+
+// Settings of maintenance mode, in which the API answers every request other
+// than the liveness and readiness checks with a 503, e.g. while the database
+// is being migrated. It can also be turned on and off while the server runs.
+type Maintenance struct {
+	// Whether the server starts in maintenance mode, from MAINTENANCE_MODE.
+	// Defaults to false.
+	Enabled bool
+
+	// The message rejected requests are answered with, from
+	// MAINTENANCE_MESSAGE. Defaults to "the API is down for maintenance, try
+	// again later".
+	Message string
+
+	// How long clients are told to wait before retrying, from
+	// MAINTENANCE_RETRY_AFTER, which is sent in the Retry-After header rounded
+	// up to whole seconds. Defaults to 1m.
+	RetryAfter time.Duration
+}
+
+// Settings of seeding an empty database at startup.
+type Seed struct {
+	// Whether the database is seeded, from SEED_ENABLED. Defaults to false.
+	Enabled bool
+
+	// The fixtures file the events are read from, from SEED_FILE. Required
+	// when Enabled is true.
+	File string
+}
+
+// Settings of the structured logs the service writes.
+type Log struct {
+	// The least severe level that's logged, from LOG_LEVEL, which is one of
+	// debug, info, warn, or error. Defaults to info.
+	Level slog.Level
+
+	// The file the request log is also written to as JSON lines, from
+	// ACCESS_LOG_FILE. It's rotated once it grows past AccessLogMaxSize, and
+	// reopened on SIGHUP so an external logrotate works too. Defaults to
+	// unset, which only logs requests to stdout.
+	AccessLogFile string
+
+	// The size in megabytes the access log file is rotated at, from
+	// ACCESS_LOG_MAX_SIZE_MB. Defaults to 100.
+	AccessLogMaxSize int
+
+	// How long rotated access log files are kept, from ACCESS_LOG_MAX_AGE.
+	// Defaults to 720h, i.e. 30 days. Zero keeps them regardless of age.
+	AccessLogMaxAge time.Duration
+
+	// The most rotated access log files that are kept, from
+	// ACCESS_LOG_MAX_BACKUPS. Defaults to 0, which keeps them regardless of
+	// how many there are.
+	AccessLogMaxBackups int
+
+	// Whether requests are still logged to stdout while ACCESS_LOG_FILE is
+	// set, from ACCESS_LOG_STDOUT. Defaults to true.
+	AccessLogStdout bool
+}
+
+// Settings of the Prometheus metrics served at /metrics.
+type Metrics struct {
+	// The Basic Auth credentials scrapers use, from METRICS_USERNAME and
+	// METRICS_PASSWORD, so they don't need the API credentials. When unset,
+	// the API or admin credentials are required instead.
+	Username string
+	Password string
+
+	// How often the number of events in the database is counted for the
+	// shion_events_total_gauge metrics, from METRICS_DB_POLL_INTERVAL_SECONDS.
+	// Defaults to 30 seconds.
+	DBPollInterval time.Duration
+}
+
+// Settings of exporting OpenTelemetry traces.
+type Tracing struct {
+	// The OTLP/HTTP endpoint traces are exported to, from
+	// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or otherwise OTEL_EXPORTER_OTLP_ENDPOINT.
+	// Traces aren't exported when neither is set. The exporter reads the rest
+	// of the standard OTEL_EXPORTER_OTLP_* variables, e.g. the headers, itself.
+	Endpoint string
+}
+
+// Returned by Load when any setting is invalid, listing every problem rather
+// than only the first.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Reads the configuration from the environment, and the .env file if there is
+// one, applying the defaults of unset settings. If any setting is invalid a
+// *ValidationError listing all of them is returned along with the rest of the
+// configuration.
+func Load() (Config, error) {
+	r := &envReader{}
+
+	cfg := Config{
+		Server: Server{
+			Port:     r.int("API_PORT", 8080, 1),
+			GRPCPort: r.int("GRPC_PORT", 0, 0),
+
+			ListenAddr: r.string("LISTEN_ADDR", ""),
+			SocketMode: r.fileMode("LISTEN_SOCKET_MODE", 0o660),
+
+			APIUsername:   r.string("API_USERNAME", ""),
+			APIPassword:   r.string("API_PASSWORD", ""),
+			AdminUsername: r.string("ADMIN_USERNAME", ""),
+			AdminPassword: r.string("ADMIN_PASSWORD", ""),
+			HMACSecret:    r.string("HMAC_SECRET", ""),
+
+			HMACAdminSecret: r.string("HMAC_ADMIN_SECRET", ""),
+
+			HTTP2Enabled:              r.bool("HTTP2_ENABLED", true),
+			HTTP2MaxConcurrentStreams: r.uint32("HTTP2_MAX_CONCURRENT_STREAMS", 250),
+
+			MaxEventsLimit: r.int("MAX_EVENTS_LIMIT", 1000, 1),
+			AllowPurge:     r.bool("ALLOW_PURGE", false),
+			ReplayWindow:   time.Duration(r.int("REPLAY_WINDOW_SECONDS", 0, 0)) * time.Second,
+
+			UniqueKeyReturnsExisting: r.bool("UNIQUE_KEY_RETURNS_EXISTING", false),
+
+			SSEKeepaliveInterval: r.duration("SSE_KEEPALIVE_INTERVAL", 15*time.Second, false),
+			LongPollMaxTimeout:   r.duration("LONG_POLL_MAX_TIMEOUT", 60*time.Second, false),
+
+			RequestTimeout:       r.duration("REQUEST_TIMEOUT", 20*time.Second, true),
+			HealthRequestTimeout: r.duration("HEALTH_REQUEST_TIMEOUT", 5*time.Second, true),
+			IngestRequestTimeout: r.duration("INGEST_REQUEST_TIMEOUT", 10*time.Second, true),
+
+			DebugLogBodies:      r.bool("DEBUG_LOG_BODIES", false),
+			DebugEndpoints:      r.bool("ENABLE_DEBUG_ENDPOINTS", false),
+			ShutdownGracePeriod: r.duration("SHUTDOWN_GRACE_PERIOD", 30*time.Second, false),
+
+			ReadTimeout:       r.duration("HTTP_READ_TIMEOUT", 10*time.Second, true),
+			ReadHeaderTimeout: r.duration("HTTP_READ_HEADER_TIMEOUT", 0, true),
+			WriteTimeout:      r.duration("HTTP_WRITE_TIMEOUT", 30*time.Second, true),
+			IdleTimeout:       r.duration("HTTP_IDLE_TIMEOUT", time.Minute, true),
+			MaxHeaderBytes:    r.int("HTTP_MAX_HEADER_BYTES", 1<<20, 1),
+
+			ReturnTimestampsIn: r.location("RETURN_TIMESTAMPS_IN"),
+
+			GinMode: r.ginMode(),
+
+			APIV1Sunset: r.date("API_V1_SUNSET"),
+
+			TrustedProxies: r.cidrs("TRUSTED_PROXIES"),
+			TrustXRealIP:   r.bool("TRUST_X_REAL_IP", false),
+		},
+		Database: Database{
+			Driver: r.driver("DB_DRIVER"),
+
+			QueryTimeout:      r.millis("DB_DEFAULT_QUERY_TIMEOUT_MS", time.Second),
+			WriteTimeout:      r.millis("DB_WRITE_TIMEOUT_MS", 2*time.Second),
+			BatchWriteTimeout: r.millis("DB_BATCH_WRITE_TIMEOUT_MS", 10*time.Second),
+			VacuumTimeout:     r.millis("DB_VACUUM_TIMEOUT_MS", 10*time.Minute),
+			DegradedLatency:   r.millis("DB_HEALTH_DEGRADED_LATENCY_MS", 500*time.Millisecond),
+
+			SlowQueryThreshold: r.millis("DB_SLOW_QUERY_MS", 500*time.Millisecond),
+
+			BatchChunkSize: r.int("DB_BATCH_CHUNK_SIZE", 500, 1),
+			BatchRollback:  r.oneOf("DB_BATCH_ROLLBACK", "all", "all", "chunk"),
+
+			JournalMode: r.oneOf("DB_JOURNAL_MODE", "WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"),
+			Synchronous: r.oneOf("DB_SYNCHRONOUS", "NORMAL", "OFF", "NORMAL", "FULL", "EXTRA"),
+			BusyTimeout: r.millis("DB_BUSY_TIMEOUT_MS", 5*time.Second),
+
+			BusyRetries:      r.int("DB_BUSY_RETRIES", 3, 0),
+			BusyRetryBackoff: r.millis("DB_BUSY_RETRY_BACKOFF_MS", 50*time.Millisecond),
+
+			Outbox: r.bool("OUTBOX_ENABLED", false),
+
+			DefaultTimezone: r.location("DEFAULT_TIMEZONE"),
+		},
+		WebSocket: WebSocket{
+			SendBufferSize:      r.int("WS_SEND_BUFFER_SIZE", 256, 1),
+			OverflowPolicy:      r.oneOf("WS_OVERFLOW_POLICY", OverflowDropOldest, OverflowDropOldest, OverflowDisconnect),
+			MaxConnections:      r.int("WS_MAX_CONNECTIONS", 0, 0),
+			MaxConnectionsPerIP: r.int("WS_MAX_CONNECTIONS_PER_IP", 0, 0),
+
+			PingInterval: r.duration("WS_PING_INTERVAL", 54*time.Second, false),
+			PongTimeout:  r.duration("WS_PONG_TIMEOUT", 60*time.Second, false),
+			WriteTimeout: r.duration("WS_WRITE_TIMEOUT", 10*time.Second, false),
+			MaxLifetime:  r.duration("WS_MAX_LIFETIME", 0, true),
+
+			ShutdownGracePeriod: r.duration("WS_SHUTDOWN_GRACE_PERIOD", 5*time.Second, false),
+
+			MaxMessageBytes: r.int("WS_MAX_MESSAGE_BYTES", 64<<10, 1),
+		},
+		Webhooks: Webhooks{
+			Workers:        r.int("WEBHOOK_WORKERS", 4, 1),
+			Timeout:        r.duration("WEBHOOK_TIMEOUT", 10*time.Second, false),
+			MaxAttempts:    r.int("WEBHOOK_MAX_ATTEMPTS", 5, 1),
+			InitialBackoff: r.duration("WEBHOOK_INITIAL_BACKOFF", time.Second, false),
+			MaxBackoff:     r.duration("WEBHOOK_MAX_BACKOFF", 5*time.Minute, false),
+			MaxFailures:    r.int("WEBHOOK_MAX_FAILURES", 20, 1),
+		},
+		Jobs: Jobs{
+			Workers:   r.int("JOB_WORKERS", 2, 1),
+			QueueSize: r.int("JOB_QUEUE_SIZE", 100, 1),
+		},
+		NATS: NATS{
+			URL:           r.string("NATS_URL", ""),
+			SubjectPrefix: r.string("NATS_SUBJECT_PREFIX", "shion.events"),
+			BufferSize:    r.int("NATS_BUFFER_SIZE", 1024, 1),
+		},
+		Kafka: Kafka{
+			Brokers:      r.list("KAFKA_BROKERS"),
+			Topic:        r.string("KAFKA_TOPIC", "shion.events"),
+			BufferSize:   r.int("KAFKA_BUFFER_SIZE", 1024, 1),
+			DeliveryMode: r.oneOf("KAFKA_DELIVERY_MODE", KafkaBestEffort, KafkaBestEffort, KafkaBlocking),
+			AckTimeout:   r.duration("KAFKA_ACK_TIMEOUT", 10*time.Second, false),
+		},
+		Forward: Forward{
+			URL:          r.string("FORWARD_URL", ""),
+			Username:     r.string("FORWARD_USERNAME", ""),
+			Password:     r.string("FORWARD_PASSWORD", ""),
+			Workers:      r.int("FORWARD_WORKERS", 4, 1),
+			BufferSize:   r.int("FORWARD_BUFFER_SIZE", 10000, 1),
+			RetryBackoff: r.duration("FORWARD_RETRY_BACKOFF", time.Second, false),
+		},
+		Redis: Redis{
+			URL:     r.string("REDIS_URL", ""),
+			Channel: r.string("REDIS_CHANNEL", "shion:events"),
+		},
+		Outbox: Outbox{
+			PollInterval:   r.duration("OUTBOX_POLL_INTERVAL", time.Second, false),
+			BatchSize:      r.int("OUTBOX_BATCH_SIZE", 100, 1),
+			Timeout:        r.duration("OUTBOX_TIMEOUT", 10*time.Second, false),
+			MaxAttempts:    r.int("OUTBOX_MAX_ATTEMPTS", 10, 1),
+			InitialBackoff: r.duration("OUTBOX_INITIAL_BACKOFF", time.Second, false),
+			MaxBackoff:     r.duration("OUTBOX_MAX_BACKOFF", 5*time.Minute, false),
+		},
+		Retention: Retention{
+			MaxAge:       r.duration("RETENTION_MAX_AGE", 0, true),
+			MaxAgeByType: r.maxAgeByType("RETENTION_MAX_AGE_BY_TYPE"),
+			MaxCount:     r.int("RETENTION_MAX_COUNT", 0, 0),
+			Interval:     r.duration("RETENTION_INTERVAL", time.Hour, false),
+			BatchSize:    r.int("RETENTION_BATCH_SIZE", 1000, 1),
+		},
+		RateLimit: RateLimit{
+			RPS:   r.float("RATE_LIMIT_RPS", 0, 0),
+			Burst: r.int("RATE_LIMIT_BURST", 0, 1),
+		},
+		WarmUp: WarmUp{
+			Attempts:    r.int("DB_WARMUP_ATTEMPTS", 30, 1),
+			Interval:    r.duration("DB_WARMUP_INTERVAL", 500*time.Millisecond, false),
+			MaxInterval: r.duration("DB_WARMUP_MAX_INTERVAL", 10*time.Second, false),
+			Timeout:     r.duration("DB_WARMUP_TIMEOUT", 2*time.Minute, false),
+		},
+		Maintenance: Maintenance{
+			Enabled:    r.bool("MAINTENANCE_MODE", false),
+			Message:    r.string("MAINTENANCE_MESSAGE", "the API is down for maintenance, try again later"),
+			RetryAfter: r.duration("MAINTENANCE_RETRY_AFTER", time.Minute, false),
+		},
+		Seed: Seed{
+			Enabled: r.bool("SEED_ENABLED", false),
+			File:    r.string("SEED_FILE", ""),
+		},
+		Features: r.featureFlags(),
+		Log: Log{
+			Level: r.logLevel("LOG_LEVEL", slog.LevelInfo),
+
+			AccessLogFile:       r.string("ACCESS_LOG_FILE", ""),
+			AccessLogMaxSize:    r.int("ACCESS_LOG_MAX_SIZE_MB", 100, 1),
+			AccessLogMaxAge:     r.duration("ACCESS_LOG_MAX_AGE", 30*24*time.Hour, true),
+			AccessLogMaxBackups: r.int("ACCESS_LOG_MAX_BACKUPS", 0, 0),
+			AccessLogStdout:     r.bool("ACCESS_LOG_STDOUT", true),
+		},
+		Metrics: Metrics{
+			Username: r.string("METRICS_USERNAME", ""),
+			Password: r.string("METRICS_PASSWORD", ""),
+
+			DBPollInterval: time.Duration(r.int("METRICS_DB_POLL_INTERVAL_SECONDS", 30, 1)) * time.Second,
+		},
+		Tracing: Tracing{
+			Endpoint: r.string("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", r.string("OTEL_EXPORTER_OTLP_ENDPOINT", "")),
+		},
+	}
+
+	if cfg.Database.Driver == DriverPostgres {
+		cfg.Database.URL = r.string("DATABASE_URL", "")
+	} else {
+		cfg.Database.URL = r.string("TURSO_DATABASE_URL", "")
+	}
+
+	if cfg.RateLimit.Burst == 0 {
+		cfg.RateLimit.Burst = int(math.Max(1, math.Ceil(cfg.RateLimit.RPS)))
+	}
+
+	cfg.validate(r)
+
+	if len(r.problems) > 0 {
+		return cfg, &ValidationError{Problems: r.problems}
+	}
+
+	return cfg, nil
+}
+
+// Records the problems that involve more than a single value, such as
+// settings that depend on each other.
+func (cfg *Config) validate(r *envReader) {
+	if cfg.Server.Port > math.MaxUint16 {
+		r.fail("API_PORT", "must be at most %d, got %d", math.MaxUint16, cfg.Server.Port)
+	}
+
+	if cfg.Server.GRPCPort > math.MaxUint16 {
+		r.fail("GRPC_PORT", "must be at most %d, got %d", math.MaxUint16, cfg.Server.GRPCPort)
+	} else if cfg.Server.GRPCPort == cfg.Server.Port {
+		r.fail("GRPC_PORT", "must differ from API_PORT, both are %d", cfg.Server.Port)
+	}
+
+	cfg.Server.validateListenAddr(r)
+
+	// Basic authentication isn't used when requests are signed, so the API
+	// credentials are only required without an HMAC secret.
+	if cfg.Server.HMACSecret == "" || !cfg.Features.HMACAuth {
+		if cfg.Server.APIUsername == "" {
+			r.fail("API_USERNAME", "is required unless HMAC_SECRET is set and FEATURE_HMAC_AUTH is on")
+		}
+		if cfg.Server.APIPassword == "" {
+			r.fail("API_PASSWORD", "is required unless HMAC_SECRET is set and FEATURE_HMAC_AUTH is on")
+		}
+	}
+
+	if cfg.Server.HMACAdminSecret != "" && cfg.Server.HMACAdminSecret == cfg.Server.HMACSecret {
+		r.fail("HMAC_ADMIN_SECRET", "must differ from HMAC_SECRET")
+	}
+
+	if (cfg.Server.AdminUsername == "") != (cfg.Server.AdminPassword == "") {
+		r.fail("ADMIN_USERNAME", "and ADMIN_PASSWORD must be set together")
+	}
+
+	if cfg.Server.DebugEndpoints && cfg.Server.AdminUsername == "" {
+		r.fail("ENABLE_DEBUG_ENDPOINTS", "requires ADMIN_USERNAME and ADMIN_PASSWORD")
+	}
+
+	if (cfg.Metrics.Username == "") != (cfg.Metrics.Password == "") {
+		r.fail("METRICS_USERNAME", "and METRICS_PASSWORD must be set together")
+	}
+
+	cfg.Database.validateURL(r)
+
+	if cfg.WebSocket.PongTimeout <= cfg.WebSocket.PingInterval {
+		r.fail("WS_PONG_TIMEOUT", "must be longer than WS_PING_INTERVAL (%s), got %s", cfg.WebSocket.PingInterval, cfg.WebSocket.PongTimeout)
+	}
+
+	if cfg.Webhooks.MaxBackoff < cfg.Webhooks.InitialBackoff {
+		r.fail("WEBHOOK_MAX_BACKOFF", "must be at least WEBHOOK_INITIAL_BACKOFF (%s), got %s", cfg.Webhooks.InitialBackoff, cfg.Webhooks.MaxBackoff)
+	}
+
+	if cfg.Outbox.MaxBackoff < cfg.Outbox.InitialBackoff {
+		r.fail("OUTBOX_MAX_BACKOFF", "must be at least OUTBOX_INITIAL_BACKOFF (%s), got %s", cfg.Outbox.InitialBackoff, cfg.Outbox.MaxBackoff)
+	}
+
+	if cfg.WarmUp.MaxInterval < cfg.WarmUp.Interval {
+		r.fail("DB_WARMUP_MAX_INTERVAL", "must be at least DB_WARMUP_INTERVAL (%s), got %s", cfg.WarmUp.Interval, cfg.WarmUp.MaxInterval)
+	}
+
+	validateURL(r, "FORWARD_URL", cfg.Forward.URL, "http", "https")
+	validateURL(r, "REDIS_URL", cfg.Redis.URL, "redis", "rediss", "unix")
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+		validateURL(r, key, r.string(key, ""), "http", "https")
+	}
+
+	if cfg.Seed.Enabled && cfg.Seed.File == "" {
+		r.fail("SEED_FILE", "is required when SEED_ENABLED is true")
+	}
+}
+
+// The prefix of a LISTEN_ADDR that's a Unix domain socket rather than a TCP
+// address.
+const UnixSocketPrefix = "unix://"
+
+// Defaults the listen address to every interface on the API port, or records
+// a problem if it's neither a TCP address nor a Unix domain socket.
+func (s *Server) validateListenAddr(r *envReader) {
+	if s.ListenAddr == "" {
+		s.ListenAddr = fmt.Sprintf(":%d", s.Port)
+		return
+	}
+
+	if path, ok := strings.CutPrefix(s.ListenAddr, UnixSocketPrefix); ok {
+		if path == "" {
+			r.fail("LISTEN_ADDR", "is missing the socket's path, e.g. unix:///var/run/shion.sock")
+		}
+		return
+	}
+
+	if _, _, err := net.SplitHostPort(s.ListenAddr); err != nil {
+		r.fail("LISTEN_ADDR", "must be a host:port or unix:///path/to/socket, got %q", s.ListenAddr)
+	}
+}
+
+// Records a problem if the database URL is missing or can't be used with the
+// driver.
+func (db *Database) validateURL(r *envReader) {
+	if db.Driver == DriverPostgres {
+		if db.URL == "" {
+			r.fail("DATABASE_URL", "is required when DB_DRIVER is postgres")
+			return
+		}
+
+		validateURL(r, "DATABASE_URL", db.URL, "postgres", "postgresql")
+		return
+	}
+
+	if db.URL == "" {
+		r.fail("TURSO_DATABASE_URL", "is required")
+		return
+	}
+
+	validateURL(r, "TURSO_DATABASE_URL", db.URL, "file", "libsql", "http", "https", "ws", "wss")
+}
+
+// Records a problem if the URL in the given variable is set but can't be
+// parsed or doesn't use one of the given schemes.
+func validateURL(r *envReader, key, value string, schemes ...string) {
+	if value == "" {
+		return
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		r.fail(key, "is not a valid URL: %s", err)
+		return
+	}
+
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return
+		}
+	}
+
+	// The password is left out so the problem is safe to print.
+	r.fail(key, "must be a %s:// URL, got %q", strings.Join(schemes, "://, "), u.Redacted())
+}
+
+// Returns the database driver in the given variable, which is either sqlite,
+// the default, or postgres. The driver's aliases, such as turso, are accepted
+// too.
+func (r *envReader) driver(key string) string {
+	switch driver := r.oneOf(key, DriverSQLite, DriverSQLite, "libsql", "turso", DriverPostgres, "postgresql"); driver {
+	case DriverPostgres, "postgresql":
+		return DriverPostgres
+	default:
+		return DriverSQLite
+	}
+}
+
+// Returns the mode Gin should run in, which is GIN_MODE_OVERRIDE or GIN_MODE
+// if either is set, and otherwise debug when APP_ENV is dev or development and
+// release when it's anything else. Gin reads GIN_MODE itself when it starts, so
+// GIN_MODE_OVERRIDE lets the mode be set without that, e.g. to keep it from
+// printing the routes in debug mode before the configured mode is applied.
+func (r *envReader) ginMode() string {
+	for _, key := range []string{"GIN_MODE_OVERRIDE", "GIN_MODE"} {
+		if mode := r.oneOf(key, "", GinDebugMode, GinReleaseMode, GinTestMode); mode != "" {
+			return mode
+		}
+	}
+
+	switch strings.ToLower(r.string("APP_ENV", "")) {
+	case "dev", "development":
+		return GinDebugMode
+	default:
+		return GinReleaseMode
+	}
+}
+
+// Returns the comma-separated type=duration pairs in the given variable, e.g.
+// debug=24h,audit=720h.
+func (r *envReader) maxAgeByType(key string) map[string]time.Duration {
+	maxAges := map[string]time.Duration{}
+
+	for _, pair := range r.list(key) {
+		eventType, raw, _ := strings.Cut(pair, "=")
+		eventType, raw = strings.TrimSpace(eventType), strings.TrimSpace(raw)
+
+		maxAge, err := time.ParseDuration(raw)
+		if eventType == "" || err != nil || maxAge <= 0 {
+			r.fail(key, "%q is not a type=duration pair with a positive duration", pair)
+			continue
+		}
+
+		maxAges[eventType] = maxAge
+	}
+
+	return maxAges
+}
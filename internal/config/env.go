@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads settings from the environment, recording a problem for every value
+// that can't be parsed instead of stopping at the first, so Load can report
+// them all at once. Unset variables get their default value.
+type envReader struct {
+	problems []string
+}
+
+// Records a problem with the given variable.
+func (r *envReader) fail(key, format string, args ...any) {
+	r.problems = append(r.problems, key+": "+fmt.Sprintf(format, args...))
+}
+
+// Returns the variable's value with surrounding whitespace removed, or the
+// default when it's unset or empty.
+func (r *envReader) string(key, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+// Returns the variable's value as an integer of at least min, or the default
+// when it's unset.
+func (r *envReader) int(key string, defaultValue, min int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		r.fail(key, "%q is not an integer", raw)
+		return defaultValue
+	}
+
+	if value < min {
+		r.fail(key, "must be at least %d, got %d", min, value)
+		return defaultValue
+	}
+
+	return value
+}
+
+// Returns the variable's value as a positive 32-bit unsigned integer, or the
+// default when it's unset.
+func (r *envReader) uint32(key string, defaultValue uint32) uint32 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil || value == 0 {
+		r.fail(key, "%q is not an integer between 1 and %d", raw, uint32(math.MaxUint32))
+		return defaultValue
+	}
+
+	return uint32(value)
+}
+
+// Returns the variable's value as a number of at least min, or the default
+// when it's unset.
+func (r *envReader) float(key string, defaultValue, min float64) float64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		r.fail(key, "%q is not a number", raw)
+		return defaultValue
+	}
+
+	if value < min {
+		r.fail(key, "must be at least %g, got %g", min, value)
+		return defaultValue
+	}
+
+	return value
+}
+
+// Returns the variable's value as a boolean, e.g. true or false, or the
+// default when it's unset.
+func (r *envReader) bool(key string, defaultValue bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		r.fail(key, "%q is not a boolean, use true or false", raw)
+		return defaultValue
+	}
+
+	return value
+}
+
+// Returns the variable's value as octal file permissions such as 0660, or the
+// default when it's unset.
+func (r *envReader) fileMode(key string, defaultValue os.FileMode) os.FileMode {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil || value > 0o777 {
+		r.fail(key, "%q is not octal file permissions, e.g. 0660", raw)
+		return defaultValue
+	}
+
+	return os.FileMode(value)
+}
+
+// Returns the variable's value as a date such as 2027-01-01, at midnight UTC,
+// or the zero time when it's unset.
+func (r *envReader) date(key string) time.Time {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return time.Time{}
+	}
+
+	value, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		r.fail(key, "%q is not a date, e.g. 2027-01-01", raw)
+		return time.Time{}
+	}
+
+	return value
+}
+
+// Returns the variable's value as a timezone from the IANA database such as
+// America/Chicago, or UTC when it's unset.
+func (r *envReader) location(key string) *time.Location {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(raw)
+	if err != nil {
+		r.fail(key, "%q is not a timezone, e.g. America/Chicago", raw)
+		return time.UTC
+	}
+
+	return loc
+}
+
+// Returns the variable's value as a duration such as 30s or 5m, or the default
+// when it's unset. Zero is only allowed when allowZero is true, for settings
+// where it turns something off.
+func (r *envReader) duration(key string, defaultValue time.Duration, allowZero bool) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		r.fail(key, "%q is not a duration, e.g. 30s", raw)
+		return defaultValue
+	}
+
+	if value < 0 || (value == 0 && !allowZero) {
+		r.fail(key, "must be positive, got %s", raw)
+		return defaultValue
+	}
+
+	return value
+}
+
+// Returns the variable's value, a positive number of milliseconds, as a
+// duration, or the default when it's unset.
+func (r *envReader) millis(key string, defaultValue time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		r.fail(key, "%q is not a positive number of milliseconds", raw)
+		return defaultValue
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Returns the variable's value, which must be one of the allowed values
+// ignoring case, or the default when it's unset. The value is returned in the
+// case it's listed in.
+func (r *envReader) oneOf(key, defaultValue string, allowed ...string) string {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	i := slices.IndexFunc(allowed, func(value string) bool { return strings.EqualFold(value, raw) })
+	if i < 0 {
+		r.fail(key, "%q must be one of %s", raw, strings.Join(allowed, ", "))
+		return defaultValue
+	}
+
+	return allowed[i]
+}
+
+// Returns the variable's value as a log level, which is one of debug, info,
+// warn, or error, or the default when it's unset.
+func (r *envReader) logLevel(key string, defaultValue slog.Level) slog.Level {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(raw)); err != nil {
+		r.fail(key, "%q must be one of debug, info, warn, error", raw)
+		return defaultValue
+	}
+
+	return level
+}
+
+// Returns the variable's comma-separated IP addresses and CIDR ranges, such as
+// 10.0.0.0/8, with whitespace and empty entries removed.
+func (r *envReader) cidrs(key string) []string {
+	values := r.list(key)
+
+	for _, value := range values {
+		if _, err := netip.ParsePrefix(value); err == nil {
+			continue
+		}
+
+		if _, err := netip.ParseAddr(value); err != nil {
+			r.fail(key, "%q is not an IP address or CIDR range, e.g. 10.0.0.0/8", value)
+		}
+	}
+
+	return values
+}
+
+// Returns the variable's comma-separated values with whitespace and empty
+// entries removed.
+func (r *envReader) list(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,35 @@
+package config
+
+// Switches for optional API features, so one can be turned off through the
+// environment when it misbehaves instead of with a code change. Every feature
+// is on unless its FEATURE_* variable is set to false.
+type FeatureFlags struct {
+	// Whether POST /events holds a lock on the Idempotency-Key header so a
+	// retry of a batch that's still being created gets a 409, from
+	// FEATURE_DEDUP. When off the header is ignored.
+	Dedup bool `json:"dedup"`
+
+	// Whether requests must be signed when HMAC_SECRET is set, from
+	// FEATURE_HMAC_AUTH. When off Basic Auth is used instead.
+	HMACAuth bool `json:"hmac_auth"`
+
+	// Whether event data is checked against the schema registered for its
+	// type and the /schemas routes are served, from
+	// FEATURE_SCHEMA_VALIDATION. When off the routes return 501.
+	SchemaValidation bool `json:"schema_validation"`
+}
+
+// Reads the feature flags from the environment. Invalid values leave their
+// feature on, and are reported by Load.
+func LoadFeatureFlags() FeatureFlags {
+	return (&envReader{}).featureFlags()
+}
+
+// Reads the feature flags, recording a problem for every invalid value.
+func (r *envReader) featureFlags() FeatureFlags {
+	return FeatureFlags{
+		Dedup:            r.bool("FEATURE_DEDUP", true),
+		HMACAuth:         r.bool("FEATURE_HMAC_AUTH", true),
+		SchemaValidation: r.bool("FEATURE_SCHEMA_VALIDATION", true),
+	}
+}
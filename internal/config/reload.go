@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// The variables the process was started with, which take precedence over the
+// .env file both at startup and on every Reload. It's captured before init
+// loads the file.
+var inheritedEnv = environKeys()
+
+var (
+	// Guards dotenvKeys.
+	dotenvMu sync.Mutex
+
+	// The variables that were last set from the .env file.
+	dotenvKeys = map[string]bool{}
+)
+
+func init() {
+	loadDotenv()
+}
+
+// Reads the configuration like Load, after reading the .env file again so
+// settings changed in it since startup are picked up. Variables that were
+// removed from the file are unset, and ones set in the process's own
+// environment still take precedence over it.
+func Reload() (Config, error) {
+	loadDotenv()
+
+	return Load()
+}
+
+// Sets every variable in the .env file, if there is one, that isn't already in
+// the process's own environment.
+func loadDotenv() {
+	values, err := godotenv.Read()
+	if err != nil {
+		values = nil
+	}
+
+	dotenvMu.Lock()
+	defer dotenvMu.Unlock()
+
+	for key := range dotenvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(dotenvKeys, key)
+		}
+	}
+
+	for key, value := range values {
+		if inheritedEnv[key] {
+			continue
+		}
+
+		os.Setenv(key, value)
+		dotenvKeys[key] = true
+	}
+}
+
+// Returns the names of the variables set in the environment.
+func environKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		keys[key] = true
+	}
+
+	return keys
+}
+
+// Returns the settings that differ between two configurations, named by their
+// section and field, e.g. Server.Port or RateLimit.RPS, in the order they're
+// declared.
+func Changes(before, after Config) []string {
+	var changed []string
+
+	beforeValue, afterValue := reflect.ValueOf(before), reflect.ValueOf(after)
+	sections := beforeValue.Type()
+
+	for i := range sections.NumField() {
+		section := sections.Field(i)
+		fields := section.Type
+
+		for j := range fields.NumField() {
+			if !reflect.DeepEqual(beforeValue.Field(i).Field(j).Interface(), afterValue.Field(i).Field(j).Interface()) {
+				changed = append(changed, section.Name+"."+fields.Field(j).Name)
+			}
+		}
+	}
+
+	return changed
+}
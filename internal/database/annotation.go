@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+// A note added to an event after it was created, e.g. an incident summary or
+// how it was resolved. Annotations are append-only and never change the event
+// itself.
+type Annotation struct {
+	// The unique identifier for the annotation, generated by the shortuuid
+	// package.
+	ID string `json:"id"`
+
+	// The ID of the event the annotation belongs to.
+	EventID string `json:"event_id"`
+
+	// The text of the annotation.
+	Note string `json:"note"`
+
+	// Who added the annotation, if known.
+	Author string `json:"author"`
+
+	// When the annotation was added in RFC 3339 format.
+	CreatedAt string `json:"created_at"`
+}
+
+// Adds an annotation with the given note and author to the event with the
+// given ID. Returns the new annotation, ErrNotFound if the event doesn't exist,
+// or an error if the operation fails.
+func (s *tursoService) AddAnnotation(eventID string, note string, author string) (Annotation, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	annotation := Annotation{
+		ID:        shortuuid.New(),
+		EventID:   eventID,
+		Note:      note,
+		Author:    author,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	// The event's existence is checked in the same statement so it can't be
+	// deleted in between. The values are cast since Postgres can't infer the
+	// types of parameters in a SELECT list.
+	query := `INSERT INTO annotations (id, event_id, note, author, created_at)
+		SELECT CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT)
+		WHERE EXISTS (SELECT 1 FROM Events WHERE ID = ?)`
+	result, err := s.db.ExecContext(ctx, query, annotation.ID, eventID, note, author, annotation.CreatedAt, eventID)
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	if inserted == 0 {
+		return Annotation{}, ErrNotFound
+	}
+
+	return annotation, nil
+}
+
+// Retrieves the annotations of the event with the given ID, oldest first.
+// Returns ErrNotFound if the event doesn't exist, or an error if the operation
+// fails.
+func (s *tursoService) GetAnnotations(eventID string) ([]Annotation, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	var exists int
+	err := s.db.QueryRowContext(ctx, "SELECT 1 FROM Events WHERE ID = ?", eventID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, event_id, note, author, created_at FROM annotations WHERE event_id = ? ORDER BY rowid", eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		if err := rows.Scan(&a.ID, &a.EventID, &a.Note, &a.Author, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		annotations = append(annotations, a)
+	}
+
+	return annotations, rows.Err()
+}
+
+// Create the annotations table if it doesn't exist, along with an index for
+// looking up an event's annotations. Returns an error if either can't be
+// created.
+func CreateAnnotationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS annotations (
+		id TEXT NOT NULL PRIMARY KEY,
+		event_id TEXT NOT NULL,
+		note TEXT NOT NULL,
+		author TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating annotations table: %w", err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS annotations_event_id ON annotations (event_id)")
+	if err != nil {
+		return fmt.Errorf("creating annotations index: %w", err)
+	}
+
+	return nil
+}
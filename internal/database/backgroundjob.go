@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Returned when the requested background job doesn't exist.
+var ErrBackgroundJobNotFound = errors.New("background job not found")
+
+// A maintenance task run in the background, e.g. a vacuum started through
+// POST /admin/vacuum, whose progress is stored so it can be checked later.
+// Unlike ingestion jobs they aren't resumed after a restart.
+type BackgroundJob struct {
+	// The unique identifier for the job, a UUID.
+	ID string `json:"id"`
+
+	// What the job does, e.g. vacuum.
+	Type string `json:"type"`
+
+	// Whether the job is running, completed, or failed.
+	Status JobStatus `json:"status"`
+
+	// When the job started and finished in RFC 3339 format. FinishedAt is
+	// empty while it's running.
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at,omitempty"`
+
+	// The job's outcome as JSON once it has finished, e.g. a VacuumResult, or
+	// an object with an error field if it failed.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// The columns of the background_jobs table in the order they're scanned by
+// GetBackgroundJob.
+const backgroundJobColumns = "id, type, status, started_at, finished_at, result"
+
+// Records a running background job of the given type, started now.
+func (s *tursoService) StartBackgroundJob(jobType string) (BackgroundJob, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	job := BackgroundJob{
+		ID:        uuid.NewString(),
+		Type:      jobType,
+		Status:    JobRunning,
+		StartedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	query := "INSERT INTO background_jobs (" + backgroundJobColumns + ") VALUES (?, ?, ?, ?, '', '')"
+	if _, err := s.db.ExecContext(ctx, query, job.ID, job.Type, job.Status, job.StartedAt); err != nil {
+		return BackgroundJob{}, err
+	}
+
+	return job, nil
+}
+
+// Records that the background job with the given ID finished, storing result
+// as JSON if jobErr is nil and marking it failed with the error otherwise.
+// Returns ErrBackgroundJobNotFound if it doesn't exist, or an error if the
+// operation fails.
+func (s *tursoService) FinishBackgroundJob(id string, result any, jobErr error) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	status := JobCompleted
+	if jobErr != nil {
+		status = JobFailed
+		result = map[string]string{"error": jobErr.Error()}
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	query := "UPDATE background_jobs SET status = ?, finished_at = ?, result = ? WHERE id = ?"
+	res, err := s.db.ExecContext(ctx, query, status, now, string(encoded), id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrBackgroundJobNotFound
+	}
+
+	return nil
+}
+
+// Retrieves the background job with the given ID. Returns
+// ErrBackgroundJobNotFound if it doesn't exist, or an error if the operation
+// fails.
+func (s *tursoService) GetBackgroundJob(id string) (BackgroundJob, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	var job BackgroundJob
+	var result string
+
+	row := s.db.QueryRowContext(ctx, "SELECT "+backgroundJobColumns+" FROM background_jobs WHERE id = ?", id)
+	err := row.Scan(&job.ID, &job.Type, &job.Status, &job.StartedAt, &job.FinishedAt, &result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return BackgroundJob{}, ErrBackgroundJobNotFound
+	}
+	if err != nil {
+		return BackgroundJob{}, err
+	}
+
+	if result != "" {
+		job.Result = json.RawMessage(result)
+	}
+
+	return job, nil
+}
+
+// Create the background_jobs table if it doesn't exist, which stores the
+// status and outcome of maintenance tasks run in the background. Returns an
+// error if it can't be created.
+func CreateBackgroundJobsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS background_jobs (
+		id TEXT NOT NULL PRIMARY KEY,
+		type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		finished_at TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating background_jobs table: %w", err)
+	}
+
+	return nil
+}
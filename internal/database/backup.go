@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Returned by Backup when the database isn't a local SQLite file, e.g. a
+// remote Turso database or Postgres, which are backed up by their own tooling.
+var ErrBackupUnsupported = errors.New("backups are only supported for local SQLite databases")
+
+// Copies the database to a new SQLite file at path using SQLite's online
+// backup API, which takes a consistent snapshot without blocking writers for
+// longer than it takes to copy each batch of pages. The file must not already
+// exist. Like Vacuum, the copy covers the whole database, so it's given the
+// vacuum timeout (DB_VACUUM_TIMEOUT_MS).
+//
+// Returns ErrBackupUnsupported if the database isn't a local file: database.
+func (s *tursoService) Backup(path string) error {
+	if s.db.dialect.name != sqliteDialect.name {
+		return ErrBackupUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.vacuumTimeout)
+	defer cancel()
+
+	src, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	return dest.Raw(func(destConn any) error {
+		return src.Raw(func(srcConn any) error {
+			// Remote Turso connections go through libsql's own driver rather
+			// than go-sqlite3.
+			srcSQLite, ok := srcConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return ErrBackupUnsupported
+			}
+
+			backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+
+			return copyPages(ctx, backup)
+		})
+	})
+}
+
+// The number of pages copied per step of a backup. Writers are only blocked
+// while a step runs, so smaller steps let them in more often.
+const backupStepPages = 1024
+
+// How long to wait before retrying a backup step that found the database busy.
+const backupBusyDelay = 10 * time.Millisecond
+
+// Steps through a backup until every page has been copied, then finishes it.
+// A step that finds the database busy or locked is retried until the context
+// is done.
+func copyPages(ctx context.Context, backup *sqlite3.SQLiteBackup) error {
+	for {
+		done, err := backup.Step(backupStepPages)
+
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+			err = nil
+			time.Sleep(backupBusyDelay)
+		}
+
+		if err == nil && !done {
+			err = ctx.Err()
+		}
+
+		if err != nil {
+			backup.Finish()
+			return fmt.Errorf("backing up the database: %w", err)
+		}
+
+		if done {
+			return backup.Finish()
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The default number of events CreateEvents inserts per transaction, used
+// when DB_BATCH_CHUNK_SIZE is unset.
+const defaultBatchChunkSize = 500
+
+// Determines what CreateEvents does when one of its chunks fails.
+type BatchRollback string
+
+const (
+	// Deletes the events created by the chunks that were already committed, so
+	// either every event is created or none are.
+	BatchRollbackAll BatchRollback = "all"
+
+	// Rolls back only the chunk that failed and carries on with the rest,
+	// returning the events that were created along with a *BatchError.
+	BatchRollbackChunk BatchRollback = "chunk"
+)
+
+// Describes a chunk of events that CreateEvents couldn't create.
+type ChunkError struct {
+	// The index of the chunk's first event in the events passed to
+	// CreateEvents.
+	Start int
+
+	// The number of events in the chunk.
+	Count int
+
+	Err error
+}
+
+func (e ChunkError) Error() string {
+	return fmt.Sprintf("events %d to %d: %s", e.Start, e.Start+e.Count-1, e.Err)
+}
+
+// Writes the chunk's position along with its error as a string.
+func (e ChunkError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Start int    `json:"start"`
+		Count int    `json:"count"`
+		Error string `json:"error"`
+	}{e.Start, e.Count, e.Err.Error()})
+}
+
+func (e ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// Returned by CreateEvents when DB_BATCH_ROLLBACK is chunk and some of its
+// chunks failed. The events in every other chunk were created.
+type BatchError struct {
+	Chunks []ChunkError
+}
+
+func (e *BatchError) Error() string {
+	messages := make([]string, len(e.Chunks))
+	for i, chunk := range e.Chunks {
+		messages[i] = chunk.Error()
+	}
+
+	return fmt.Sprintf("%d chunk(s) failed: %s", len(e.Chunks), strings.Join(messages, "; "))
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Chunks))
+	for i, chunk := range e.Chunks {
+		errs[i] = chunk
+	}
+
+	return errs
+}
+
+// Returns what to do when a chunk fails from the configured rollback mode.
+// Defaults to BatchRollbackAll unless it's set to chunk.
+func batchRollback(mode string) BatchRollback {
+	if BatchRollback(strings.ToLower(mode)) == BatchRollbackChunk {
+		return BatchRollbackChunk
+	}
+
+	return BatchRollbackAll
+}
+
+// Creates the events in a single transaction, which gets its own batch write
+// timeout, along with the outbox entries of the inserted events when
+// OUTBOX_ENABLED is true. Returns the created events, and the ones that were
+// actually inserted rather than already existing.
+func (s *tursoService) createEventsChunk(events []EventEntry) ([]EventEntry, []EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.batchWriteTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertEventQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stmt.Close()
+
+	newEvents := make([]EventEntry, 0, len(events))
+	var insertedEvents []EventEntry
+
+	for _, e := range events {
+		fe, inserted, err := s.insertEvent(ctx, tx, stmt, e)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		newEvents = append(newEvents, fe)
+		if !inserted {
+			continue
+		}
+
+		insertedEvents = append(insertedEvents, fe)
+
+		if s.outbox {
+			if err := insertOutboxEntry(ctx, tx, fe.ID); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return newEvents, insertedEvents, nil
+}
+
+// Returns the events that were inserted by CreateEvent or CreateEvents, leaving
+// out the duplicates of ones that already existed.
+func Inserted(events []EventEntry) []EventEntry {
+	inserted := make([]EventEntry, 0, len(events))
+	for _, event := range events {
+		if !event.Duplicate {
+			inserted = append(inserted, event)
+		}
+	}
+
+	return inserted
+}
+
+// Deletes the given events, a chunk at a time, to undo the chunks of a
+// CreateEvents call that were committed before a later one failed. Their
+// outbox entries are deleted as well, although the outbox relay may have
+// delivered some of the events in the meantime.
+func (s *tursoService) deleteInsertedEvents(events []EventEntry) error {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+
+	for start := 0; start < len(ids); start += s.batchChunkSize {
+		chunk := ids[start:min(start+s.batchChunkSize, len(ids))]
+
+		ctx, cancel := context.WithTimeout(s.ctx, s.batchWriteTimeout)
+
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+
+		query := "DELETE FROM Events WHERE ID IN (?" + strings.Repeat(", ?", len(chunk)-1) + ")"
+		_, err := s.db.ExecContext(ctx, query, args...)
+		if err == nil && s.outbox {
+			err = deleteOutboxEntries(ctx, s.db, chunk)
+		}
+		cancel()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Joins the error of a failed chunk with the error of undoing the chunks
+// before it, if that failed too.
+func rollbackError(chunkErr ChunkError, deleteErr error) error {
+	if deleteErr == nil {
+		return chunkErr
+	}
+
+	return errors.Join(chunkErr, fmt.Errorf("deleting the events created before the failed chunk: %w", deleteErr))
+}
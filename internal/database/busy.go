@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Returned, wrapping the driver's error, when a write still found the SQLite
+// database locked by another connection after every retry.
+var ErrDatabaseBusy = errors.New("the database is busy")
+
+// The default number of times a write that found the database locked is
+// retried, used when DB_BUSY_RETRIES is unset.
+const defaultBusyRetries = 3
+
+// The default delay before the first retry of a write that found the database
+// locked, used when DB_BUSY_RETRY_BACKOFF_MS is unset. It doubles with every
+// retry after that.
+const defaultBusyRetryBackoff = 50 * time.Millisecond
+
+// Returns whether the error means SQLite gave up waiting for another
+// connection's lock on the database, i.e. SQLITE_BUSY or SQLITE_LOCKED, which
+// is worth retrying. Remote Turso databases only report it in the message.
+func IsBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrDatabaseBusy) {
+		return true
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+
+	message := err.Error()
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "SQLITE_BUSY")
+}
+
+// Runs a write, retrying it up to DB_BUSY_RETRIES times while it fails because
+// the database is locked, waiting DB_BUSY_RETRY_BACKOFF_MS before the first
+// retry and twice as long before each one after. This complements the
+// busy_timeout pragma, which makes every statement wait for the lock, by also
+// retrying whole transactions SQLite had to abort to avoid a deadlock. Every
+// other error is returned as is, and one that outlasts the retries wraps
+// ErrDatabaseBusy.
+func (s *tursoService) retryBusy(write func() error) error {
+	backoff := s.busyRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if !IsBusy(err) {
+			return err
+		}
+
+		if attempt == s.busyRetries {
+			return fmt.Errorf("%w: %w", ErrDatabaseBusy, err)
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,44 @@
+package database
+
+import "sync"
+
+// A function called with the events a write created once its transaction has
+// committed.
+type CommitHook func(events ...EventEntry)
+
+// The hooks registered with AfterCommit. They're kept behind a pointer so the
+// copies WithTimeout returns run the same hooks.
+type commitHooks struct {
+	mu    sync.RWMutex
+	hooks []CommitHook
+}
+
+// Registers a hook that's called with the events CreateEvent and CreateEvents
+// create, once the transactions that created them have committed, e.g. to
+// broadcast them to subscribers. Events that already existed aren't passed,
+// and neither are events that were rolled back, including the chunks of a
+// CreateEvents call that are deleted again when a later chunk fails.
+//
+// Hooks run synchronously on the goroutine that created the events, in the
+// order they were registered, so they shouldn't block.
+func (s *tursoService) AfterCommit(hook CommitHook) {
+	s.commitHooks.mu.Lock()
+	defer s.commitHooks.mu.Unlock()
+
+	s.commitHooks.hooks = append(s.commitHooks.hooks, hook)
+}
+
+// Calls every registered commit hook with the given events, unless there
+// aren't any.
+func (h *commitHooks) run(events []EventEntry) {
+	if len(events) == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, hook := range h.hooks {
+		hook(events...)
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/4lch4/shion-api/internal/config"
+)
+
+// The query parameters of a database URL that hold credentials, which are
+// left out of RedactURL's result.
+var credentialParams = []string{"authToken", "password"}
+
+// Describes the database the configuration connects to, without any of its
+// credentials, so it can be logged.
+type ConnectionInfo struct {
+	// The dialect DB_DRIVER resolved to, e.g. sqlite or postgres.
+	Dialect string
+
+	// The database/sql driver used to connect, e.g. libsql or pgx.
+	Driver string
+
+	// Where the database is: the host of a remote database, or the path of a
+	// local file.
+	Host string
+}
+
+// Returns the dialect, driver, and host of the database the configuration
+// connects to. The dialect and driver are empty if DB_DRIVER is unknown.
+func DescribeConnection(cfg config.Database) ConnectionInfo {
+	d, _ := dialectByName(cfg.Driver)
+	info := ConnectionInfo{Dialect: d.name, Driver: d.driverName}
+
+	u, err := url.Parse(cfg.URL)
+	switch {
+	case err != nil:
+		info.Host = "(invalid URL)"
+	case u.Scheme == "file":
+		info.Host = strings.TrimPrefix(strings.TrimPrefix(cfg.URL, "file:"), "//")
+		info.Host, _, _ = strings.Cut(info.Host, "?")
+	default:
+		info.Host = u.Host
+	}
+
+	return info
+}
+
+// Returns the database URL with its password and credential query parameters,
+// e.g. a Turso authToken, redacted, so it can be logged.
+func RedactURL(dbUrl string) string {
+	u, err := url.Parse(dbUrl)
+	if err != nil {
+		return "(invalid URL)"
+	}
+
+	query := u.Query()
+	for _, param := range credentialParams {
+		if query.Has(param) {
+			query.Set(param, "xxxxx")
+		}
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.Redacted()
+}
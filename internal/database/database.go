@@ -1,16 +1,19 @@
 package database
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
+	"log/slog"
+	"strings"
 	"time"
 
-	// "github.com/google/uuid"
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/google/uuid"
 	"github.com/lithammer/shortuuid/v4"
+	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
@@ -19,7 +22,9 @@ import (
 type EventType string
 
 type EventEntry struct {
-	// The unique identifier for the event, generated by the google/uuid package.
+	// The unique identifier for the event. Clients may supply their own UUID, or
+	// short UUID, so retries are idempotent, otherwise one is generated by the
+	// shortuuid package.
 	ID string `json:"id"`
 
 	// The type of event. E.g. mouse-click, mouse-move, key-down, key-up, etc.
@@ -28,28 +33,231 @@ type EventEntry struct {
 	// The data associated with the event. E.g. mouse coordinates, key pressed, etc.
 	Data string `json:"data"`
 
-	// The timestamp of the event.
+	// The timestamp of the event in RFC 3339 format. It may be given without a
+	// UTC offset, e.g. 2024-05-01T09:30:00, in which case it's taken to be in
+	// DEFAULT_TIMEZONE. It's always stored in UTC. If not provided when the
+	// event is created then the current time is used.
 	Timestamp string `json:"timestamp"`
+
+	// How urgent the event is, from MinPriority to MaxPriority (the most
+	// urgent). If not provided when the event is created then DefaultPriority
+	// is used.
+	Priority int `json:"priority"`
+
+	// An optional key the event is unique by, e.g. the ID the event has in
+	// the system it came from. Creating an event with the unique key of one
+	// that already exists returns that event instead of a duplicate.
+	UniqueKey string `json:"unique_key,omitempty"`
+
+	// Set on the events CreateEvent and CreateEvents return that already
+	// existed, with the same ID or unique key, instead of being inserted. It
+	// isn't stored.
+	Duplicate bool `json:"duplicate,omitempty"`
 }
 
+// The range of event priorities, and the priority of events created without
+// one.
+const (
+	MinPriority     = 1
+	MaxPriority     = 5
+	DefaultPriority = 3
+)
+
+// The longest unique key an event may have, in bytes.
+const MaxUniqueKeyLength = 255
+
 type TursoDB interface {
-	Health() map[string]string
+	Health() HealthStatus
+
+	Ping() error
+
+	CheckSchema() error
+
+	CreateTables() error
 
 	Close() error
 
 	CreateEvent(e EventEntry) (EventEntry, error)
 
+	CreateEvents(events []EventEntry) ([]EventEntry, error)
+
 	GetEventByID(id string) (EventEntry, error)
 
+	GetEventsByIDs(ids []string) ([]EventEntry, error)
+
+	PatchEvent(id string, fields map[string]interface{}) (EventEntry, error)
+
 	GetEventsByType(eventType EventType) ([]EventEntry, error)
 
-	GetEvents() ([]EventEntry, error)
+	GetLatestEvents(limit int) ([]EventEntry, error)
+
+	GetLatestEventsByType(eventType EventType, maxEntries int) ([]EventEntry, error)
+
+	GetLatestEventsByTypes(types []EventType, maxEntries int) ([]EventEntry, error)
+
+	GetEventsSorted(sortBy, order string, types []EventType, maxEntries int) ([]EventEntry, error)
+
+	GetOldestEvent(eventType EventType) (EventEntry, error)
+
+	GetEventsAfter(id string, maxEntries int) ([]EventEntry, error)
+
+	GetEventsSince(since time.Time) ([]EventEntry, error)
+
+	GetEventCount() (int64, error)
+
+	GetEventCountByTypes(types []EventType) (int64, error)
+
+	GetEventCountsByType() (map[EventType]int64, error)
+
+	GetEventTimeSeries(start, end time.Time, bucket string) ([]TimeSeriesBucket, error)
+
+	GetDistinctValues(eventType EventType, field string, maxValues int) ([]DistinctValue, bool, error)
+
+	GetEventSummary() (EventSummary, error)
+
+	GetTopEventTypes(topN int, since time.Time) ([]EventTypeCount, error)
+
+	GetEventRate(window time.Duration) (EventRate, error)
+
+	GetTopPriorityEvents(minPriority int, max int) ([]EventEntry, error)
+
+	GetEventCountByMinPriority(minPriority int) (int64, error)
+
+	WithTimeout(timeout time.Duration) TursoDB
+
+	WithContext(ctx context.Context) TursoDB
+
+	PurgeEvents() (int64, error)
+
+	DeleteEventsBefore(before time.Time, eventType EventType, excluded []EventType, limit int) (int64, error)
+
+	DeleteEventsBeyond(keep int, limit int) (int64, error)
+
+	Vacuum() (VacuumResult, error)
+
+	StartBackgroundJob(jobType string) (BackgroundJob, error)
+
+	FinishBackgroundJob(id string, result any, jobErr error) error
+
+	GetBackgroundJob(id string) (BackgroundJob, error)
+
+	Backup(path string) error
+
+	AfterCommit(hook CommitHook)
+
+	RegisterProcessor(eventType EventType, processor EventProcessor)
+
+	AcquireLock(key string, ttl time.Duration) (bool, error)
+
+	ReleaseLock(key string) error
+
+	RecordNonce(nonce string, expiresAt time.Time) (bool, error)
+
+	DeleteNonce(nonce string) error
+
+	RegisterSchema(eventType string, schema string) error
 
-	GetLatestEvents(maxEntries int) ([]EventEntry, error)
+	GetSchema(eventType string) (string, error)
+
+	DeleteSchema(eventType string) error
+
+	ValidateEventData(eventType string, data string) (bool, []string, error)
+
+	CreateWebhook(w Webhook) (Webhook, error)
+
+	GetWebhook(id string) (Webhook, error)
+
+	ListWebhooks() ([]Webhook, error)
+
+	GetWebhooksForEvent(eventType EventType) ([]Webhook, error)
+
+	UpdateWebhook(w Webhook) (Webhook, error)
+
+	DeleteWebhook(id string) error
+
+	RecordWebhookDelivery(id string, deliveryErr error, maxFailures int) (bool, error)
+
+	AddAnnotation(eventID string, note string, author string) (Annotation, error)
+
+	GetAnnotations(eventID string) ([]Annotation, error)
+
+	CreateJob(events []EventEntry) (Job, error)
+
+	GetJob(id string) (Job, error)
+
+	GetIncompleteJobs() ([]Job, error)
+
+	StartJob(id string) error
+
+	FinishJob(job Job) error
+
+	GetPendingOutbox(limit int) ([]OutboxEntry, error)
+
+	ListOutbox(status OutboxStatus, limit int) ([]OutboxEntry, error)
+
+	MarkOutboxSent(eventID string) error
+
+	RecordOutboxFailure(eventID string, delivered []string, deliveryErr error, retryAt time.Time, maxAttempts int) (bool, error)
+
+	RequeueOutbox(eventID string) error
 }
 
 type tursoService struct {
-	db *sql.DB
+	db *dialectDB
+
+	// The context every operation's context is derived from, which carries
+	// values such as the request ID set by WithContext but is never canceled.
+	ctx context.Context
+
+	// How long reads may take, e.g. point lookups and listing events.
+	queryTimeout time.Duration
+
+	// How long single writes may take, e.g. creating or patching one event.
+	writeTimeout time.Duration
+
+	// How long batch writes may take, e.g. creating many events at once.
+	batchWriteTimeout time.Duration
+
+	// How long compacting the database with Vacuum may take.
+	vacuumTimeout time.Duration
+
+	// How slow a health check ping may be before the database is reported as
+	// degraded.
+	degradedLatency time.Duration
+
+	// How many events CreateEvents inserts per transaction.
+	batchChunkSize int
+
+	// What CreateEvents does when one of its chunks fails.
+	batchRollback BatchRollback
+
+	// How many times a write that found the database locked is retried, and
+	// how long to wait before the first retry.
+	busyRetries      int
+	busyRetryBackoff time.Duration
+
+	// Whether an outbox entry is added for every event that's created, in the
+	// same transaction, so the outbox relay can deliver it.
+	outbox bool
+
+	// Called with the events that are created once they've been committed.
+	commitHooks *commitHooks
+
+	// Called with the events that are about to be created, by type.
+	processors *eventProcessors
+
+	// The timezone event timestamps without a UTC offset are taken to be in.
+	location *time.Location
+}
+
+// The query methods shared by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// The exec methods shared by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
 // #endregion Structs/Types
@@ -68,90 +276,182 @@ const (
 )
 
 var (
-	// The URL for the Turso database.
-	dbUrl = os.Getenv("TURSO_DATABASE_URL")
+	// Returned when the requested event doesn't exist.
+	ErrNotFound = errors.New("event not found")
+
+	// Returned by CreateEvent, along with the existing event, when an event
+	// with the same unique key but a different ID already exists.
+	ErrUniqueKeyExists = errors.New("an event with this unique key already exists")
+
+	// SQL query to insert an event into the Events table. Inserting an event
+	// with an ID or unique key that already exists is a no-op so client
+	// retries are safe. An empty unique key is stored as NULL, so events
+	// without one never conflict.
+	insertEventQuery = "INSERT INTO Events (ID, Type, Data, Timestamp, Priority, UniqueKey) VALUES (?, ?, ?, ?, ?, NULLIF(?, '')) ON CONFLICT DO NOTHING"
+
+	// SQL query to retrieve a single event by its ID.
+	selectEventByIDQuery = "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE ID = ?"
 
-	// SQL query to insert an event into the Events table.
-	insertEventQuery = "INSERT INTO Events (ID, Type, Data, Timestamp) VALUES (?, ?, ?, ?)"
+	// SQL query to retrieve a single event by its unique key.
+	selectEventByUniqueKeyQuery = "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE UniqueKey = ?"
 )
 
 // #endregion Constants/Variables
 
-// Creates a new Event entry with a unique ID and timestamp. Returns the Event
-// entry with the updated fields.
-func initEventEntry(e EventEntry) EventEntry {
-	e.ID = shortuuid.New()
-	e.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+// Checks that the Event entry has the fields required to be stored, returning
+// an error describing the first problem found.
+func (e EventEntry) Validate() error {
+	if e.Type == "" {
+		return errors.New("event is missing a type")
+	}
 
-	return e
-}
+	if e.ID != "" && !validEventID(e.ID) {
+		return fmt.Errorf("event id %q is not a valid UUID", e.ID)
+	}
 
-// Creates a new TursoDB instance and returns it. The database URL is read from
-// the TURSO_DATABASE_URL environment variable. If the connection fails then an
-// error is printed to the console and nil is returned.
-func New() TursoDB {
-	fmt.Println("[NewTurso()]: Connecting to Turso database at", dbUrl)
+	if e.Timestamp != "" {
+		if _, err := ParseTimestamp(e.Timestamp, time.UTC); err != nil {
+			return fmt.Errorf("event timestamp %q is not a valid RFC 3339 timestamp", e.Timestamp)
+		}
+	}
 
-	db, err := sql.Open("libsql", dbUrl)
-	if err != nil {
-		fmt.Println("Error opening database", err)
-		return nil
+	// Zero means the priority wasn't provided, so the default is used.
+	if e.Priority != 0 && (e.Priority < MinPriority || e.Priority > MaxPriority) {
+		return fmt.Errorf("event priority %d is not between %d and %d", e.Priority, MinPriority, MaxPriority)
+	}
+
+	if len(e.UniqueKey) > MaxUniqueKeyLength {
+		return fmt.Errorf("event unique key is longer than %d bytes", MaxUniqueKeyLength)
 	}
 
-	return &tursoService{db: db}
+	return nil
 }
 
-// #region Route Helpers
+// Returns whether the ID is a UUID, or a short UUID like the ones generated
+// for events created without an ID. Accepting both lets an event be copied to
+// another instance, e.g. when it's forwarded, with its ID intact.
+func validEventID(id string) bool {
+	if _, err := uuid.Parse(id); err == nil {
+		return true
+	}
 
-// Returns a map of health status information. The keys and values in the map
-// are service-specific.
-func (s *tursoService) Health() map[string]string {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+	// Decoding accepts strings of any length, so the ID must also round-trip.
+	decoded, err := shortuuid.DefaultEncoder.Decode(id)
+	return err == nil && shortuuid.DefaultEncoder.Encode(decoded) == id
+}
 
-	stats := make(map[string]string)
+// Creates a new Event entry with a unique ID and timestamp. An ID or valid
+// timestamp provided by the client (e.g. for idempotent retries or when
+// importing historical events) is kept, with the timestamp normalized to UTC.
+// A timestamp without a UTC offset is taken to be in loc. Returns the Event
+// entry with the updated fields.
+func initEventEntry(e EventEntry, loc *time.Location) EventEntry {
+	if e.ID == "" {
+		e.ID = shortuuid.New()
+	}
+	e.Duplicate = false
 
-	// Ping the database
-	err := s.db.PingContext(ctx)
+	ts, err := ParseTimestamp(e.Timestamp, loc)
 	if err != nil {
-		stats["status"] = "down"
-		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
-		return stats
+		ts = time.Now()
+	}
+	e.Timestamp = ts.UTC().Format(time.RFC3339Nano)
+
+	if e.Priority == 0 {
+		e.Priority = DefaultPriority
 	}
 
-	// Database is up, add more statistics
-	stats["status"] = "up"
-	stats["message"] = "It's healthy"
+	return e
+}
 
-	// Get database stats (like open connections, in use, idle, etc.)
-	dbStats := s.db.Stats()
-	stats["open_connections"] = strconv.Itoa(dbStats.OpenConnections)
-	stats["in_use"] = strconv.Itoa(dbStats.InUse)
-	stats["idle"] = strconv.Itoa(dbStats.Idle)
-	stats["wait_count"] = strconv.FormatInt(dbStats.WaitCount, 10)
-	stats["wait_duration"] = dbStats.WaitDuration.String()
-	stats["max_idle_closed"] = strconv.FormatInt(dbStats.MaxIdleClosed, 10)
-	stats["max_lifetime_closed"] = strconv.FormatInt(dbStats.MaxLifetimeClosed, 10)
+// Creates a new TursoDB instance from the given configuration and returns it.
+// The database is selected by cfg.Driver:
+//
+//   - sqlite (the default) connects to the Turso database at cfg.URL, which
+//     may also be a local file: URL for development and testing.
+//   - postgres connects to the Postgres database at cfg.URL.
+//
+// Settings left at their zero value use the defaults. The service logs to the
+// given logger, or to slog's default logger when it's nil. If the driver is
+// unknown or the connection fails then an error is logged and nil is returned.
+func New(cfg config.Database, logger *slog.Logger) TursoDB {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	d, ok := dialectByName(cfg.Driver)
+	if !ok {
+		logger.Error("Error opening database: unknown DB_DRIVER", "driver", cfg.Driver)
+		return nil
+	}
 
-	// Evaluate stats to provide a health message
-	if dbStats.OpenConnections > 40 { // Assuming 50 is the max for this example
-		stats["message"] = "The database is experiencing heavy load."
+	dbUrl := cfg.URL
+	if d.name == postgresDialect.name {
+		logger.Info("Connecting to Postgres database")
+	} else {
+		dbUrl = withSQLitePragmas(cfg.URL, cfg)
+		logger.Info("Connecting to Turso database", "url", RedactURL(cfg.URL))
 	}
 
-	if dbStats.WaitCount > 1000 {
-		stats["message"] = "The database has a high number of wait events, indicating potential bottlenecks."
+	db, err := sql.Open(d.driverName, dbUrl)
+	if err != nil {
+		logger.Error("Error opening database", "error", err)
+		return nil
 	}
 
-	if dbStats.MaxIdleClosed > int64(dbStats.OpenConnections)/2 {
-		stats["message"] = "Many idle connections are being closed, consider revising the connection pool settings."
+	// The service is still returned if the tables can't be created so the
+	// server can wait for the database to become reachable and call
+	// CreateTables again.
+	if err := d.createTables(db); err != nil {
+		logger.Warn("Error creating tables", "error", err)
 	}
 
-	if dbStats.MaxLifetimeClosed > int64(dbStats.OpenConnections)/2 {
-		stats["message"] = "Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern."
+	return &tursoService{
+		db: &dialectDB{
+			DB:      db,
+			dialect: d,
+			slow:    slowQueryLog{logger: logger, threshold: cmp.Or(cfg.SlowQueryThreshold, defaultSlowQueryThreshold)},
+		},
+
+		ctx: context.Background(),
+
+		queryTimeout:      cmp.Or(cfg.QueryTimeout, defaultQueryTimeout),
+		writeTimeout:      cmp.Or(cfg.WriteTimeout, defaultWriteTimeout),
+		batchWriteTimeout: cmp.Or(cfg.BatchWriteTimeout, defaultBatchWriteTimeout),
+		vacuumTimeout:     cmp.Or(cfg.VacuumTimeout, defaultVacuumTimeout),
+
+		degradedLatency: cmp.Or(cfg.DegradedLatency, defaultDegradedLatency),
+
+		batchChunkSize: cmp.Or(cfg.BatchChunkSize, defaultBatchChunkSize),
+		batchRollback:  batchRollback(cfg.BatchRollback),
+
+		busyRetries:      cmp.Or(max(cfg.BusyRetries, 0), defaultBusyRetries),
+		busyRetryBackoff: cmp.Or(cfg.BusyRetryBackoff, defaultBusyRetryBackoff),
+
+		outbox: cfg.Outbox,
+
+		commitHooks: &commitHooks{},
+		processors:  &eventProcessors{},
+
+		location: cmp.Or(cfg.DefaultTimezone, time.UTC),
 	}
+}
 
-	return stats
+// #region Route Helpers
+
+// Checks that the database is reachable, returning an error if it isn't.
+func (s *tursoService) Ping() error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	return s.db.PingContext(ctx)
+}
+
+// Creates every table the service uses if it doesn't already exist. New does
+// this as well, but it fails if the database isn't reachable yet. Returns the
+// first error that occurs.
+func (s *tursoService) CreateTables() error {
+	return s.db.dialect.createTables(s.db.DB)
 }
 
 // Terminates the database connection, returning an error if the connection
@@ -160,65 +460,204 @@ func (s *tursoService) Close() error {
 	return s.db.Close()
 }
 
-// Creates a new Event entry in the database. Returns the full Event entry if
+// Creates a new Event entry in the database. If the client supplied an ID that
+// already exists then nothing is inserted and the stored event is returned
+// instead, so retrying a create is safe. Returns the full Event entry if
 // successful, or an error if the operation fails.
+//
+// When OUTBOX_ENABLED is true the event's outbox entry is added in the same
+// transaction. The commit hooks are called with the event once it's committed,
+// unless it already existed. If the database is locked the insert is retried,
+// each attempt getting its own write timeout. The event is first passed to the
+// processors registered for its type, and isn't created if one rejects it.
 func (s *tursoService) CreateEvent(e EventEntry) (EventEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
-	defer cancel()
-
-	stmt, err := s.db.Prepare(insertEventQuery)
+	e, err := s.processors.process(e)
 	if err != nil {
 		return EventEntry{}, err
 	}
 
-	fe := initEventEntry(e)
-	_, err = stmt.ExecContext(ctx, fe.ID, e.Type, e.Data, fe.Timestamp)
+	var event EventEntry
+	var inserted bool
+
+	err = s.retryBusy(func() (err error) {
+		ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+		defer cancel()
+
+		if s.outbox {
+			event, inserted, err = s.createEventWithOutbox(ctx, e)
+		} else {
+			event, inserted, err = s.createEvent(ctx, e)
+		}
+
+		return err
+	})
+
 	if err != nil {
 		return EventEntry{}, err
 	}
 
-	return fe, nil
-}
+	if inserted {
+		s.commitHooks.run([]EventEntry{event})
+	}
 
-// Create multiple Event entries in the database. Returns a slice of the events
-// that were created if successful, or an error if the operation fails.
-func (s *tursoService) CreateEvents(events []EventEntry) ([]EventEntry, error) {
-	// Create a timeout duration of 500ms per event.
-	timeoutDuration := time.Duration(500*len(events)) * time.Millisecond
+	// A retry with the same ID gets its own event back, but a different event
+	// with the same unique key is reported so the caller can say so.
+	if !inserted && e.ID != event.ID {
+		return event, ErrUniqueKeyExists
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
-	defer cancel()
+	return event, nil
+}
 
-	var newEvents []EventEntry
+// Creates a single event in a statement of its own, returning whether it was
+// inserted rather than already existing.
+func (s *tursoService) createEvent(ctx context.Context, e EventEntry) (EventEntry, bool, error) {
 	stmt, err := s.db.Prepare(insertEventQuery)
+	if err != nil {
+		return EventEntry{}, false, err
+	}
+	defer stmt.Close()
+
+	return s.insertEvent(ctx, s.db, stmt, e)
+}
+
+// Creates a single event and its outbox entry in one transaction, so the
+// entry exists if and only if the event does. Returns whether the event was
+// inserted rather than already existing.
+func (s *tursoService) createEventWithOutbox(ctx context.Context, e EventEntry) (EventEntry, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return EventEntry{}, false, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertEventQuery)
+	if err != nil {
+		return EventEntry{}, false, err
+	}
+	defer stmt.Close()
+
+	event, inserted, err := s.insertEvent(ctx, tx, stmt, e)
+	if err != nil {
+		return EventEntry{}, false, err
+	}
+
+	if inserted {
+		if err := insertOutboxEntry(ctx, tx, event.ID); err != nil {
+			return EventEntry{}, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return EventEntry{}, false, err
+	}
+
+	return event, inserted, nil
+}
+
+// Inserts a single event using the given prepared insertEventQuery statement,
+// returning the stored event if one with the same ID, or else the same unique
+// key, already exists. Also returns whether the event was inserted rather than
+// already existing.
+func (s *tursoService) insertEvent(ctx context.Context, q querier, stmt *sql.Stmt, e EventEntry) (EventEntry, bool, error) {
+	fe := initEventEntry(e, s.location)
+	result, err := stmt.ExecContext(ctx, fe.ID, fe.Type, fe.Data, fe.Timestamp, fe.Priority, fe.UniqueKey)
+	if err != nil {
+		return EventEntry{}, false, err
+	}
+
+	if inserted, err := result.RowsAffected(); err == nil && inserted == 0 {
+		var existing EventEntry
+		err := q.QueryRowContext(ctx, selectEventByIDQuery, fe.ID).Scan(&existing.ID, &existing.Type, &existing.Data, &existing.Timestamp, &existing.Priority, &existing.UniqueKey)
+		if errors.Is(err, sql.ErrNoRows) && fe.UniqueKey != "" {
+			err = q.QueryRowContext(ctx, selectEventByUniqueKeyQuery, fe.UniqueKey).Scan(&existing.ID, &existing.Type, &existing.Data, &existing.Timestamp, &existing.Priority, &existing.UniqueKey)
+		}
+		if err != nil {
+			return EventEntry{}, false, err
+		}
+		existing.Duplicate = true
+
+		return existing, false, nil
+	}
+
+	return fe, true, nil
+}
+
+// Create multiple Event entries in the database, inserting them in chunks of
+// DB_BATCH_CHUNK_SIZE events per transaction so very large batches don't hold
+// the write lock for too long. If a chunk fails then, depending on
+// DB_BATCH_ROLLBACK, either every event created by the earlier chunks is
+// deleted again and the error is returned, or only that chunk is skipped and
+// the events that were created are returned along with a *BatchError. Returns
+// a slice of the events that were created, in the order they were given. A
+// chunk that finds the database locked is retried before it counts as failed.
+//
+// The commit hooks are called once with every event that was inserted and
+// kept, after the last chunk, so events that are deleted again when a later
+// chunk fails are never passed to them.
+//
+// Every event is passed to the processors registered for its type before any
+// are inserted, and if one is rejected then none of them are created.
+func (s *tursoService) CreateEvents(events []EventEntry) ([]EventEntry, error) {
+	if len(events) == 0 {
+		return []EventEntry{}, nil
+	}
+
+	events, err := s.processors.processAll(events)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, e := range events {
-		fe := initEventEntry(e)
-		_, err := stmt.ExecContext(ctx, fe.ID, fe.Type, fe.Data, fe.Timestamp)
+	newEvents := make([]EventEntry, 0, len(events))
+	var inserted []EventEntry
+	var batchErr BatchError
+
+	for start := 0; start < len(events); start += s.batchChunkSize {
+		chunk := events[start:min(start+s.batchChunkSize, len(events))]
+
+		var created, chunkInserted []EventEntry
+		err := s.retryBusy(func() (err error) {
+			created, chunkInserted, err = s.createEventsChunk(chunk)
+			return err
+		})
 		if err != nil {
-			return nil, err
+			chunkErr := ChunkError{Start: start, Count: len(chunk), Err: err}
+
+			if s.batchRollback == BatchRollbackChunk {
+				batchErr.Chunks = append(batchErr.Chunks, chunkErr)
+				continue
+			}
+
+			return nil, rollbackError(chunkErr, s.deleteInsertedEvents(inserted))
 		}
 
-		newEvents = append(newEvents, fe)
+		newEvents = append(newEvents, created...)
+		inserted = append(inserted, chunkInserted...)
+	}
+
+	s.commitHooks.run(inserted)
+
+	if len(batchErr.Chunks) > 0 {
+		return newEvents, &batchErr
 	}
 
 	return newEvents, nil
 }
 
 // Retrieves an Event entry from the DB with the given ID. Returns the Event
-// entry if found, or an error if the operation fails.
+// entry if found, ErrNotFound if there isn't one, or an error if the
+// operation fails.
 func (s *tursoService) GetEventByID(id string) (EventEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
 	defer cancel()
 
-	query := "SELECT ID, Type, Data, Timestamp FROM Events WHERE ID = ?"
-	row := s.db.QueryRowContext(ctx, query, id)
+	row := s.db.QueryRowContext(ctx, selectEventByIDQuery, id)
 
 	var event EventEntry
-	err := row.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp)
+	err := row.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EventEntry{}, ErrNotFound
+	}
 	if err != nil {
 		return EventEntry{}, err
 	}
@@ -226,13 +665,52 @@ func (s *tursoService) GetEventByID(id string) (EventEntry, error) {
 	return event, nil
 }
 
+// Retrieves the Event entries with the given IDs in a single query. IDs that
+// don't exist are left out of the result, so the returned slice may be shorter
+// than ids and isn't guaranteed to be in the same order. Returns an error if the
+// operation fails.
+func (s *tursoService) GetEventsByIDs(ids []string) ([]EventEntry, error) {
+	if len(ids) == 0 {
+		return []EventEntry{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE ID IN (" + placeholders + ")"
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EventEntry
+	for rows.Next() {
+		var event EventEntry
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
 // Retrieves all Events that have the given type. Returns a slice of Event
 // entries if found, or an error if the operation fails.
 func (s *tursoService) GetEventsByType(eventType EventType) ([]EventEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
 	defer cancel()
 
-	query := "SELECT ID, Type, Data, Timestamp FROM Events WHERE Type = ?"
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE Type = ?"
 	rows, err := s.db.QueryContext(ctx, query, eventType)
 	if err != nil {
 		return nil, err
@@ -242,7 +720,7 @@ func (s *tursoService) GetEventsByType(eventType EventType) ([]EventEntry, error
 	var events []EventEntry
 	for rows.Next() {
 		var event EventEntry
-		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp)
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
 		if err != nil {
 			return nil, err
 		}
@@ -253,17 +731,85 @@ func (s *tursoService) GetEventsByType(eventType EventType) ([]EventEntry, error
 	return events, nil
 }
 
-// Retrieves all Event entries from the DB as a slice of Event entries if found,
-// or an error if the operation fails.
-//
-// !!WARNING!! This function is not recommended for use in production as it may
-// return a large number of entries and consume a lot of memory.
-func (s *tursoService) GetEvents() ([]EventEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+// Retrieves the latest Event entries from the DB sorted by timestamp in
+// descending order, returning at most limit entries. This replaces fetching
+// every event, which could return an unbounded number of entries. Returns a
+// slice of Event entries if found, or an error if the operation fails.
+func (s *tursoService) GetLatestEvents(limit int) ([]EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events ORDER BY Timestamp DESC LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EventEntry
+	for rows.Next() {
+		var event EventEntry
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// Retrieves the latest X Event entries with the given type from the DB sorted
+// by timestamp in descending order where X is the max number of entries to
+// return. Returns a slice of Event entries if found, or an error if the
+// operation fails.
+func (s *tursoService) GetLatestEventsByType(eventType EventType, maxEntries int) ([]EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE Type = ? ORDER BY Timestamp DESC LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, query, eventType, maxEntries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EventEntry
+	for rows.Next() {
+		var event EventEntry
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// Retrieves the latest X Event entries with any of the given types from the DB
+// sorted by timestamp in descending order where X is the max number of entries
+// to return. Returns an empty slice if no types are given, a slice of Event
+// entries if found, or an error if the operation fails.
+func (s *tursoService) GetLatestEventsByTypes(types []EventType, maxEntries int) ([]EventEntry, error) {
+	if len(types) == 0 {
+		return []EventEntry{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
 	defer cancel()
 
-	query := "SELECT ID, Type, Data, Timestamp FROM Events"
-	rows, err := s.db.QueryContext(ctx, query)
+	args := make([]any, 0, len(types)+1)
+	for _, eventType := range types {
+		args = append(args, eventType)
+	}
+	args = append(args, maxEntries)
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(types)), ", ")
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE Type IN (" + placeholders + ") ORDER BY Timestamp DESC LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -272,7 +818,7 @@ func (s *tursoService) GetEvents() ([]EventEntry, error) {
 	var events []EventEntry
 	for rows.Next() {
 		var event EventEntry
-		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp)
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
 		if err != nil {
 			return nil, err
 		}
@@ -283,15 +829,44 @@ func (s *tursoService) GetEvents() ([]EventEntry, error) {
 	return events, nil
 }
 
-// Retrieves the latest X Event entries from the DB sorted by timestamp in
-// descending order where X is the max number of entries to return. Returns
-// a slice of Event entries if found, or an error if the operation fails.
-func (s *tursoService) GetLatestEvents(maxEntries int) ([]EventEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+// Retrieves the Event entry with the earliest timestamp, only considering
+// events of the given type unless it's empty. Returns ErrNotFound if there are
+// no such events, or an error if the operation fails.
+func (s *tursoService) GetOldestEvent(eventType EventType) (EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	var row *sql.Row
+	if eventType == "" {
+		row = s.db.QueryRowContext(ctx, "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events ORDER BY Timestamp ASC LIMIT 1")
+	} else {
+		row = s.db.QueryRowContext(ctx, "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE Type = ? ORDER BY Timestamp ASC LIMIT 1", eventType)
+	}
+
+	var event EventEntry
+	err := row.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EventEntry{}, ErrNotFound
+	}
+	if err != nil {
+		return EventEntry{}, err
+	}
+
+	return event, nil
+}
+
+// Retrieves up to X Event entries that were created after the event with the
+// given ID, in the order they were created, where X is the max number of
+// entries to return. Returns an empty slice if the ID doesn't exist, or an
+// error if the operation fails.
+func (s *tursoService) GetEventsAfter(id string, maxEntries int) ([]EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
 	defer cancel()
 
-	query := "SELECT ID, Type, Data, Timestamp FROM Events ORDER BY Timestamp DESC LIMIT ?"
-	rows, err := s.db.QueryContext(ctx, query, maxEntries)
+	// Timestamps may be supplied by clients so they don't reflect the order the
+	// events were created in, but the implicit rowid does.
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE rowid > (SELECT rowid FROM Events WHERE ID = ?) ORDER BY rowid LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, query, id, maxEntries)
 	if err != nil {
 		return nil, err
 	}
@@ -300,7 +875,7 @@ func (s *tursoService) GetLatestEvents(maxEntries int) ([]EventEntry, error) {
 	var events []EventEntry
 	for rows.Next() {
 		var event EventEntry
-		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp)
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
 		if err != nil {
 			return nil, err
 		}
@@ -311,21 +886,198 @@ func (s *tursoService) GetLatestEvents(maxEntries int) ([]EventEntry, error) {
 	return events, nil
 }
 
+// Retrieves every Event entry with a timestamp at or after since, sorted by
+// timestamp in descending order. Returns an empty slice if there aren't any, or
+// an error if the operation fails.
+func (s *tursoService) GetEventsSince(since time.Time) ([]EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.eventsSinceQuery, since.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EventEntry{}
+	for rows.Next() {
+		var event EventEntry
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Returns the total number of Event entries in the DB, or an error if the
+// operation fails.
+func (s *tursoService) GetEventCount() (int64, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Events").Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Retrieves the number of Event entries with any of the given types. Returns
+// 0 if no types are given, or an error if the operation fails.
+func (s *tursoService) GetEventCountByTypes(types []EventType) (int64, error) {
+	if len(types) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	args := make([]any, len(types))
+	for i, eventType := range types {
+		args[i] = eventType
+	}
+
+	var count int64
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(types)), ", ")
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Events WHERE Type IN ("+placeholders+")", args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Retrieves the number of Event entries of each type, keyed by type. Types
+// without any events are left out. Returns an error if the operation fails.
+func (s *tursoService) GetEventCountsByType() (map[EventType]int64, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT Type, COUNT(*) FROM Events GROUP BY Type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[EventType]int64{}
+	for rows.Next() {
+		var eventType EventType
+		var count int64
+		if err := rows.Scan(&eventType, &count); err != nil {
+			return nil, err
+		}
+
+		counts[eventType] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// Deletes every Event entry from the DB. Returns the number of entries that
+// were deleted, or an error if the operation fails.
+//
+// !!WARNING!! This is irreversible and only intended for test environments.
+func (s *tursoService) PurgeEvents() (int64, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.batchWriteTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM Events")
+	if err != nil {
+		return 0, err
+	}
+
+	// The deleted events can't be delivered anymore.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM outbox"); err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
 // #endregion Route Helpers
 
-// Create the Events table if it doesn't exist. If an error occurs, it will be
-// printed to the console and returned.
+// Create the Events table if it doesn't exist. Returns an error if it can't be
+// created.
 func CreateEventsTable(db *sql.DB) error {
 	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS Events (
 		ID TEXT NOT NULL PRIMARY KEY,
 		Type TEXT NOT NULL,
 		Data TEXT NOT NULL,
-		Timestamp TEXT NOT NULL
+		Timestamp TEXT NOT NULL,
+		Priority INTEGER NOT NULL DEFAULT 3,
+		UniqueKey TEXT
 	)`)
 	if err != nil {
-		fmt.Println("Error creating Events table:", err)
+		return fmt.Errorf("creating Events table: %w", err)
+	}
+
+	return addEventsColumns(db)
+}
+
+// Adds the columns, and their indexes, that were added to the Events table
+// after it was first created, so existing databases gain them.
+func addEventsColumns(db *sql.DB) error {
+	if err := addEventsPriorityColumn(db); err != nil {
 		return err
 	}
 
+	return addEventsUniqueKeyColumn(db)
+}
+
+// Adds the Priority column to an Events table created before events had a
+// priority, giving the existing events the default one. Does nothing if the
+// column already exists.
+func addEventsPriorityColumn(db *sql.DB) error {
+	rows, err := db.Query("SELECT Priority FROM Events LIMIT 0")
+	if err == nil {
+		return rows.Close()
+	}
+
+	if _, err := db.Exec("ALTER TABLE Events ADD COLUMN Priority INTEGER NOT NULL DEFAULT 3"); err != nil {
+		return fmt.Errorf("adding the Priority column to the Events table: %w", err)
+	}
+
+	return nil
+}
+
+// Adds the UniqueKey column to an Events table created before events could
+// have a unique key, along with the unique index on it. Existing events are
+// left without one. Does nothing if the column and index already exist.
+func addEventsUniqueKeyColumn(db *sql.DB) error {
+	rows, err := db.Query("SELECT UniqueKey FROM Events LIMIT 0")
+	if err == nil {
+		err = rows.Close()
+	} else {
+		_, err = db.Exec("ALTER TABLE Events ADD COLUMN UniqueKey TEXT")
+	}
+	if err != nil {
+		return fmt.Errorf("adding the UniqueKey column to the Events table: %w", err)
+	}
+
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS events_unique_key ON Events (UniqueKey)"); err != nil {
+		return fmt.Errorf("creating the Events unique key index: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,726 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/mattn/go-sqlite3"
+)
+
+// The service and the wrapper Observe returns must implement every method of
+// TursoDB, which the server calls them through.
+var (
+	_ TursoDB = (*tursoService)(nil)
+	_ TursoDB = (*observedService)(nil)
+)
+
+// Opens a fresh database in a temporary directory through New.
+func newTestService(t *testing.T) *tursoService {
+	t.Helper()
+
+	return newTestServiceWithConfig(t, config.Database{})
+}
+
+// Opens a fresh database in a temporary directory through New with the given
+// configuration, whose URL is filled in.
+func newTestServiceWithConfig(t *testing.T, cfg config.Database) *tursoService {
+	t.Helper()
+
+	cfg.URL = "file:" + filepath.Join(t.TempDir(), "shion.db")
+
+	db, ok := New(cfg, nil).(*tursoService)
+	if !ok || db == nil {
+		t.Fatal("expected New to return a *tursoService")
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// Returns the value of the given pragma on a connection from the pool.
+func queryPragma(t *testing.T, db *tursoService, pragma string) string {
+	t.Helper()
+
+	var value string
+	if err := db.db.QueryRow("PRAGMA " + pragma).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func TestSQLitePragmasDefaults(t *testing.T) {
+	db := newTestService(t)
+
+	if mode := queryPragma(t, db, "journal_mode"); !strings.EqualFold(mode, "wal") {
+		t.Errorf("unexpected journal_mode: got %q want wal", mode)
+	}
+
+	// 1 is NORMAL.
+	if synchronous := queryPragma(t, db, "synchronous"); synchronous != "1" {
+		t.Errorf("unexpected synchronous: got %q want 1", synchronous)
+	}
+
+	if timeout := queryPragma(t, db, "busy_timeout"); timeout != "5000" {
+		t.Errorf("unexpected busy_timeout: got %q want 5000", timeout)
+	}
+}
+
+func TestSQLitePragmasOverriddenByConfig(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{
+		JournalMode: "DELETE",
+		Synchronous: "FULL",
+		BusyTimeout: 250 * time.Millisecond,
+	})
+
+	if mode := queryPragma(t, db, "journal_mode"); !strings.EqualFold(mode, "delete") {
+		t.Errorf("unexpected journal_mode: got %q want delete", mode)
+	}
+
+	// 2 is FULL.
+	if synchronous := queryPragma(t, db, "synchronous"); synchronous != "2" {
+		t.Errorf("unexpected synchronous: got %q want 2", synchronous)
+	}
+
+	if timeout := queryPragma(t, db, "busy_timeout"); timeout != "250" {
+		t.Errorf("unexpected busy_timeout: got %q want 250", timeout)
+	}
+}
+
+func TestWithSQLitePragmasSkipsNonFileURLs(t *testing.T) {
+	for _, dbUrl := range []string{
+		"libsql://shion.turso.io",
+		"https://shion.turso.io",
+	} {
+		if got := withSQLitePragmas(dbUrl, config.Database{}); got != dbUrl {
+			t.Errorf("expected %q to be unchanged, got %q", dbUrl, got)
+		}
+	}
+}
+
+func TestWithSQLitePragmasSkipsWALForInMemory(t *testing.T) {
+	got := withSQLitePragmas("file::memory:?cache=shared", config.Database{})
+	if strings.Contains(got, "_journal_mode") {
+		t.Errorf("expected no journal mode for an in-memory database, got %q", got)
+	}
+}
+
+func TestTimeoutsReadFromConfig(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{
+		QueryTimeout:      100 * time.Millisecond,
+		WriteTimeout:      200 * time.Millisecond,
+		BatchWriteTimeout: 300 * time.Millisecond,
+	})
+
+	if db.queryTimeout != 100*time.Millisecond || db.writeTimeout != 200*time.Millisecond || db.batchWriteTimeout != 300*time.Millisecond {
+		t.Fatalf("unexpected timeouts: query %s, write %s, batch write %s", db.queryTimeout, db.writeTimeout, db.batchWriteTimeout)
+	}
+}
+
+func TestSlowWriteTimesOut(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{WriteTimeout: 100 * time.Millisecond})
+
+	// Make every insert run a query that never finishes on its own.
+	_, err := db.db.Exec(`CREATE TRIGGER slow_insert BEFORE INSERT ON Events BEGIN
+		SELECT count(*) FROM (WITH RECURSIVE forever(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM forever) SELECT x FROM forever);
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = db.CreateEvent(EventEntry{Type: "seq", Data: "slow"})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("expected the blocked write to fail")
+	}
+
+	if elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected the write to time out after about 100ms, took %s", elapsed)
+	}
+
+	// An override replaces the configured timeout.
+	start = time.Now()
+	_, err = db.WithTimeout(300 * time.Millisecond).CreateEvent(EventEntry{Type: "seq", Data: "slow"})
+	elapsed = time.Since(start)
+
+	if err == nil || elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected the overridden write to time out after about 300ms, took %s: %v", elapsed, err)
+	}
+}
+
+func TestWithTimeoutKeepsTheDefaultTimezone(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip("the America/Chicago timezone isn't available:", err)
+	}
+
+	db := newTestServiceWithConfig(t, config.Database{DefaultTimezone: chicago})
+
+	created, err := db.WithTimeout(time.Second).CreateEvent(EventEntry{Type: "seq", Data: "local", Timestamp: "2024-05-01T09:30:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Timestamp != "2024-05-01T14:30:00Z" {
+		t.Fatalf("expected the timestamp to be read in America/Chicago, got %s", created.Timestamp)
+	}
+}
+
+func TestSlowQueriesAreLoggedWithTheRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	cfg := config.Database{
+		URL:                "file:" + filepath.Join(t.TempDir(), "shion.db"),
+		SlowQueryThreshold: time.Nanosecond,
+	}
+
+	db := New(cfg, logger)
+	t.Cleanup(func() { db.Close() })
+
+	logs.Reset()
+	ctx, cancel := context.WithCancel(WithRequestID(context.Background(), "abc-123"))
+	scoped := db.WithContext(ctx)
+
+	// The request's context being canceled doesn't cancel its queries.
+	cancel()
+
+	if _, err := scoped.GetEventCount(); err != nil {
+		t.Fatal(err)
+	}
+
+	var line map[string]any
+	if err := json.NewDecoder(&logs).Decode(&line); err != nil {
+		t.Fatalf("expected a slow query log line, got %q: %v", logs.String(), err)
+	}
+
+	if line["msg"] != "Slow query" || line["request_id"] != "abc-123" || line["query"] == "" {
+		t.Fatalf("unexpected log line: %v", line)
+	}
+}
+
+func TestSQLiteService(t *testing.T) {
+	runServiceTests(t, newTestService)
+}
+
+func TestRebindNumbersPlaceholders(t *testing.T) {
+	query := "SELECT '?', \"a?\" FROM Events WHERE ID = ? AND Type IN (?, ?)"
+
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("expected SQLite queries to be unchanged, got %q", got)
+	}
+
+	want := "SELECT '?', \"a?\" FROM Events WHERE ID = $1 AND Type IN ($2, $3)"
+	if got := postgresDialect.rebind(query); got != want {
+		t.Errorf("unexpected Postgres query: got %q want %q", got, want)
+	}
+}
+
+func TestHealthDegradedWhenPingIsSlow(t *testing.T) {
+	db := newTestService(t)
+
+	if health := db.Health(); health.Status != HealthUp {
+		t.Fatalf("unexpected status: got %q want %q", health.Status, HealthUp)
+	}
+
+	// Any ping takes longer than a nanosecond.
+	db.degradedLatency = time.Nanosecond
+
+	health := db.Health()
+	if health.Status != HealthDegraded || health.Components["database"].Status != HealthDegraded {
+		t.Fatalf("expected the database to be degraded, got %+v", health)
+	}
+}
+
+// Makes inserting an event with "boom" as its data fail.
+func failInsertsOfBoom(t *testing.T, db *tursoService) {
+	t.Helper()
+
+	_, err := db.db.Exec(`CREATE TRIGGER fail_insert BEFORE INSERT ON Events WHEN NEW.Data = 'boom' BEGIN
+		SELECT RAISE(ABORT, 'boom');
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateEventsRollsBackEveryChunk(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{BatchChunkSize: 2})
+	failInsertsOfBoom(t, db)
+
+	existing, err := db.CreateEvent(EventEntry{Type: "seq", Data: "existing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first chunk is committed before the second fails.
+	_, err = db.CreateEvents([]EventEntry{
+		existing,
+		{Type: "seq", Data: "1"},
+		{Type: "seq", Data: "boom"},
+		{Type: "seq", Data: "3"},
+	})
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		t.Fatalf("expected a plain error when rolling back every chunk, got %v", err)
+	}
+
+	// Only the event that existed before the batch is left.
+	if count, err := db.GetEventCount(); err != nil || count != 1 {
+		t.Fatalf("expected 1 event, got %d, %v", count, err)
+	}
+
+	if _, err := db.GetEventByID(existing.ID); err != nil {
+		t.Fatalf("expected the existing event to be kept: %v", err)
+	}
+}
+
+func TestCreateEventsRollsBackFailedChunk(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{BatchChunkSize: 2, BatchRollback: "chunk"})
+	failInsertsOfBoom(t, db)
+
+	created, err := db.CreateEvents([]EventEntry{
+		{Type: "seq", Data: "0"},
+		{Type: "seq", Data: "1"},
+		{Type: "seq", Data: "2"},
+		{Type: "seq", Data: "boom"},
+		{Type: "seq", Data: "4"},
+	})
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected a *BatchError, got %v", err)
+	}
+
+	if len(batchErr.Chunks) != 1 || batchErr.Chunks[0].Start != 2 || batchErr.Chunks[0].Count != 2 {
+		t.Fatalf("unexpected failed chunks: %+v", batchErr.Chunks)
+	}
+
+	var data []string
+	for _, event := range created {
+		data = append(data, event.Data)
+	}
+
+	if want := []string{"0", "1", "4"}; !slices.Equal(data, want) {
+		t.Fatalf("unexpected created events: got %v want %v", data, want)
+	}
+
+	if count, err := db.GetEventCount(); err != nil || count != 3 {
+		t.Fatalf("expected 3 events, got %d, %v", count, err)
+	}
+}
+
+func TestEventProcessors(t *testing.T) {
+	db := newTestService(t)
+
+	db.RegisterProcessor("upper", func(e EventEntry) (EventEntry, error) {
+		e.Data = strings.ToUpper(e.Data)
+		return e, nil
+	})
+	db.RegisterProcessor("upper", func(e EventEntry) (EventEntry, error) {
+		e.Data += "!"
+		return e, nil
+	})
+	db.RegisterProcessor("forbidden", func(e EventEntry) (EventEntry, error) {
+		return EventEntry{}, errors.New("forbidden events aren't allowed")
+	})
+
+	// A type's processors run in the order they were registered.
+	event, err := db.CreateEvent(EventEntry{Type: "upper", Data: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Data != "HELLO!" {
+		t.Errorf("unexpected processed data: got %q want %q", event.Data, "HELLO!")
+	}
+
+	stored, err := db.GetEventByID(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data != "HELLO!" {
+		t.Errorf("unexpected stored data: got %q want %q", stored.Data, "HELLO!")
+	}
+
+	// Types without processors are created as they are.
+	event, err = db.CreateEvent(EventEntry{Type: "plain", Data: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Data != "hello" {
+		t.Errorf("unexpected unprocessed data: got %q want %q", event.Data, "hello")
+	}
+
+	if _, err := db.CreateEvent(EventEntry{Type: "forbidden", Data: "x"}); !errors.Is(err, ErrEventRejected) {
+		t.Fatalf("expected ErrEventRejected, got %v", err)
+	}
+
+	// A batch with a rejected event creates none of its events.
+	_, err = db.CreateEvents([]EventEntry{{Type: "upper", Data: "a"}, {Type: "forbidden", Data: "b"}})
+	if !errors.Is(err, ErrEventRejected) || !strings.Contains(err.Error(), "event 1") {
+		t.Fatalf("expected event 1 to be rejected, got %v", err)
+	}
+
+	events, err := db.CreateEvents([]EventEntry{{Type: "upper", Data: "a"}, {Type: "plain", Data: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].Data != "A!" || events[1].Data != "b" {
+		t.Errorf("unexpected processed batch: %+v", events)
+	}
+
+	all, err := db.GetLatestEvents(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Errorf("unexpected number of stored events: got %d want 4", len(all))
+	}
+}
+
+func TestCommitHooksOnlySeeCommittedEvents(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{BatchChunkSize: 2})
+	failInsertsOfBoom(t, db)
+
+	var committed []string
+	db.WithTimeout(time.Second).AfterCommit(func(events ...EventEntry) {
+		for _, event := range events {
+			committed = append(committed, event.Data)
+		}
+	})
+
+	existing, err := db.CreateEvent(EventEntry{Type: "seq", Data: "existing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither a failed create nor a resubmitted event is passed to the hooks.
+	if _, err := db.CreateEvent(EventEntry{Type: "seq", Data: "boom"}); err == nil {
+		t.Fatal("expected the event to fail")
+	}
+
+	if _, err := db.CreateEvent(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor are the events of a chunk that's deleted again when a later one
+	// fails.
+	_, err = db.CreateEvents([]EventEntry{
+		{Type: "seq", Data: "1"},
+		{Type: "seq", Data: "2"},
+		{Type: "seq", Data: "boom"},
+	})
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+
+	if _, err := db.CreateEvents([]EventEntry{existing, {Type: "seq", Data: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"existing", "3"}; !slices.Equal(committed, want) {
+		t.Fatalf("unexpected committed events: got %v want %v", committed, want)
+	}
+}
+
+func TestOutboxEntriesShareTheEventsTransaction(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{Outbox: true, BatchChunkSize: 2})
+	failInsertsOfBoom(t, db)
+
+	existing, err := db.CreateEvent(EventEntry{Type: "seq", Data: "existing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateEvent(EventEntry{Type: "seq", Data: "boom"}); err == nil {
+		t.Fatal("expected the event to fail")
+	}
+
+	// The entries of the chunk committed before the batch failed are deleted
+	// along with its events.
+	_, err = db.CreateEvents([]EventEntry{
+		{Type: "seq", Data: "1"},
+		{Type: "seq", Data: "2"},
+		{Type: "seq", Data: "boom"},
+	})
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+
+	pending, err := db.GetPendingOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 1 || pending[0].EventID != existing.ID {
+		t.Fatalf("expected only the existing event's entry, got %+v", pending)
+	}
+
+	// An event isn't created if its entry can't be.
+	_, err = db.db.Exec(`CREATE TRIGGER fail_outbox BEFORE INSERT ON outbox BEGIN
+		SELECT RAISE(ABORT, 'outbox unavailable');
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateEvent(EventEntry{Type: "seq", Data: "3"}); err == nil {
+		t.Fatal("expected the event to fail")
+	}
+
+	if count, err := db.GetEventCount(); err != nil || count != 1 {
+		t.Fatalf("expected 1 event, got %d, %v", count, err)
+	}
+}
+
+func TestRetryBusyGivesUpWithErrDatabaseBusy(t *testing.T) {
+	db := &tursoService{busyRetries: 2, busyRetryBackoff: time.Millisecond}
+	locked := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	attempts := 0
+	err := db.retryBusy(func() error {
+		attempts++
+		return locked
+	})
+
+	if attempts != 3 {
+		t.Errorf("expected the write to be tried 3 times, got %d", attempts)
+	}
+
+	if !errors.Is(err, ErrDatabaseBusy) || !errors.Is(err, locked) {
+		t.Errorf("expected ErrDatabaseBusy wrapping the driver's error, got %v", err)
+	}
+
+	// Any other error is returned straight away.
+	attempts = 0
+	failed := errors.New("constraint failed")
+	err = db.retryBusy(func() error {
+		attempts++
+		return failed
+	})
+
+	if attempts != 1 || err != failed {
+		t.Errorf("expected the error after 1 attempt, got %v after %d", err, attempts)
+	}
+}
+
+func TestPriorityColumnAddedToExistingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shion.db")
+
+	// An Events table from before events had a priority.
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE Events (ID TEXT NOT NULL PRIMARY KEY, Type TEXT NOT NULL, Data TEXT NOT NULL, Timestamp TEXT NOT NULL)",
+		"INSERT INTO Events (ID, Type, Data, Timestamp) VALUES ('old', 'seq', 'old', '2024-01-01T00:00:00Z')",
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, ok := New(config.Database{URL: "file:" + path}, nil).(*tursoService)
+	if !ok || db == nil {
+		t.Fatal("expected New to return a *tursoService")
+	}
+	t.Cleanup(func() { db.Close() })
+
+	event, err := db.GetEventByID("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Priority != DefaultPriority {
+		t.Fatalf("expected the existing event to get the default priority, got %d", event.Priority)
+	}
+
+	// Creating the tables again leaves the column alone.
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniqueKeyColumnAddedToExistingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shion.db")
+
+	// An Events table from before events could have a unique key.
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE Events (ID TEXT NOT NULL PRIMARY KEY, Type TEXT NOT NULL, Data TEXT NOT NULL, Timestamp TEXT NOT NULL, Priority INTEGER NOT NULL DEFAULT 3)",
+		"INSERT INTO Events (ID, Type, Data, Timestamp) VALUES ('old', 'seq', 'old', '2024-01-01T00:00:00Z')",
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, ok := New(config.Database{URL: "file:" + path}, nil).(*tursoService)
+	if !ok || db == nil {
+		t.Fatal("expected New to return a *tursoService")
+	}
+	t.Cleanup(func() { db.Close() })
+
+	event, err := db.GetEventByID("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.UniqueKey != "" {
+		t.Fatalf("expected the existing event to have no unique key, got %q", event.UniqueKey)
+	}
+
+	first, err := db.CreateEvent(EventEntry{Type: "seq", Data: "first", UniqueKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := db.CreateEvent(EventEntry{Type: "seq", Data: "second", UniqueKey: "k"})
+	if !errors.Is(err, ErrUniqueKeyExists) {
+		t.Fatalf("expected ErrUniqueKeyExists, got %v", err)
+	}
+	if existing.ID != first.ID {
+		t.Fatalf("expected the first event to be returned, got %+v", existing)
+	}
+
+	// Creating the tables again leaves the column and index alone.
+	if err := db.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateEventsSkipsDuplicateUniqueKeys(t *testing.T) {
+	db := newTestService(t)
+
+	first, err := db.CreateEvent(EventEntry{Type: "seq", Data: "first", UniqueKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A retry with the same ID isn't a conflict.
+	if retried, err := db.CreateEvent(first); err != nil || retried.ID != first.ID {
+		t.Fatalf("expected the retry to return the first event, got %+v, %v", retried, err)
+	}
+
+	created, err := db.CreateEvents([]EventEntry{
+		{Type: "seq", Data: "second", UniqueKey: "k"},
+		{Type: "seq", Data: "third", UniqueKey: "j"},
+		{Type: "seq", Data: "fourth", UniqueKey: "j"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 3 || created[0].ID != first.ID || created[1].ID != created[2].ID {
+		t.Fatalf("expected the duplicates to return the existing events, got %+v", created)
+	}
+	if inserted := Inserted(created); len(inserted) != 1 || inserted[0].Data != "third" {
+		t.Fatalf("expected only the third event to be inserted, got %+v", inserted)
+	}
+
+	count, err := db.GetEventCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 events to be stored, got %d", count)
+	}
+}
+
+func TestCreateEventRetriesWhileTheDatabaseIsLocked(t *testing.T) {
+	db := newTestServiceWithConfig(t, config.Database{
+		BusyTimeout:      10 * time.Millisecond,
+		BusyRetries:      5,
+		BusyRetryBackoff: 50 * time.Millisecond,
+	})
+
+	holdWriteLock(t, db, 100*time.Millisecond)
+
+	if _, err := db.CreateEvent(EventEntry{Type: "seq", Data: "1"}); err != nil {
+		t.Fatalf("expected the write to succeed once the lock was released, got %v", err)
+	}
+}
+
+func TestWithTimeoutRetriesWhileTheDatabaseIsLocked(t *testing.T) {
+	// The retries aren't configured, so the defaults are used.
+	db := newTestServiceWithConfig(t, config.Database{BusyTimeout: 10 * time.Millisecond})
+	if db.busyRetries != defaultBusyRetries {
+		t.Fatalf("expected %d retries by default, got %d", defaultBusyRetries, db.busyRetries)
+	}
+
+	holdWriteLock(t, db, 75*time.Millisecond)
+
+	if _, err := db.WithTimeout(time.Second).CreateEvent(EventEntry{Type: "seq", Data: "1"}); err != nil {
+		t.Fatalf("expected the write to succeed once the lock was released, got %v", err)
+	}
+}
+
+// Holds the write lock on the service's database from a connection of its
+// own, like another process would, and lets go of it after the given delay.
+func holdWriteLock(t *testing.T, db *tursoService, delay time.Duration) {
+	t.Helper()
+
+	var path string
+	if err := db.db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO Events (ID, Type, Data, Timestamp) VALUES ('lock', 'seq', 'lock', '2024-01-01T00:00:00Z')"); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(delay, func() { tx.Rollback() })
+}
+
+func TestRedactURL(t *testing.T) {
+	for dbUrl, want := range map[string]string{
+		"libsql://shion.turso.io?authToken=secret":  "libsql://shion.turso.io?authToken=xxxxx",
+		"postgres://shion:secret@db:5432/shion":     "postgres://shion:xxxxx@db:5432/shion",
+		"file:/var/lib/shion.db?_busy_timeout=5000": "file:/var/lib/shion.db?_busy_timeout=5000",
+	} {
+		if got := RedactURL(dbUrl); got != want {
+			t.Errorf("RedactURL(%q): got %q want %q", dbUrl, got, want)
+		}
+	}
+}
+
+func TestDescribeConnection(t *testing.T) {
+	for _, test := range []struct {
+		cfg  config.Database
+		want ConnectionInfo
+	}{
+		{config.Database{URL: "file:/var/lib/shion.db?_busy_timeout=5000"}, ConnectionInfo{"sqlite", "libsql", "/var/lib/shion.db"}},
+		{config.Database{Driver: "turso", URL: "libsql://shion.turso.io?authToken=secret"}, ConnectionInfo{"sqlite", "libsql", "shion.turso.io"}},
+		{config.Database{Driver: "postgres", URL: "postgres://shion:secret@db:5432/shion"}, ConnectionInfo{"postgres", "pgx", "db:5432"}},
+	} {
+		if got := DescribeConnection(test.cfg); got != test.want {
+			t.Errorf("DescribeConnection(%q): got %+v want %+v", test.cfg.URL, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The maximum size of a single line when decoding NDJSON.
+const maxNDJSONLineSize = 1024 * 1024
+
+// Decodes events from r one at a time without loading the whole input into
+// memory. The input may contain either a JSON array of events or
+// newline-delimited JSON (NDJSON) with one event per line.
+//
+// fn is called for every record with its 1-based position in the input and
+// either the decoded event or an error describing why that record is invalid.
+// Invalid records don't stop decoding, but a malformed JSON array does since
+// the rest of the input can't be parsed reliably. If fn returns an error then
+// decoding stops and that error is returned.
+func DecodeEvents(r io.Reader, fn func(record int, e EventEntry, err error) error) error {
+	br := bufio.NewReader(r)
+
+	first, err := peekNonSpace(br)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	if first == '[' {
+		return decodeJSONArray(br, fn)
+	}
+
+	return decodeNDJSON(br, fn)
+}
+
+// Reads every event from r, which may contain either a JSON array of events or
+// newline-delimited JSON (NDJSON) with one event per line. Returns an error if
+// any record is invalid.
+func ReadEvents(r io.Reader) ([]EventEntry, error) {
+	var events []EventEntry
+
+	err := DecodeEvents(r, func(record int, e EventEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("record %d: %w", record, err)
+		}
+
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Decodes the elements of a JSON array one at a time.
+func decodeJSONArray(r io.Reader, fn func(int, EventEntry, error) error) error {
+	dec := json.NewDecoder(r)
+
+	// Consume the opening bracket.
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	for record := 1; dec.More(); record++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("record %d: %w", record, err)
+		}
+
+		e, decodeErr := decodeEvent(raw)
+		if err := fn(record, e, decodeErr); err != nil {
+			return err
+		}
+	}
+
+	// Consume the closing bracket.
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Decodes one event per non-empty line.
+func decodeNDJSON(r io.Reader, fn func(int, EventEntry, error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineSize)
+
+	record := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		record++
+		e, decodeErr := decodeEvent(line)
+		if err := fn(record, e, decodeErr); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Unmarshals and validates a single event.
+func decodeEvent(data []byte) (EventEntry, error) {
+	var e EventEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return EventEntry{}, err
+	}
+
+	if err := e.Validate(); err != nil {
+		return EventEntry{}, err
+	}
+
+	return e, nil
+}
+
+// Returns the first non-whitespace byte in br without consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		return b, br.UnreadByte()
+	}
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The differences between the databases the service can be backed by. Queries
+// are written once with ? placeholders and rewritten for the database by
+// rebind, so only the SQL that really differs (e.g. date functions and table
+// definitions) is defined per dialect.
+type dialect struct {
+	// The name DB_DRIVER is set to to select the dialect.
+	name string
+
+	// The database/sql driver used to connect.
+	driverName string
+
+	// Whether the database uses numbered placeholders ($1, $2, ...) instead of
+	// ? placeholders.
+	numberedPlaceholders bool
+
+	// Counts the events per time bucket. Its parameters are the bucket's entry
+	// in timeSeriesBuckets, then the start and end of the time range.
+	timeSeriesQuery string
+
+	// The argument timeSeriesQuery is given for each supported bucket size.
+	timeSeriesBuckets map[string]string
+
+	// Selects the events with timestamps at or after its only parameter,
+	// newest first.
+	eventsSinceQuery string
+
+	// A condition that holds for events with timestamps before its only
+	// parameter.
+	timestampBefore string
+
+	// A condition that holds for events with timestamps at or after its only
+	// parameter.
+	timestampAtOrAfter string
+
+	// Creates every table the service uses if it doesn't already exist,
+	// returning the first error that occurs.
+	createTables func(db *sql.DB) error
+}
+
+// The SQLite dialect, used for Turso and local file: databases.
+var sqliteDialect = dialect{
+	name:       "sqlite",
+	driverName: "libsql",
+
+	// Timestamps have a varying number of fractional digits so they can't be
+	// compared as strings, but julianday parses them into numbers that can be
+	// compared to the nearest millisecond.
+	timeSeriesQuery: `SELECT strftime(?, Timestamp) AS bucket, COUNT(*) FROM Events
+		WHERE julianday(Timestamp) >= julianday(?) AND julianday(Timestamp) < julianday(?)
+		GROUP BY bucket ORDER BY bucket`,
+
+	// The strftime formats that truncate timestamps to the start of each
+	// bucket.
+	timeSeriesBuckets: map[string]string{
+		"minute": "%Y-%m-%dT%H:%M:00Z",
+		"hour":   "%Y-%m-%dT%H:00:00Z",
+		"day":    "%Y-%m-%dT00:00:00Z",
+	},
+
+	eventsSinceQuery: `SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events
+		WHERE julianday(Timestamp) >= julianday(?) ORDER BY julianday(Timestamp) DESC`,
+
+	timestampBefore:    "julianday(Timestamp) < julianday(?)",
+	timestampAtOrAfter: "julianday(Timestamp) >= julianday(?)",
+
+	createTables: func(db *sql.DB) error {
+		for _, create := range []func(*sql.DB) error{
+			CreateEventsTable,
+			CreateLocksTable,
+			CreateNoncesTable,
+			CreateEventSchemasTable,
+			CreateWebhooksTable,
+			CreateAnnotationsTable,
+			CreateJobsTable,
+			CreateOutboxTable,
+			CreateBackgroundJobsTable,
+		} {
+			if err := create(db); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+// Returns the dialect with the given name, or false if there isn't one. An
+// empty name selects SQLite.
+func dialectByName(name string) (dialect, bool) {
+	switch name {
+	case "", "sqlite", "libsql", "turso":
+		return sqliteDialect, true
+	case "postgres", "postgresql":
+		return postgresDialect, true
+	default:
+		return dialect{}, false
+	}
+}
+
+// Rewrites a query written with ? placeholders for the dialect. Question marks
+// inside quoted strings are left alone.
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// A *sql.DB that rewrites queries for its dialect before running them, and
+// logs the ones that are slow.
+type dialectDB struct {
+	*sql.DB
+
+	dialect dialect
+
+	slow slowQueryLog
+}
+
+func (db *dialectDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer db.slow.observe(ctx, query, time.Now())
+	return db.DB.ExecContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db *dialectDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer db.slow.observe(ctx, query, time.Now())
+	return db.DB.QueryContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db *dialectDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer db.slow.observe(ctx, query, time.Now())
+	return db.DB.QueryRowContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db *dialectDB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.dialect.rebind(query))
+}
+
+func (db *dialectDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return db.DB.PrepareContext(ctx, db.dialect.rebind(query))
+}
+
+func (db *dialectDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dialectTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dialectTx{Tx: tx, dialect: db.dialect, slow: db.slow}, nil
+}
+
+// A *sql.Tx that rewrites queries for its dialect before running them, and
+// logs the ones that are slow.
+type dialectTx struct {
+	*sql.Tx
+
+	dialect dialect
+
+	slow slowQueryLog
+}
+
+func (tx *dialectTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer tx.slow.observe(ctx, query, time.Now())
+	return tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *dialectTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer tx.slow.observe(ctx, query, time.Now())
+	return tx.Tx.QueryContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *dialectTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer tx.slow.observe(ctx, query, time.Now())
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *dialectTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(ctx, tx.dialect.rebind(query))
+}
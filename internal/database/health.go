@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The statuses reported for the service and each of its components.
+const (
+	// Everything is working normally.
+	HealthUp = "up"
+
+	// The component works but is slow or under pressure.
+	HealthDegraded = "degraded"
+
+	// The component isn't working.
+	HealthDown = "down"
+)
+
+// The default ping latency above which the database is reported as degraded,
+// used when DB_HEALTH_DEGRADED_LATENCY_MS is unset.
+const defaultDegradedLatency = 500 * time.Millisecond
+
+// The tables every dialect creates, which the schema component checks for.
+var requiredTables = []string{"Events", "locks", "nonces", "event_schemas", "webhooks", "annotations", "jobs", "outbox", "background_jobs"}
+
+// The health of the service as a whole and of each of its components.
+type HealthStatus struct {
+	// The worst status of any component: up, degraded, or down.
+	Status string `json:"status"`
+
+	// The health of each component, keyed by name.
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// The health of a single component of the service.
+type ComponentHealth struct {
+	// Whether the component is up, degraded, or down.
+	Status string `json:"status"`
+
+	// Why the component is degraded or down, if it is.
+	Message string `json:"message,omitempty"`
+
+	// How long the component's health check took.
+	Latency time.Duration `json:"latency"`
+
+	// Extra information about the component, e.g. connection pool statistics.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Writes the latency as a duration string, e.g. "1.5ms", instead of a number
+// of nanoseconds.
+func (h ComponentHealth) MarshalJSON() ([]byte, error) {
+	type componentHealth ComponentHealth
+
+	return json.Marshal(struct {
+		componentHealth
+		Latency string `json:"latency"`
+	}{componentHealth(h), h.Latency.String()})
+}
+
+// Checks the health of the database connection and schema. The overall status
+// is the worst status of any component.
+func (s *tursoService) Health() HealthStatus {
+	components := map[string]ComponentHealth{
+		"database": s.databaseHealth(),
+		"schema":   s.schemaHealth(),
+	}
+
+	status := HealthUp
+	for _, component := range components {
+		switch {
+		case component.Status == HealthDown:
+			status = HealthDown
+		case component.Status == HealthDegraded && status == HealthUp:
+			status = HealthDegraded
+		}
+	}
+
+	return HealthStatus{Status: status, Components: components}
+}
+
+// Pings the database and reports it as degraded if the ping is slow or the
+// connection pool statistics point to a bottleneck.
+func (s *tursoService) databaseHealth() ComponentHealth {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.db.PingContext(ctx)
+	latency := time.Since(start)
+
+	if err != nil {
+		return ComponentHealth{Status: HealthDown, Message: fmt.Sprintf("db down: %v", err), Latency: latency}
+	}
+
+	// Get database stats (like open connections, in use, idle, etc.)
+	dbStats := s.db.Stats()
+	health := ComponentHealth{
+		Status:  HealthUp,
+		Message: "It's healthy",
+		Latency: latency,
+		Details: map[string]string{
+			"open_connections":    strconv.Itoa(dbStats.OpenConnections),
+			"in_use":              strconv.Itoa(dbStats.InUse),
+			"idle":                strconv.Itoa(dbStats.Idle),
+			"wait_count":          strconv.FormatInt(dbStats.WaitCount, 10),
+			"wait_duration":       dbStats.WaitDuration.String(),
+			"max_idle_closed":     strconv.FormatInt(dbStats.MaxIdleClosed, 10),
+			"max_lifetime_closed": strconv.FormatInt(dbStats.MaxLifetimeClosed, 10),
+		},
+	}
+
+	degrade := func(message string) {
+		health.Status = HealthDegraded
+		health.Message = message
+	}
+
+	// Evaluate stats to provide a health message
+	if dbStats.OpenConnections > 40 { // Assuming 50 is the max for this example
+		degrade("The database is experiencing heavy load.")
+	}
+
+	if dbStats.WaitCount > 1000 {
+		degrade("The database has a high number of wait events, indicating potential bottlenecks.")
+	}
+
+	if dbStats.MaxIdleClosed > int64(dbStats.OpenConnections)/2 {
+		degrade("Many idle connections are being closed, consider revising the connection pool settings.")
+	}
+
+	if dbStats.MaxLifetimeClosed > int64(dbStats.OpenConnections)/2 {
+		degrade("Many connections are being closed due to max lifetime, consider increasing max lifetime or revising the connection usage pattern.")
+	}
+
+	if latency > s.degradedLatency {
+		degrade(fmt.Sprintf("The database took %s to respond to a ping.", latency))
+	}
+
+	return health
+}
+
+// Checks that every table the service uses exists and can be queried.
+func (s *tursoService) schemaHealth() ComponentHealth {
+	start := time.Now()
+	if err := s.CheckSchema(); err != nil {
+		return ComponentHealth{Status: HealthDown, Message: err.Error(), Latency: time.Since(start)}
+	}
+
+	return ComponentHealth{Status: HealthUp, Latency: time.Since(start)}
+}
+
+// Checks that CreateTables has created every table the service uses and that
+// each one can be queried, returning an error naming the first one that
+// can't.
+func (s *tursoService) CheckSchema() error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	for _, table := range requiredTables {
+		// An empty table is fine, so only other errors mean it's unavailable.
+		// Scanning rather than just preparing the query catches tables dropped
+		// by another connection, which SQLite only notices once it's stepped.
+		var one int
+		err := s.db.QueryRowContext(ctx, "SELECT 1 FROM "+table+" LIMIT 1").Scan(&one)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("table %s is unavailable: %w", table, err)
+		}
+	}
+
+	return nil
+}
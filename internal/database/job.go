@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+// Returned when the requested ingestion job doesn't exist.
+var ErrJobNotFound = errors.New("job not found")
+
+// The state of an ingestion job.
+type JobStatus string
+
+const (
+	// The job is waiting for a worker.
+	JobPending JobStatus = "pending"
+
+	// A worker is creating the job's events.
+	JobRunning JobStatus = "running"
+
+	// The job finished. Some chunks of events may still have failed when
+	// DB_BATCH_ROLLBACK is chunk, which are listed in its errors.
+	JobCompleted JobStatus = "completed"
+
+	// None of the job's events were created.
+	JobFailed JobStatus = "failed"
+)
+
+// Describes a chunk of a job's events that couldn't be created.
+type JobError struct {
+	// The index of the chunk's first event in the job.
+	Start int `json:"start"`
+
+	// The number of events in the chunk.
+	Count int `json:"count"`
+
+	Error string `json:"error"`
+}
+
+// A batch of events created in the background, e.g. through POST
+// /events?async=true, whose progress is stored so it survives a restart.
+type Job struct {
+	// The unique identifier for the job, generated by the shortuuid package.
+	ID string `json:"id"`
+
+	Status JobStatus `json:"status"`
+
+	// The number of events in the job.
+	Total int `json:"total"`
+
+	// The number of events that were created, once the job has finished.
+	Created int `json:"created"`
+
+	// The number of events that couldn't be created, once the job has
+	// finished.
+	Failed int `json:"failed"`
+
+	// Why the job failed, or empty if it didn't.
+	Error string `json:"error,omitempty"`
+
+	// The chunks of events that couldn't be created when only some failed.
+	Errors []JobError `json:"errors,omitempty"`
+
+	// When the job was created and last changed in RFC 3339 format.
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+
+	// The events to create. Only loaded for jobs that haven't finished, and
+	// cleared once they have.
+	Events []EventEntry `json:"-"`
+}
+
+// The columns of the jobs table in the order they're scanned by scanJob,
+// excluding the events.
+const jobColumns = "id, status, total, created, failed, error, errors, created_at, updated_at"
+
+// Creates a pending job for the given events. Events without an ID or
+// timestamp have them filled in first, so running the job again after a
+// restart returns the events it already created instead of duplicating them.
+func (s *tursoService) CreateJob(events []EventEntry) (Job, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	job := Job{
+		ID:        shortuuid.New(),
+		Status:    JobPending,
+		Total:     len(events),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Events:    make([]EventEntry, len(events)),
+	}
+
+	for i, event := range events {
+		job.Events[i] = initEventEntry(event, s.location)
+	}
+
+	payload, err := json.Marshal(job.Events)
+	if err != nil {
+		return Job{}, err
+	}
+
+	query := "INSERT INTO jobs (" + jobColumns + ", events) VALUES (?, ?, ?, 0, 0, '', '[]', ?, ?, ?)"
+	_, err = s.db.ExecContext(ctx, query, job.ID, job.Status, job.Total, job.CreatedAt, job.UpdatedAt, string(payload))
+	if err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// Retrieves the job with the given ID, without its events. Returns
+// ErrJobNotFound if it doesn't exist, or an error if the operation fails.
+func (s *tursoService) GetJob(id string) (Job, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	job, err := scanJob(s.db.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, ErrJobNotFound
+	}
+
+	return job, err
+}
+
+// Retrieves the jobs that haven't finished, oldest first, along with their
+// events so they can be run again, e.g. after a restart.
+func (s *tursoService) GetIncompleteJobs() ([]Job, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	query := "SELECT " + jobColumns + ", events FROM jobs WHERE status IN (?, ?) ORDER BY created_at"
+	rows, err := s.db.QueryContext(ctx, query, JobPending, JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var payload string
+		job, err := scanJob(rows, &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(payload), &job.Events); err != nil {
+			return nil, fmt.Errorf("decoding the events of job %s: %w", job.ID, err)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// Marks the job with the given ID as running. Returns ErrJobNotFound if it
+// doesn't exist, or an error if the operation fails.
+func (s *tursoService) StartJob(id string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	result, err := s.db.ExecContext(ctx, "UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?", JobRunning, now, id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// Stores the outcome of a job, i.e. its status, counts, and errors, and clears
+// its events since it won't be run again. Returns ErrJobNotFound if it doesn't
+// exist, or an error if the operation fails.
+func (s *tursoService) FinishJob(job Job) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	errs := job.Errors
+	if errs == nil {
+		errs = []JobError{}
+	}
+
+	encodedErrs, err := json.Marshal(errs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	query := `UPDATE jobs SET status = ?, created = ?, failed = ?, error = ?, errors = ?, events = '[]', updated_at = ?
+		WHERE id = ?`
+	result, err := s.db.ExecContext(ctx, query, job.Status, job.Created, job.Failed, job.Error, string(encodedErrs), now, job.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// Scans a row selected with jobColumns into a Job. Any extra columns selected
+// after them are scanned into extra.
+func scanJob(row interface{ Scan(dest ...any) error }, extra ...any) (Job, error) {
+	var job Job
+	var errs string
+
+	dest := append([]any{&job.ID, &job.Status, &job.Total, &job.Created, &job.Failed, &job.Error, &errs, &job.CreatedAt, &job.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Job{}, err
+	}
+
+	if err := json.Unmarshal([]byte(errs), &job.Errors); err != nil {
+		return Job{}, fmt.Errorf("decoding the errors of job %s: %w", job.ID, err)
+	}
+
+	if len(job.Errors) == 0 {
+		job.Errors = nil
+	}
+
+	return job, nil
+}
+
+// Create the jobs table if it doesn't exist, which stores ingestion jobs and
+// the events of the ones that haven't finished yet as a JSON array. Returns an
+// error if it can't be created.
+func CreateJobsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT NOT NULL PRIMARY KEY,
+		status TEXT NOT NULL,
+		total INTEGER NOT NULL,
+		created INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		errors TEXT NOT NULL DEFAULT '[]',
+		events TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating jobs table: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Tries to acquire the lock with the given key for the given duration, which is
+// stored in the locks table so it's shared by every instance using the same
+// database. Returns true if the lock was acquired, or false if it's already
+// held and hasn't expired yet. A lock whose TTL has passed is treated as
+// released, so a holder that crashes can't block the key forever.
+func (s *tursoService) AcquireLock(key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM locks WHERE key = ? AND expires_at <= ?", key, now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.db.ExecContext(ctx, "INSERT INTO locks (key, expires_at) VALUES (?, ?) ON CONFLICT (key) DO NOTHING", key, now.Add(ttl).UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return inserted == 1, nil
+}
+
+// Releases the lock with the given key. Releasing a lock that isn't held is a
+// no-op.
+func (s *tursoService) ReleaseLock(key string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM locks WHERE key = ?", key)
+	return err
+}
+
+// Create the locks table if it doesn't exist. The expires_at column holds the
+// Unix time in milliseconds the lock expires at. Returns an error if it can't
+// be created.
+func CreateLocksTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS locks (
+		key TEXT NOT NULL PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating locks table: %w", err)
+	}
+
+	return nil
+}
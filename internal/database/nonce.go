@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Records that the given nonce, e.g. an event ID, has been seen until it
+// expires at the given time. Returns true if it was recorded, or false if it
+// was already seen and hasn't expired, i.e. it's being replayed. Expired
+// nonces are removed first, so the table only holds the ones that are still
+// live.
+func (s *tursoService) RecordNonce(nonce string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM nonces WHERE expires_at <= ?", time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.db.ExecContext(ctx, "INSERT INTO nonces (nonce, expires_at) VALUES (?, ?) ON CONFLICT (nonce) DO NOTHING", nonce, expiresAt.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return inserted == 1, nil
+}
+
+// Forgets the given nonce so it can be used again, e.g. when the request that
+// recorded it failed. Forgetting a nonce that wasn't recorded is a no-op.
+func (s *tursoService) DeleteNonce(nonce string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM nonces WHERE nonce = ?", nonce)
+	return err
+}
+
+// Create the nonces table if it doesn't exist. The expires_at column holds the
+// Unix time in milliseconds the nonce expires at. Returns an error if it can't
+// be created.
+func CreateNoncesTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS nonces (
+		nonce TEXT NOT NULL PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating nonces table: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Receives the service's event operations as they run, e.g. to export how long
+// they take as metrics or to trace them.
+type Observer interface {
+	// Called when an operation, e.g. create_event, starts with the context
+	// it's running in. Returns the context the operation runs in instead, e.g.
+	// one with a span of its own, and a function that's called once it
+	// finishes.
+	StartOperation(ctx context.Context, operation string) (context.Context, OperationDone)
+
+	// Called with the number of events each CreateEvents call is given, along
+	// with the context returned by StartOperation.
+	ObserveBatchSize(ctx context.Context, size int)
+}
+
+// Called once an operation finishes with how long it took, the number of rows
+// it returned or wrote, and whether it failed.
+type OperationDone func(elapsed time.Duration, rows int, err error)
+
+// Returns the service wrapped so the event operations, which are the ones on
+// the request path, are reported to the observer. Every other method is passed
+// through untouched. The copies returned by WithTimeout and WithContext are
+// wrapped as well, and an operation runs in the context the observer returns
+// for it.
+func Observe(db TursoDB, observer Observer) TursoDB {
+	return &observedService{TursoDB: db, observer: observer, ctx: context.Background()}
+}
+
+// A TursoDB that reports its event operations to an Observer.
+type observedService struct {
+	TursoDB
+
+	observer Observer
+
+	// The context set by WithContext, which operations are started in.
+	ctx context.Context
+}
+
+// Starts the operation with the given name, returning the context and copy of
+// the service it runs with, and a function to call with the number of rows it
+// returned or wrote and its error once it finishes.
+func (s *observedService) start(operation string) (context.Context, TursoDB, func(rows int, err error)) {
+	ctx, done := s.observer.StartOperation(s.ctx, operation)
+
+	db := s.TursoDB
+	if ctx != s.ctx {
+		db = db.WithContext(ctx)
+	}
+
+	start := time.Now()
+
+	return ctx, db, func(rows int, err error) {
+		if err != nil {
+			rows = 0
+		}
+
+		done(time.Since(start), rows, err)
+	}
+}
+
+func (s *observedService) Ping() error {
+	_, db, finish := s.start("ping")
+	err := db.Ping()
+	finish(0, err)
+
+	return err
+}
+
+func (s *observedService) CreateEvent(e EventEntry) (EventEntry, error) {
+	_, db, finish := s.start("create_event")
+	event, err := db.CreateEvent(e)
+	finish(1, err)
+
+	return event, err
+}
+
+func (s *observedService) CreateEvents(events []EventEntry) ([]EventEntry, error) {
+	ctx, db, finish := s.start("create_events")
+	s.observer.ObserveBatchSize(ctx, len(events))
+
+	created, err := db.CreateEvents(events)
+	finish(len(created), err)
+
+	return created, err
+}
+
+func (s *observedService) GetEventByID(id string) (EventEntry, error) {
+	_, db, finish := s.start("get_event_by_id")
+	event, err := db.GetEventByID(id)
+	finish(1, err)
+
+	return event, err
+}
+
+func (s *observedService) GetEventsByIDs(ids []string) ([]EventEntry, error) {
+	_, db, finish := s.start("get_events_by_ids")
+	events, err := db.GetEventsByIDs(ids)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) PatchEvent(id string, fields map[string]interface{}) (EventEntry, error) {
+	_, db, finish := s.start("patch_event")
+	event, err := db.PatchEvent(id, fields)
+	finish(1, err)
+
+	return event, err
+}
+
+func (s *observedService) GetLatestEvents(limit int) ([]EventEntry, error) {
+	_, db, finish := s.start("get_latest_events")
+	events, err := db.GetLatestEvents(limit)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetLatestEventsByType(eventType EventType, maxEntries int) ([]EventEntry, error) {
+	_, db, finish := s.start("get_latest_events_by_type")
+	events, err := db.GetLatestEventsByType(eventType, maxEntries)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetLatestEventsByTypes(types []EventType, maxEntries int) ([]EventEntry, error) {
+	_, db, finish := s.start("get_latest_events_by_types")
+	events, err := db.GetLatestEventsByTypes(types, maxEntries)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetEventsSorted(sortBy, order string, types []EventType, maxEntries int) ([]EventEntry, error) {
+	_, db, finish := s.start("get_events_sorted")
+	events, err := db.GetEventsSorted(sortBy, order, types, maxEntries)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetEventsAfter(id string, maxEntries int) ([]EventEntry, error) {
+	_, db, finish := s.start("get_events_after")
+	events, err := db.GetEventsAfter(id, maxEntries)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetEventsSince(since time.Time) ([]EventEntry, error) {
+	_, db, finish := s.start("get_events_since")
+	events, err := db.GetEventsSince(since)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetEventCount() (int64, error) {
+	_, db, finish := s.start("get_event_count")
+	count, err := db.GetEventCount()
+	finish(1, err)
+
+	return count, err
+}
+
+func (s *observedService) GetEventCountByTypes(types []EventType) (int64, error) {
+	_, db, finish := s.start("get_event_count_by_types")
+	count, err := db.GetEventCountByTypes(types)
+	finish(1, err)
+
+	return count, err
+}
+
+func (s *observedService) GetEventCountsByType() (map[EventType]int64, error) {
+	_, db, finish := s.start("get_event_counts_by_type")
+	counts, err := db.GetEventCountsByType()
+	finish(len(counts), err)
+
+	return counts, err
+}
+
+func (s *observedService) GetTopPriorityEvents(minPriority int, max int) ([]EventEntry, error) {
+	_, db, finish := s.start("get_top_priority_events")
+	events, err := db.GetTopPriorityEvents(minPriority, max)
+	finish(len(events), err)
+
+	return events, err
+}
+
+func (s *observedService) GetEventCountByMinPriority(minPriority int) (int64, error) {
+	_, db, finish := s.start("get_event_count_by_min_priority")
+	count, err := db.GetEventCountByMinPriority(minPriority)
+	finish(1, err)
+
+	return count, err
+}
+
+func (s *observedService) WithTimeout(timeout time.Duration) TursoDB {
+	return &observedService{TursoDB: s.TursoDB.WithTimeout(timeout), observer: s.observer, ctx: s.ctx}
+}
+
+func (s *observedService) WithContext(ctx context.Context) TursoDB {
+	return &observedService{TursoDB: s.TursoDB.WithContext(ctx), observer: s.observer, ctx: ctx}
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Returned when the requested outbox entry doesn't exist or isn't in the state
+// the operation needs, e.g. requeueing an entry that isn't dead-lettered.
+var ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+
+// The state of an outbox entry.
+type OutboxStatus string
+
+const (
+	// The event still has to be delivered to at least one sink.
+	OutboxPending OutboxStatus = "pending"
+
+	// Delivering the event failed too many times, so the relay gave up until
+	// it's requeued.
+	OutboxDead OutboxStatus = "dead"
+)
+
+// A notification that an event was created, stored in the outbox table in the
+// same transaction as the event so it's delivered to the downstream sinks
+// (webhooks, NATS, and Kafka) even if they're down or the process restarts
+// before it's sent. Entries are removed once every sink has acknowledged the
+// event.
+type OutboxEntry struct {
+	// The ID of the event to deliver.
+	EventID string `json:"event_id"`
+
+	Status OutboxStatus `json:"status"`
+
+	// The number of delivery attempts that have failed.
+	Attempts int `json:"attempts"`
+
+	// The sinks that have already acknowledged the event, which aren't sent it
+	// again when the entry is retried.
+	Delivered []string `json:"delivered"`
+
+	// Why the last attempt failed, or empty if none have.
+	LastError string `json:"last_error,omitempty"`
+
+	// When the entry was created and last changed in RFC 3339 format.
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+
+	// The event to deliver.
+	Event EventEntry `json:"event"`
+}
+
+// Adds a pending outbox entry for the event with the given ID, e.g. inside the
+// transaction that created it. Does nothing if the event already has one.
+func insertOutboxEntry(ctx context.Context, q execer, eventID string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	_, err := q.ExecContext(ctx, `INSERT INTO outbox (event_id, status, attempts, delivered, last_error, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, 0, '[]', '', 0, ?, ?) ON CONFLICT (event_id) DO NOTHING`, eventID, OutboxPending, now, now)
+	return err
+}
+
+// The columns selected by scanOutboxEntry, with the outbox table aliased as o
+// and the Events table as e.
+const outboxColumns = "o.event_id, o.status, o.attempts, o.delivered, o.last_error, o.created_at, o.updated_at, e.ID, e.Type, e.Data, e.Timestamp, e.Priority, COALESCE(e.UniqueKey, '')"
+
+// Retrieves up to limit pending outbox entries that are due to be delivered,
+// in the order their events were created, along with the events. Returns an
+// error if the operation fails.
+func (s *tursoService) GetPendingOutbox(limit int) ([]OutboxEntry, error) {
+	query := "SELECT " + outboxColumns + ` FROM outbox o JOIN Events e ON e.ID = o.event_id
+		WHERE o.status = ? AND o.next_attempt_at <= ? ORDER BY o.rowid LIMIT ?`
+
+	return s.queryOutbox(query, OutboxPending, time.Now().UnixMilli(), limit)
+}
+
+// Retrieves up to limit outbox entries with the given status, oldest first,
+// along with their events. Returns an error if the operation fails.
+func (s *tursoService) ListOutbox(status OutboxStatus, limit int) ([]OutboxEntry, error) {
+	query := "SELECT " + outboxColumns + ` FROM outbox o JOIN Events e ON e.ID = o.event_id
+		WHERE o.status = ? ORDER BY o.rowid LIMIT ?`
+
+	return s.queryOutbox(query, status, limit)
+}
+
+// Removes the outbox entry for the event with the given ID once every sink has
+// acknowledged the event. Removing an entry that doesn't exist is a no-op.
+func (s *tursoService) MarkOutboxSent(eventID string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM outbox WHERE event_id = ?", eventID)
+	return err
+}
+
+// Records a failed delivery attempt for the event with the given ID, along
+// with the sinks that have acknowledged it so far. The entry is retried at
+// retryAt unless this was attempt maxAttempts, in which case it's dead-lettered
+// instead. Returns whether the entry was dead-lettered, ErrOutboxEntryNotFound
+// if it doesn't exist, or an error if the operation fails.
+func (s *tursoService) RecordOutboxFailure(eventID string, delivered []string, deliveryErr error, retryAt time.Time, maxAttempts int) (bool, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	if delivered == nil {
+		delivered = []string{}
+	}
+
+	encoded, err := json.Marshal(delivered)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var status OutboxStatus
+	query := `UPDATE outbox SET attempts = attempts + 1, delivered = ?, last_error = ?, next_attempt_at = ?, updated_at = ?,
+		status = CASE WHEN attempts + 1 >= ? THEN ? ELSE status END
+		WHERE event_id = ? RETURNING status`
+	err = s.db.QueryRowContext(ctx, query, string(encoded), deliveryErr.Error(), retryAt.UnixMilli(), now, maxAttempts, OutboxDead, eventID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrOutboxEntryNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return status == OutboxDead, nil
+}
+
+// Moves the dead-lettered outbox entry for the event with the given ID back to
+// pending with its attempts reset, so it's delivered again straight away. The
+// sinks that already acknowledged the event aren't sent it again. Returns
+// ErrOutboxEntryNotFound if there's no dead-lettered entry for the event, or an
+// error if the operation fails.
+func (s *tursoService) RequeueOutbox(eventID string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	query := "UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = 0, updated_at = ? WHERE event_id = ? AND status = ?"
+	result, err := s.db.ExecContext(ctx, query, OutboxPending, now, eventID, OutboxDead)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrOutboxEntryNotFound
+	}
+
+	return nil
+}
+
+// Runs a query selecting outboxColumns and scans the entries it returns.
+func (s *tursoService) queryOutbox(query string, args ...any) ([]OutboxEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []OutboxEntry{}
+	for rows.Next() {
+		var entry OutboxEntry
+		var delivered string
+
+		err := rows.Scan(&entry.EventID, &entry.Status, &entry.Attempts, &delivered, &entry.LastError, &entry.CreatedAt, &entry.UpdatedAt,
+			&entry.Event.ID, &entry.Event.Type, &entry.Event.Data, &entry.Event.Timestamp, &entry.Event.Priority, &entry.Event.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(delivered), &entry.Delivered); err != nil {
+			return nil, fmt.Errorf("decoding the delivered sinks of outbox entry %s: %w", entry.EventID, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Deletes the outbox entries of the events with the given IDs, e.g. when the
+// events themselves are deleted.
+func deleteOutboxEntries(ctx context.Context, q execer, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	_, err := q.ExecContext(ctx, "DELETE FROM outbox WHERE event_id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", args...)
+	return err
+}
+
+// Create the outbox table if it doesn't exist, which holds the events that
+// still have to be delivered to the downstream sinks. The delivered column is
+// a JSON array of sink names, and next_attempt_at holds the Unix time in
+// milliseconds the entry is retried at. Returns an error if it can't be
+// created.
+func CreateOutboxTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS outbox (
+		event_id TEXT NOT NULL PRIMARY KEY,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		delivered TEXT NOT NULL DEFAULT '[]',
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at INTEGER NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating outbox table: %w", err)
+	}
+
+	return nil
+}
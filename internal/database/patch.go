@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// Returned when patching a field that can't be changed after an event is
+	// created.
+	ErrReadOnlyField = errors.New("field is read-only")
+
+	// Returned when patching a field that doesn't exist on an event.
+	ErrUnknownField = errors.New("unknown field")
+
+	// Returned when a patched field has a value of the wrong type or format.
+	ErrInvalidFieldValue = errors.New("invalid field value")
+)
+
+// The fields that can't be patched, keyed by their JSON name.
+var readOnlyFields = map[string]struct{}{
+	"id":           {},
+	"created_at":   {},
+	"content_hash": {},
+	"unique_key":   {},
+}
+
+// The columns that can be patched, keyed by their JSON name.
+var patchableColumns = map[string]string{
+	"type":      "Type",
+	"data":      "Data",
+	"timestamp": "Timestamp",
+	"priority":  "Priority",
+}
+
+// Updates only the given fields of the Event entry with the given ID, where the
+// keys are the JSON field names (e.g. "data"), leaving every other field
+// untouched. Returns the updated Event entry, ErrNotFound if there is no event
+// with the given ID, or an error wrapping ErrReadOnlyField, ErrUnknownField, or
+// ErrInvalidFieldValue if the fields can't be applied.
+func (s *tursoService) PatchEvent(id string, fields map[string]interface{}) (EventEntry, error) {
+	if len(fields) == 0 {
+		return EventEntry{}, fmt.Errorf("%w: no fields to update", ErrInvalidFieldValue)
+	}
+
+	// Sort the keys so the same set of fields always builds the same query.
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	assignments := make([]string, 0, len(keys))
+	args := make([]any, 0, len(keys)+1)
+
+	for _, key := range keys {
+		if _, ok := readOnlyFields[key]; ok {
+			return EventEntry{}, fmt.Errorf("%w: %s", ErrReadOnlyField, key)
+		}
+
+		column, ok := patchableColumns[key]
+		if !ok {
+			return EventEntry{}, fmt.Errorf("%w: %s", ErrUnknownField, key)
+		}
+
+		value, err := patchValue(key, fields[key], s.location)
+		if err != nil {
+			return EventEntry{}, err
+		}
+
+		assignments = append(assignments, column+" = ?")
+		args = append(args, value)
+	}
+	args = append(args, id)
+
+	query := "UPDATE Events SET " + strings.Join(assignments, ", ") + " WHERE ID = ?"
+
+	var result sql.Result
+	err := s.retryBusy(func() (err error) {
+		ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+		defer cancel()
+
+		result, err = s.db.ExecContext(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return EventEntry{}, err
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return EventEntry{}, ErrNotFound
+	}
+
+	return s.GetEventByID(id)
+}
+
+// Validates and normalizes the value of a single patched field. A timestamp
+// without a UTC offset is taken to be in loc.
+func patchValue(key string, value interface{}, loc *time.Location) (any, error) {
+	if key == "priority" {
+		return patchPriority(value)
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s must be a string", ErrInvalidFieldValue, key)
+	}
+
+	switch key {
+	case "type":
+		if str == "" {
+			return "", fmt.Errorf("%w: type can't be empty", ErrInvalidFieldValue)
+		}
+	case "timestamp":
+		ts, err := ParseTimestamp(str, loc)
+		if err != nil {
+			return "", fmt.Errorf("%w: timestamp must be in RFC 3339 format", ErrInvalidFieldValue)
+		}
+		str = ts.UTC().Format(time.RFC3339Nano)
+	}
+
+	return str, nil
+}
+
+// Validates a patched priority, which must be a whole number from MinPriority
+// to MaxPriority. Numbers decoded from JSON are float64s.
+func patchPriority(value interface{}) (int, error) {
+	var priority float64
+	switch v := value.(type) {
+	case int:
+		priority = float64(v)
+	case float64:
+		priority = v
+	default:
+		return 0, fmt.Errorf("%w: priority must be a number", ErrInvalidFieldValue)
+	}
+
+	if priority != math.Trunc(priority) || priority < MinPriority || priority > MaxPriority {
+		return 0, fmt.Errorf("%w: priority must be a whole number between %d and %d", ErrInvalidFieldValue, MinPriority, MaxPriority)
+	}
+
+	return int(priority), nil
+}
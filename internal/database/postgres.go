@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// The Postgres dialect, selected with DB_DRIVER=postgres.
+var postgresDialect = dialect{
+	name:                 "postgres",
+	driverName:           "pgx",
+	numberedPlaceholders: true,
+
+	timeSeriesQuery: `SELECT to_char(date_trunc(?, CAST(Timestamp AS timestamptz) AT TIME ZONE 'UTC'), 'YYYY-MM-DD"T"HH24:MI:SS"Z"') AS bucket, COUNT(*) FROM Events
+		WHERE CAST(Timestamp AS timestamptz) >= CAST(? AS timestamptz) AND CAST(Timestamp AS timestamptz) < CAST(? AS timestamptz)
+		GROUP BY bucket ORDER BY bucket`,
+
+	// The date_trunc field names for each bucket.
+	timeSeriesBuckets: map[string]string{
+		"minute": "minute",
+		"hour":   "hour",
+		"day":    "day",
+	},
+
+	eventsSinceQuery: `SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events
+		WHERE CAST(Timestamp AS timestamptz) >= CAST(? AS timestamptz) ORDER BY CAST(Timestamp AS timestamptz) DESC`,
+
+	timestampBefore:    "CAST(Timestamp AS timestamptz) < CAST(? AS timestamptz)",
+	timestampAtOrAfter: "CAST(Timestamp AS timestamptz) >= CAST(? AS timestamptz)",
+
+	createTables: createPostgresTables,
+}
+
+// The Postgres versions of the tables created by the Create*Table functions,
+// which are also the tables the Postgres tests empty between tests. Tables
+// that are read in the order rows were inserted get a rowid column standing in
+// for SQLite's implicit one, so the same queries work on both.
+var postgresTables = []postgresTable{
+	{"Events", `
+		rowid BIGSERIAL NOT NULL UNIQUE,
+		ID TEXT NOT NULL PRIMARY KEY,
+		Type TEXT NOT NULL,
+		Data TEXT NOT NULL,
+		Timestamp TEXT NOT NULL,
+		Priority INTEGER NOT NULL DEFAULT 3,
+		UniqueKey TEXT
+	`},
+	{"locks", `
+		key TEXT NOT NULL PRIMARY KEY,
+		expires_at BIGINT NOT NULL
+	`},
+	{"nonces", `
+		nonce TEXT NOT NULL PRIMARY KEY,
+		expires_at BIGINT NOT NULL
+	`},
+	{"jobs", `
+		id TEXT NOT NULL PRIMARY KEY,
+		status TEXT NOT NULL,
+		total INTEGER NOT NULL,
+		created INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		errors TEXT NOT NULL DEFAULT '[]',
+		events TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	`},
+	{"background_jobs", `
+		id TEXT NOT NULL PRIMARY KEY,
+		type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		finished_at TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL DEFAULT ''
+	`},
+	{"outbox", `
+		rowid BIGSERIAL NOT NULL UNIQUE,
+		event_id TEXT NOT NULL PRIMARY KEY,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		delivered TEXT NOT NULL DEFAULT '[]',
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at BIGINT NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	`},
+	{"event_schemas", `
+		event_type TEXT NOT NULL PRIMARY KEY,
+		json_schema TEXT NOT NULL
+	`},
+	{"webhooks", `
+		id TEXT NOT NULL PRIMARY KEY,
+		url TEXT NOT NULL,
+		type TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		consecutive_failures INTEGER NOT NULL DEFAULT 0,
+		last_status TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		last_delivery_at TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL
+	`},
+	{"annotations", `
+		rowid BIGSERIAL NOT NULL UNIQUE,
+		id TEXT NOT NULL PRIMARY KEY,
+		event_id TEXT NOT NULL,
+		note TEXT NOT NULL,
+		author TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL
+	`},
+}
+
+// The indexes created on the postgresTables once they exist.
+var postgresIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS annotations_event_id ON annotations (event_id)",
+}
+
+// A table in a Postgres database.
+type postgresTable struct {
+	// The table's name.
+	name string
+
+	// The column definitions between the parentheses of its CREATE TABLE.
+	columns string
+}
+
+// Creates every table in a Postgres database if it doesn't exist, returning
+// the first error that occurs.
+func createPostgresTables(db *sql.DB) error {
+	for _, table := range postgresTables {
+		if _, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table.name, table.columns)); err != nil {
+			return fmt.Errorf("creating Postgres tables: %w", err)
+		}
+	}
+
+	for _, index := range postgresIndexes {
+		if _, err := db.Exec(index); err != nil {
+			return fmt.Errorf("creating Postgres indexes: %w", err)
+		}
+	}
+
+	return addEventsColumns(db)
+}
@@ -0,0 +1,46 @@
+//go:build postgres
+
+package database
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/config"
+)
+
+// Opens the Postgres database at POSTGRES_TEST_URL through New, emptying every
+// table first. The test is skipped if POSTGRES_TEST_URL is unset.
+//
+// Run with: POSTGRES_TEST_URL=postgres://... go test -tags postgres ./internal/database
+func newPostgresTestService(t *testing.T) *tursoService {
+	t.Helper()
+
+	dbUrl := os.Getenv("POSTGRES_TEST_URL")
+	if dbUrl == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+
+	db, ok := New(config.Database{Driver: config.DriverPostgres, URL: dbUrl}, nil).(*tursoService)
+	if !ok || db == nil {
+		t.Fatal("expected New to return a *tursoService")
+	}
+	t.Cleanup(func() { db.Close() })
+
+	tables := make([]string, len(postgresTables))
+	for i, table := range postgresTables {
+		tables[i] = table.name
+	}
+
+	_, err := db.db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPostgresService(t *testing.T) {
+	runServiceTests(t, newPostgresTestService)
+}
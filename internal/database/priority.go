@@ -0,0 +1,45 @@
+package database
+
+import "context"
+
+// Retrieves up to max Event entries with at least the given priority, the most
+// urgent first and the latest first within each priority. Returns an error if
+// the operation fails.
+func (s *tursoService) GetTopPriorityEvents(minPriority int, max int) ([]EventEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events WHERE Priority >= ? ORDER BY Priority DESC, Timestamp DESC LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, query, minPriority, max)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EventEntry{}
+	for rows.Next() {
+		var event EventEntry
+		if err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// Retrieves the number of Event entries with at least the given priority.
+// Returns an error if the operation fails.
+func (s *tursoService) GetEventCountByMinPriority(minPriority int) (int64, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Events WHERE Priority >= ?", minPriority).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
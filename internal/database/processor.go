@@ -0,0 +1,87 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Returned, wrapping the processor's error, when an event processor rejects an
+// event so it isn't created.
+var ErrEventRejected = errors.New("event rejected")
+
+// A function called with every event of the type it's registered for before
+// the event is created, returning the event to store in its place, e.g. with
+// its data transformed. Returning an error rejects the event instead.
+type EventProcessor func(e EventEntry) (EventEntry, error)
+
+// The processors registered with RegisterProcessor, by event type. They're
+// kept behind a pointer so the copies WithTimeout returns run the same
+// processors.
+type eventProcessors struct {
+	mu     sync.RWMutex
+	byType map[EventType][]EventProcessor
+}
+
+// Registers a processor that's called with every event of the given type that
+// CreateEvent and CreateEvents are about to create, to validate or transform
+// it before it's inserted. Events of types without a processor are created as
+// they are.
+//
+// A type's processors run in the order they were registered, each being passed
+// the event the previous one returned, and an error from any of them rejects
+// the event with ErrEventRejected. They run after the event has been checked
+// against its type's schema, and only the processors registered for the type
+// the event was created with are called, even if one of them changes it.
+func (s *tursoService) RegisterProcessor(eventType EventType, processor EventProcessor) {
+	s.processors.mu.Lock()
+	defer s.processors.mu.Unlock()
+
+	if s.processors.byType == nil {
+		s.processors.byType = map[EventType][]EventProcessor{}
+	}
+
+	s.processors.byType[eventType] = append(s.processors.byType[eventType], processor)
+}
+
+// Runs the processors registered for the event's type, returning the event
+// they produced or an error wrapping ErrEventRejected if one of them rejected
+// it.
+func (p *eventProcessors) process(e EventEntry) (EventEntry, error) {
+	p.mu.RLock()
+	processors := p.byType[e.Type]
+	p.mu.RUnlock()
+
+	for _, processor := range processors {
+		processed, err := processor(e)
+		if err != nil {
+			if errors.Is(err, ErrEventRejected) {
+				return EventEntry{}, err
+			}
+
+			return EventEntry{}, fmt.Errorf("%w: %w", ErrEventRejected, err)
+		}
+
+		e = processed
+	}
+
+	return e, nil
+}
+
+// Runs the processors registered for each event's type, returning the events
+// they produced in the same order, or the first rejection, naming the index of
+// the event that was rejected.
+func (p *eventProcessors) processAll(events []EventEntry) ([]EventEntry, error) {
+	processed := make([]EventEntry, len(events))
+
+	for i, e := range events {
+		event, err := p.process(e)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+
+		processed[i] = event
+	}
+
+	return processed, nil
+}
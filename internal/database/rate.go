@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// How quickly events were created over a recent window of time.
+type EventRate struct {
+	WindowSeconds   int     `json:"window_seconds"`
+	TotalEvents     int64   `json:"total_events"`
+	EventsPerSecond float64 `json:"events_per_second"`
+	EventsPerMinute float64 `json:"events_per_minute"`
+}
+
+// Returns the number of events with timestamps within the given window before
+// now, and the average rate they were created at over it. Returns an error if
+// the operation fails.
+func (s *tursoService) GetEventRate(window time.Duration) (EventRate, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	since := time.Now().Add(-window).UTC().Format(time.RFC3339Nano)
+	query := "SELECT COUNT(*) FROM Events WHERE " + s.db.dialect.timestampAtOrAfter
+
+	rate := EventRate{WindowSeconds: int(window / time.Second)}
+	if err := s.db.QueryRowContext(ctx, query, since).Scan(&rate.TotalEvents); err != nil {
+		return EventRate{}, err
+	}
+
+	rate.EventsPerSecond = float64(rate.TotalEvents) / window.Seconds()
+	rate.EventsPerMinute = float64(rate.TotalEvents) / window.Minutes()
+
+	return rate, nil
+}
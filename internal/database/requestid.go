@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// The default threshold above which queries are logged as slow, used when
+// DB_SLOW_QUERY_MS is unset.
+const defaultSlowQueryThreshold = 500 * time.Millisecond
+
+// The context key the ID of the request a query is run for is stored under.
+type requestIDKey struct{}
+
+// Returns a copy of the context carrying the ID of the request it belongs to,
+// which is included in the slow query logs of queries run with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Returns the request ID the context carries, or an empty string if it doesn't
+// carry one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Returns a copy of the service whose queries run with the given context's
+// values, e.g. the request ID set by WithRequestID, so their logs can be
+// matched to the request. The context's cancellation isn't carried over, so
+// queries are still only bounded by the service's timeouts and work started by
+// a request, e.g. a vacuum run in the background, can outlive it. The copy
+// shares the underlying connection pool.
+func (s *tursoService) WithContext(ctx context.Context) TursoDB {
+	scoped := *s
+	scoped.ctx = context.WithoutCancel(ctx)
+
+	return &scoped
+}
+
+// Logs the queries that take longer than the threshold, with the ID of the
+// request they were run for.
+type slowQueryLog struct {
+	logger *slog.Logger
+
+	threshold time.Duration
+}
+
+// Logs the query if it took longer than the threshold since it started.
+func (l slowQueryLog) observe(ctx context.Context, query string, start time.Time) {
+	elapsed := time.Since(start)
+	if l.logger == nil || elapsed <= l.threshold {
+		return
+	}
+
+	attrs := []slog.Attr{slog.String("query", query), slog.Duration("duration", elapsed)}
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+
+	l.logger.LogAttrs(ctx, slog.LevelWarn, "Slow query", attrs...)
+}
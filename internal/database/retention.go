@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Deletes up to limit of the oldest events with timestamps before the given
+// time, e.g. to enforce a retention policy. Only events of the given type are
+// deleted, or events of every type except the excluded ones when it's empty.
+// Returns the number of events deleted, or an error if the operation fails.
+func (s *tursoService) DeleteEventsBefore(before time.Time, eventType EventType, excluded []EventType, limit int) (int64, error) {
+	query := "SELECT ID FROM Events WHERE " + s.db.dialect.timestampBefore
+	args := []any{before.UTC().Format(time.RFC3339Nano)}
+
+	if eventType != "" {
+		query += " AND Type = ?"
+		args = append(args, eventType)
+	} else if len(excluded) > 0 {
+		query += " AND Type NOT IN (?" + strings.Repeat(", ?", len(excluded)-1) + ")"
+		for _, t := range excluded {
+			args = append(args, t)
+		}
+	}
+
+	query += " ORDER BY Timestamp LIMIT ?"
+	args = append(args, limit)
+
+	return s.deleteSelectedEvents(query, args...)
+}
+
+// Deletes up to limit of the oldest events beyond the newest keep events, e.g.
+// to enforce a retention policy. Returns the number of events deleted, or an
+// error if the operation fails.
+func (s *tursoService) DeleteEventsBeyond(keep int, limit int) (int64, error) {
+	query := `SELECT ID FROM Events WHERE ID NOT IN (SELECT ID FROM Events ORDER BY Timestamp DESC LIMIT ?)
+		ORDER BY Timestamp LIMIT ?`
+
+	return s.deleteSelectedEvents(query, keep, limit)
+}
+
+// Deletes the events whose IDs are selected by the given query, along with
+// their annotations and outbox entries, in a single transaction so a batch is
+// either deleted completely or not at all. Returns the number of events
+// deleted.
+func (s *tursoService) deleteSelectedEvents(query string, args ...any) (int64, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.batchWriteTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []any
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM Events WHERE ID IN "+placeholders, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range []string{"annotations", "outbox"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE event_id IN "+placeholders, ids...); err != nil {
+			return 0, err
+		}
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
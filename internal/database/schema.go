@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	// Returned when no schema is registered for the requested event type.
+	ErrSchemaNotFound = errors.New("schema not found")
+
+	// Returned when a schema being registered isn't a valid JSON Schema.
+	ErrInvalidSchema = errors.New("invalid schema")
+)
+
+// Registers the given JSON Schema for the given event type, replacing any
+// schema already registered for it. Events of that type must then have Data
+// that is a JSON document conforming to the schema. Returns an error wrapping
+// ErrInvalidSchema if the schema can't be compiled.
+func (s *tursoService) RegisterSchema(eventType string, schema string) error {
+	if _, err := compileSchema(eventType, schema); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	query := "INSERT INTO event_schemas (event_type, json_schema) VALUES (?, ?) ON CONFLICT (event_type) DO UPDATE SET json_schema = excluded.json_schema"
+	_, err := s.db.ExecContext(ctx, query, eventType, schema)
+	return err
+}
+
+// Retrieves the JSON Schema registered for the given event type. Returns
+// ErrSchemaNotFound if there isn't one, or an error if the operation fails.
+func (s *tursoService) GetSchema(eventType string) (string, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	var schema string
+	err := s.db.QueryRowContext(ctx, "SELECT json_schema FROM event_schemas WHERE event_type = ?", eventType).Scan(&schema)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSchemaNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return schema, nil
+}
+
+// Removes the JSON Schema registered for the given event type so its events
+// are no longer validated. Returns ErrSchemaNotFound if there isn't one, or an
+// error if the operation fails.
+func (s *tursoService) DeleteSchema(eventType string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM event_schemas WHERE event_type = ?", eventType)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrSchemaNotFound
+	}
+
+	return nil
+}
+
+// Validates the given event data against the JSON Schema registered for the
+// given event type. Returns true if the data conforms or no schema is
+// registered, otherwise false and a description of each violation. The error
+// is only set if the schema couldn't be loaded.
+func (s *tursoService) ValidateEventData(eventType string, data string) (bool, []string, error) {
+	schema, err := s.GetSchema(eventType)
+	if errors.Is(err, ErrSchemaNotFound) {
+		return true, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	compiled, err := compileSchema(eventType, schema)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var doc any
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return false, []string{"data is not valid JSON: " + err.Error()}, nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	err = compiled.Validate(doc)
+	if errors.As(err, &validationErr) {
+		return false, validationMessages(validationErr), nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, nil, nil
+}
+
+// Compiles the given JSON Schema, wrapping any error in ErrInvalidSchema.
+func compileSchema(eventType string, schema string) (*jsonschema.Schema, error) {
+	compiled, err := jsonschema.CompileString("schema://"+eventType, schema)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSchema, err)
+	}
+
+	return compiled, nil
+}
+
+// Flattens a validation error into one message per violation, each prefixed
+// with the location of the offending value, e.g. "/count: expected integer".
+func validationMessages(err *jsonschema.ValidationError) []string {
+	if len(err.Causes) == 0 {
+		location := err.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+
+		return []string{location + ": " + err.Message}
+	}
+
+	var messages []string
+	for _, cause := range err.Causes {
+		messages = append(messages, validationMessages(cause)...)
+	}
+
+	return messages
+}
+
+// Create the event_schemas table if it doesn't exist. Returns an error if it
+// can't be created.
+func CreateEventSchemasTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS event_schemas (
+		event_type TEXT NOT NULL PRIMARY KEY,
+		json_schema TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating event_schemas table: %w", err)
+	}
+
+	return nil
+}
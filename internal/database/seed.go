@@ -0,0 +1,43 @@
+package database
+
+import (
+	"fmt"
+	"os"
+)
+
+// Loads the events in the given fixtures file into the database, but only if
+// the Events table is empty so seeding is safe to run on every startup. The
+// file may contain either a JSON array of events or newline-delimited JSON
+// (NDJSON) with one event per line. Returns the number of events seeded.
+func SeedFromFile(db TursoDB, path string) (int, error) {
+	count, err := db.GetEventCount()
+	if err != nil {
+		return 0, err
+	}
+
+	if count > 0 {
+		return 0, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	events, err := ReadEvents(file)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	created, err := db.CreateEvents(events)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(created), nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Returned when events are asked to be sorted by a field or in an order that
+// isn't supported.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// The fields events can be sorted by, in the order they're listed in errors.
+// created_at is the order the events were stored in, which differs from their
+// timestamps when clients supply their own.
+var EventSortFields = []string{"timestamp", "type", "created_at"}
+
+// The column each of EventSortFields sorts on. Only these are ever put into a
+// query's ORDER BY clause, so sort fields can't be used to inject SQL.
+var eventSortColumns = map[string]string{
+	"timestamp":  "Timestamp",
+	"type":       "Type",
+	"created_at": "rowid",
+}
+
+// The directions events can be sorted in.
+var eventSortOrders = map[string]string{
+	"asc":  "ASC",
+	"desc": "DESC",
+}
+
+// Retrieves at most maxEntries Event entries sorted by the given field, one of
+// EventSortFields, in the given order, asc or desc. Events that sort the same
+// are kept in the order they were stored in. Only events of the given types
+// are considered, unless there are none. Returns ErrInvalidSort if the field
+// or order isn't supported, or an error if the operation fails.
+func (s *tursoService) GetEventsSorted(sortBy, order string, types []EventType, maxEntries int) ([]EventEntry, error) {
+	column, ok := eventSortColumns[sortBy]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	direction, ok := eventSortOrders[order]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	query := "SELECT ID, Type, Data, Timestamp, Priority, COALESCE(UniqueKey, '') FROM Events"
+	args := make([]any, 0, len(types)+1)
+
+	if len(types) > 0 {
+		query += " WHERE Type IN (?" + strings.Repeat(", ?", len(types)-1) + ")"
+		for _, eventType := range types {
+			args = append(args, eventType)
+		}
+	}
+
+	query += " ORDER BY " + column + " " + direction
+	if column != "rowid" {
+		query += ", rowid " + direction
+	}
+
+	query += " LIMIT ?"
+	args = append(args, maxEntries)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EventEntry
+	for rows.Next() {
+		var event EventEntry
+		err := rows.Scan(&event.ID, &event.Type, &event.Data, &event.Timestamp, &event.Priority, &event.UniqueKey)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"cmp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+)
+
+// The default SQLite pragmas applied to local file databases, tuned for a
+// write-heavy event store.
+const (
+	defaultJournalMode = "WAL"
+	defaultSynchronous = "NORMAL"
+	defaultBusyTimeout = 5 * time.Second
+)
+
+// Adds the SQLite pragmas in the configuration, or their defaults when unset,
+// to a file: database URL. They are passed as go-sqlite3 DSN parameters rather
+// than executed once so they apply to every connection in the pool, not just
+// the first. Pragmas the URL
+// already sets are left alone, and non-file URLs (e.g. a remote Turso database)
+// are returned unchanged since their pragmas are managed by the server.
+func withSQLitePragmas(dbUrl string, cfg config.Database) string {
+	if !strings.HasPrefix(dbUrl, "file:") {
+		return dbUrl
+	}
+
+	path, rawQuery, _ := strings.Cut(dbUrl, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return dbUrl
+	}
+
+	setDefault := func(key, value string) {
+		if value != "" && !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+
+	// WAL requires a shared file on disk, so it can't be used for in-memory
+	// databases.
+	if !isInMemory(path, query) {
+		setDefault("_journal_mode", cmp.Or(cfg.JournalMode, defaultJournalMode))
+	}
+
+	setDefault("_synchronous", cmp.Or(cfg.Synchronous, defaultSynchronous))
+	setDefault("_busy_timeout", strconv.FormatInt(cmp.Or(cfg.BusyTimeout, defaultBusyTimeout).Milliseconds(), 10))
+
+	return path + "?" + query.Encode()
+}
+
+// Returns whether a file: URL refers to an in-memory database.
+func isInMemory(path string, query url.Values) bool {
+	return strings.Contains(path, ":memory:") || query.Get("mode") == "memory"
+}
@@ -0,0 +1,1156 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+)
+
+// The tests every database backend has to pass. They're run against SQLite by
+// TestSQLiteService, and against Postgres by TestPostgresService when built
+// with the postgres tag.
+var serviceTests = []struct {
+	name string
+	run  func(t *testing.T, db *tursoService)
+}{
+	{"AcquireLockCollision", testAcquireLockCollision},
+	{"BackgroundJobLifecycle", testBackgroundJobLifecycle},
+	{"GetLatestEventsByTypes", testGetLatestEventsByTypes},
+	{"GetEventsSorted", testGetEventsSorted},
+	{"ReleaseLockAllowsReacquiring", testReleaseLockAllowsReacquiring},
+	{"RecordNonce", testRecordNonce},
+	{"AcquireLockAfterTTLExpires", testAcquireLockAfterTTLExpires},
+	{"GetLatestEventsNewestFirst", testGetLatestEventsNewestFirst},
+	{"GetLatestEventsEnforcesLimit", testGetLatestEventsEnforcesLimit},
+	{"GetEventByID", testGetEventByID},
+	{"GetOldestEvent", testGetOldestEvent},
+	{"GetEventTimeSeriesBuckets", testGetEventTimeSeriesBuckets},
+	{"GetEventTimeSeriesEmptyRange", testGetEventTimeSeriesEmptyRange},
+	{"GetDistinctValues", testGetDistinctValues},
+	{"GetEventSummary", testGetEventSummary},
+	{"GetEventCountsByType", testGetEventCountsByType},
+	{"GetTopEventTypes", testGetTopEventTypes},
+	{"GetEventRate", testGetEventRate},
+	{"GetTopPriorityEvents", testGetTopPriorityEvents},
+	{"CreateEventsAndGetEventsAfter", testCreateEventsAndGetEventsAfter},
+	{"GetEventsSince", testGetEventsSince},
+	{"CreateEventsInChunks", testCreateEventsInChunks},
+	{"PatchEvent", testPatchEvent},
+	{"RegisterSchemaReplaces", testRegisterSchemaReplaces},
+	{"WebhookDeliveriesDisableAfterFailures", testWebhookDeliveriesDisableAfterFailures},
+	{"Annotations", testAnnotations},
+	{"Vacuum", testVacuum},
+	{"Backup", testBackup},
+	{"Jobs", testJobs},
+	{"Outbox", testOutbox},
+	{"DeleteEventsBefore", testDeleteEventsBefore},
+	{"DeleteEventsBeyond", testDeleteEventsBeyond},
+}
+
+// Runs every service test as a subtest, each with a fresh service from
+// newService.
+func runServiceTests(t *testing.T, newService func(t *testing.T) *tursoService) {
+	for _, test := range serviceTests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newService(t))
+		})
+	}
+}
+
+// Creates events with the given timestamps, in order, failing the test if any
+// can't be created.
+func createEventsAt(t *testing.T, db *tursoService, timestamps ...string) {
+	t.Helper()
+
+	for i, timestamp := range timestamps {
+		_, err := db.CreateEvent(EventEntry{Type: "seq", Data: strconv.Itoa(i), Timestamp: timestamp})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testAcquireLockCollision(t *testing.T, db *tursoService) {
+
+	acquired, err := db.AcquireLock("batch", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	acquired, err = db.AcquireLock("batch", time.Minute)
+	if err != nil || acquired {
+		t.Fatalf("expected the held lock to collide, got %v, %v", acquired, err)
+	}
+
+	acquired, err = db.AcquireLock("other", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected a different key to be acquired, got %v, %v", acquired, err)
+	}
+}
+
+func testReleaseLockAllowsReacquiring(t *testing.T, db *tursoService) {
+
+	if acquired, err := db.AcquireLock("batch", time.Minute); err != nil || !acquired {
+		t.Fatalf("expected to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	if err := db.ReleaseLock("batch"); err != nil {
+		t.Fatal(err)
+	}
+
+	if acquired, err := db.AcquireLock("batch", time.Minute); err != nil || !acquired {
+		t.Fatalf("expected to reacquire the released lock, got %v, %v", acquired, err)
+	}
+
+	if err := db.ReleaseLock("never-held"); err != nil {
+		t.Fatalf("expected releasing an unheld lock to be a no-op, got %v", err)
+	}
+}
+
+func testAcquireLockAfterTTLExpires(t *testing.T, db *tursoService) {
+
+	if acquired, err := db.AcquireLock("batch", 50*time.Millisecond); err != nil || !acquired {
+		t.Fatalf("expected to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if acquired, err := db.AcquireLock("batch", time.Minute); err != nil || !acquired {
+		t.Fatalf("expected to acquire the expired lock, got %v, %v", acquired, err)
+	}
+}
+
+func testGetLatestEventsNewestFirst(t *testing.T, db *tursoService) {
+
+	// Created out of order so the result can't just be insertion order.
+	createEventsAt(t, db,
+		"2024-01-02T00:00:00Z",
+		"2024-01-03T00:00:00Z",
+		"2024-01-01T00:00:00Z",
+	)
+
+	events, err := db.GetLatestEvents(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Timestamp)
+	}
+
+	want := []string{"2024-01-03T00:00:00Z", "2024-01-02T00:00:00Z", "2024-01-01T00:00:00Z"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected order: got %v want %v", got, want)
+	}
+}
+
+func testGetLatestEventsEnforcesLimit(t *testing.T, db *tursoService) {
+
+	createEventsAt(t, db,
+		"2024-01-01T00:00:00Z",
+		"2024-01-02T00:00:00Z",
+		"2024-01-03T00:00:00Z",
+		"2024-01-04T00:00:00Z",
+	)
+
+	events, err := db.GetLatestEvents(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[0].Timestamp != "2024-01-04T00:00:00Z" || events[1].Timestamp != "2024-01-03T00:00:00Z" {
+		t.Fatalf("expected the 2 newest events, got %+v", events)
+	}
+}
+
+func testGetEventByID(t *testing.T, db *tursoService) {
+
+	created, err := db.CreateEvent(EventEntry{Type: "deploy", Data: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := db.GetEventByID(created.ID)
+	if err != nil || found != created {
+		t.Fatalf("expected to find %+v, got %+v, %v", created, found, err)
+	}
+
+	if _, err := db.GetEventByID("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testGetOldestEvent(t *testing.T, db *tursoService) {
+
+	if _, err := db.GetOldestEvent(""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an empty table, got %v", err)
+	}
+
+	// Created out of order so the result can't just be insertion order.
+	for _, event := range []EventEntry{
+		{Type: "seq", Data: "2", Timestamp: "2024-01-02T00:00:00Z"},
+		{Type: "deploy", Data: "3", Timestamp: "2024-01-03T00:00:00Z"},
+		{Type: "seq", Data: "1", Timestamp: "2024-01-01T00:00:00Z"},
+	} {
+		if _, err := db.CreateEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldest, err := db.GetOldestEvent("")
+	if err != nil || oldest.Data != "1" {
+		t.Fatalf("expected the oldest event, got %+v, %v", oldest, err)
+	}
+
+	oldest, err = db.GetOldestEvent("deploy")
+	if err != nil || oldest.Data != "3" {
+		t.Fatalf("expected the oldest deploy event, got %+v, %v", oldest, err)
+	}
+
+	if _, err := db.GetOldestEvent("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a type without events, got %v", err)
+	}
+}
+
+func testGetEventTimeSeriesBuckets(t *testing.T, db *tursoService) {
+
+	createEventsAt(t, db,
+		"2024-01-01T09:59:59.999Z",
+		"2024-01-01T10:00:00Z",
+		"2024-01-01T10:59:59.5Z",
+		"2024-01-01T11:00:00Z",
+		"2024-01-02T00:00:00Z",
+	)
+
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	buckets, err := db.GetEventTimeSeries(start, end, "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The range includes its start but not its end, and each event lands in the
+	// bucket its timestamp truncates to.
+	want := []TimeSeriesBucket{
+		{Bucket: "2024-01-01T10:00:00Z", Count: 2},
+		{Bucket: "2024-01-01T11:00:00Z", Count: 1},
+	}
+	if !reflect.DeepEqual(buckets, want) {
+		t.Fatalf("unexpected buckets: got %+v want %+v", buckets, want)
+	}
+
+	buckets, err = db.GetEventTimeSeries(start.Add(-time.Hour), end.Add(time.Hour), "day")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []TimeSeriesBucket{
+		{Bucket: "2024-01-01T00:00:00Z", Count: 4},
+		{Bucket: "2024-01-02T00:00:00Z", Count: 1},
+	}
+	if !reflect.DeepEqual(buckets, want) {
+		t.Fatalf("unexpected buckets: got %+v want %+v", buckets, want)
+	}
+}
+
+func testGetEventTimeSeriesEmptyRange(t *testing.T, db *tursoService) {
+
+	createEventsAt(t, db, "2024-01-01T10:00:00Z")
+
+	start := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	buckets, err := db.GetEventTimeSeries(start, start.Add(time.Hour), "minute")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buckets == nil || len(buckets) != 0 {
+		t.Fatalf("expected an empty, non-nil slice, got %#v", buckets)
+	}
+
+	if _, err := db.GetEventTimeSeries(start, start.Add(time.Hour), "week"); !errors.Is(err, ErrInvalidBucket) {
+		t.Fatalf("expected ErrInvalidBucket, got %v", err)
+	}
+}
+
+func testGetDistinctValues(t *testing.T, db *tursoService) {
+	for _, event := range []EventEntry{
+		{Type: "deploy", Data: "staging"},
+		{Type: "deploy", Data: "production"},
+		{Type: "deploy", Data: "staging"},
+		{Type: "deploy", Data: "canary"},
+		{Type: "deploy", Data: "production"},
+		{Type: "deploy", Data: "staging"},
+		{Type: "alert", Data: "canary"},
+	} {
+		if _, err := db.CreateEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values, truncated, err := db.GetDistinctValues("deploy", "data", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The most common values come first, and other types aren't counted.
+	want := []DistinctValue{{"staging", 3}, {"production", 2}, {"canary", 1}}
+	if !reflect.DeepEqual(values, want) || truncated {
+		t.Fatalf("unexpected values: got %+v, %v want %+v", values, truncated, want)
+	}
+
+	values, truncated, err = db.GetDistinctValues("deploy", "data", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(values, want[:2]) || !truncated {
+		t.Fatalf("expected the values to be truncated: got %+v, %v", values, truncated)
+	}
+
+	values, _, err = db.GetDistinctValues("missing", "data", 10)
+	if err != nil || values == nil || len(values) != 0 {
+		t.Fatalf("expected an empty, non-nil slice for a type without events, got %#v, %v", values, err)
+	}
+
+	if _, _, err := db.GetDistinctValues("deploy", "Type", 10); !errors.Is(err, ErrInvalidValueField) {
+		t.Fatalf("expected ErrInvalidValueField, got %v", err)
+	}
+}
+
+func testGetEventSummary(t *testing.T, db *tursoService) {
+	summary, err := db.GetEventSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary != (EventSummary{}) {
+		t.Fatalf("expected an empty summary without events, got %+v", summary)
+	}
+
+	for _, event := range []EventEntry{
+		{Type: "deploy", Data: "v1", Timestamp: "2024-01-02T00:00:00Z"},
+		{Type: "alert", Data: "cpu", Timestamp: "2024-01-03T00:00:00Z"},
+		{Type: "deploy", Data: "v2", Timestamp: "2024-01-01T00:00:00Z"},
+	} {
+		if _, err := db.CreateEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err = db.GetEventSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Earliest == nil || *summary.Earliest != "2024-01-01T00:00:00Z" || summary.Latest == nil || *summary.Latest != "2024-01-03T00:00:00Z" {
+		t.Errorf("unexpected timestamps: got %v to %v", summary.Earliest, summary.Latest)
+	}
+
+	if summary.Count != 3 || summary.Types != 2 {
+		t.Errorf("unexpected counts: got %d events of %d types want 3 of 2", summary.Count, summary.Types)
+	}
+}
+
+func testGetEventCountsByType(t *testing.T, db *tursoService) {
+	for _, eventType := range []EventType{"deploy", "alert", "deploy"} {
+		if _, err := db.CreateEvent(EventEntry{Type: eventType, Data: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := db.GetEventCountsByType()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[EventType]int64{"deploy": 2, "alert": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("unexpected counts: got %v want %v", counts, want)
+	}
+}
+
+func testGetTopEventTypes(t *testing.T, db *tursoService) {
+	now := time.Now().UTC()
+	recent := now.Add(-time.Hour).Format(time.RFC3339Nano)
+	old := now.Add(-48 * time.Hour).Format(time.RFC3339Nano)
+
+	// 4 clicks, 3 keys, 2 scrolls, and 1 resize in the window, with older
+	// resizes that would otherwise come first.
+	var events []EventEntry
+	for eventType, count := range map[EventType]int{"click": 4, "key": 3, "scroll": 2, "resize": 1} {
+		for range count {
+			events = append(events, EventEntry{Type: eventType, Data: "x", Timestamp: recent})
+		}
+	}
+	for range 5 {
+		events = append(events, EventEntry{Type: "resize", Data: "x", Timestamp: old})
+	}
+
+	if _, err := db.CreateEvents(events); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.GetTopEventTypes(3, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EventTypeCount{{"click", 4}, {"key", 3}, {"scroll", 2}}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("unexpected top types: got %+v want %+v", counts, want)
+	}
+
+	counts, err = db.GetTopEventTypes(10, now)
+	if err != nil || counts == nil || len(counts) != 0 {
+		t.Fatalf("expected an empty, non-nil slice for an empty window, got %#v, %v", counts, err)
+	}
+}
+
+func testGetEventRate(t *testing.T, db *tursoService) {
+	now := time.Now().UTC()
+
+	// 6 events in the last minute, 9 more in the last 5 minutes, 45 more in
+	// the last hour, and some older ones that are never counted.
+	var events []EventEntry
+	for age, count := range map[time.Duration]int{30 * time.Second: 6, 3 * time.Minute: 9, 30 * time.Minute: 45, 2 * time.Hour: 20} {
+		for range count {
+			events = append(events, EventEntry{Type: "seq", Data: "x", Timestamp: now.Add(-age).Format(time.RFC3339Nano)})
+		}
+	}
+
+	if _, err := db.CreateEvents(events); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []EventRate{
+		{WindowSeconds: 60, TotalEvents: 6, EventsPerSecond: 0.1, EventsPerMinute: 6},
+		{WindowSeconds: 300, TotalEvents: 15, EventsPerSecond: 0.05, EventsPerMinute: 3},
+		{WindowSeconds: 3600, TotalEvents: 60, EventsPerSecond: 60.0 / 3600, EventsPerMinute: 1},
+	} {
+		rate, err := db.GetEventRate(time.Duration(want.WindowSeconds) * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rate != want {
+			t.Errorf("unexpected rate: got %+v want %+v", rate, want)
+		}
+	}
+}
+
+func testGetTopPriorityEvents(t *testing.T, db *tursoService) {
+	created, err := db.CreateEvents([]EventEntry{
+		{Type: "seq", Data: "urgent-old", Priority: 5, Timestamp: "2024-01-01T00:00:00Z"},
+		{Type: "seq", Data: "default", Timestamp: "2024-01-02T00:00:00Z"},
+		{Type: "seq", Data: "high", Priority: 4, Timestamp: "2024-01-03T00:00:00Z"},
+		{Type: "seq", Data: "urgent-new", Priority: 5, Timestamp: "2024-01-04T00:00:00Z"},
+		{Type: "seq", Data: "low", Priority: 1, Timestamp: "2024-01-05T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created[1].Priority != DefaultPriority {
+		t.Errorf("expected the default priority for an event without one, got %d", created[1].Priority)
+	}
+
+	for _, tc := range []struct {
+		minPriority, max int
+		want             []string
+	}{
+		{4, 10, []string{"urgent-new", "urgent-old", "high"}},
+		{4, 2, []string{"urgent-new", "urgent-old"}},
+		{3, 10, []string{"urgent-new", "urgent-old", "high", "default"}},
+		{1, 10, []string{"urgent-new", "urgent-old", "high", "default", "low"}},
+	} {
+		events, err := db.GetTopPriorityEvents(tc.minPriority, tc.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, event := range events {
+			got = append(got, event.Data)
+		}
+
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("min priority %d, max %d: got %v want %v", tc.minPriority, tc.max, got, tc.want)
+		}
+	}
+
+	if count, err := db.GetEventCountByMinPriority(4); err != nil || count != 3 {
+		t.Errorf("expected 3 events with a priority of at least 4, got %d, %v", count, err)
+	}
+}
+
+func testCreateEventsAndGetEventsAfter(t *testing.T, db *tursoService) {
+	// Timestamps go backwards so the result can't just be timestamp order.
+	created, err := db.CreateEvents([]EventEntry{
+		{Type: "seq", Data: "0", Timestamp: "2024-01-03T00:00:00Z"},
+		{Type: "seq", Data: "1", Timestamp: "2024-01-02T00:00:00Z"},
+		{Type: "seq", Data: "2", Timestamp: "2024-01-01T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := db.CreateEvent(EventEntry{Type: "seq", Data: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Creating an event with an existing ID returns the stored event, marked
+	// as a duplicate.
+	duplicate, err := db.CreateEvents([]EventEntry{{ID: created[0].ID, Type: "other", Data: "changed"}})
+	if err != nil || len(duplicate) != 1 || !duplicate[0].Duplicate {
+		t.Fatalf("expected the stored event back as a duplicate, got %+v, %v", duplicate, err)
+	}
+	if duplicate[0].Duplicate = false; duplicate[0] != created[0] {
+		t.Fatalf("expected the stored event back, got %+v", duplicate[0])
+	}
+
+	after, err := db.GetEventsAfter(created[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EventEntry{created[1], created[2], again}
+	if !reflect.DeepEqual(after, want) {
+		t.Fatalf("unexpected events after the first: got %+v want %+v", after, want)
+	}
+
+	if count, err := db.GetEventCount(); err != nil || count != 4 {
+		t.Fatalf("expected 4 events, got %d, %v", count, err)
+	}
+}
+
+func testCreateEventsInChunks(t *testing.T, db *tursoService) {
+	db.batchChunkSize = 2
+
+	entries := make([]EventEntry, 5)
+	for i := range entries {
+		entries[i] = EventEntry{Type: "seq", Data: strconv.Itoa(i)}
+	}
+
+	created, err := db.CreateEvents(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(created) != len(entries) {
+		t.Fatalf("expected %d events, got %d", len(entries), len(created))
+	}
+
+	for i, event := range created {
+		if event.Data != strconv.Itoa(i) {
+			t.Errorf("unexpected event at %d: got %q want %q", i, event.Data, strconv.Itoa(i))
+		}
+	}
+
+	if count, err := db.GetEventCount(); err != nil || count != int64(len(entries)) {
+		t.Fatalf("expected %d events, got %d, %v", len(entries), count, err)
+	}
+}
+
+func testPatchEvent(t *testing.T, db *tursoService) {
+	created, err := db.CreateEvent(EventEntry{Type: "deploy", Data: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := db.PatchEvent(created.ID, map[string]interface{}{"data": "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if patched.Data != "v2" || patched.Type != created.Type || patched.Timestamp != created.Timestamp {
+		t.Fatalf("unexpected patched event: %+v", patched)
+	}
+
+	if _, err := db.PatchEvent("missing", map[string]interface{}{"data": "v2"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testRegisterSchemaReplaces(t *testing.T, db *tursoService) {
+	if err := db.RegisterSchema("deploy", `{"type": "object"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RegisterSchema("deploy", `{"type": "array"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if schema, err := db.GetSchema("deploy"); err != nil || schema != `{"type": "array"}` {
+		t.Fatalf("expected the replaced schema, got %q, %v", schema, err)
+	}
+
+	if err := db.DeleteSchema("deploy"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetSchema("deploy"); !errors.Is(err, ErrSchemaNotFound) {
+		t.Fatalf("expected ErrSchemaNotFound, got %v", err)
+	}
+}
+
+func testWebhookDeliveriesDisableAfterFailures(t *testing.T, db *tursoService) {
+	webhook, err := db.CreateWebhook(Webhook{URL: "https://example.com/hook", Type: "deploy", Secret: "secret", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateWebhook(Webhook{URL: "https://example.com/off", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	matching, err := db.GetWebhooksForEvent("deploy")
+	if err != nil || len(matching) != 1 || matching[0].ID != webhook.ID || matching[0].Secret != "secret" {
+		t.Fatalf("expected only the enabled deploy webhook, got %+v, %v", matching, err)
+	}
+
+	if matching, err := db.GetWebhooksForEvent("build"); err != nil || len(matching) != 0 {
+		t.Fatalf("expected no webhooks for other types, got %+v, %v", matching, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		disabled, err := db.RecordWebhookDelivery(webhook.ID, errors.New("boom"), 3)
+		if err != nil || disabled != (i == 3) {
+			t.Fatalf("failure %d: unexpected disabled %v, %v", i, disabled, err)
+		}
+	}
+
+	stored, err := db.GetWebhook(webhook.ID)
+	if err != nil || stored.Enabled || stored.ConsecutiveFailures != 3 || stored.LastStatus != WebhookDeliveryFailed || stored.LastError != "boom" {
+		t.Fatalf("unexpected webhook after failures: %+v, %v", stored, err)
+	}
+
+	// Re-enabling the webhook gives it a fresh start.
+	stored.Enabled = true
+	if updated, err := db.UpdateWebhook(stored); err != nil || !updated.Enabled || updated.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected re-enabled webhook: %+v, %v", updated, err)
+	}
+
+	if err := db.DeleteWebhook(webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.RecordWebhookDelivery(webhook.ID, nil, 3); !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("expected ErrWebhookNotFound, got %v", err)
+	}
+}
+
+func testAnnotations(t *testing.T, db *tursoService) {
+	event, err := db.CreateEvent(EventEntry{Type: "incident", Data: "db down"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, note := range []string{"investigating", "resolved"} {
+		if _, err := db.AddAnnotation(event.ID, note, "oncall"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	annotations, err := db.GetAnnotations(event.ID)
+	if err != nil || len(annotations) != 2 || annotations[0].Note != "investigating" || annotations[1].Note != "resolved" {
+		t.Fatalf("unexpected annotations: %+v, %v", annotations, err)
+	}
+
+	if _, err := db.AddAnnotation("missing", "note", "oncall"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testVacuum(t *testing.T, db *tursoService) {
+	createEventsAt(t, db, "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z")
+
+	result, err := db.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only SQLite databases are compacted.
+	if db.db.dialect.name != sqliteDialect.name {
+		if !result.Skipped {
+			t.Fatalf("expected the vacuum to be skipped, got %+v", result)
+		}
+		return
+	}
+
+	if result.Skipped || result.SizeBefore <= 0 || result.SizeAfter <= 0 {
+		t.Fatalf("expected the sizes to be reported, got %+v", result)
+	}
+
+	if count, err := db.GetEventCount(); err != nil || count != 2 {
+		t.Fatalf("expected the events to survive the vacuum, got %d, %v", count, err)
+	}
+}
+
+func testBackup(t *testing.T, db *tursoService) {
+	createEventsAt(t, db, "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z")
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	err := db.Backup(path)
+
+	// Only SQLite databases can be backed up.
+	if db.db.dialect.name != sqliteDialect.name {
+		if !errors.Is(err, ErrBackupUnsupported) {
+			t.Fatalf("expected ErrBackupUnsupported, got %v", err)
+		}
+		return
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := New(config.Database{URL: "file:" + path}, nil)
+	t.Cleanup(func() { backup.Close() })
+
+	if count, err := backup.GetEventCount(); err != nil || count != 2 {
+		t.Fatalf("expected the backup to hold the events, got %d, %v", count, err)
+	}
+}
+
+func testGetLatestEventsByTypes(t *testing.T, db *tursoService) {
+	for i, eventType := range []EventType{"heartbeat", "alert", "deploy", "heartbeat"} {
+		_, err := db.CreateEvent(EventEntry{Type: eventType, Data: "x", Timestamp: "2024-01-0" + strconv.Itoa(i+1) + "T00:00:00Z"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := db.GetLatestEventsByTypes([]EventType{"heartbeat", "deploy"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[0].Type != "heartbeat" || events[1].Type != "deploy" {
+		t.Fatalf("expected the latest heartbeat and deploy, got %+v", events)
+	}
+
+	if count, err := db.GetEventCountByTypes([]EventType{"heartbeat", "deploy"}); err != nil || count != 3 {
+		t.Fatalf("expected 3 matching events, got %d, %v", count, err)
+	}
+
+	if events, err := db.GetLatestEventsByTypes(nil, 10); err != nil || len(events) != 0 {
+		t.Fatalf("expected no events without types, got %+v, %v", events, err)
+	}
+}
+
+func testGetEventsSorted(t *testing.T, db *tursoService) {
+	for _, event := range []EventEntry{
+		{Type: "b", Data: "2", Timestamp: "2024-01-02T00:00:00Z"},
+		{Type: "a", Data: "1", Timestamp: "2024-01-01T00:00:00Z"},
+		{Type: "a", Data: "3", Timestamp: "2024-01-03T00:00:00Z"},
+	} {
+		if _, err := db.CreateEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		sortBy, order string
+		types         []EventType
+		want          []string
+	}{
+		{"timestamp", "asc", nil, []string{"1", "2", "3"}},
+		{"timestamp", "desc", nil, []string{"3", "2", "1"}},
+		// Events of the same type stay in the order they were stored in.
+		{"type", "asc", nil, []string{"1", "3", "2"}},
+		{"type", "desc", nil, []string{"2", "3", "1"}},
+		{"created_at", "asc", nil, []string{"2", "1", "3"}},
+		{"created_at", "desc", []EventType{"a"}, []string{"3", "1"}},
+	} {
+		events, err := db.GetEventsSorted(test.sortBy, test.order, test.types, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, event := range events {
+			got = append(got, event.Data)
+		}
+
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s %s %v: got %v want %v", test.sortBy, test.order, test.types, got, test.want)
+		}
+	}
+
+	for _, sort := range [][2]string{{"Data", "asc"}, {"timestamp", "sideways"}, {"rowid; DROP TABLE Events", "asc"}} {
+		if _, err := db.GetEventsSorted(sort[0], sort[1], nil, 10); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("%v: expected ErrInvalidSort, got %v", sort, err)
+		}
+	}
+}
+
+func testBackgroundJobLifecycle(t *testing.T, db *tursoService) {
+	job, err := db.StartBackgroundJob("vacuum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.GetBackgroundJob(job.ID); err != nil || got.Status != JobRunning || got.Result != nil {
+		t.Fatalf("expected a running job without a result, got %+v, %v", got, err)
+	}
+
+	if err := db.FinishBackgroundJob(job.ID, VacuumResult{SizeBefore: 2, SizeAfter: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetBackgroundJob(job.ID)
+	if err != nil || got.Status != JobCompleted || got.FinishedAt == "" || string(got.Result) != `{"skipped":false,"size_before":2,"size_after":1}` {
+		t.Fatalf("expected a completed job with its result, got %+v (%s), %v", got, got.Result, err)
+	}
+
+	failed, err := db.StartBackgroundJob("vacuum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.FinishBackgroundJob(failed.ID, nil, errors.New("disk full")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.GetBackgroundJob(failed.ID); err != nil || got.Status != JobFailed || string(got.Result) != `{"error":"disk full"}` {
+		t.Fatalf("expected a failed job with its error, got %+v (%s), %v", got, got.Result, err)
+	}
+
+	if _, err := db.GetBackgroundJob("missing"); !errors.Is(err, ErrBackgroundJobNotFound) {
+		t.Fatalf("expected ErrBackgroundJobNotFound, got %v", err)
+	}
+
+	if err := db.FinishBackgroundJob("missing", nil, nil); !errors.Is(err, ErrBackgroundJobNotFound) {
+		t.Fatalf("expected ErrBackgroundJobNotFound when finishing, got %v", err)
+	}
+}
+
+func testRecordNonce(t *testing.T, db *tursoService) {
+	recorded, err := db.RecordNonce("abc", time.Now().Add(time.Minute))
+	if err != nil || !recorded {
+		t.Fatalf("expected the nonce to be recorded, got %v, %v", recorded, err)
+	}
+
+	recorded, err = db.RecordNonce("abc", time.Now().Add(time.Minute))
+	if err != nil || recorded {
+		t.Fatalf("expected the duplicate nonce to be rejected, got %v, %v", recorded, err)
+	}
+
+	// A forgotten nonce can be recorded again.
+	if err := db.DeleteNonce("abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorded, err := db.RecordNonce("abc", time.Now().Add(-time.Millisecond)); err != nil || !recorded {
+		t.Fatalf("expected the forgotten nonce to be recorded, got %v, %v", recorded, err)
+	}
+
+	// So can an expired one.
+	if recorded, err := db.RecordNonce("abc", time.Now().Add(time.Minute)); err != nil || !recorded {
+		t.Fatalf("expected the expired nonce to be recorded, got %v, %v", recorded, err)
+	}
+}
+
+func testJobs(t *testing.T, db *tursoService) {
+	job, err := db.CreateJob([]EventEntry{{Type: "seq", Data: "0"}, {Type: "seq", Data: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Status != JobPending || job.Total != 2 || job.Events[0].ID == "" || job.Events[0].Timestamp == "" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	incomplete, err := db.GetIncompleteJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(incomplete) != 1 || incomplete[0].ID != job.ID || len(incomplete[0].Events) != 2 || incomplete[0].Events[1].ID != job.Events[1].ID {
+		t.Fatalf("expected the pending job with its events, got %+v", incomplete)
+	}
+
+	if err := db.StartJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.GetJob(job.ID); err != nil || got.Status != JobRunning {
+		t.Fatalf("expected the job to be running, got %+v, %v", got, err)
+	}
+
+	job.Status = JobCompleted
+	job.Created = 1
+	job.Failed = 1
+	job.Errors = []JobError{{Start: 1, Count: 1, Error: "boom"}}
+	if err := db.FinishJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != JobCompleted || got.Created != 1 || got.Failed != 1 || len(got.Errors) != 1 || got.Errors[0].Error != "boom" {
+		t.Fatalf("unexpected finished job: %+v", got)
+	}
+
+	if incomplete, err := db.GetIncompleteJobs(); err != nil || len(incomplete) != 0 {
+		t.Fatalf("expected no incomplete jobs, got %+v, %v", incomplete, err)
+	}
+
+	if _, err := db.GetJob("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+
+	if err := db.StartJob("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func testGetEventsSince(t *testing.T, db *tursoService) {
+	// The timestamps have differing fractional digits, so they're only in order
+	// when compared as times.
+	createEventsAt(t, db,
+		"2024-01-01T09:59:59.999Z",
+		"2024-01-01T10:00:00Z",
+		"2024-01-01T10:00:00.5Z",
+		"2024-01-01T10:30:00Z",
+		"2024-01-01T09:00:00Z",
+	)
+
+	events, err := db.GetEventsSince(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Data)
+	}
+
+	if want := []string{"3", "2", "1"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected events: got %v want %v", got, want)
+	}
+
+	events, err = db.GetEventsSince(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil || events == nil || len(events) != 0 {
+		t.Fatalf("expected an empty slice, got %v, %v", events, err)
+	}
+}
+
+func testOutbox(t *testing.T, db *tursoService) {
+	db.outbox = true
+
+	first, err := db.CreateEvent(EventEntry{Type: "seq", Data: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateEvents([]EventEntry{first, {Type: "seq", Data: "1"}, {Type: "seq", Data: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The event that already existed doesn't get a second entry.
+	pending, err := db.GetPendingOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range pending {
+		got = append(got, entry.Event.Data)
+	}
+
+	if want := []string{"0", "1", "2"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected pending entries: got %v want %v", got, want)
+	}
+
+	if pending[0].EventID != first.ID || pending[0].Status != OutboxPending || len(pending[0].Delivered) != 0 {
+		t.Fatalf("unexpected entry: %+v", pending[0])
+	}
+
+	if limited, err := db.GetPendingOutbox(2); err != nil || len(limited) != 2 {
+		t.Fatalf("expected the limit to be applied, got %d entries, %v", len(limited), err)
+	}
+
+	// A failed entry isn't due again until its retry time.
+	dead, err := db.RecordOutboxFailure(first.ID, []string{"nats"}, errors.New("kafka: down"), time.Now().Add(time.Hour), 2)
+	if err != nil || dead {
+		t.Fatalf("expected the entry to be retried, got %v, %v", dead, err)
+	}
+
+	if pending, err := db.GetPendingOutbox(10); err != nil || len(pending) != 2 {
+		t.Fatalf("expected the failed entry to wait for its retry, got %+v, %v", pending, err)
+	}
+
+	dead, err = db.RecordOutboxFailure(first.ID, []string{"nats"}, errors.New("kafka: still down"), time.Now(), 2)
+	if err != nil || !dead {
+		t.Fatalf("expected the entry to be dead-lettered, got %v, %v", dead, err)
+	}
+
+	deadEntries, err := db.ListOutbox(OutboxDead, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deadEntries) != 1 || deadEntries[0].Attempts != 2 || deadEntries[0].LastError != "kafka: still down" || !slices.Equal(deadEntries[0].Delivered, []string{"nats"}) {
+		t.Fatalf("unexpected dead entries: %+v", deadEntries)
+	}
+
+	if pending, err := db.GetPendingOutbox(10); err != nil || len(pending) != 2 {
+		t.Fatalf("expected the dead entry not to be pending, got %+v, %v", pending, err)
+	}
+
+	// Requeueing makes it due straight away, remembering where it was
+	// delivered.
+	if err := db.RequeueOutbox(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err = db.GetPendingOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 3 || pending[0].Attempts != 0 || !slices.Equal(pending[0].Delivered, []string{"nats"}) {
+		t.Fatalf("unexpected requeued entry: %+v", pending)
+	}
+
+	if err := db.RequeueOutbox(first.ID); !errors.Is(err, ErrOutboxEntryNotFound) {
+		t.Fatalf("expected ErrOutboxEntryNotFound requeueing a pending entry, got %v", err)
+	}
+
+	if _, err := db.RecordOutboxFailure("missing", nil, errors.New("down"), time.Now(), 2); !errors.Is(err, ErrOutboxEntryNotFound) {
+		t.Fatalf("expected ErrOutboxEntryNotFound, got %v", err)
+	}
+
+	if err := db.MarkOutboxSent(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := db.GetPendingOutbox(10); err != nil || len(pending) != 2 {
+		t.Fatalf("expected the sent entry to be removed, got %+v, %v", pending, err)
+	}
+
+	// Purging the events empties the outbox too.
+	if _, err := db.PurgeEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := db.GetPendingOutbox(10); err != nil || len(pending) != 0 {
+		t.Fatalf("expected the outbox to be empty, got %+v, %v", pending, err)
+	}
+}
+
+// Returns the data of every stored event, oldest first.
+func storedEventData(t *testing.T, db *tursoService) []string {
+	t.Helper()
+
+	events, err := db.GetLatestEvents(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []string{}
+	for i := len(events) - 1; i >= 0; i-- {
+		data = append(data, events[i].Data)
+	}
+
+	return data
+}
+
+func testDeleteEventsBefore(t *testing.T, db *tursoService) {
+	for i, event := range []EventEntry{
+		{Type: "debug", Timestamp: "2024-01-01T00:00:00Z"},
+		{Type: "deploy", Timestamp: "2024-01-01T00:00:30Z"},
+		{Type: "debug", Timestamp: "2024-01-01T01:00:00Z"},
+		{Type: "deploy", Timestamp: "2024-01-02T00:00:00Z"},
+	} {
+		event.Data = strconv.Itoa(i)
+		if _, err := db.CreateEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldest, err := db.GetLatestEvents(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddAnnotation(oldest[len(oldest)-1].ID, "expired", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Deleting a type in batches stops at the limit.
+	deleted, err := db.DeleteEventsBefore(cutoff, "debug", nil, 1)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 event to be deleted, got %d, %v", deleted, err)
+	}
+
+	if got, want := storedEventData(t, db), []string{"1", "2", "3"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected events: got %v want %v", got, want)
+	}
+
+	var annotations int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM annotations").Scan(&annotations); err != nil || annotations != 0 {
+		t.Fatalf("expected the annotations to be deleted with the event, got %d, %v", annotations, err)
+	}
+
+	// Excluded types are kept.
+	deleted, err = db.DeleteEventsBefore(cutoff, "", []EventType{"debug"}, 10)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 event to be deleted, got %d, %v", deleted, err)
+	}
+
+	if got, want := storedEventData(t, db), []string{"2", "3"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected events: got %v want %v", got, want)
+	}
+
+	deleted, err = db.DeleteEventsBefore(cutoff, "", nil, 10)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 event to be deleted, got %d, %v", deleted, err)
+	}
+
+	if deleted, err := db.DeleteEventsBefore(cutoff, "", nil, 10); err != nil || deleted != 0 {
+		t.Fatalf("expected nothing left to delete, got %d, %v", deleted, err)
+	}
+}
+
+func testDeleteEventsBeyond(t *testing.T, db *tursoService) {
+	createEventsAt(t, db,
+		"2024-01-01T00:00:00Z",
+		"2024-01-01T00:00:01Z",
+		"2024-01-01T00:00:02Z",
+		"2024-01-01T00:00:03Z",
+		"2024-01-01T00:00:04Z",
+	)
+
+	deleted, err := db.DeleteEventsBeyond(2, 2)
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected 2 events to be deleted, got %d, %v", deleted, err)
+	}
+
+	if got, want := storedEventData(t, db), []string{"2", "3", "4"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected events: got %v want %v", got, want)
+	}
+
+	deleted, err = db.DeleteEventsBeyond(2, 2)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 event to be deleted, got %d, %v", deleted, err)
+	}
+
+	if got, want := storedEventData(t, db), []string{"3", "4"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected events: got %v want %v", got, want)
+	}
+}
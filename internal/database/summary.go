@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// An overview of every event in the database.
+type EventSummary struct {
+	// The timestamps of the earliest and latest events, or nil if there
+	// aren't any events.
+	Earliest *string `json:"earliest"`
+	Latest   *string `json:"latest"`
+
+	// The number of events.
+	Count int64 `json:"count"`
+
+	// The number of distinct event types.
+	Types int64 `json:"types"`
+}
+
+// Returns the timestamps of the earliest and latest events along with the
+// number of events and of distinct event types, in a single query. When there
+// are no events the timestamps are nil and the counts zero. Returns an error if
+// the operation fails.
+func (s *tursoService) GetEventSummary() (EventSummary, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	// MIN and MAX are NULL for an empty table, while the counts are 0.
+	var earliest, latest sql.NullString
+	var summary EventSummary
+
+	err := s.db.QueryRowContext(ctx, "SELECT MIN(Timestamp), MAX(Timestamp), COUNT(*), COUNT(DISTINCT Type) FROM Events").
+		Scan(&earliest, &latest, &summary.Count, &summary.Types)
+	if err != nil {
+		return EventSummary{}, err
+	}
+
+	if earliest.Valid {
+		summary.Earliest = &earliest.String
+	}
+	if latest.Valid {
+		summary.Latest = &latest.String
+	}
+
+	return summary, nil
+}
+
+// An event type and the number of events of that type.
+type EventTypeCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// Returns the topN event types with the most events with timestamps at or
+// after since, most events first. Types with the same number of events are
+// sorted alphabetically. Returns an empty slice if there are no events in the
+// window, or an error if the operation fails.
+func (s *tursoService) GetTopEventTypes(topN int, since time.Time) ([]EventTypeCount, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	query := "SELECT Type, COUNT(*) FROM Events WHERE " + s.db.dialect.timestampAtOrAfter +
+		" GROUP BY Type ORDER BY COUNT(*) DESC, Type LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, query, since.UTC().Format(time.RFC3339Nano), topN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []EventTypeCount{}
+	for rows.Next() {
+		var count EventTypeCount
+		if err := rows.Scan(&count.Type, &count.Count); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
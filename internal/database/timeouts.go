@@ -0,0 +1,36 @@
+package database
+
+import "time"
+
+const (
+	// The default timeout for reads, used when DB_DEFAULT_QUERY_TIMEOUT_MS is
+	// unset.
+	defaultQueryTimeout = 1 * time.Second
+
+	// The default timeout for single writes, used when DB_WRITE_TIMEOUT_MS is
+	// unset.
+	defaultWriteTimeout = 2 * time.Second
+
+	// The default timeout for batch writes, used when DB_BATCH_WRITE_TIMEOUT_MS
+	// is unset.
+	defaultBatchWriteTimeout = 10 * time.Second
+
+	// The default timeout for compacting the database, used when
+	// DB_VACUUM_TIMEOUT_MS is unset. VACUUM rewrites the whole file, so it can
+	// take far longer than any other operation.
+	defaultVacuumTimeout = 10 * time.Minute
+)
+
+// Returns a copy of the service that uses the given timeout for every
+// operation instead of the configured per-category timeouts, e.g. to give a
+// single request longer to run. The copy shares the underlying connection pool
+// and keeps every other setting.
+func (s *tursoService) WithTimeout(timeout time.Duration) TursoDB {
+	scoped := *s
+	scoped.queryTimeout = timeout
+	scoped.writeTimeout = timeout
+	scoped.batchWriteTimeout = timeout
+	scoped.vacuumTimeout = timeout
+
+	return &scoped
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned when a time series is requested with a bucket size other than
+// minute, hour, or day.
+var ErrInvalidBucket = errors.New("bucket must be one of minute, hour, or day")
+
+// The number of events created within a single time bucket.
+type TimeSeriesBucket struct {
+	// The start of the bucket in RFC 3339 format, e.g. 2024-01-02T15:00:00Z.
+	Bucket string `json:"bucket"`
+
+	// The number of events in the bucket.
+	Count int64 `json:"count"`
+}
+
+// Counts the events with timestamps from start (inclusive) to end (exclusive),
+// grouped into minute, hour, or day buckets. Only buckets containing at least
+// one event are returned, in chronological order. Returns ErrInvalidBucket for
+// an unsupported bucket size, or an error if the operation fails.
+func (s *tursoService) GetEventTimeSeries(start, end time.Time, bucket string) ([]TimeSeriesBucket, error) {
+	format, ok := s.db.dialect.timeSeriesBuckets[bucket]
+	if !ok {
+		return nil, ErrInvalidBucket
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, s.db.dialect.timeSeriesQuery, format, start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []TimeSeriesBucket{}
+	for rows.Next() {
+		var b TimeSeriesBucket
+		if err := rows.Scan(&b.Bucket, &b.Count); err != nil {
+			return nil, err
+		}
+
+		buckets = append(buckets, b)
+	}
+
+	return buckets, nil
+}
@@ -0,0 +1,34 @@
+package database
+
+import "time"
+
+// The layout of event timestamps given without a UTC offset, e.g.
+// 2024-05-01T09:30:00. Fractional seconds are accepted too, since parsing
+// allows them after the seconds even though the layout doesn't include them.
+const localTimestampLayout = "2006-01-02T15:04:05"
+
+// Parses an event timestamp, which is either in RFC 3339 format or the same
+// without a UTC offset, in which case it's taken to be in loc.
+func ParseTimestamp(value string, loc *time.Location) (time.Time, error) {
+	ts, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return ts, nil
+	}
+
+	if local, localErr := time.ParseInLocation(localTimestampLayout, value, loc); localErr == nil {
+		return local, nil
+	}
+
+	return time.Time{}, err
+}
+
+// Returns the timestamp in loc, in the same RFC 3339 format it's stored in.
+// Timestamps that can't be parsed are returned as they are.
+func FormatTimestamp(value string, loc *time.Location) string {
+	ts, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return value
+	}
+
+	return ts.In(loc).Format(time.RFC3339Nano)
+}
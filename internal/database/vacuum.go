@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+)
+
+// The outcome of compacting the database with Vacuum.
+type VacuumResult struct {
+	// Whether the database doesn't support being compacted this way, e.g.
+	// Postgres, in which case nothing was done and the sizes are 0.
+	Skipped bool `json:"skipped"`
+
+	// The size of the database in bytes before and after it was compacted.
+	SizeBefore int64 `json:"size_before"`
+	SizeAfter  int64 `json:"size_after"`
+}
+
+// Compacts a SQLite database with VACUUM, which rebuilds the file without the
+// free pages left behind by deleted events, and reports its size before and
+// after. Does nothing for other databases.
+//
+// VACUUM takes an exclusive lock for as long as it runs, so writes from this
+// and every other connection wait for it up to the busy timeout
+// (DB_BUSY_TIMEOUT_MS) and then fail with "database is locked" rather than
+// deadlocking. It runs on a connection of its own, since SQLite refuses to
+// vacuum a connection with statements still in progress, and the WAL is
+// checkpointed afterwards so the file on disk shrinks too.
+func (s *tursoService) Vacuum() (VacuumResult, error) {
+	if s.db.dialect.name != sqliteDialect.name {
+		return VacuumResult{Skipped: true}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.vacuumTimeout)
+	defer cancel()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return VacuumResult{}, err
+	}
+	defer conn.Close()
+
+	var result VacuumResult
+
+	if result.SizeBefore, err = sqliteSize(ctx, conn); err != nil {
+		return VacuumResult{}, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return VacuumResult{}, err
+	}
+
+	// Readers may keep the WAL from being truncated, in which case it's reused
+	// rather than shrunk, so a failed checkpoint isn't an error.
+	conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+
+	if result.SizeAfter, err = sqliteSize(ctx, conn); err != nil {
+		return VacuumResult{}, err
+	}
+
+	return result, nil
+}
+
+// Returns the size of a SQLite database in bytes, which is its number of pages
+// times the page size.
+func sqliteSize(ctx context.Context, db querier) (int64, error) {
+	var pageCount, pageSize int64
+
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+
+	return pageCount * pageSize, nil
+}
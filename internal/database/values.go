@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// Returned when distinct values are requested for a field other than one of
+// EventValueFields.
+var ErrInvalidValueField = errors.New("invalid value field")
+
+// The fields of events whose distinct values can be listed. Events don't
+// have any other free-form fields worth filtering on.
+var EventValueFields = []string{"data"}
+
+// The columns holding each of EventValueFields. The field is never put into a
+// query directly, only the column it maps to.
+var eventValueColumns = map[string]string{
+	"data": "Data",
+}
+
+// One of the distinct values of a field and the number of events with it.
+type DistinctValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Returns the distinct values of the given field, one of EventValueFields,
+// across the events of the given type, with the number of events having each.
+// The most common values come first, and values with the same count are sorted
+// alphabetically. At most maxValues are returned, and truncated is true if
+// there were more. Returns ErrInvalidValueField for an unsupported field, or
+// an error if the operation fails.
+func (s *tursoService) GetDistinctValues(eventType EventType, field string, maxValues int) (values []DistinctValue, truncated bool, err error) {
+	column, ok := eventValueColumns[field]
+	if !ok {
+		return nil, false, ErrInvalidValueField
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	// One more than the maximum is selected to tell whether there were more.
+	query := "SELECT " + column + ", COUNT(*) FROM Events WHERE Type = ? GROUP BY " + column + " ORDER BY COUNT(*) DESC, " + column + " LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, query, eventType, maxValues+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	values = []DistinctValue{}
+	for rows.Next() {
+		var value DistinctValue
+		if err := rows.Scan(&value.Value, &value.Count); err != nil {
+			return nil, false, err
+		}
+
+		values = append(values, value)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(values) > maxValues {
+		return values[:maxValues], true, nil
+	}
+
+	return values, false, nil
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+// Returned when the requested webhook subscription doesn't exist.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// The outcome of the latest delivery to a webhook subscription.
+const (
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// A subscription that has newly created events POSTed to its URL.
+type Webhook struct {
+	// The unique identifier for the subscription, generated by the shortuuid
+	// package.
+	ID string `json:"id"`
+
+	// The URL events are POSTed to.
+	URL string `json:"url"`
+
+	// Only events of this type are delivered, or every event when empty.
+	Type EventType `json:"type,omitempty"`
+
+	// The secret each delivery is signed with. It's never included in
+	// responses.
+	Secret string `json:"-"`
+
+	// Whether events are delivered to the subscription. Subscriptions are
+	// disabled automatically after too many consecutive failed deliveries.
+	Enabled bool `json:"enabled"`
+
+	// The number of delivery attempts, including retries, that have failed in a
+	// row since the last successful one.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	// Whether the latest delivery succeeded or failed, or empty if there
+	// hasn't been one yet.
+	LastStatus string `json:"last_status,omitempty"`
+
+	// Why the latest delivery failed, or empty if it succeeded.
+	LastError string `json:"last_error,omitempty"`
+
+	// When the latest delivery finished in RFC 3339 format.
+	LastDeliveryAt string `json:"last_delivery_at,omitempty"`
+
+	// When the subscription was created in RFC 3339 format.
+	CreatedAt string `json:"created_at"`
+}
+
+// The columns of the webhooks table in the order they're scanned by
+// scanWebhook.
+const webhookColumns = "id, url, type, secret, enabled, consecutive_failures, last_status, last_error, last_delivery_at, created_at"
+
+// Creates a new webhook subscription with a unique ID. Returns the full
+// subscription if successful, or an error if the operation fails.
+func (s *tursoService) CreateWebhook(w Webhook) (Webhook, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	w.ID = shortuuid.New()
+	w.ConsecutiveFailures = 0
+	w.LastStatus, w.LastError, w.LastDeliveryAt = "", "", ""
+	w.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	query := "INSERT INTO webhooks (" + webhookColumns + ") VALUES (?, ?, ?, ?, ?, 0, '', '', '', ?)"
+	_, err := s.db.ExecContext(ctx, query, w.ID, w.URL, w.Type, w.Secret, w.Enabled, w.CreatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return w, nil
+}
+
+// Retrieves the webhook subscription with the given ID. Returns
+// ErrWebhookNotFound if it doesn't exist, or an error if the operation fails.
+func (s *tursoService) GetWebhook(id string) (Webhook, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	w, err := scanWebhook(s.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, ErrWebhookNotFound
+	}
+
+	return w, err
+}
+
+// Retrieves every webhook subscription, oldest first.
+func (s *tursoService) ListWebhooks() ([]Webhook, error) {
+	return s.queryWebhooks("SELECT " + webhookColumns + " FROM webhooks ORDER BY created_at")
+}
+
+// Retrieves the enabled webhook subscriptions that events of the given type
+// should be delivered to.
+func (s *tursoService) GetWebhooksForEvent(eventType EventType) ([]Webhook, error) {
+	return s.queryWebhooks("SELECT "+webhookColumns+" FROM webhooks WHERE enabled AND (type = '' OR type = ?) ORDER BY created_at", eventType)
+}
+
+// Replaces the URL, type filter, secret, and enabled flag of the webhook
+// subscription with the given ID. Enabling a subscription resets its
+// consecutive failures so a subscription that was disabled automatically gets
+// a fresh start. Returns the updated subscription, ErrWebhookNotFound if it
+// doesn't exist, or an error if the operation fails.
+func (s *tursoService) UpdateWebhook(w Webhook) (Webhook, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	query := `UPDATE webhooks SET url = ?, type = ?, secret = ?, enabled = ?,
+		consecutive_failures = CASE WHEN ? THEN 0 ELSE consecutive_failures END
+		WHERE id = ?`
+	result, err := s.db.ExecContext(ctx, query, w.URL, w.Type, w.Secret, w.Enabled, w.Enabled, w.ID)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if updated == 0 {
+		return Webhook{}, ErrWebhookNotFound
+	}
+
+	return s.GetWebhook(w.ID)
+}
+
+// Removes the webhook subscription with the given ID. Returns
+// ErrWebhookNotFound if it doesn't exist, or an error if the operation fails.
+func (s *tursoService) DeleteWebhook(id string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+// Records the outcome of a delivery to the webhook subscription with the given
+// ID. A nil deliveryErr resets the subscription's consecutive failures,
+// otherwise they're incremented and the subscription is disabled once they
+// reach maxFailures (when positive). Returns whether the subscription is now
+// disabled.
+func (s *tursoService) RecordWebhookDelivery(id string, deliveryErr error, maxFailures int) (bool, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var (
+		query string
+		args  []any
+	)
+	if deliveryErr == nil {
+		query = `UPDATE webhooks SET consecutive_failures = 0, last_status = ?, last_error = '', last_delivery_at = ?
+			WHERE id = ? RETURNING enabled`
+		args = []any{WebhookDeliverySucceeded, now, id}
+	} else {
+		// SET expressions see the row's old values, so the failure count is
+		// incremented in the comparison as well.
+		query = `UPDATE webhooks SET consecutive_failures = consecutive_failures + 1, last_status = ?, last_error = ?, last_delivery_at = ?,
+			enabled = CASE WHEN ? > 0 AND consecutive_failures + 1 >= ? THEN FALSE ELSE enabled END
+			WHERE id = ? RETURNING enabled`
+		args = []any{WebhookDeliveryFailed, deliveryErr.Error(), now, maxFailures, maxFailures, id}
+	}
+
+	var enabled bool
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrWebhookNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !enabled, nil
+}
+
+// Runs a query returning webhook subscriptions and scans every row.
+func (s *tursoService) queryWebhooks(query string, args ...any) ([]Webhook, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// Scans a row selected with webhookColumns into a Webhook.
+func scanWebhook(row interface{ Scan(dest ...any) error }) (Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.ID, &w.URL, &w.Type, &w.Secret, &w.Enabled, &w.ConsecutiveFailures, &w.LastStatus, &w.LastError, &w.LastDeliveryAt, &w.CreatedAt)
+	return w, err
+}
+
+// Create the webhooks table if it doesn't exist, which stores webhook
+// subscriptions and the status of their latest delivery. Returns an error if
+// it can't be created.
+func CreateWebhooksTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT NOT NULL PRIMARY KEY,
+		url TEXT NOT NULL,
+		type TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		consecutive_failures INTEGER NOT NULL DEFAULT 0,
+		last_status TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		last_delivery_at TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating webhooks table: %w", err)
+	}
+
+	return nil
+}
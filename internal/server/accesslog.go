@@ -0,0 +1,304 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+)
+
+// The layout of the timestamp added to the names of rotated access log files,
+// which sorts in the order they were rotated.
+const accessLogBackupLayout = "2006-01-02T15-04-05.000000000"
+
+// Writes the access log to a file, rotating it to a timestamped backup next to
+// it once it would grow past maxSize bytes and removing backups that are older
+// than maxAge or beyond the newest maxBackups. It's safe for concurrent use,
+// and every write lands whole in a single file.
+type rotatingWriter struct {
+	mu sync.Mutex
+
+	path string
+	file *os.File
+
+	// The current size of the file in bytes.
+	size int64
+
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	// Receives SIGHUP, and is closed by Close.
+	hangups chan os.Signal
+	done    chan struct{}
+}
+
+// Opens the access log file at the given path for appending, creating it and
+// its directory if needed, and starts reopening it whenever the process gets a
+// SIGHUP.
+func newRotatingWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		hangups:    make(chan os.Signal, 1),
+		done:       make(chan struct{}),
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	signal.Notify(w.hangups, syscall.SIGHUP)
+	go w.reopenOnHangup()
+
+	return w, nil
+}
+
+// Writes p to the file, rotating it first if p would take it past the max
+// size. A single write larger than the max size still goes in one file.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Closes and reopens the file at the configured path, so writes go to a new
+// file once an external tool such as logrotate has moved the old one away.
+func (w *rotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+
+	w.file.Close()
+
+	return w.open()
+}
+
+// Stops watching for SIGHUP and closes the file. It's safe to call on a nil
+// writer, which is what the server has when ACCESS_LOG_FILE isn't set.
+func (w *rotatingWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	signal.Stop(w.hangups)
+	close(w.done)
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+// Reopens the file on every SIGHUP until the writer is closed.
+func (w *rotatingWriter) reopenOnHangup() {
+	for {
+		select {
+		case <-w.hangups:
+			if err := w.Reopen(); err != nil {
+				fmt.Println("[rotatingWriter]: Error reopening the access log:", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Opens the file at the configured path for appending. The caller must hold
+// mu, or be the constructor.
+func (w *rotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+
+	return nil
+}
+
+// Moves the current file to a timestamped backup, opens a new one in its
+// place, and removes the backups that are no longer kept. The caller must
+// hold mu.
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(w.path, w.backupName(time.Now())); err != nil {
+		return err
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if err := w.prune(); err != nil {
+		fmt.Println("[rotatingWriter]: Error removing old access logs:", err)
+	}
+
+	return nil
+}
+
+// Returns the path of a backup rotated at the given time, such as
+// access-2026-10-18T09-30-00.000000000.log for access.log, adding a counter
+// if a backup was already rotated at the same instant.
+func (w *rotatingWriter) backupName(at time.Time) string {
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext) + "-" + at.UTC().Format(accessLogBackupLayout)
+
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+
+		name = base + "." + strconv.Itoa(i) + ext
+	}
+}
+
+// Removes the backups older than maxAge and all but the newest maxBackups.
+func (w *rotatingWriter) prune() error {
+	if w.maxAge == 0 && w.maxBackups == 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(w.path, ext) + "-"
+
+	backups, err := filepath.Glob(globEscape(prefix) + "*" + globEscape(ext))
+	if err != nil {
+		return err
+	}
+
+	type backup struct {
+		path    string
+		modTime time.Time
+	}
+
+	var existing []backup
+	for _, path := range backups {
+		// Other files that happen to match, e.g. access-old.log, aren't ours
+		// to remove.
+		stamp := strings.TrimPrefix(path, prefix)
+		if len(stamp) < len(accessLogBackupLayout) {
+			continue
+		}
+		if _, err := time.Parse(accessLogBackupLayout, stamp[:len(accessLogBackupLayout)]); err != nil {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		existing = append(existing, backup{path: path, modTime: info.ModTime()})
+	}
+
+	// Newest first.
+	slices.SortFunc(existing, func(a, b backup) int {
+		return cmp.Or(b.modTime.Compare(a.modTime), strings.Compare(b.path, a.path))
+	})
+
+	var errs []error
+	for i, b := range existing {
+		tooMany := w.maxBackups > 0 && i >= w.maxBackups
+		tooOld := w.maxAge > 0 && time.Since(b.modTime) > w.maxAge
+
+		if tooMany || tooOld {
+			if err := os.Remove(b.path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Escapes the characters filepath.Glob treats specially.
+func globEscape(path string) string {
+	var escaped strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+
+	return escaped.String()
+}
+
+// Returns the logger the request log is written to, which is the server's
+// logger unless ACCESS_LOG_FILE is set. In that case it's a logger writing to
+// the file, and to stdout too unless ACCESS_LOG_STDOUT is false, along with the
+// file's writer so it can be closed on shutdown. If the file can't be opened
+// the server's logger is used instead. The file's logger logs at the given
+// level, like the server's.
+func newAccessLogger(cfg config.Log, level slog.Leveler, logger *slog.Logger) (*slog.Logger, *rotatingWriter) {
+	if cfg.AccessLogFile == "" {
+		return logger, nil
+	}
+
+	file, err := newRotatingWriter(cfg.AccessLogFile, int64(cfg.AccessLogMaxSize)<<20, cfg.AccessLogMaxAge, cfg.AccessLogMaxBackups)
+	if err != nil {
+		fmt.Println("Error opening the access log, logging requests to stdout only:", err)
+		return logger, nil
+	}
+
+	var out io.Writer = file
+	if cfg.AccessLogStdout {
+		out = io.MultiWriter(os.Stdout, file)
+	}
+
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})), file
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns every line of every file in the directory.
+func readLogLines(t *testing.T, dir string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+	}
+
+	return lines
+}
+
+func TestRotatingWriterRotatesPastMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	w, err := newRotatingWriter(path, 1024, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 8 writers of 50 lines of 31 bytes each write 12.4 KB, forcing a dozen
+	// rotations while they race each other.
+	var wg sync.WaitGroup
+	for writer := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for line := range 50 {
+				fmt.Fprintf(w, "writer %02d line %02d %s\n", writer, line, strings.Repeat("x", 12))
+			}
+		}()
+	}
+	wg.Wait()
+
+	backups, err := filepath.Glob(filepath.Join(dir, "access-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) < 10 {
+		t.Fatalf("expected at least 10 rotated files, got %d", len(backups))
+	}
+
+	for _, backup := range append(backups, path) {
+		info, err := os.Stat(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1024 {
+			t.Errorf("%s grew past the max size: %d bytes", backup, info.Size())
+		}
+	}
+
+	// Every line made it to exactly one file, whole.
+	lines := readLogLines(t, dir)
+	if len(lines) != 400 {
+		t.Fatalf("unexpected number of lines: got %d want 400", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) != 30 || !strings.HasPrefix(line, "writer ") {
+			t.Fatalf("found a torn line: %q", line)
+		}
+	}
+}
+
+func TestRotatingWriterPrunesBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	// Neither an expired backup nor a file that isn't a backup is kept
+	// around by the count, but only the expired backup is removed.
+	expired := filepath.Join(dir, "access-2020-01-01T00-00-00.000000000.log")
+	unrelated := filepath.Join(dir, "access-old.log")
+	for _, file := range []string{expired, unrelated} {
+		if err := os.WriteFile(file, []byte("old\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newRotatingWriter(path, 10, 24*time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := range 5 {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "access-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated int
+	for _, backup := range backups {
+		switch backup {
+		case expired:
+			t.Error("expected the expired backup to be removed")
+		case unrelated:
+		default:
+			rotated++
+		}
+	}
+
+	if rotated != 2 {
+		t.Errorf("expected the 2 newest backups to be kept, got %d: %v", rotated, backups)
+	}
+
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("expected the unrelated file to be left alone: %v", err)
+	}
+}
+
+func TestRotatingWriterReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+
+	w, err := newRotatingWriter(path, 1<<20, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	fmt.Fprintln(w, "before")
+
+	// As logrotate would, move the file away and then ask for it to be
+	// reopened.
+	moved := filepath.Join(dir, "access.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Fprintln(w, "after")
+
+	for file, want := range map[string]string{moved: "before\n", path: "after\n"} {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != want {
+			t.Errorf("unexpected contents of %s: got %q want %q", file, contents, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The request body of the POST /event/:id/annotations endpoint.
+type AnnotationRequest struct {
+	// The text of the annotation.
+	Note string `json:"note"`
+
+	// Who is adding the annotation. Defaults to the Basic Auth username.
+	Author string `json:"author"`
+}
+
+// An event along with its annotations, returned by GET /event/:id when
+// ?include_annotations=true is set.
+type AnnotatedEvent struct {
+	database.EventEntry
+
+	Annotations []database.Annotation `json:"annotations"`
+}
+
+// Handles requests to the POST /event/:id/annotations endpoint, which adds a
+// note to the event without changing the event itself. Annotations can't be
+// edited or removed once added. Returns the new annotation, or 404 if the
+// event doesn't exist.
+func (s *Server) addAnnotationHandler(c *gin.Context) {
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	if strings.TrimSpace(req.Note) == "" {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "note is required"))
+		return
+	}
+
+	if req.Author == "" {
+		req.Author, _, _ = c.Request.BasicAuth()
+	}
+
+	annotation, err := s.dbFor(c).AddAnnotation(c.Param("id"), req.Note, req.Author)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusCreated, annotation)
+}
+
+// Handles requests to the GET /event/:id/annotations endpoint, which returns
+// the event's annotations oldest first, or 404 if the event doesn't exist.
+func (s *Server) getAnnotationsHandler(c *gin.Context) {
+	annotations, err := s.dbFor(c).GetAnnotations(c.Param("id"))
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, annotations)
+}
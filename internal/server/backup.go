@@ -0,0 +1,87 @@
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The layout of the timestamp in backup file names, which sorts in the order
+// the backups were taken and contains no characters that need escaping.
+const backupTimestampLayout = "20060102T150405Z"
+
+// Handles requests to the GET /admin/backup endpoint, which takes a snapshot of
+// a local SQLite database with SQLite's online backup API and streams it back
+// gzip-compressed as shion-backup-<timestamp>.db.gz. Requires the admin
+// credentials, otherwise a 403 is returned.
+//
+// A 503 is returned if the database can't be reached, and a 501 if it isn't a
+// local SQLite database (e.g. a remote Turso database or Postgres). The
+// snapshot is written to a temporary file, which is deleted once it's been
+// sent.
+func (s *Server) backupHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "backing up the database requires admin credentials"))
+		return
+	}
+
+	db := s.dbFor(c)
+	if err := db.Ping(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(c, err.Error()))
+		return
+	}
+
+	// SQLite may create journal files next to the backup, so it gets a
+	// directory of its own that's removed as a whole.
+	dir, err := os.MkdirTemp("", "shion-backup-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "shion.db")
+	if err := db.Backup(path); errors.Is(err, database.ErrBackupUnsupported) {
+		c.JSON(http.StatusNotImplemented, errorResponse(c, err.Error()))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+	defer file.Close()
+
+	// Sending a large database can take far longer than the server's write
+	// timeout allows for regular requests.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("shion-backup-%s.db.gz", time.Now().UTC().Format(backupTimestampLayout))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Content-Type", "application/gzip")
+	c.Status(http.StatusOK)
+
+	// The status has been sent by the time copying fails, so the client only
+	// sees a truncated gzip stream.
+	gz := gzip.NewWriter(c.Writer)
+	if _, err := io.Copy(gz, file); err != nil {
+		fmt.Println("[backupHandler]: Error sending the backup:", err)
+		return
+	}
+
+	if err := gz.Close(); err != nil {
+		fmt.Println("[backupHandler]: Error sending the backup:", err)
+	}
+}
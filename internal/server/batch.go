@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The maximum number of IDs that can be requested in a single call to the
+// POST /events/batch-get endpoint.
+const maxBatchGetIDs = 100
+
+// The request body accepted by the POST /events/batch-get endpoint.
+type BatchGetRequest struct {
+	// The IDs of the events to return.
+	IDs []string `json:"ids"`
+}
+
+// The response body returned by the POST /events/batch-get endpoint.
+type BatchGetResponse struct {
+	// The events that were found, in the order their IDs were requested.
+	Events []database.EventEntry `json:"events"`
+
+	// The requested IDs that don't match any event.
+	Missing []string `json:"missing"`
+}
+
+// Handles requests to the POST /events/batch-get endpoint, which accepts a list
+// of up to maxBatchGetIDs event IDs and returns every matching event in one
+// round trip, along with the IDs that weren't found. Duplicate IDs are only
+// looked up and returned once.
+func (s *Server) batchGetEventsHandler(c *gin.Context) {
+	var req BatchGetRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	ids := uniqueIDs(req.IDs)
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "at least one id is required"))
+		return
+	}
+
+	if len(ids) > maxBatchGetIDs {
+		c.JSON(http.StatusBadRequest, errorResponse(c, fmt.Sprintf("too many ids: got %d, the maximum is %d", len(ids), maxBatchGetIDs)))
+		return
+	}
+
+	found, err := s.dbFor(c).GetEventsByIDs(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	byID := make(map[string]database.EventEntry, len(found))
+	for _, event := range found {
+		byID[event.ID] = event
+	}
+
+	resp := BatchGetResponse{Events: []database.EventEntry{}, Missing: []string{}}
+	for _, id := range ids {
+		if event, ok := byID[id]; ok {
+			resp.Events = append(resp.Events, event)
+		} else {
+			resp.Missing = append(resp.Missing, id)
+		}
+	}
+
+	resp.Events = localizeEvents(c, resp.Events)
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Returns the non-empty IDs in the given slice with duplicates removed,
+// preserving the order they first appear in.
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	unique := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+
+	return unique
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The most bytes of a request or response body that are logged.
+const maxLoggedBodySize = 2048
+
+// Returns the logger request and response bodies are written to when
+// DEBUG_LOG_BODIES is true. Bodies are logged at the Debug level, so they go
+// through their own handler rather than the default logger, which drops Debug
+// messages.
+func newBodyLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// Captures up to maxLoggedBodySize bytes of the response body as it's written
+// to the client.
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+
+	// The total number of bytes written, including any that weren't captured.
+	size int
+}
+
+func (w *bodyCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Returns the wrapped writer so http.ResponseController can reach the
+// connection, e.g. for streaming handlers clearing their write deadline.
+func (w *bodyCaptureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *bodyCaptureWriter) capture(data []byte) {
+	w.size += len(data)
+	if remaining := maxLoggedBodySize - w.body.Len(); remaining > 0 {
+		w.body.Write(data[:min(remaining, len(data))])
+	}
+}
+
+// A debugging middleware that logs each request's body before it's handled and
+// the response's body once it has been written, both truncated to 2048 bytes.
+// The request body is read in full and then restored so the handlers can still
+// parse it. Bodies may contain sensitive data, so this is only registered when
+// DEBUG_LOG_BODIES is true.
+func bodyLoggingMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				logger.Debug("Error reading request body", "method", c.Request.Method, "path", c.Request.URL.Path, "request_id", requestIDOf(c), "error", err)
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			logger.Debug("Request body",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"request_id", requestIDOf(c),
+				"size", len(body),
+				"truncated", len(body) > maxLoggedBodySize,
+				"body", string(body[:min(maxLoggedBodySize, len(body))]),
+			)
+		}
+
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		logger.Debug("Response body",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"request_id", requestIDOf(c),
+			"status", writer.Status(),
+			"size", writer.size,
+			"truncated", writer.size > maxLoggedBodySize,
+			"body", writer.body.String(),
+		)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// How many seconds clients are told to wait with Retry-After before retrying a
+// write that found the database locked.
+const databaseBusyRetryAfter = 1
+
+// Responds to a write that failed with a 503 and a Retry-After header if the
+// database was still locked by another connection after every retry, since
+// trying again shortly will likely succeed, a 422 if an event processor
+// rejected the event, or a 500 for any other error.
+func databaseErrorResponse(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrEventRejected) {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(c, err.Error()))
+		return
+	}
+
+	if database.IsBusy(err) {
+		c.Header("Retry-After", strconv.Itoa(databaseBusyRetryAfter))
+		c.JSON(http.StatusServiceUnavailable, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Configures how the router resolves c.ClientIP, which the request logs, the
+// rate limiter, and the WebSocket connection limits use. Gin trusts the
+// forwarding headers of every peer by default, which lets any client spoof its
+// IP, so only the proxies in TRUSTED_PROXIES are trusted, and none by default.
+// For a request from a trusted proxy the client's IP is the last address in
+// X-Forwarded-For that isn't itself a trusted proxy, or the X-Real-IP header
+// when TRUST_X_REAL_IP is set and there's no X-Forwarded-For. Every other
+// request is attributed to the address it came from.
+func (s *Server) configureClientIP(r *gin.Engine) {
+	r.ForwardedByClientIP = true
+
+	r.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	if s.trustXRealIP {
+		r.RemoteIPHeaders = append(r.RemoteIPHeaders, "X-Real-IP")
+	}
+
+	// The proxies were validated when the configuration was loaded, so this
+	// can only fail if the server was built by hand.
+	if err := r.SetTrustedProxies(s.trustedProxies); err != nil {
+		fmt.Println("[configureClientIP]: Not trusting any proxies, the trusted proxies are invalid:", err)
+		r.SetTrustedProxies(nil)
+	}
+}
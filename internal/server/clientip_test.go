@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		proxies      []string
+		trustXRealIP bool
+		remoteAddr   string
+		headers      map[string]string
+		want         string
+	}{
+		{
+			name:       "no proxies are trusted by default",
+			remoteAddr: "203.0.113.7:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "203.0.113.7:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.5:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			// A client can prepend anything, so only the address the proxy
+			// appended is believed.
+			name:       "spoofed entries before the trusted proxy's",
+			proxies:    []string{"10.0.0.5"},
+			remoteAddr: "10.0.0.5:4242",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.5:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 10.1.2.3"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "X-Real-IP ignored by default",
+			proxies:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.5:4242",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:       "10.0.0.5",
+		},
+		{
+			name:         "X-Real-IP from a trusted proxy",
+			proxies:      []string{"10.0.0.0/8"},
+			trustXRealIP: true,
+			remoteAddr:   "10.0.0.5:4242",
+			headers:      map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:         "198.51.100.1",
+		},
+		{
+			name:         "X-Real-IP from an untrusted peer",
+			proxies:      []string{"10.0.0.0/8"},
+			trustXRealIP: true,
+			remoteAddr:   "203.0.113.7:4242",
+			headers:      map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:         "203.0.113.7",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Server{trustedProxies: test.proxies, trustXRealIP: test.trustXRealIP}
+
+			r := gin.New()
+			s.configureClientIP(r)
+			r.GET("/ip", func(c *gin.Context) {
+				c.String(http.StatusOK, c.ClientIP())
+			})
+
+			req := httptest.NewRequest("GET", "/ip", nil)
+			req.RemoteAddr = test.remoteAddr
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if got := rec.Body.String(); got != test.want {
+				t.Errorf("unexpected client IP: got %q want %q", got, test.want)
+			}
+		})
+	}
+}
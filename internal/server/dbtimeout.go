@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The header admins can send to override the database timeout for a single
+// request, in milliseconds.
+const dbTimeoutHeader = "X-DB-Timeout-MS"
+
+// The longest database timeout a request can ask for with dbTimeoutHeader.
+// Longer values are capped to this.
+const maxDBTimeoutOverride = 30 * time.Second
+
+// The gin context key the request's database is stored under when its timeout
+// has been overridden.
+const dbContextKey = "db"
+
+// A middleware that lets admins override the database timeout for a single
+// request with the X-DB-Timeout-MS header, e.g. to run a slow query that would
+// otherwise time out. Non-admins sending the header get a 403 and invalid
+// values a 400. Handlers must use dbFor to pick up the override.
+func (s *Server) dbTimeoutMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader(dbTimeoutHeader)
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		if !isAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorResponse(c, dbTimeoutHeader+" requires admin credentials"))
+			return
+		}
+
+		ms, err := strconv.Atoi(header)
+		if err != nil || ms <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(c, dbTimeoutHeader+" must be a positive number of milliseconds"))
+			return
+		}
+
+		timeout := min(time.Duration(ms)*time.Millisecond, maxDBTimeoutOverride)
+		c.Set(dbContextKey, s.db.WithTimeout(timeout))
+
+		c.Next()
+	}
+}
+
+// Returns the database handlers should use for the request, which has the
+// request's timeout override applied if it has one, and runs its queries with
+// the request's context so they're logged with its ID.
+func (s *Server) dbFor(c *gin.Context) database.TursoDB {
+	db := s.db
+	if override, ok := c.Get(dbContextKey); ok {
+		db = override.(database.TursoDB)
+	}
+
+	if db == nil {
+		return nil
+	}
+
+	return db.WithContext(c.Request.Context())
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Registers the runtime debug endpoints under /debug, which are only served
+// when ENABLE_DEBUG_ENDPOINTS is true and always require the admin
+// credentials:
+//
+//   - /debug/pprof/ lists the profiles, and /debug/pprof/:name serves one,
+//     e.g. heap or goroutine, the same as the net/http/pprof package.
+//   - /debug/pprof/profile and /debug/pprof/trace record a CPU profile or an
+//     execution trace for ?seconds=, and aren't cut off by HTTP_WRITE_TIMEOUT.
+//   - /debug/vars serves the expvar variables, e.g. memstats.
+func (s *Server) registerDebugRoutes(r *gin.Engine) {
+	debugGroup := r.Group("/debug",
+		basicAuthMiddleware(s.apiUsername, s.apiPassword, s.adminUsername, s.adminPassword),
+		requireAdminMiddleware("the debug endpoints require admin credentials"),
+	)
+
+	debugGroup.GET("/vars", gin.WrapH(expvar.Handler()))
+	debugGroup.Any("/pprof/*name", pprofHandler)
+}
+
+// A middleware that only lets requests authenticated with the admin credentials
+// through, returning a 403 with the given message for any other.
+func requireAdminMiddleware(message string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorResponse(c, message))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Serves the net/http/pprof endpoint named by the route's path, falling back
+// to pprof.Index, which lists the profiles and serves the named ones.
+func pprofHandler(c *gin.Context) {
+	switch strings.TrimPrefix(c.Param("name"), "/") {
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "profile":
+		withoutWriteTimeout(c, pprof.Profile)
+	case "trace":
+		withoutWriteTimeout(c, pprof.Trace)
+	default:
+		pprof.Index(c.Writer, c.Request)
+	}
+}
+
+// Serves a pprof endpoint that streams for ?seconds= after clearing the
+// response's write deadline, so HTTP_WRITE_TIMEOUT doesn't cut it off. pprof
+// refuses durations longer than the server's write timeout, so it's handed a
+// server without one.
+func withoutWriteTimeout(c *gin.Context, handler http.HandlerFunc) {
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	ctx := context.WithValue(c.Request.Context(), http.ServerContextKey, &http.Server{})
+	handler(c.Writer, c.Request.WithContext(ctx))
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4lch4/shion-api/client"
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// The headers sent with every webhook delivery in addition to the
+// X-Shion-Timestamp and X-Shion-Signature headers.
+const (
+	// The ID of the event being delivered, which stays the same across retries
+	// so receivers can deduplicate.
+	webhookEventIDHeader = "X-Shion-Event-ID"
+
+	// The delivery attempt number, starting at 1.
+	webhookAttemptHeader = "X-Shion-Delivery-Attempt"
+)
+
+// Configures how a WebhookDispatcher delivers events and retries failed
+// deliveries. Zero values are replaced with their defaults.
+type WebhookConfig struct {
+	// The number of deliveries that can be sent at the same time. Defaults to
+	// 4.
+	Workers int
+
+	// The number of newly created events that can be waiting to be dispatched
+	// before new ones are dropped. Defaults to 1024.
+	QueueSize int
+
+	// How long a single delivery attempt may take. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// The most times an event is sent to a subscription before giving up.
+	// Defaults to 5.
+	MaxAttempts int
+
+	// How long to wait before the first retry, which doubles after each
+	// attempt. Defaults to 1 second.
+	InitialBackoff time.Duration
+
+	// The longest wait between retries. Defaults to 5 minutes.
+	MaxBackoff time.Duration
+
+	// The number of consecutive failed attempts after which a subscription is
+	// disabled. Defaults to 20.
+	MaxFailures int
+}
+
+// A webhook delivery of a single event to a single subscription.
+type webhookDelivery struct {
+	webhook database.Webhook
+	event   database.EventEntry
+	attempt int
+}
+
+// A WebhookDispatcher POSTs newly created events to the webhook subscriptions
+// interested in them. Events are queued and sent in the background so slow
+// webhook targets never delay the request that created the event.
+type WebhookDispatcher struct {
+	db     database.TursoDB
+	client *http.Client
+	config WebhookConfig
+
+	// Newly created events waiting to be matched with subscriptions.
+	events chan database.EventEntry
+
+	// Deliveries waiting for a worker, including retries once their backoff
+	// has passed.
+	deliveries chan webhookDelivery
+
+	// Closed when the dispatcher starts shutting down.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Tracks the goroutines that need to finish before shutdown completes.
+	running sync.WaitGroup
+
+	// The number of events that weren't dispatched because the queue was full.
+	dropped atomic.Int64
+}
+
+// Creates a WebhookDispatcher that reads subscriptions from the given database
+// and starts its workers.
+func NewWebhookDispatcher(db database.TursoDB, config WebhookConfig) *WebhookDispatcher {
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = 20
+	}
+
+	d := &WebhookDispatcher{
+		db:         db,
+		client:     &http.Client{Timeout: config.Timeout},
+		config:     config,
+		events:     make(chan database.EventEntry, config.QueueSize),
+		deliveries: make(chan webhookDelivery),
+		closing:    make(chan struct{}),
+	}
+
+	d.running.Add(1 + config.Workers)
+	go d.route()
+	for i := 0; i < config.Workers; i++ {
+		go d.work()
+	}
+
+	return d
+}
+
+// Queues the given events to be delivered to every matching subscription
+// without waiting for them to be sent. Events are dropped if the queue is full
+// or the dispatcher is shutting down.
+func (d *WebhookDispatcher) Enqueue(events ...database.EventEntry) {
+	for _, event := range events {
+		select {
+		case <-d.closing:
+			return
+		default:
+		}
+
+		select {
+		case d.events <- event:
+		default:
+			d.dropped.Add(1)
+			fmt.Println("[WebhookDispatcher]: Queue is full, dropping event", event.ID)
+		}
+	}
+}
+
+// Sends the event to every enabled subscription interested in it straight
+// away and records each outcome, without retrying. Used by the outbox relay,
+// which retries the event itself, so the given attempt number is sent in the
+// X-Shion-Delivery-Attempt header. Returns the errors of the deliveries that
+// failed.
+func (d *WebhookDispatcher) Deliver(event database.EventEntry, attempt int) error {
+	webhooks, err := d.db.GetWebhooksForEvent(event.Type)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, webhook := range webhooks {
+		deliveryErr := d.send(webhookDelivery{webhook: webhook, event: event, attempt: attempt})
+
+		disabled, err := d.db.RecordWebhookDelivery(webhook.ID, deliveryErr, d.config.MaxFailures)
+		if err != nil && !errors.Is(err, database.ErrWebhookNotFound) {
+			fmt.Println("[WebhookDispatcher]: Error recording delivery to webhook", webhook.ID, err)
+		}
+
+		if disabled {
+			fmt.Printf("[WebhookDispatcher]: Disabled webhook %s after %d consecutive failures\n", webhook.ID, d.config.MaxFailures)
+		}
+
+		if deliveryErr != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", webhook.ID, deliveryErr))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns the number of events that weren't dispatched because the queue was
+// full.
+func (d *WebhookDispatcher) Dropped() int64 {
+	return d.dropped.Load()
+}
+
+// Stops dispatching events and waits for in-flight deliveries to finish.
+// Queued events and pending retries are abandoned. Returns the context's error
+// if it's done first.
+func (d *WebhookDispatcher) Shutdown(ctx context.Context) error {
+	d.closeOnce.Do(func() { close(d.closing) })
+
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Matches queued events with the subscriptions interested in them and hands a
+// delivery for each to the workers.
+func (d *WebhookDispatcher) route() {
+	defer d.running.Done()
+
+	for {
+		select {
+		case <-d.closing:
+			return
+		case event := <-d.events:
+			webhooks, err := d.db.GetWebhooksForEvent(event.Type)
+			if err != nil {
+				fmt.Println("[WebhookDispatcher]: Error loading webhooks for event", event.ID, err)
+				continue
+			}
+
+			for _, webhook := range webhooks {
+				if !d.schedule(webhookDelivery{webhook: webhook, event: event, attempt: 1}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Hands the delivery to a worker, waiting for one to be free. Returns false if
+// the dispatcher shut down first.
+func (d *WebhookDispatcher) schedule(delivery webhookDelivery) bool {
+	select {
+	case d.deliveries <- delivery:
+		return true
+	case <-d.closing:
+		return false
+	}
+}
+
+// Sends deliveries, records their outcome, and schedules retries for the ones
+// that failed.
+func (d *WebhookDispatcher) work() {
+	defer d.running.Done()
+
+	for {
+		select {
+		case <-d.closing:
+			return
+		case delivery := <-d.deliveries:
+			d.attempt(delivery)
+		}
+	}
+}
+
+// Sends a single delivery attempt and records its outcome. A failed attempt is
+// retried after a backoff unless it was the last attempt or the subscription
+// has been disabled.
+func (d *WebhookDispatcher) attempt(delivery webhookDelivery) {
+	// Retries use the latest version of the subscription so they pick up a new
+	// URL or secret, and stop if it has been disabled or deleted.
+	if delivery.attempt > 1 {
+		webhook, err := d.db.GetWebhook(delivery.webhook.ID)
+		if err != nil || !webhook.Enabled {
+			return
+		}
+
+		delivery.webhook = webhook
+	}
+
+	deliveryErr := d.send(delivery)
+
+	disabled, err := d.db.RecordWebhookDelivery(delivery.webhook.ID, deliveryErr, d.config.MaxFailures)
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		return
+	}
+	if err != nil {
+		fmt.Println("[WebhookDispatcher]: Error recording delivery to webhook", delivery.webhook.ID, err)
+	}
+
+	if deliveryErr == nil {
+		return
+	}
+
+	if disabled {
+		fmt.Printf("[WebhookDispatcher]: Disabled webhook %s after %d consecutive failures\n", delivery.webhook.ID, d.config.MaxFailures)
+		return
+	}
+
+	if delivery.attempt >= d.config.MaxAttempts {
+		fmt.Printf("[WebhookDispatcher]: Giving up delivering event %s to webhook %s: %s\n", delivery.event.ID, delivery.webhook.ID, deliveryErr)
+		return
+	}
+
+	retry := delivery
+	retry.attempt++
+
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+
+		timer := time.NewTimer(d.backoff(delivery.attempt))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			d.schedule(retry)
+		case <-d.closing:
+		}
+	}()
+}
+
+// Returns how long to wait before retrying after the given failed attempt,
+// which doubles with each attempt up to the configured maximum.
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	backoff := d.config.InitialBackoff
+	for i := 1; i < attempt && backoff < d.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, d.config.MaxBackoff)
+}
+
+// POSTs the delivery's event as JSON to its subscription's URL, signed with
+// the subscription's secret the same way client.SignRequest signs requests to
+// the API. Returns an error if the request fails or the response status isn't
+// 2xx.
+func (d *WebhookDispatcher) send(delivery webhookDelivery) error {
+	body, err := json.Marshal(delivery.event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(client.TimestampHeader, timestamp)
+	req.Header.Set(client.SignatureHeader, "sha256="+client.Signature(delivery.webhook.Secret, timestamp, req.Method, req.URL.RequestURI(), body))
+	req.Header.Set(webhookEventIDHeader, delivery.event.ID)
+	req.Header.Set(webhookAttemptHeader, strconv.Itoa(delivery.attempt))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
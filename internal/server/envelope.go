@@ -0,0 +1,32 @@
+package server
+
+import "github.com/gin-gonic/gin"
+
+// The header clients can send instead of ?envelope=false to get responses
+// without the EventResponse envelope.
+const envelopeHeader = "X-Response-Envelope"
+
+// The gin context key storing whether the response should be wrapped in the
+// EventResponse envelope.
+const envelopeContextKey = "envelope"
+
+// A middleware that records whether the client wants responses wrapped in the
+// EventResponse envelope. It's on unless the request has ?envelope=false or an
+// X-Response-Envelope: false header, for clients that only want the raw events.
+// Handlers check the choice with wantsEnvelope.
+func responseEnvelopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(envelopeContextKey, c.Query("envelope") != "false" && c.GetHeader(envelopeHeader) != "false")
+		c.Next()
+	}
+}
+
+// Returns whether the response to the request should be wrapped in the
+// EventResponse envelope, which it is unless the client turned it off.
+func wantsEnvelope(c *gin.Context) bool {
+	if envelope, ok := c.Get(envelopeContextKey); ok {
+		return envelope.(bool)
+	}
+
+	return true
+}
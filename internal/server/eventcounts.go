@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// The default interval the events in the database are counted at, used when
+// METRICS_DB_POLL_INTERVAL_SECONDS is unset.
+const defaultEventCountPollInterval = 30 * time.Second
+
+// An eventCountPoller periodically counts the events in the database, in total
+// and by type, and sets the shion_events_total_gauge and
+// shion_events_by_type_gauge metrics to the counts, so dashboards can follow
+// the database's growth without querying it themselves.
+type eventCountPoller struct {
+	db       database.TursoDB
+	metrics  *serverMetrics
+	interval time.Duration
+
+	// Closed once the database is ready and the poller should start.
+	started   chan struct{}
+	startOnce sync.Once
+
+	// Closed when the poller starts shutting down.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Closed once the poller has stopped.
+	done chan struct{}
+}
+
+// Creates an eventCountPoller that counts the events in the given database
+// every interval. It doesn't count anything until it's started.
+func newEventCountPoller(db database.TursoDB, metrics *serverMetrics, interval time.Duration) *eventCountPoller {
+	if interval <= 0 {
+		interval = defaultEventCountPollInterval
+	}
+
+	p := &eventCountPoller{
+		db:       db,
+		metrics:  metrics,
+		interval: interval,
+		started:  make(chan struct{}),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+// Starts counting the events, straight away and then every interval. Does
+// nothing if the poller has already started.
+func (p *eventCountPoller) Start() {
+	p.startOnce.Do(func() { close(p.started) })
+}
+
+// Stops the poller once the count it's running, if any, has finished. Returns
+// the context's error if it's done first.
+func (p *eventCountPoller) Shutdown(ctx context.Context) error {
+	p.closeOnce.Do(func() { close(p.closing) })
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Counts the events every interval until the poller shuts down.
+func (p *eventCountPoller) run() {
+	defer close(p.done)
+
+	select {
+	case <-p.started:
+	case <-p.closing:
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll()
+
+		select {
+		case <-p.closing:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Counts the events and updates the gauges. Types that no longer have any
+// events are removed from shion_events_by_type_gauge. The gauges keep their
+// last values if counting fails.
+func (p *eventCountPoller) poll() {
+	total, err := p.db.GetEventCount()
+	if err != nil {
+		fmt.Println("[eventCountPoller]: Error counting events:", err)
+		return
+	}
+
+	counts, err := p.db.GetEventCountsByType()
+	if err != nil {
+		fmt.Println("[eventCountPoller]: Error counting events by type:", err)
+		return
+	}
+
+	p.metrics.eventsTotal.Set(float64(total))
+
+	p.metrics.eventsByType.Reset()
+	for eventType, count := range counts {
+		p.metrics.eventsByType.WithLabelValues(string(eventType)).Set(float64(count))
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A middleware that lets the request through to the route's handler when the
+// feature is on, and otherwise responds with the fallback instead. The
+// fallback defaults to a 501 when nil.
+func featureFlagMiddleware(enabled bool, fallback gin.HandlerFunc) gin.HandlerFunc {
+	if fallback == nil {
+		fallback = featureDisabledHandler("this feature")
+	}
+
+	return func(c *gin.Context) {
+		if enabled {
+			c.Next()
+			return
+		}
+
+		fallback(c)
+		c.Abort()
+	}
+}
+
+// Returns a handler that responds with a 501 saying the named feature is
+// turned off.
+func featureDisabledHandler(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusNotImplemented, errorResponse(c, feature+" is disabled"))
+	}
+}
+
+// Handles requests to the GET /admin/features endpoint, which returns which
+// optional features are turned on. Requires admin credentials.
+func (s *Server) featuresHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "viewing feature flags requires admin credentials"))
+		return
+	}
+
+	c.JSON(http.StatusOK, s.features)
+}
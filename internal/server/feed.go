@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The number of events included in the Atom feed.
+const feedMaxEvents = 50
+
+// An Atom 1.0 feed as described by RFC 4287.
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    AtomLink    `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// A link to a related resource, e.g. the feed itself.
+type AtomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// A single event within an Atom feed.
+type AtomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category AtomCategory `xml:"category"`
+	Content  AtomContent  `xml:"content"`
+}
+
+// The category of an entry, which holds the event type.
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// The content of an entry, which holds the event data.
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Handles requests to the GET /feed/events endpoint, which returns the latest
+// events as an Atom 1.0 feed for monitoring tools and feed readers. Accepts an
+// optional ?type= query parameter to only include events of a single type.
+func (s *Server) feedEventsHandler(c *gin.Context) {
+	var events []database.EventEntry
+	var err error
+
+	if eventType := c.Query("type"); eventType != "" {
+		events, err = s.dbFor(c).GetLatestEventsByType(database.EventType(eventType), feedMaxEvents)
+	} else {
+		events, err = s.dbFor(c).GetLatestEvents(feedMaxEvents)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	selfURL := requestURL(c.Request)
+	feed := AtomFeed{
+		ID:      selfURL,
+		Title:   "Shion Events",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    AtomLink{Rel: "self", Href: selfURL},
+		Entries: make([]AtomEntry, 0, len(events)),
+	}
+
+	// Events are sorted newest first, so the feed was last updated when the
+	// first event happened.
+	if len(events) > 0 {
+		feed.Updated = events[0].Timestamp
+	}
+
+	for _, event := range events {
+		feed.Entries = append(feed.Entries, AtomEntry{
+			ID:       "urn:shion:event:" + event.ID,
+			Title:    string(event.Type),
+			Updated:  event.Timestamp,
+			Category: AtomCategory{Term: string(event.Type)},
+			Content:  AtomContent{Type: "text", Body: event.Data},
+		})
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// Returns the absolute URL the client used to make the request, taking TLS
+// termination by a reverse proxy into account.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
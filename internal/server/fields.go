@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The gin context key the fields the client asked for with ?fields= are
+// stored under.
+const fieldsContextKey = "fields"
+
+// The fields of an event clients can ask for with ?fields=, keyed by their
+// name in the response JSON, in the order they're listed in errors.
+var eventFields = []struct {
+	name  string
+	value func(event database.EventEntry) any
+}{
+	{"id", func(event database.EventEntry) any { return event.ID }},
+	{"type", func(event database.EventEntry) any { return event.Type }},
+	{"data", func(event database.EventEntry) any { return event.Data }},
+	{"timestamp", func(event database.EventEntry) any { return event.Timestamp }},
+	{"priority", func(event database.EventEntry) any { return event.Priority }},
+	{"unique_key", func(event database.EventEntry) any { return event.UniqueKey }},
+}
+
+// A middleware that reads the comma-separated list of event fields the client
+// wants returned from ?fields=, e.g. ?fields=id,type, and responds with a 400
+// if any of them isn't one of eventFields. Handlers project the events they
+// return with projectEvent and projectEvents, which return every field when
+// ?fields= is missing or empty.
+//
+// The fields are only ever picked out of the events after they've been
+// queried, so the client's input never reaches the database.
+func fieldsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fields, err := parseFields(c.Query("fields"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+			return
+		}
+
+		c.Set(fieldsContextKey, fields)
+		c.Next()
+	}
+}
+
+// Parses a comma-separated list of event field names, ignoring blank entries
+// and duplicates. Returns nil if there aren't any, or an error naming the
+// first field that isn't one of eventFields.
+func parseFields(raw string) ([]string, error) {
+	var fields []string
+
+	for _, part := range strings.Split(raw, ",") {
+		name := strings.TrimSpace(part)
+		if name == "" || slices.Contains(fields, name) {
+			continue
+		}
+
+		if !isEventField(name) {
+			names := make([]string, len(eventFields))
+			for i, field := range eventFields {
+				names[i] = field.name
+			}
+
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+
+		fields = append(fields, name)
+	}
+
+	return fields, nil
+}
+
+// Returns whether name is one of eventFields.
+func isEventField(name string) bool {
+	for _, field := range eventFields {
+		if field.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the fields the client asked for with ?fields=, or nil for every
+// field.
+func fieldsOf(c *gin.Context) []string {
+	if fields, ok := c.Get(fieldsContextKey); ok {
+		return fields.([]string)
+	}
+
+	return nil
+}
+
+// Returns the given fields of the event as a map from their names to values.
+func selectFields(event database.EventEntry, fields []string) map[string]any {
+	selected := make(map[string]any, len(fields))
+	for _, field := range eventFields {
+		if slices.Contains(fields, field.name) {
+			selected[field.name] = field.value(event)
+		}
+	}
+
+	return selected
+}
+
+// Returns the event with only the fields the client asked for, or the event
+// itself if every field was asked for, with its timestamp in the timezone the
+// client asked for (see timezoneMiddleware).
+func projectEvent(c *gin.Context, event database.EventEntry) any {
+	event = localizeEvent(c, event)
+
+	fields := fieldsOf(c)
+	if len(fields) == 0 {
+		return event
+	}
+
+	return selectFields(event, fields)
+}
+
+// Returns the events with only the fields the client asked for, or the events
+// themselves if every field was asked for, with their timestamps in the
+// timezone the client asked for (see timezoneMiddleware).
+func projectEvents(c *gin.Context, events []database.EventEntry) any {
+	events = localizeEvents(c, events)
+
+	if len(fieldsOf(c)) == 0 {
+		return events
+	}
+
+	projected := make([]map[string]any, len(events))
+	for i, event := range events {
+		projected[i] = selectFields(event, fieldsOf(c))
+	}
+
+	return projected
+}
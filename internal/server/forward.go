@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Configures how an EventForwarder sends events to the secondary instance.
+// Zero values are replaced with their defaults.
+type ForwardConfig struct {
+	// The base URL of the secondary instance, e.g. https://standby.example.com.
+	// Events are POSTed to <URL>/api/v1/event.
+	URL string
+
+	// The Basic Auth credentials of the secondary instance.
+	Username string
+	Password string
+
+	// The number of events that can be forwarded at the same time. Defaults to
+	// 4.
+	Workers int
+
+	// The number of events that can be waiting to be forwarded before new ones
+	// are dropped. Defaults to 10000.
+	BufferSize int
+
+	// The most times a failed forward is retried before giving up. Defaults to
+	// 3.
+	MaxRetries int
+
+	// How long to wait before the first retry, which doubles after each retry.
+	// Defaults to 1 second.
+	RetryBackoff time.Duration
+
+	// How long a single forward attempt may take. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// An EventForwarder mirrors events created through POST /event to a secondary
+// Shion API instance, e.g. a hot standby. Events are buffered and sent by a
+// pool of workers so a slow or unreachable secondary never delays the request
+// that created the event.
+type EventForwarder struct {
+	client *http.Client
+	config ForwardConfig
+
+	// The URL events are POSTed to.
+	eventURL string
+
+	// Events waiting to be forwarded.
+	events chan database.EventEntry
+
+	// Closed when the forwarder starts shutting down, after which the workers
+	// forward whatever is left in the buffer and exit.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Closed when the shutdown deadline passes, which cuts short any retries
+	// that are waiting for their backoff.
+	abort     chan struct{}
+	abortOnce sync.Once
+
+	// Tracks the workers that need to finish before shutdown completes.
+	running sync.WaitGroup
+}
+
+// Creates an EventForwarder for the secondary instance at the configured URL
+// and starts its workers.
+func NewEventForwarder(config ForwardConfig) *EventForwarder {
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 10000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	f := &EventForwarder{
+		client:   &http.Client{Timeout: config.Timeout},
+		config:   config,
+		eventURL: strings.TrimSuffix(config.URL, "/") + apiBasePath + "/event",
+		events:   make(chan database.EventEntry, config.BufferSize),
+		closing:  make(chan struct{}),
+		abort:    make(chan struct{}),
+	}
+
+	f.running.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go f.work()
+	}
+
+	return f
+}
+
+// Queues the given events to be forwarded without waiting for them to be sent.
+// Events are dropped if the buffer is full or the forwarder is shutting down.
+// Does nothing if the forwarder is nil, i.e. FORWARD_URL isn't set.
+func (f *EventForwarder) Enqueue(events ...database.EventEntry) {
+	if f == nil {
+		return
+	}
+
+	for _, event := range events {
+		select {
+		case <-f.closing:
+			fmt.Println("[EventForwarder]: Shutting down, dropping event", event.ID)
+			continue
+		default:
+		}
+
+		select {
+		case f.events <- event:
+		default:
+			fmt.Println("[EventForwarder]: Buffer is full, dropping event", event.ID)
+		}
+	}
+}
+
+// Stops accepting events and waits for the workers to forward the ones that
+// are still buffered. If the context is done first, pending retries are
+// abandoned and the context's error is returned. Does nothing if the forwarder
+// is nil.
+func (f *EventForwarder) Shutdown(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+
+	f.closeOnce.Do(func() { close(f.closing) })
+
+	done := make(chan struct{})
+	go func() {
+		f.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		f.abortOnce.Do(func() { close(f.abort) })
+		return ctx.Err()
+	}
+}
+
+// Forwards buffered events until the forwarder shuts down, then forwards
+// whatever is left in the buffer.
+func (f *EventForwarder) work() {
+	defer f.running.Done()
+
+	for {
+		select {
+		case event := <-f.events:
+			f.forward(event)
+		case <-f.closing:
+			for {
+				select {
+				case event := <-f.events:
+					f.forward(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Sends a single event, retrying with a backoff that doubles after each failed
+// attempt. The event is logged if it still can't be sent after the last retry.
+func (f *EventForwarder) forward(event database.EventEntry) {
+	backoff := f.config.RetryBackoff
+
+	for retry := 0; ; retry++ {
+		err := f.send(event)
+		if err == nil {
+			return
+		}
+
+		if retry >= f.config.MaxRetries {
+			fmt.Printf("[EventForwarder]: Giving up forwarding event %s after %d attempts: %s\n", event.ID, retry+1, err)
+			return
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-f.abort:
+			timer.Stop()
+			fmt.Printf("[EventForwarder]: Abandoned forwarding event %s during shutdown: %s\n", event.ID, err)
+			return
+		}
+
+		backoff *= 2
+	}
+}
+
+// POSTs the event as JSON to the secondary instance. Returns an error if the
+// request fails or the response status isn't 2xx.
+func (f *EventForwarder) send(event database.EventEntry) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, f.eventURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(f.config.Username, f.config.Password)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/4lch4/shion-api/client"
+	"github.com/4lch4/shion-api/internal/database"
+	shionv1 "github.com/4lch4/shion-api/proto/shion/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The number of events ListEvents returns when the request doesn't set max,
+// the same as GET /events.
+const defaultListEventsMax = 50
+
+// Implements the gRPC EventService using the same database, hub, and
+// publishers as the HTTP API, so events created through either are broadcast
+// to every client.
+type grpcEventService struct {
+	shionv1.UnimplementedEventServiceServer
+
+	s *Server
+}
+
+// Creates the gRPC server with the EventService registered and every RPC
+// guarded by the same credentials as the HTTP API.
+func newGRPCServer(s *Server) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.grpcUnaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(s.grpcStreamAuthInterceptor),
+	)
+
+	shionv1.RegisterEventServiceServer(srv, &grpcEventService{s: s})
+
+	return srv
+}
+
+// Checks the Basic Auth credentials in the call's authorization metadata
+// against the API and admin credentials, then rejects the call with
+// UNAVAILABLE until the database warm-up has finished, the same as the HTTP
+// middleware.
+func (s *Server) authorizeGRPC(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get(client.AuthorizationMetadataKey)
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "missing "+client.AuthorizationMetadataKey+" metadata")
+	}
+
+	// The metadata holds the same value as an Authorization header, so it's
+	// parsed the same way.
+	user, pass, hasAuth := (&http.Request{Header: http.Header{"Authorization": values[:1]}}).BasicAuth()
+	if valid, _ := checkBasicAuth(user, pass, hasAuth, s.apiUsername, s.apiPassword, s.adminUsername, s.adminPassword); !valid {
+		return status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	if !s.ready.Load() {
+		return status.Error(codes.Unavailable, "the server is starting up, try again shortly")
+	}
+
+	return nil
+}
+
+// Authorizes unary RPCs with authorizeGRPC before they're handled.
+func (s *Server) grpcUnaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorizeGRPC(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// Authorizes streaming RPCs with authorizeGRPC before they're handled.
+func (s *Server) grpcStreamAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorizeGRPC(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// Converts a database event to its protobuf message.
+func toProtoEvent(event database.EventEntry) *shionv1.Event {
+	return &shionv1.Event{
+		Id:        event.ID,
+		Type:      string(event.Type),
+		Data:      event.Data,
+		Timestamp: event.Timestamp,
+	}
+}
+
+// Converts a protobuf event message to a database event. A nil message is
+// converted to an empty event, which fails validation.
+func fromProtoEvent(event *shionv1.Event) database.EventEntry {
+	return database.EventEntry{
+		ID:        event.GetId(),
+		Type:      database.EventType(event.GetType()),
+		Data:      event.GetData(),
+		Timestamp: event.GetTimestamp(),
+	}
+}
+
+// Converts database events to their protobuf messages.
+func toProtoEvents(events []database.EventEntry) []*shionv1.Event {
+	messages := make([]*shionv1.Event, len(events))
+	for i, event := range events {
+		messages[i] = toProtoEvent(event)
+	}
+
+	return messages
+}
+
+// Checks an event the same as the HTTP handlers do before creating it, i.e.
+// validates its fields and checks its data against the schema registered for
+// its type unless FEATURE_SCHEMA_VALIDATION is off. Returns an
+// INVALID_ARGUMENT status if it isn't valid.
+func (s *Server) validateGRPCEvent(event database.EventEntry) error {
+	if err := event.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err := s.validateEventSchema(s.db, event)
+
+	var invalid *schemaError
+	switch {
+	case errors.As(err, &invalid):
+		return status.Errorf(codes.InvalidArgument, "%s: %s", invalid, strings.Join(invalid.violations, "; "))
+	case err != nil:
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return nil
+}
+
+// Returns the status of a write that failed, which is UNAVAILABLE if the
+// database stayed locked by another writer, the same as the HTTP handlers'
+// 503, INVALID_ARGUMENT if an event processor rejected the event, or INTERNAL
+// for any other error.
+func databaseStatus(err error) error {
+	if errors.Is(err, database.ErrEventRejected) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if database.IsBusy(err) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Creates a single event, the same as POST /event.
+func (g *grpcEventService) CreateEvent(ctx context.Context, req *shionv1.CreateEventRequest) (*shionv1.Event, error) {
+	event := fromProtoEvent(req.GetEvent())
+
+	if err := g.s.validateGRPCEvent(event); err != nil {
+		return nil, err
+	}
+
+	if code, err := g.s.checkReplay(g.s.db, event); err != nil {
+		switch code {
+		case http.StatusBadRequest:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case http.StatusConflict:
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		default:
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	created, err := g.s.db.CreateEvent(event)
+	if errors.Is(err, database.ErrUniqueKeyExists) {
+		g.s.forgetReplayNonce(g.s.db, event)
+		return nil, status.Errorf(codes.AlreadyExists, "event %s already has unique key %q", created.ID, created.UniqueKey)
+	}
+	if err != nil {
+		g.s.forgetReplayNonce(g.s.db, event)
+		return nil, databaseStatus(err)
+	}
+
+	// A retry of an event that was already created has been published once.
+	if !created.Duplicate {
+		g.s.forwarder.Enqueue(created)
+
+		if err := g.s.publishAcked(ctx, created); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "event %s was stored but Kafka didn't acknowledge it: %v", created.ID, err)
+		}
+	}
+
+	return toProtoEvent(created), nil
+}
+
+// Creates every event sent on the stream once the client closes its side, the
+// same as POST /events. Nothing is created if any event is invalid.
+func (g *grpcEventService) CreateEvents(stream grpc.ClientStreamingServer[shionv1.CreateEventsRequest, shionv1.CreateEventsResponse]) error {
+	var events []database.EventEntry
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		event := fromProtoEvent(req.GetEvent())
+		if err := g.s.validateGRPCEvent(event); err != nil {
+			st := status.Convert(err)
+			return status.Errorf(st.Code(), "event %d: %s", len(events), st.Message())
+		}
+
+		events = append(events, event)
+	}
+
+	// When only the failed chunks are rolled back the rest of the events were
+	// still created, so they're published and returned alongside the errors.
+	var batchErr *database.BatchError
+
+	created, err := g.s.db.CreateEvents(events)
+	if err != nil && !errors.As(err, &batchErr) {
+		return databaseStatus(err)
+	}
+
+	g.s.publish(database.Inserted(created)...)
+
+	resp := &shionv1.CreateEventsResponse{Events: toProtoEvents(created)}
+	if batchErr != nil {
+		for _, chunk := range batchErr.Chunks {
+			resp.FailedChunks = append(resp.FailedChunks, &shionv1.FailedChunk{
+				Start: int32(chunk.Start),
+				Count: int32(chunk.Count),
+				Error: chunk.Err.Error(),
+			})
+		}
+	}
+
+	return stream.SendAndClose(resp)
+}
+
+// Returns the event with the given ID, the same as GET /event/:id.
+func (g *grpcEventService) GetEvent(ctx context.Context, req *shionv1.GetEventRequest) (*shionv1.Event, error) {
+	event, err := g.s.db.GetEventByID(req.GetId())
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return toProtoEvent(event), nil
+}
+
+// Returns the latest events, optionally of a single type, or the events
+// created after after_id when it's set.
+func (g *grpcEventService) ListEvents(ctx context.Context, req *shionv1.ListEventsRequest) (*shionv1.ListEventsResponse, error) {
+	max := int(req.GetMax())
+	switch {
+	case max < 0:
+		return nil, status.Error(codes.InvalidArgument, "max must be a positive integer")
+	case max == 0:
+		max = defaultListEventsMax
+	case max > g.s.maxEventsLimit:
+		max = g.s.maxEventsLimit
+	}
+
+	eventType := database.EventType(req.GetType())
+
+	var events []database.EventEntry
+	var err error
+
+	switch {
+	case req.GetAfterId() != "":
+		events, err = g.s.db.GetEventsAfter(req.GetAfterId(), max)
+		if eventType != "" {
+			events = filterEventsByType(events, eventType)
+		}
+	case eventType != "":
+		events, err = g.s.db.GetLatestEventsByType(eventType, max)
+	default:
+		events, err = g.s.db.GetLatestEvents(max)
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &shionv1.ListEventsResponse{Events: toProtoEvents(events)}, nil
+}
+
+// Returns the events of the given type, keeping their order.
+func filterEventsByType(events []database.EventEntry, eventType database.EventType) []database.EventEntry {
+	var filtered []database.EventEntry
+	for _, event := range events {
+		if event.Type == eventType {
+			filtered = append(filtered, event)
+		}
+	}
+
+	return filtered
+}
+
+// Streams newly created events to the client using the same Hub that feeds
+// WebSocket and Server-Sent Events clients. If last_event_id is set the events
+// created after it are replayed first. The stream ends with RESOURCE_EXHAUSTED
+// if the client falls too far behind under the disconnect overflow policy, or
+// with UNAVAILABLE when the server shuts down.
+func (g *grpcEventService) Subscribe(req *shionv1.SubscribeRequest, stream grpc.ServerStreamingServer[shionv1.SubscribeResponse]) error {
+	sub, replay, err := g.s.resumeSubscription(req.GetLastEventId(), parseEventTypes(req.GetTypes()))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer g.s.hub.unsubscribe(sub)
+
+	for _, event := range replay {
+		if err := stream.Send(&shionv1.SubscribeResponse{Event: toProtoEvent(event)}); err != nil {
+			return err
+		}
+	}
+
+	replayed := replayedIDs(replay)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-sub.overflowed:
+			return status.Error(codes.ResourceExhausted, "the subscriber fell too far behind")
+		case <-g.s.hub.closing:
+			return status.Error(codes.Unavailable, "the server is shutting down")
+		case event := <-sub.events:
+			if _, ok := replayed[event.ID]; ok {
+				delete(replayed, event.ID)
+				continue
+			}
+
+			resp := &shionv1.SubscribeResponse{Event: toProtoEvent(event), Skipped: sub.takeSkipped()}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Serves the gRPC EventService on the given listener until the server shuts
+// down, e.g. for tests that listen on a random port.
+func (s *HTTPServer) ServeGRPC(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// Listens on GRPC_PORT and serves the gRPC EventService until the server shuts
+// down. Returns nil straight away if GRPC_PORT isn't set.
+func (s *HTTPServer) ListenAndServeGRPC() error {
+	if s.grpcPort == 0 {
+		return nil
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.grpcPort))
+	if err != nil {
+		return err
+	}
+
+	return s.ServeGRPC(lis)
+}
+
+// Stops the gRPC server from accepting new calls and waits for the ones in
+// progress to finish. Subscribe streams end once the Hub starts shutting down.
+// If the context is done first the remaining calls are cancelled and the
+// context's error is returned.
+func (s *HTTPServer) shutdownGRPC(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A response writer that throws the body of a HEAD response away, counting its
+// bytes so the response can say how long the GET body would have been.
+type headWriter struct {
+	gin.ResponseWriter
+
+	size    int
+	written bool
+}
+
+func (w *headWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *headWriter) Write(data []byte) (int, error) {
+	w.written = true
+	w.size += len(data)
+
+	return len(data), nil
+}
+
+func (w *headWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *headWriter) Written() bool {
+	return w.written
+}
+
+func (w *headWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+
+	return w.size
+}
+
+// The body isn't sent, so there's nothing to flush early.
+func (w *headWriter) Flush() {}
+
+// A middleware that lets HEAD routes share their GET handler. The handler's
+// body is discarded and the response is sent with the headers it would have
+// had, along with a Content-Length matching the body a GET would have
+// returned. It's registered ahead of prettyJSONMiddleware so the length
+// accounts for ?pretty=true. Requests with any other method pass through.
+func headMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodHead {
+			c.Next()
+			return
+		}
+
+		// The writer is restored even if the handler panics, so the recovery
+		// middleware writes its own response.
+		writer := c.Writer
+		head := &headWriter{ResponseWriter: writer}
+		c.Writer = head
+		defer func() { c.Writer = writer }()
+
+		c.Next()
+
+		c.Writer = writer
+
+		if !head.Written() {
+			return
+		}
+
+		if writer.Header().Get("Content-Length") == "" {
+			writer.Header().Set("Content-Length", strconv.Itoa(head.size))
+		}
+		writer.WriteHeaderNow()
+	}
+}
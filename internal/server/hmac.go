@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4lch4/shion-api/client"
+	"github.com/gin-gonic/gin"
+)
+
+// How far a signed request's timestamp may be from the server's clock before
+// the request is rejected, which limits how long a captured request can be
+// replayed for.
+const hmacTimestampWindow = 5 * time.Minute
+
+// The largest body a signed request may have, since the whole body is read
+// into memory to verify it before the request is authenticated.
+const hmacMaxBodyBytes = 32 << 20
+
+// An auth middleware for machine clients that sign their requests with a
+// shared secret instead of sending credentials, e.g. via client.SignRequest.
+// The X-Shion-Signature header must be sha256=<hex> where the hex is the
+// HMAC-SHA256 of "<timestamp>.<METHOD>.<path?query>.<raw body>" (see
+// client.Signature), and the X-Shion-Timestamp header must be a Unix time in
+// seconds within 5 minutes of the server's clock. The body, which may be at
+// most hmacMaxBodyBytes, is buffered to verify it and then restored for the
+// handlers.
+//
+// Requests signed with the admin secret instead, if it's set, are marked as
+// admin requests (see isAdmin).
+func hmacAuthMiddleware(secret, adminSecret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		unauthorized := func(reason string) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "error": reason, "request_id": requestIDOf(c)})
+		}
+
+		timestamp := c.GetHeader(client.TimestampHeader)
+		signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			unauthorized("missing or invalid " + client.TimestampHeader + " header")
+			return
+		}
+
+		if age := time.Since(time.Unix(signedAt, 0)); age > hmacTimestampWindow || age < -hmacTimestampWindow {
+			unauthorized("request timestamp is outside the allowed window")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, hmacMaxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, errorResponse(c, fmt.Sprintf("signed request bodies may be at most %d bytes", tooLarge.Limit)))
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		method, target := c.Request.Method, c.Request.URL.RequestURI()
+		signature := c.GetHeader(client.SignatureHeader)
+		switch {
+		case adminSecret != "" && client.VerifySignature(adminSecret, timestamp, method, target, body, signature):
+			c.Set(adminContextKey, true)
+		case !client.VerifySignature(secret, timestamp, method, target, body, signature):
+			unauthorized("invalid request signature")
+			return
+		}
+
+		c.Next()
+	}
+}
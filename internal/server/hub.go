@@ -0,0 +1,636 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/google/uuid"
+)
+
+// The default number of events that can be queued for a single subscriber
+// before the Hub's overflow policy kicks in.
+const defaultSubscriberBufferSize = 256
+
+// Determines what the Hub does when a subscriber's send buffer is full.
+type OverflowPolicy string
+
+const (
+	// Drops the oldest queued event to make room for the new one. The
+	// subscriber is told how many events it skipped before its next event.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// Disconnects the subscriber so it can reconnect and backfill the events it
+	// missed from the database instead of silently losing them.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// The number of Broadcast calls that can be queued for the fan-out goroutine
+// before Broadcast waits for it to catch up.
+const fanoutQueueSize = 1024
+
+// The number of recently broadcast event IDs the fan-out goroutine remembers
+// so an event that's broadcast more than once is only delivered once.
+const recentBroadcastIDs = 4096
+
+// The maximum number of missed events replayed to a subscriber resuming from a
+// previously seen event.
+const maxReplayEvents = 1000
+
+// Returned by Hub.connect when the server already has the maximum number of
+// open WebSocket connections.
+var errTooManyConnections = errors.New("too many WebSocket connections, try again later")
+
+// Returned by Hub.connect once the Hub has started shutting down.
+var errShuttingDown = errors.New("server is shutting down")
+
+// Returned by Hub.connect when the client's IP address already has the maximum
+// number of open WebSocket connections.
+var errTooManyConnectionsFromIP = errors.New("too many WebSocket connections from this IP address")
+
+// Configures how a Hub buffers events for subscribers and how many WebSocket
+// connections it accepts.
+type HubConfig struct {
+	// The number of events that can be queued for each subscriber. Defaults to
+	// defaultSubscriberBufferSize when not positive.
+	SendBufferSize int
+
+	// What to do when a subscriber's buffer is full. Defaults to
+	// OverflowDropOldest.
+	OverflowPolicy OverflowPolicy
+
+	// The maximum number of open WebSocket connections, or 0 for no limit.
+	MaxConnections int
+
+	// The maximum number of open WebSocket connections from a single IP
+	// address, or 0 for no limit.
+	MaxConnectionsPerIP int
+}
+
+// A Hub fans newly created events out to every subscriber (e.g. WebSocket
+// clients) that is interested in them. Broadcast only queues the events, and a
+// single fan-out goroutine copies each one into the buffer of every subscriber
+// that wants it, without ever waiting on a subscriber, so a slow client can't
+// hold up anyone else.
+type Hub struct {
+	mu sync.RWMutex
+
+	// The events waiting to be fanned out to the subscribers.
+	queue chan []database.EventEntry
+
+	// The IDs of the events fanned out most recently, as a ring whose oldest
+	// entry is at nextRecentID, and the same IDs as a set. Only the fan-out
+	// goroutine uses them.
+	recentIDs    [recentBroadcastIDs]string
+	nextRecentID int
+	recentIDsSet map[string]struct{}
+
+	// The set of currently active subscribers.
+	subscribers map[*subscriber]struct{}
+
+	// Guards connectionsByIP and reserving a connection slot.
+	connMu sync.Mutex
+
+	// The number of currently open WebSocket connections.
+	connections atomic.Int64
+
+	// The number of currently open WebSocket connections per client IP.
+	connectionsByIP map[string]int
+
+	// The maximum number of open WebSocket connections, or 0 for no limit.
+	maxConnections int
+
+	// The maximum number of open WebSocket connections per client IP, or 0 for
+	// no limit.
+	maxConnectionsPerIP int
+
+	// The total number of frames written to WebSocket clients.
+	messagesSent atomic.Int64
+
+	// Closed when the Hub starts shutting down, telling every WebSocket client
+	// to disconnect.
+	closing chan struct{}
+
+	// Tracks the open WebSocket connections so Shutdown can wait for them.
+	active sync.WaitGroup
+
+	// The number of events that can be queued for each subscriber.
+	bufferSize int
+
+	// What to do when a subscriber's buffer is full.
+	overflowPolicy OverflowPolicy
+
+	// The total number of events dropped because a subscriber's buffer was full.
+	dropped atomic.Int64
+
+	// The total number of subscribers disconnected because their buffer was
+	// full.
+	overflowDisconnects atomic.Int64
+
+	// The open WebSocket connections by client ID, each a *trackedClient.
+	clients sync.Map
+
+	// The total number of WebSocket connections opened since the server
+	// started.
+	totalConnections atomic.Int64
+}
+
+// What GET /admin/ws/connections reports about an open WebSocket connection.
+type ConnectionInfo struct {
+	// The ID the connection is tracked under.
+	ID string `json:"id"`
+
+	// The address the connection came from, which is the proxy's when the
+	// server is behind one.
+	RemoteAddr string `json:"remote_addr"`
+
+	// The client's IP address, resolved from the trusted proxies' headers.
+	ClientIP string `json:"client_ip"`
+
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// The client's active filter as a comma-separated list of event types, or
+	// empty when it receives every event or has unsubscribed from every type.
+	EventTypeFilter string `json:"event_type_filter"`
+
+	// The number of frames written to the client.
+	MessagesSent int64 `json:"messages_sent"`
+
+	// The number of events the client missed because it fell behind and its
+	// send buffer was full.
+	MessagesDropped int64 `json:"messages_dropped"`
+}
+
+// The state the Hub tracks for an open WebSocket connection.
+type trackedClient struct {
+	remoteAddr  string
+	clientIP    string
+	connectedAt time.Time
+
+	// The client's subscription, which its filter is read from.
+	sub *subscriber
+
+	messagesSent atomic.Int64
+}
+
+// A subscriber receives the events broadcast by a Hub that match its filter.
+type subscriber struct {
+	// The channel matching events are delivered on.
+	events chan database.EventEntry
+
+	mu sync.RWMutex
+
+	// The event types the subscriber is interested in, or nil if it receives
+	// every event. An empty set means it unsubscribed from every type it had
+	// subscribed to, so it receives no events until it subscribes again.
+	types map[database.EventType]struct{}
+
+	// The number of events dropped since the subscriber was last told about a
+	// gap in its stream.
+	skipped atomic.Int64
+
+	// The total number of events dropped because the subscriber's buffer was
+	// full.
+	dropped atomic.Int64
+
+	// Closed when the subscriber's buffer overflows under the disconnect
+	// policy, signalling that it should be disconnected.
+	overflowed chan struct{}
+
+	overflowOnce sync.Once
+}
+
+// Creates a new, empty Hub with the given config. Invalid values fall back to
+// their defaults.
+func NewHub(config HubConfig) *Hub {
+	if config.SendBufferSize <= 0 {
+		config.SendBufferSize = defaultSubscriberBufferSize
+	}
+
+	if config.OverflowPolicy != OverflowDisconnect {
+		config.OverflowPolicy = OverflowDropOldest
+	}
+
+	h := &Hub{
+		queue:               make(chan []database.EventEntry, fanoutQueueSize),
+		recentIDsSet:        make(map[string]struct{}, recentBroadcastIDs),
+		subscribers:         make(map[*subscriber]struct{}),
+		connectionsByIP:     make(map[string]int),
+		closing:             make(chan struct{}),
+		maxConnections:      max(config.MaxConnections, 0),
+		maxConnectionsPerIP: max(config.MaxConnectionsPerIP, 0),
+		bufferSize:          config.SendBufferSize,
+		overflowPolicy:      config.OverflowPolicy,
+	}
+
+	go h.fanout()
+
+	return h
+}
+
+// Returns the number of currently open WebSocket connections.
+func (h *Hub) Connections() int64 {
+	return h.connections.Load()
+}
+
+// Returns the total number of frames written to WebSocket clients.
+func (h *Hub) MessagesSent() int64 {
+	return h.messagesSent.Load()
+}
+
+// Returns the total number of WebSocket connections opened since the server
+// started.
+func (h *Hub) TotalConnections() int64 {
+	return h.totalConnections.Load()
+}
+
+// Starts tracking an open WebSocket connection with the given subscription,
+// returning the ID it's tracked under. Every call must be paired with a call
+// to unregister.
+func (h *Hub) register(remoteAddr, clientIP string, sub *subscriber) string {
+	id := uuid.NewString()
+
+	h.clients.Store(id, &trackedClient{
+		remoteAddr:  remoteAddr,
+		clientIP:    clientIP,
+		connectedAt: time.Now().UTC(),
+		sub:         sub,
+	})
+	h.totalConnections.Add(1)
+
+	return id
+}
+
+// Stops tracking the WebSocket connection with the given ID.
+func (h *Hub) unregister(clientID string) {
+	h.clients.Delete(clientID)
+}
+
+// Counts a frame written to the WebSocket client with the given ID, both for
+// the client and in the Hub's total.
+func (h *Hub) IncrementSent(clientID string) {
+	h.messagesSent.Add(1)
+
+	if client, ok := h.clients.Load(clientID); ok {
+		client.(*trackedClient).messagesSent.Add(1)
+	}
+}
+
+// Returns the open WebSocket connections, oldest first.
+func (h *Hub) Clients() []ConnectionInfo {
+	clients := []ConnectionInfo{}
+
+	h.clients.Range(func(id, value any) bool {
+		client := value.(*trackedClient)
+
+		var types []string
+		for _, t := range client.sub.activeTypes() {
+			types = append(types, string(t))
+		}
+
+		clients = append(clients, ConnectionInfo{
+			ID:              id.(string),
+			RemoteAddr:      client.remoteAddr,
+			ClientIP:        client.clientIP,
+			ConnectedAt:     client.connectedAt,
+			EventTypeFilter: strings.Join(types, ","),
+			MessagesSent:    client.messagesSent.Load(),
+			MessagesDropped: client.sub.dropped.Load(),
+		})
+
+		return true
+	})
+
+	slices.SortFunc(clients, func(a, b ConnectionInfo) int {
+		return cmp.Or(a.ConnectedAt.Compare(b.ConnectedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	return clients
+}
+
+// Reserves a connection slot for a WebSocket client from the given IP address,
+// returning errTooManyConnections or errTooManyConnectionsFromIP if a limit has
+// been reached. Every successful call must be paired with a call to disconnect.
+func (h *Hub) connect(ip string) error {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+
+	select {
+	case <-h.closing:
+		return errShuttingDown
+	default:
+	}
+
+	if h.maxConnections > 0 && h.connections.Load() >= int64(h.maxConnections) {
+		return errTooManyConnections
+	}
+
+	if h.maxConnectionsPerIP > 0 && h.connectionsByIP[ip] >= h.maxConnectionsPerIP {
+		return errTooManyConnectionsFromIP
+	}
+
+	h.connections.Add(1)
+	h.connectionsByIP[ip]++
+	h.active.Add(1)
+
+	return nil
+}
+
+// Releases the connection slot reserved by connect for the given IP address.
+func (h *Hub) disconnect(ip string) {
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+
+	h.connections.Add(-1)
+	if h.connectionsByIP[ip]--; h.connectionsByIP[ip] <= 0 {
+		delete(h.connectionsByIP, ip)
+	}
+	h.active.Done()
+}
+
+// Stops accepting new WebSocket connections and tells every connected client
+// to disconnect, then waits for them all to close. Each client is sent a
+// going-away close frame and given its shutdown grace period to close
+// cleanly before it's force-closed. Returns the context's error if it's done
+// before every connection has closed.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	// Closing under connMu means connect can't reserve a slot (and add to the
+	// WaitGroup) once we've started waiting on it.
+	h.connMu.Lock()
+	select {
+	case <-h.closing:
+	default:
+		close(h.closing)
+	}
+	h.connMu.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns the total number of events dropped because a subscriber's buffer was
+// full.
+func (h *Hub) Dropped() int64 {
+	return h.dropped.Load()
+}
+
+// Returns the total number of subscribers disconnected because their buffer
+// was full.
+func (h *Hub) OverflowDisconnects() int64 {
+	return h.overflowDisconnects.Load()
+}
+
+// Registers a new subscriber with the Hub that only receives events of the
+// given types, or every event if no types are given.
+func (h *Hub) subscribe(types []database.EventType) *subscriber {
+	sub := &subscriber{
+		events:     make(chan database.EventEntry, h.bufferSize),
+		overflowed: make(chan struct{}),
+	}
+	sub.addTypes(types)
+
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+// Registers a new subscriber with the Hub the same as subscribe, and if a
+// lastEventID is given also returns the events (matching the filter) that were
+// created after it so the caller can replay them before switching to the live
+// stream. The subscriber is registered before the database is queried so no
+// events are missed in between; use replayedIDs to skip live events that were
+// already part of the replay.
+func (s *Server) resumeSubscription(lastEventID string, types []database.EventType) (*subscriber, []database.EventEntry, error) {
+	sub := s.hub.subscribe(types)
+
+	if lastEventID == "" {
+		return sub, nil, nil
+	}
+
+	missed, err := s.db.GetEventsAfter(lastEventID, maxReplayEvents)
+	if err != nil {
+		s.hub.unsubscribe(sub)
+		return nil, nil, err
+	}
+
+	var replay []database.EventEntry
+	for _, event := range missed {
+		if sub.wants(event.Type) {
+			replay = append(replay, event)
+		}
+	}
+
+	return sub, replay, nil
+}
+
+// Returns the set of IDs in the given events, used to skip live events that
+// were already delivered as part of a replay.
+func replayedIDs(events []database.EventEntry) map[string]struct{} {
+	ids := make(map[string]struct{}, len(events))
+	for _, event := range events {
+		ids[event.ID] = struct{}{}
+	}
+
+	return ids
+}
+
+// Removes the given subscriber from the Hub so it no longer receives events.
+func (h *Hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	h.mu.Unlock()
+}
+
+// Queues the given events to be sent to every subscriber whose filter matches
+// them, in order. The fan-out goroutine never waits on a subscriber, so this
+// only waits if it has fallen fanoutQueueSize broadcasts behind. Events
+// broadcast once the Hub has started shutting down are discarded, since every
+// subscriber is disconnecting.
+func (h *Hub) Broadcast(events ...database.EventEntry) {
+	if len(events) == 0 {
+		return
+	}
+
+	select {
+	case h.queue <- events:
+	case <-h.closing:
+	}
+}
+
+// Fans the queued events out to the subscribers until the Hub starts shutting
+// down, skipping any that were already fanned out recently.
+func (h *Hub) fanout() {
+	for {
+		select {
+		case events := <-h.queue:
+			h.distribute(h.dedupe(events))
+		case <-h.closing:
+			return
+		}
+	}
+}
+
+// Returns the events that weren't among the last recentBroadcastIDs events
+// fanned out, remembering them for next time.
+func (h *Hub) dedupe(events []database.EventEntry) []database.EventEntry {
+	fresh := events[:0:0]
+
+	for _, event := range events {
+		if _, ok := h.recentIDsSet[event.ID]; ok {
+			continue
+		}
+
+		delete(h.recentIDsSet, h.recentIDs[h.nextRecentID])
+		h.recentIDs[h.nextRecentID] = event.ID
+		h.nextRecentID = (h.nextRecentID + 1) % recentBroadcastIDs
+		h.recentIDsSet[event.ID] = struct{}{}
+
+		fresh = append(fresh, event)
+	}
+
+	return fresh
+}
+
+// Copies the events into the buffer of every subscriber whose filter matches
+// them. When a subscriber's buffer is full the Hub's overflow policy is
+// applied instead, so a slow subscriber never holds up the others.
+func (h *Hub) distribute(events []database.EventEntry) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subscribers {
+		for _, event := range events {
+			if !sub.wants(event.Type) {
+				continue
+			}
+
+			select {
+			case sub.events <- event:
+			default:
+				h.overflow(sub, event)
+			}
+		}
+	}
+}
+
+// Applies the Hub's overflow policy to a subscriber whose buffer is full,
+// logging when the subscriber starts falling behind.
+func (h *Hub) overflow(sub *subscriber, event database.EventEntry) {
+	if h.overflowPolicy == OverflowDisconnect {
+		h.dropped.Add(1)
+		sub.dropped.Add(1)
+		sub.overflowOnce.Do(func() {
+			fmt.Println("[Hub]: Disconnecting a subscriber whose send buffer is full")
+			close(sub.overflowed)
+			h.overflowDisconnects.Add(1)
+		})
+		return
+	}
+
+	// The subscriber may be draining its buffer at the same time, so keep
+	// evicting the oldest event until there's room for this one.
+	for {
+		select {
+		case <-sub.events:
+			if sub.skipped.Add(1) == 1 {
+				fmt.Printf("[Hub]: A subscriber's send buffer is full, dropping its oldest events (%d dropped so far)\n", sub.dropped.Load()+1)
+			}
+			sub.dropped.Add(1)
+			h.dropped.Add(1)
+		default:
+		}
+
+		select {
+		case sub.events <- event:
+			return
+		default:
+		}
+	}
+}
+
+// Returns the number of events the subscriber has skipped since the last call,
+// resetting the count.
+func (s *subscriber) takeSkipped() int64 {
+	return s.skipped.Swap(0)
+}
+
+// Returns whether the subscriber is interested in events of the given type.
+func (s *subscriber) wants(eventType database.EventType) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.types == nil {
+		return true
+	}
+
+	_, ok := s.types[eventType]
+	return ok
+}
+
+// Adds the given event types to the subscriber's filter.
+func (s *subscriber) addTypes(types []database.EventType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range types {
+		if t == "" {
+			continue
+		}
+
+		if s.types == nil {
+			s.types = make(map[database.EventType]struct{})
+		}
+		s.types[t] = struct{}{}
+	}
+}
+
+// Removes the given event types from the subscriber's filter. Removing the
+// last one leaves the subscriber receiving no events. If no types are given
+// then the filter is cleared and the subscriber receives every event.
+func (s *subscriber) removeTypes(types []database.EventType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(types) == 0 {
+		s.types = nil
+		return
+	}
+
+	for _, t := range types {
+		delete(s.types, t)
+	}
+}
+
+// Returns the event types the subscriber is currently filtering on.
+func (s *subscriber) activeTypes() []database.EventType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	types := make([]database.EventType, 0, len(s.types))
+	for t := range s.types {
+		types = append(types, t)
+	}
+
+	slices.Sort(types)
+
+	return types
+}
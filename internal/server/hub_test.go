@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Receives the next event from the subscriber, failing the test if none
+// arrives within a few seconds.
+func receiveEvent(t *testing.T, sub *subscriber) database.EventEntry {
+	t.Helper()
+
+	select {
+	case event := <-sub.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return database.EventEntry{}
+	}
+}
+
+func TestHubSlowSubscriberDoesNotHoldUpOthers(t *testing.T) {
+	hub := NewHub(HubConfig{SendBufferSize: 4})
+	t.Cleanup(func() { hub.Shutdown(context.Background()) })
+
+	fast := hub.subscribe(nil)
+	slow := hub.subscribe(nil)
+
+	// The fast subscriber keeps up, while the slow one never reads.
+	for i := range 100 {
+		hub.Broadcast(database.EventEntry{ID: strconv.Itoa(i), Type: "seq"})
+
+		if event := receiveEvent(t, fast); event.ID != strconv.Itoa(i) {
+			t.Fatalf("unexpected event: got %q want %q", event.ID, strconv.Itoa(i))
+		}
+	}
+
+	// Fan-out is asynchronous, so wait for the last event to reach the slow
+	// subscriber's buffer.
+	deadline := time.Now().Add(5 * time.Second)
+	for slow.dropped.Load() < 96 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if fast.dropped.Load() != 0 || slow.dropped.Load() != 96 || hub.Dropped() != 96 {
+		t.Fatalf("unexpected drops: fast %d, slow %d, hub %d", fast.dropped.Load(), slow.dropped.Load(), hub.Dropped())
+	}
+
+	// The slow subscriber is left with the newest events.
+	if event := receiveEvent(t, slow); event.ID != "96" {
+		t.Fatalf("unexpected oldest buffered event: got %q want %q", event.ID, "96")
+	}
+}
+
+func TestHubDeliversEachEventOnce(t *testing.T) {
+	hub := NewHub(HubConfig{})
+	t.Cleanup(func() { hub.Shutdown(context.Background()) })
+
+	sub := hub.subscribe(nil)
+
+	hub.Broadcast(database.EventEntry{ID: "a"}, database.EventEntry{ID: "b"})
+	hub.Broadcast(database.EventEntry{ID: "a"}, database.EventEntry{ID: "c"})
+
+	for _, want := range []string{"a", "b", "c"} {
+		if event := receiveEvent(t, sub); event.ID != want {
+			t.Fatalf("unexpected event: got %q want %q", event.ID, want)
+		}
+	}
+
+	select {
+	case event := <-sub.events:
+		t.Fatalf("expected the duplicate to be skipped, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriberUnsubscribingFromEveryTypeReceivesNothing(t *testing.T) {
+	hub := NewHub(HubConfig{})
+	t.Cleanup(func() { hub.Shutdown(context.Background()) })
+
+	sub := hub.subscribe([]database.EventType{"alert"})
+	if !sub.wants("alert") || sub.wants("deploy") {
+		t.Fatal("expected only alert events to be wanted")
+	}
+
+	// Removing the only type doesn't go back to every event.
+	sub.removeTypes([]database.EventType{"alert"})
+	if sub.wants("alert") || sub.wants("deploy") {
+		t.Fatal("expected no events to be wanted after unsubscribing from every type")
+	}
+
+	sub.addTypes([]database.EventType{"deploy"})
+	if !sub.wants("deploy") || sub.wants("alert") {
+		t.Fatal("expected only deploy events to be wanted after subscribing again")
+	}
+
+	// Unsubscribing without any types clears the filter.
+	sub.removeTypes(nil)
+	if !sub.wants("alert") || !sub.wants("deploy") {
+		t.Fatal("expected every event to be wanted once the filter was cleared")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The number of events inserted per CreateEvents call during an import.
+const importBatchSize = 500
+
+// The response body returned by the POST /events/import endpoint.
+type ImportResponse struct {
+	// The number of events that were created.
+	Imported int `json:"imported"`
+
+	// The number of valid events that were intentionally not created, e.g.
+	// because they duplicate an existing event.
+	Skipped int `json:"skipped"`
+
+	// The records that could not be imported and why.
+	Errors []ImportError `json:"errors"`
+}
+
+// Describes a record (or batch of records) that could not be imported.
+type ImportError struct {
+	// The 1-based position of the record in the file. For a failed batch this
+	// is the position of the first record in the batch.
+	Record int `json:"record"`
+
+	// A description of what went wrong.
+	Error string `json:"error"`
+
+	// Each way the record's data doesn't conform to the schema for its type,
+	// if that's why it couldn't be imported.
+	ValidationErrors []string `json:"validation_errors,omitempty"`
+}
+
+// Handles requests to the POST /events/import endpoint, which accepts a
+// multipart/form-data upload with a `file` field containing either a JSON
+// array of events or NDJSON. The file is decoded as it's streamed in and the
+// events are created in batches, so large files are never held in memory.
+// Invalid records are reported in the response rather than failing the
+// whole import.
+func (s *Server) importEventsHandler(c *gin.Context) {
+	file, err := importFilePart(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+	defer file.Close()
+
+	resp := ImportResponse{Errors: []ImportError{}}
+	batch := make([]database.EventEntry, 0, importBatchSize)
+	// The record each event in the batch came from, which isn't contiguous
+	// when invalid records were skipped in between.
+	records := make([]int, 0, importBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		created, err := s.dbFor(c).CreateEvents(batch)
+
+		var batchErr *database.BatchError
+		switch {
+		case errors.As(err, &batchErr):
+			// Only the failed chunks were rolled back. Each is reported at
+			// the record its first event came from.
+			for _, chunk := range batchErr.Chunks {
+				resp.Errors = append(resp.Errors, ImportError{
+					Record: records[chunk.Start],
+					Error:  fmt.Sprintf("batch of %d event(s) failed: %s", chunk.Count, chunk.Err),
+				})
+			}
+		case err != nil:
+			resp.Errors = append(resp.Errors, ImportError{
+				Record: records[0],
+				Error:  fmt.Sprintf("batch of %d event(s) failed: %s", len(batch), err),
+			})
+		}
+
+		// Events that already existed, with the same ID or unique_key, are
+		// skipped rather than imported again.
+		inserted := database.Inserted(created)
+		resp.Imported += len(inserted)
+		resp.Skipped += len(created) - len(inserted)
+		s.publish(inserted...)
+
+		batch = batch[:0]
+		records = records[:0]
+	}
+
+	err = database.DecodeEvents(file, func(record int, e database.EventEntry, err error) error {
+		if err != nil {
+			resp.Errors = append(resp.Errors, ImportError{Record: record, Error: err.Error()})
+			return nil
+		}
+
+		err = s.validateEventSchema(s.dbFor(c), e)
+
+		var invalid *schemaError
+		if errors.As(err, &invalid) {
+			resp.Errors = append(resp.Errors, ImportError{Record: record, Error: err.Error(), ValidationErrors: invalid.violations})
+			return nil
+		}
+		if err != nil {
+			resp.Errors = append(resp.Errors, ImportError{Record: record, Error: err.Error()})
+			return nil
+		}
+
+		batch = append(batch, e)
+		records = append(records, record)
+		if len(batch) == importBatchSize {
+			flush()
+		}
+
+		return nil
+	})
+	flush()
+
+	if err != nil {
+		resp.Errors = append(resp.Errors, ImportError{Error: "stopped reading file: " + err.Error()})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Returns the `file` part of a multipart/form-data request without buffering
+// the rest of the form.
+func importFilePart(r *http.Request) (io.ReadCloser, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("missing form field: file")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "file" {
+			return part, nil
+		}
+
+		part.Close()
+	}
+}
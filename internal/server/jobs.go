@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// How many seconds clients are told to wait with Retry-After before
+// submitting another job when the queue is full.
+const jobQueueRetryAfter = 5
+
+// Returned by JobQueue.Submit when every slot in the queue is taken, or the
+// queue is shutting down.
+var errJobQueueFull = errors.New("too many ingestion jobs are queued, try again shortly")
+
+// Configures how many ingestion jobs a JobQueue runs and holds. Zero values
+// are replaced with their defaults.
+type JobQueueConfig struct {
+	// The number of jobs that run at the same time. Defaults to 2.
+	Workers int
+
+	// The most jobs that can be queued or running before new ones are
+	// rejected. Defaults to 100.
+	QueueSize int
+}
+
+// A JobQueue creates batches of events in the background so large batches
+// don't hold the request open while they're inserted. Jobs are stored in the
+// database before they're queued, so the ones a restart interrupts can be
+// picked up again with Resume.
+type JobQueue struct {
+	db database.TursoDB
+
+	// Publishes the events a job created, the same as the synchronous
+	// handlers do.
+	publish func(events ...database.EventEntry)
+
+	// Holds a token for every job that's queued or running, so the queue can't
+	// grow without bound.
+	slots chan struct{}
+
+	// Jobs waiting for a worker.
+	jobs chan database.Job
+
+	// Closed when the queue starts shutting down. Jobs still waiting are left
+	// pending in the database for the next Resume.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Tracks the workers that need to finish before shutdown completes.
+	running sync.WaitGroup
+}
+
+// Creates a JobQueue that stores jobs in the given database and starts its
+// workers.
+func NewJobQueue(db database.TursoDB, publish func(events ...database.EventEntry), config JobQueueConfig) *JobQueue {
+	if config.Workers <= 0 {
+		config.Workers = 2
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+
+	q := &JobQueue{
+		db:      db,
+		publish: publish,
+		slots:   make(chan struct{}, config.QueueSize),
+		jobs:    make(chan database.Job, config.QueueSize),
+		closing: make(chan struct{}),
+	}
+
+	q.running.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Stores a pending job for the given events in the database and queues it.
+// Returns errJobQueueFull without storing anything if the queue is full or
+// shutting down.
+func (q *JobQueue) Submit(db database.TursoDB, events []database.EventEntry) (database.Job, error) {
+	select {
+	case <-q.closing:
+		return database.Job{}, errJobQueueFull
+	default:
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		return database.Job{}, errJobQueueFull
+	}
+
+	job, err := db.CreateJob(events)
+	if err != nil {
+		<-q.slots
+		return database.Job{}, err
+	}
+
+	// There's a slot for every place in the channel, so this never blocks.
+	q.jobs <- job
+
+	return job, nil
+}
+
+// Queues the jobs that were pending or running when the server last stopped,
+// waiting for slots to free up if there are more than the queue holds. Returns
+// early if the queue shuts down first.
+func (q *JobQueue) Resume() error {
+	jobs, err := q.db.GetIncompleteJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		select {
+		case q.slots <- struct{}{}:
+			q.jobs <- job
+		case <-q.closing:
+			return nil
+		}
+	}
+
+	if len(jobs) > 0 {
+		fmt.Printf("[JobQueue]: Resumed %d incomplete job(s)\n", len(jobs))
+	}
+
+	return nil
+}
+
+// Stops starting new jobs and waits for the running ones to finish. Jobs that
+// haven't started are left pending in the database. Returns the context's
+// error if it's done first.
+func (q *JobQueue) Shutdown(ctx context.Context) error {
+	q.closeOnce.Do(func() { close(q.closing) })
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Runs queued jobs until the queue shuts down.
+func (q *JobQueue) work() {
+	defer q.running.Done()
+
+	for {
+		// Checked first so a worker doesn't start another job once shutdown
+		// has begun, even when both are ready.
+		select {
+		case <-q.closing:
+			return
+		default:
+		}
+
+		select {
+		case job := <-q.jobs:
+			q.run(job)
+			<-q.slots
+		case <-q.closing:
+			return
+		}
+	}
+}
+
+// Creates a job's events, publishes the ones that were created, and stores the
+// outcome.
+func (q *JobQueue) run(job database.Job) {
+	if err := q.db.StartJob(job.ID); err != nil {
+		fmt.Println("[JobQueue]: Error starting job", job.ID, err)
+		return
+	}
+
+	// When only the failed chunks are rolled back the rest of the events were
+	// still created, so the job completes with the failed chunks listed.
+	var batchErr *database.BatchError
+
+	created, err := q.db.CreateEvents(job.Events)
+	switch {
+	case errors.As(err, &batchErr):
+		job.Status = database.JobCompleted
+		for _, chunk := range batchErr.Chunks {
+			job.Failed += chunk.Count
+			job.Errors = append(job.Errors, database.JobError{Start: chunk.Start, Count: chunk.Count, Error: chunk.Err.Error()})
+		}
+	case err != nil:
+		job.Status = database.JobFailed
+		job.Failed = len(job.Events)
+		job.Error = err.Error()
+	default:
+		job.Status = database.JobCompleted
+	}
+
+	job.Created = len(created)
+	q.publish(database.Inserted(created)...)
+
+	if err := q.db.FinishJob(job); err != nil {
+		fmt.Println("[JobQueue]: Error storing the outcome of job", job.ID, err)
+	}
+}
+
+// Queues the events to be created in the background for POST
+// /events?async=true, responding with a 202, the pending job, and a Location
+// header pointing at its status. Responds with a 503 and a Retry-After header
+// if the queue is full.
+func (s *Server) submitEventsJob(c *gin.Context, events []database.EventEntry) {
+	job, err := s.jobs.Submit(s.dbFor(c), events)
+	if errors.Is(err, errJobQueueFull) {
+		c.Header("Retry-After", strconv.Itoa(jobQueueRetryAfter))
+		c.JSON(http.StatusServiceUnavailable, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.Header("Location", basePathOf(c)+"/jobs/"+url.PathEscape(job.ID))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Handles requests to the GET /jobs/:id endpoint, which returns the status of
+// an ingestion job created through POST /events?async=true, or 404 if it
+// doesn't exist.
+func (s *Server) getJobHandler(c *gin.Context) {
+	job, err := s.dbFor(c).GetJob(c.Param("id"))
+	if errors.Is(err, database.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
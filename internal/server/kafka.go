@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The default number of events that can be waiting to be produced to Kafka
+// before new ones are dropped.
+const defaultKafkaBufferSize = 1024
+
+// The default topic events are produced to.
+const defaultKafkaTopic = "shion.events"
+
+// The default time POST /event waits for Kafka to acknowledge an event in
+// blocking mode.
+const defaultKafkaAckTimeout = 10 * time.Second
+
+// Determines whether POST /event waits for Kafka to acknowledge the event it
+// created before responding.
+type KafkaDeliveryMode string
+
+const (
+	// Queues events to be produced in the background without delaying the
+	// response. Failed deliveries are logged and counted.
+	KafkaBestEffort KafkaDeliveryMode = "best-effort"
+
+	// Waits until Kafka acknowledges the event, responding with a 502 if it
+	// isn't acknowledged within the ack timeout. Only POST /event blocks, events
+	// created any other way are produced in the background.
+	KafkaBlocking KafkaDeliveryMode = "blocking"
+)
+
+// Configures a KafkaProducer.
+type KafkaConfig struct {
+	// The addresses of the Kafka brokers to bootstrap from.
+	Brokers []string
+
+	// The topic events are produced to. Defaults to shion.events.
+	Topic string
+
+	// The number of events that can be waiting to be produced before new ones
+	// are dropped. Defaults to 1024.
+	BufferSize int
+
+	// Whether POST /event waits for events to be acknowledged. Defaults to
+	// KafkaBestEffort.
+	DeliveryMode KafkaDeliveryMode
+
+	// How long POST /event waits for an acknowledgement in blocking mode.
+	// Defaults to 10 seconds.
+	AckTimeout time.Duration
+}
+
+// A KafkaProducer produces newly created events to a Kafka topic as JSON.
+// Events are keyed by their type, so every event of a type lands on the same
+// partition and is consumed in the order it was created.
+type KafkaProducer struct {
+	client *kgo.Client
+
+	topic string
+
+	deliveryMode KafkaDeliveryMode
+
+	ackTimeout time.Duration
+
+	// The number of events Kafka acknowledged.
+	produced atomic.Int64
+
+	// The number of events Kafka didn't acknowledge.
+	failed atomic.Int64
+
+	// The number of events that weren't produced because the buffer was full or
+	// the producer was shutting down.
+	dropped atomic.Int64
+}
+
+// Creates a producer for the given Kafka brokers. Connections are made in the
+// background, and events are buffered and retried while no broker can be
+// reached.
+func NewKafkaProducer(config KafkaConfig) (*KafkaProducer, error) {
+	if config.Topic == "" {
+		config.Topic = defaultKafkaTopic
+	}
+
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultKafkaBufferSize
+	}
+
+	if config.DeliveryMode != KafkaBlocking {
+		config.DeliveryMode = KafkaBestEffort
+	}
+
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = defaultKafkaAckTimeout
+	}
+
+	// Records are acknowledged by every in-sync replica and produced
+	// idempotently by default, so retries can't reorder or duplicate them.
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ClientID("shion-api"),
+		kgo.DefaultProduceTopic(config.Topic),
+		kgo.MaxBufferedRecords(config.BufferSize),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaProducer{
+		client:       client,
+		topic:        config.Topic,
+		deliveryMode: config.DeliveryMode,
+		ackTimeout:   config.AckTimeout,
+	}, nil
+}
+
+// Queues the given events to be produced without waiting for them to be
+// acknowledged. Events are dropped and counted if the buffer is full or the
+// producer is shutting down. Does nothing if the producer is nil, i.e. Kafka
+// isn't configured.
+func (p *KafkaProducer) Enqueue(events ...database.EventEntry) {
+	if p == nil {
+		return
+	}
+
+	for _, event := range events {
+		record, err := p.record(event)
+		if err != nil {
+			continue
+		}
+
+		p.client.TryProduce(context.Background(), record, func(_ *kgo.Record, err error) {
+			p.count(event, err)
+		})
+	}
+}
+
+// Produces the given events and waits until Kafka acknowledges every one of
+// them, for at most the ack timeout. Returns the errors of any events that
+// weren't acknowledged, or the context's error if it's done first. Does
+// nothing if the producer is nil.
+func (p *KafkaProducer) Produce(ctx context.Context, events ...database.EventEntry) error {
+	if p == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.ackTimeout)
+	defer cancel()
+
+	acks := make(chan error, len(events))
+
+	for _, event := range events {
+		record, err := p.record(event)
+		if err != nil {
+			acks <- err
+			continue
+		}
+
+		p.client.Produce(ctx, record, func(_ *kgo.Record, err error) {
+			p.count(event, err)
+			acks <- err
+		})
+	}
+
+	var errs []error
+	for range events {
+		select {
+		case err := <-acks:
+			errs = append(errs, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns whether POST /event should wait for its event to be acknowledged.
+// Returns false if the producer is nil.
+func (p *KafkaProducer) Blocking() bool {
+	return p != nil && p.deliveryMode == KafkaBlocking
+}
+
+// Returns the delivery mode.
+func (p *KafkaProducer) DeliveryMode() KafkaDeliveryMode {
+	return p.deliveryMode
+}
+
+// Returns the number of events Kafka acknowledged.
+func (p *KafkaProducer) Produced() int64 {
+	return p.produced.Load()
+}
+
+// Returns the number of events Kafka didn't acknowledge.
+func (p *KafkaProducer) Failed() int64 {
+	return p.failed.Load()
+}
+
+// Returns the number of events that weren't produced because the buffer was
+// full or the producer was shutting down.
+func (p *KafkaProducer) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Waits until every buffered event has been acknowledged or has failed, then
+// closes the connections to Kafka. If the context is done first, the events
+// that are still buffered are failed and the context's error is returned.
+// Does nothing if the producer is nil.
+func (p *KafkaProducer) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	err := p.client.Flush(ctx)
+	p.client.Close()
+
+	return err
+}
+
+// Builds the record for a single event, encoded as JSON and keyed by its type.
+func (p *KafkaProducer) record(event database.EventEntry) (*kgo.Record, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		p.failed.Add(1)
+		fmt.Println("[KafkaProducer]: Error encoding event", event.ID, err)
+		return nil, err
+	}
+
+	return &kgo.Record{Key: []byte(event.Type), Value: payload}, nil
+}
+
+// Counts the result of producing an event, logging it if it failed.
+func (p *KafkaProducer) count(event database.EventEntry, err error) {
+	switch {
+	case err == nil:
+		p.produced.Add(1)
+	case errors.Is(err, kgo.ErrMaxBuffered), errors.Is(err, kgo.ErrClientClosed):
+		p.dropped.Add(1)
+	default:
+		p.failed.Add(1)
+		fmt.Println("[KafkaProducer]: Error producing event", event.ID, "to", p.topic, err)
+	}
+}
+
+// Splits a comma separated list of broker addresses, ignoring empty entries.
+func parseKafkaBrokers(brokers string) []string {
+	var parsed []string
+
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			parsed = append(parsed, broker)
+		}
+	}
+
+	return parsed
+}
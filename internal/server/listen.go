@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/4lch4/shion-api/internal/config"
+)
+
+// The permissions the Unix domain socket is created with when
+// LISTEN_SOCKET_MODE isn't set.
+const defaultSocketMode os.FileMode = 0o660
+
+// Listens on LISTEN_ADDR, which is either a TCP address or a Unix domain
+// socket such as unix:///var/run/shion.sock, and serves HTTP on it until the
+// server shuts down. This replaces http.Server's ListenAndServe, which only
+// supports TCP.
+func (s *HTTPServer) ListenAndServe() error {
+	lis, err := s.Listen()
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(lis)
+}
+
+// Binds LISTEN_ADDR without serving it, so the caller can pass the listener to
+// Serve. A Unix domain socket is created with LISTEN_SOCKET_MODE permissions,
+// replacing a stale socket file left behind by a server that didn't shut down
+// cleanly, and is removed again once the listener is closed, which Shutdown
+// does. Returns an error if another server is still listening on the socket,
+// or if its path is taken by something other than a socket.
+func (s *HTTPServer) Listen() (net.Listener, error) {
+	path, ok := strings.CutPrefix(s.Addr, config.UnixSocketPrefix)
+	if !ok {
+		return net.Listen("tcp", s.Addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, s.socketMode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	return lis, nil
+}
+
+// Removes the socket file at the given path if nothing is listening on it any
+// more, so a server that was killed doesn't stop the next one from starting.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("cannot listen on %s: the file exists and isn't a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen on %s: another server is already listening on it", path)
+	}
+
+	return os.Remove(path)
+}
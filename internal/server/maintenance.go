@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// Whether the server is in maintenance mode, which can be changed while it
+// runs through POST /admin/maintenance.
+type maintenanceMode struct {
+	mu sync.RWMutex
+
+	enabled bool
+
+	// The message rejected requests are answered with.
+	message string
+
+	// When maintenance mode was last turned on.
+	since time.Time
+
+	// The message used when maintenance mode is turned on without one.
+	defaultMessage string
+
+	// How long clients are told to wait before retrying.
+	retryAfter time.Duration
+}
+
+// The body of the GET and POST /admin/maintenance responses.
+type MaintenanceStatus struct {
+	// Whether requests are being rejected.
+	Enabled bool `json:"enabled"`
+
+	// The message rejected requests are answered with.
+	Message string `json:"message"`
+
+	// The number of seconds clients are told to wait before retrying, which is
+	// sent in the Retry-After header.
+	RetryAfter int `json:"retry_after"`
+
+	// When maintenance mode was turned on, only set while it's on.
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// The body of a POST /admin/maintenance request.
+type MaintenanceRequest struct {
+	// Whether to turn maintenance mode on or off. Required.
+	Enabled *bool `json:"enabled"`
+
+	// The message rejected requests are answered with while maintenance mode
+	// is on. Defaults to MAINTENANCE_MESSAGE.
+	Message string `json:"message"`
+}
+
+// Returns the maintenance mode the server starts in.
+func newMaintenanceMode(cfg config.Maintenance) *maintenanceMode {
+	m := &maintenanceMode{
+		defaultMessage: cfg.Message,
+		retryAfter:     cfg.RetryAfter,
+	}
+	m.set(cfg.Enabled, "")
+
+	return m
+}
+
+// Turns maintenance mode on or off. The message falls back to the default when
+// it's empty.
+func (m *maintenanceMode) set(enabled bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if message == "" {
+		message = m.defaultMessage
+	}
+
+	if enabled && !m.enabled {
+		m.since = time.Now().UTC()
+	}
+
+	m.enabled = enabled
+	m.message = message
+}
+
+// Returns whether maintenance mode is on and the message it answers with.
+func (m *maintenanceMode) status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := MaintenanceStatus{
+		Enabled:    m.enabled,
+		Message:    m.message,
+		RetryAfter: int(math.Ceil(m.retryAfter.Seconds())),
+	}
+
+	if m.enabled {
+		since := m.since
+		status.Since = &since
+	}
+
+	return status
+}
+
+// Rejects requests with a 503 and a Retry-After header while the server is in
+// maintenance mode, which also refuses new WebSocket connections. Requests
+// already being handled when it's turned on are allowed to finish. The
+// liveness and readiness checks are always let through, as is the
+// /admin/maintenance endpoint so maintenance mode can be turned off again.
+func (s *Server) maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := s.maintenance.status()
+		if !status.Enabled {
+			c.Next()
+			return
+		}
+
+		switch basePath := basePathOf(c); c.FullPath() {
+		case basePath + "/health/liveness", basePath + "/health/readiness", basePath + "/admin/maintenance":
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(status.RetryAfter))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, errorResponse(c, status.Message))
+	}
+}
+
+// Handles requests to the GET /admin/maintenance endpoint, which returns
+// whether the server is in maintenance mode. Requires admin credentials.
+func (s *Server) getMaintenanceHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "viewing maintenance mode requires admin credentials"))
+		return
+	}
+
+	c.JSON(http.StatusOK, s.maintenance.status())
+}
+
+// Handles requests to the POST /admin/maintenance endpoint, which turns
+// maintenance mode on or off with a body such as {"enabled": true}, optionally
+// with the message to answer rejected requests with. Returns the new status.
+// Requires admin credentials.
+//
+// The change only lasts until the server restarts, after which it starts in
+// the mode MAINTENANCE_MODE sets.
+func (s *Server) setMaintenanceHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "changing maintenance mode requires admin credentials"))
+		return
+	}
+
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	if req.Enabled == nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "enabled is required"))
+		return
+	}
+
+	s.maintenance.set(*req.Enabled, req.Message)
+
+	if *req.Enabled {
+		fmt.Println("[Maintenance]: Maintenance mode is on, rejecting requests")
+	} else {
+		fmt.Println("[Maintenance]: Maintenance mode is off")
+	}
+
+	c.JSON(http.StatusOK, s.maintenance.status())
+}
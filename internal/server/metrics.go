@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The route label of requests that didn't match any route, so scanners
+// probing random paths can't blow up the number of series.
+const unmatchedRoute = "unmatched"
+
+// The least priority events are counted as high priority at.
+const highPriority = 4
+
+// The Prometheus metrics the server exports at /metrics. They're kept in a
+// registry of their own rather than the global one so every server, e.g. each
+// one the tests start, counts its own traffic.
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+
+	eventsIngested     *prometheus.CounterVec
+	highPriorityEvents prometheus.Counter
+	batchSize          prometheus.Histogram
+
+	// The number of events in the database, as of the last time they were
+	// counted by the eventCountPoller.
+	eventsTotal  prometheus.Gauge
+	eventsByType *prometheus.GaugeVec
+
+	dbOperationDuration *prometheus.HistogramVec
+}
+
+// Creates the server's metrics, registering them along with the Go runtime and
+// process metrics. The number of open WebSocket connections is read from the
+// hub whenever the metrics are scraped.
+func newServerMetrics(hub *Hub) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shion_http_requests_total",
+			Help: "The number of HTTP requests handled, by method, route, and status code.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shion_http_request_duration_seconds",
+			Help:    "How long HTTP requests took to handle, by method, route, and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+
+		eventsIngested: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shion_events_ingested_total",
+			Help: "The number of events created, by type.",
+		}, []string{"type"}),
+		highPriorityEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shion_high_priority_events_total",
+			Help: "The number of events created with a priority of 4 or more.",
+		}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "shion_event_batch_size",
+			Help:    "The number of events in each batch created at once.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		eventsTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shion_events_total_gauge",
+			Help: "The number of events in the database, counted every METRICS_DB_POLL_INTERVAL_SECONDS.",
+		}),
+		eventsByType: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "shion_events_by_type_gauge",
+			Help: "The number of events in the database by type, counted every METRICS_DB_POLL_INTERVAL_SECONDS.",
+		}, []string{"event_type"}),
+
+		dbOperationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shion_db_operation_duration_seconds",
+			Help:    "How long database operations took, by operation and whether they failed.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation", "status"}),
+	}
+
+	m.registry.MustRegister(
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.eventsIngested,
+		m.highPriorityEvents,
+		m.batchSize,
+		m.eventsTotal,
+		m.eventsByType,
+		m.dbOperationDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "shion_websocket_connections",
+			Help: "The number of open WebSocket connections.",
+		}, func() float64 {
+			return float64(hub.Connections())
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Counts the events a write created by type, and the ones with a high
+// priority. Registered as a commit hook so events that are rolled back aren't
+// counted.
+func (m *serverMetrics) countIngested(events ...database.EventEntry) {
+	for _, event := range events {
+		m.eventsIngested.WithLabelValues(string(event.Type)).Inc()
+
+		if event.Priority >= highPriority {
+			m.highPriorityEvents.Inc()
+		}
+	}
+}
+
+// Records how long a database operation took once it finishes. Implements
+// database.Observer.
+func (m *serverMetrics) StartOperation(ctx context.Context, operation string) (context.Context, database.OperationDone) {
+	return ctx, func(elapsed time.Duration, rows int, err error) {
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+
+		m.dbOperationDuration.WithLabelValues(operation, status).Observe(elapsed.Seconds())
+	}
+}
+
+// Records the size of a batch of events. Implements database.Observer.
+func (m *serverMetrics) ObserveBatchSize(ctx context.Context, size int) {
+	m.batchSize.Observe(float64(size))
+}
+
+// A middleware that counts every request and records how long it took, labeled
+// by its method, the route it matched, e.g. /api/v1/event/:id, and the status
+// code of the response.
+func metricsMiddleware(m *serverMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		status := strconv.Itoa(c.Writer.Status())
+
+		m.httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handles requests to the GET /metrics endpoint, which serves the metrics in
+// the Prometheus text format. Requires the METRICS_USERNAME and
+// METRICS_PASSWORD credentials when they're set, otherwise the API or admin
+// credentials, and returns a 401 without them.
+func (s *Server) metricsHandler() gin.HandlerFunc {
+	handler := promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+
+	return func(c *gin.Context) {
+		user, pass, hasAuth := c.Request.BasicAuth()
+
+		var valid bool
+		if s.metricsUsername != "" {
+			valid = hasAuth && user == s.metricsUsername && pass == s.metricsPassword
+		} else {
+			valid, _ = checkBasicAuth(user, pass, hasAuth, s.apiUsername, s.apiPassword, s.adminUsername, s.adminPassword)
+		}
+
+		if !valid {
+			c.Header("WWW-Authenticate", `Basic realm="metrics"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "request_id": requestIDOf(c)})
+			return
+		}
+
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/nats-io/nats.go"
+)
+
+// The default number of events that can be waiting to be published to NATS
+// before new ones are dropped.
+const defaultNATSBufferSize = 1024
+
+// The default subject prefix events are published under, followed by the
+// event's type.
+const defaultNATSSubjectPrefix = "shion.events"
+
+// Replaces the characters that can't appear in a NATS subject token, since
+// event types are used as the last token of the subject.
+var natsSubjectReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+// A NATSPublisher mirrors newly created events onto NATS subjects. Events are
+// buffered and published by a background worker so publishing never delays
+// the request that created the event.
+type NATSPublisher struct {
+	conn *nats.Conn
+
+	// Events are published to <subjectPrefix>.<type>.
+	subjectPrefix string
+
+	// Events waiting to be published.
+	events chan database.EventEntry
+
+	// Closed when the publisher starts shutting down.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Closed once the worker has published every buffered event and exited.
+	done chan struct{}
+
+	// The number of events that were published.
+	published atomic.Int64
+
+	// The number of events that weren't published because the buffer was full
+	// or publishing failed.
+	dropped atomic.Int64
+}
+
+// Connects to the NATS server at the given URL and starts publishing events.
+// If the server can't be reached the connection keeps retrying in the
+// background, as it does whenever the connection is lost.
+func NewNATSPublisher(url, subjectPrefix string, bufferSize int) (*NATSPublisher, error) {
+	if subjectPrefix == "" {
+		subjectPrefix = defaultNATSSubjectPrefix
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultNATSBufferSize
+	}
+
+	conn, err := nats.Connect(url,
+		nats.Name("shion-api"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				fmt.Println("[NATSPublisher]: Disconnected from NATS:", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			fmt.Println("[NATSPublisher]: Reconnected to NATS at", conn.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &NATSPublisher{
+		conn:          conn,
+		subjectPrefix: strings.TrimSuffix(subjectPrefix, "."),
+		events:        make(chan database.EventEntry, bufferSize),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// Queues the given events to be published without waiting for them to be
+// sent. Events are dropped and counted if the buffer is full or the publisher
+// is shutting down. Does nothing if the publisher is nil, i.e. NATS isn't
+// configured.
+func (p *NATSPublisher) Enqueue(events ...database.EventEntry) {
+	if p == nil {
+		return
+	}
+
+	for _, event := range events {
+		select {
+		case <-p.closing:
+			p.dropped.Add(1)
+			continue
+		default:
+		}
+
+		select {
+		case p.events <- event:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// Publishes the event and waits for the server to confirm it received it, for
+// the outbox relay, which retries the event if this fails. Returns an error if
+// the event couldn't be published or wasn't confirmed before the context is
+// done, e.g. while the connection is down.
+func (p *NATSPublisher) Deliver(ctx context.Context, event database.EventEntry) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := p.conn.Publish(p.Subject(event), payload); err != nil {
+		return err
+	}
+
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return err
+	}
+
+	p.published.Add(1)
+
+	return nil
+}
+
+// Returns the subject the given event is published to.
+func (p *NATSPublisher) Subject(event database.EventEntry) string {
+	eventType := natsSubjectReplacer.Replace(string(event.Type))
+	if eventType == "" {
+		eventType = "_"
+	}
+
+	return p.subjectPrefix + "." + eventType
+}
+
+// Returns whether the publisher is currently connected to NATS.
+func (p *NATSPublisher) Connected() bool {
+	return p.conn.IsConnected()
+}
+
+// Returns the number of events that were published.
+func (p *NATSPublisher) Published() int64 {
+	return p.published.Load()
+}
+
+// Returns the number of events that weren't published because the buffer was
+// full or publishing failed.
+func (p *NATSPublisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Publishes the events that are still buffered, then drains the connection so
+// every published message is flushed to the server before it's closed.
+// Returns the context's error if it's done first. Does nothing if the
+// publisher is nil.
+func (p *NATSPublisher) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.closeOnce.Do(func() { close(p.closing) })
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.conn.Close()
+		return ctx.Err()
+	}
+
+	// A connection that's still trying to (re)connect can't be drained, and
+	// there's nothing it could flush.
+	if !p.conn.IsConnected() {
+		p.conn.Close()
+		return nil
+	}
+
+	closed := make(chan struct{})
+	p.conn.SetClosedHandler(func(*nats.Conn) { close(closed) })
+
+	if err := p.conn.Drain(); err != nil {
+		p.conn.Close()
+		return err
+	}
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		p.conn.Close()
+		return ctx.Err()
+	}
+}
+
+// Publishes buffered events until the publisher shuts down, then publishes
+// whatever is left in the buffer.
+func (p *NATSPublisher) run() {
+	defer close(p.done)
+
+	for {
+		select {
+		case event := <-p.events:
+			p.publish(event)
+		case <-p.closing:
+			for {
+				select {
+				case event := <-p.events:
+					p.publish(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Publishes a single event as JSON. While the connection is down, messages
+// are buffered by the NATS client until it reconnects.
+func (p *NATSPublisher) publish(event database.EventEntry) {
+	payload, err := json.Marshal(event)
+	if err == nil {
+		err = p.conn.Publish(p.Subject(event), payload)
+	}
+
+	if err != nil {
+		p.dropped.Add(1)
+		fmt.Println("[NATSPublisher]: Error publishing event", event.ID, err)
+		return
+	}
+
+	p.published.Add(1)
+}
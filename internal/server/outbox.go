@@ -0,0 +1,362 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The lock held while relaying the outbox, so instances sharing a database
+// don't deliver the same entries at the same time.
+const outboxLockKey = "outbox-relay"
+
+// How long the relay lock is held before another instance may take over, in
+// case the instance holding it crashed. A single pass stops early once half of
+// it has passed so the lock doesn't expire mid-pass.
+const outboxLockTTL = time.Minute
+
+// The most outbox entries GET /admin/outbox returns.
+const maxOutboxListLimit = 1000
+
+// Configures how an OutboxRelay delivers outbox entries and retries failed
+// ones. Zero values are replaced with their defaults.
+type OutboxConfig struct {
+	// How often the outbox is checked for entries that are due, in addition to
+	// whenever new events are created. Defaults to 1 second.
+	PollInterval time.Duration
+
+	// The number of entries read from the outbox at a time. Defaults to 100.
+	BatchSize int
+
+	// How long delivering an event to a single sink may take. Defaults to 10
+	// seconds.
+	Timeout time.Duration
+
+	// The most times an entry is attempted before it's dead-lettered. Defaults
+	// to 10.
+	MaxAttempts int
+
+	// How long to wait before the first retry, which doubles after each
+	// attempt. Defaults to 1 second.
+	InitialBackoff time.Duration
+
+	// The longest wait between retries. Defaults to 5 minutes.
+	MaxBackoff time.Duration
+}
+
+// A downstream system the outbox relay delivers events to.
+type outboxSink struct {
+	// Recorded in an entry's delivered sinks once the sink acknowledges it.
+	name string
+
+	// Delivers the event, returning an error unless the sink acknowledged it.
+	deliver func(ctx context.Context, event database.EventEntry, attempt int) error
+}
+
+// An OutboxRelay delivers the events in the outbox table to webhook
+// subscriptions, NATS, and Kafka in the order they were created. Each sink is
+// only sent an event until it acknowledges it, and entries that fail are
+// retried with a backoff until they're dead-lettered after MaxAttempts.
+// Because the entries are written in the same transaction as their events,
+// every event is delivered at least once even if a sink is down or the
+// process restarts, but a sink may see the same event more than once.
+type OutboxRelay struct {
+	db     database.TursoDB
+	sinks  []outboxSink
+	config OutboxConfig
+
+	// Wakes the relay up early when new events are created.
+	wake chan struct{}
+
+	// Closed once the database is ready and the relay should start.
+	started   chan struct{}
+	startOnce sync.Once
+
+	// Closed when the relay starts shutting down.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Closed once the relay has stopped.
+	done chan struct{}
+}
+
+// Creates an OutboxRelay that delivers the outbox entries in the given database
+// to the webhook dispatcher, and to the NATS publisher and Kafka producer when
+// they aren't nil. The relay doesn't deliver anything until it's started.
+func NewOutboxRelay(db database.TursoDB, webhooks *WebhookDispatcher, nats *NATSPublisher, kafka *KafkaProducer, config OutboxConfig) *OutboxRelay {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 10
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+
+	r := &OutboxRelay{
+		db:      db,
+		config:  config,
+		wake:    make(chan struct{}, 1),
+		started: make(chan struct{}),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if webhooks != nil {
+		r.sinks = append(r.sinks, outboxSink{name: "webhooks", deliver: func(_ context.Context, event database.EventEntry, attempt int) error {
+			return webhooks.Deliver(event, attempt)
+		}})
+	}
+
+	if nats != nil {
+		r.sinks = append(r.sinks, outboxSink{name: "nats", deliver: func(ctx context.Context, event database.EventEntry, _ int) error {
+			return nats.Deliver(ctx, event)
+		}})
+	}
+
+	if kafka != nil {
+		r.sinks = append(r.sinks, outboxSink{name: "kafka", deliver: func(ctx context.Context, event database.EventEntry, _ int) error {
+			return kafka.Produce(ctx, event)
+		}})
+	}
+
+	go r.run()
+
+	return r
+}
+
+// Starts delivering outbox entries, including the ones left over from before
+// the last restart. Does nothing if the relay is nil, i.e. the outbox is
+// disabled, or has already started.
+func (r *OutboxRelay) Start() {
+	if r == nil {
+		return
+	}
+
+	r.startOnce.Do(func() { close(r.started) })
+}
+
+// Tells the relay new entries were added so it delivers them without waiting
+// for the next poll. Does nothing if the relay is nil.
+func (r *OutboxRelay) Notify() {
+	if r == nil {
+		return
+	}
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stops the relay once the entry it's delivering, if any, has finished.
+// Entries that haven't been delivered stay in the outbox for the next start.
+// Returns the context's error if it's done first. Does nothing if the relay is
+// nil.
+func (r *OutboxRelay) Shutdown(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.closeOnce.Do(func() { close(r.closing) })
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Relays the outbox whenever it's notified or the poll interval passes, until
+// the relay shuts down.
+func (r *OutboxRelay) run() {
+	defer close(r.done)
+
+	select {
+	case <-r.started:
+	case <-r.closing:
+		return
+	}
+
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.relay()
+
+		select {
+		case <-r.closing:
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Delivers the entries that are due a batch at a time while holding the relay
+// lock, until there are none left, the lock has been held for half its TTL,
+// or the relay shuts down.
+func (r *OutboxRelay) relay() {
+	acquired, err := r.db.AcquireLock(outboxLockKey, outboxLockTTL)
+	if err != nil {
+		fmt.Println("[OutboxRelay]: Error acquiring the relay lock:", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	defer r.db.ReleaseLock(outboxLockKey)
+
+	start := time.Now()
+	for time.Since(start) < outboxLockTTL/2 {
+		entries, err := r.db.GetPendingOutbox(r.config.BatchSize)
+		if err != nil {
+			fmt.Println("[OutboxRelay]: Error reading the outbox:", err)
+			return
+		}
+
+		for _, entry := range entries {
+			select {
+			case <-r.closing:
+				return
+			default:
+			}
+
+			r.deliver(entry)
+		}
+
+		if len(entries) < r.config.BatchSize {
+			return
+		}
+	}
+}
+
+// Delivers an entry's event to every sink that hasn't acknowledged it yet,
+// then removes the entry if they all have, or records the failure otherwise.
+func (r *OutboxRelay) deliver(entry database.OutboxEntry) {
+	attempt := entry.Attempts + 1
+
+	var errs []error
+	for _, sink := range r.sinks {
+		if slices.Contains(entry.Delivered, sink.name) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+		err := sink.deliver(ctx, entry.Event, attempt)
+		cancel()
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.name, err))
+			continue
+		}
+
+		entry.Delivered = append(entry.Delivered, sink.name)
+	}
+
+	if len(errs) == 0 {
+		if err := r.db.MarkOutboxSent(entry.EventID); err != nil {
+			fmt.Println("[OutboxRelay]: Error removing delivered event", entry.EventID, err)
+		}
+		return
+	}
+
+	deliveryErr := errors.Join(errs...)
+
+	dead, err := r.db.RecordOutboxFailure(entry.EventID, entry.Delivered, deliveryErr, time.Now().Add(r.backoff(attempt)), r.config.MaxAttempts)
+	if err != nil {
+		fmt.Println("[OutboxRelay]: Error recording failed delivery of event", entry.EventID, err)
+		return
+	}
+
+	if dead {
+		fmt.Printf("[OutboxRelay]: Dead-lettered event %s after %d attempts: %s\n", entry.EventID, attempt, deliveryErr)
+	}
+}
+
+// Returns how long to wait before retrying after the given failed attempt,
+// which doubles with each attempt up to the configured maximum.
+func (r *OutboxRelay) backoff(attempt int) time.Duration {
+	backoff := r.config.InitialBackoff
+	for i := 1; i < attempt && backoff < r.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, r.config.MaxBackoff)
+}
+
+// Handles requests to the GET /admin/outbox endpoint, which lists the outbox
+// entries with the given ?status=, dead by default, oldest first along with
+// their events. At most ?max= entries are returned, which defaults to 100.
+// Requires the admin credentials, otherwise a 403 is returned.
+func (s *Server) listOutboxHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "listing the outbox requires admin credentials"))
+		return
+	}
+
+	status := database.OutboxStatus(c.DefaultQuery("status", string(database.OutboxDead)))
+	if status != database.OutboxDead && status != database.OutboxPending {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "status must be dead or pending"))
+		return
+	}
+
+	max, err := strconv.Atoi(c.DefaultQuery("max", "100"))
+	if err != nil || max <= 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "max must be a positive integer"))
+		return
+	}
+
+	entries, err := s.dbFor(c).ListOutbox(status, min(max, maxOutboxListLimit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// Handles requests to the POST /admin/outbox/:id/requeue endpoint, which moves
+// the dead-lettered outbox entry for the event with the given ID back to
+// pending so it's delivered again straight away. Returns a 204 if successful,
+// 404 if the event has no dead-lettered entry, or 403 without the admin
+// credentials.
+func (s *Server) requeueOutboxHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "requeueing outbox entries requires admin credentials"))
+		return
+	}
+
+	err := s.dbFor(c).RequeueOutbox(c.Param("id"))
+	if errors.Is(err, database.ErrOutboxEntryNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	s.outbox.Notify()
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The default time a GET /events/poll request waits for new events when the
+// client doesn't give a ?timeout=.
+const defaultPollTimeout = 30 * time.Second
+
+// Handles requests to the GET /events/poll endpoint, a long-polling fallback
+// for clients behind proxies that block both WebSockets and Server-Sent
+// Events. If there are already events newer than the ?after= event ID then
+// they're returned immediately, otherwise the request blocks until new events
+// are broadcast or the ?timeout= (e.g. 30s) passes, in which case an empty
+// array is returned. The timeout is capped at LONG_POLL_MAX_TIMEOUT. Accepts
+// the same ?types= filter as the streaming endpoints.
+func (s *Server) pollEventsHandler(c *gin.Context) {
+	timeout := defaultPollTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, errorResponse(c, "timeout must be a non-negative duration, e.g. 30s"))
+			return
+		}
+
+		timeout = parsed
+	}
+	timeout = min(timeout, s.longPollMaxTimeout)
+
+	sub, missed, err := s.resumeSubscription(c.Query("after"), parseEventTypes(c.QueryArray("types")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+	defer s.hub.unsubscribe(sub)
+
+	if len(missed) > 0 {
+		c.JSON(http.StatusOK, localizeEvents(c, missed))
+		return
+	}
+
+	// The request may wait longer than the server's write timeout allows for
+	// regular requests.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case event := <-sub.events:
+		c.JSON(http.StatusOK, localizeEvents(c, drainEvents(sub, event)))
+	case <-timer.C:
+		c.JSON(http.StatusOK, []database.EventEntry{})
+	case <-sub.overflowed:
+		c.JSON(http.StatusOK, []database.EventEntry{})
+	case <-s.hub.closing:
+		c.JSON(http.StatusOK, []database.EventEntry{})
+	case <-c.Request.Context().Done():
+	}
+}
+
+// Returns the given event followed by any others already queued for the
+// subscriber, so events broadcast together are returned together.
+func drainEvents(sub *subscriber, first database.EventEntry) []database.EventEntry {
+	events := []database.EventEntry{first}
+
+	for {
+		select {
+		case event := <-sub.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The indentation used for pretty-printed responses, matching gin's
+// IndentedJSON.
+const prettyIndent = "    "
+
+// Indents the JSON bodies written through it. Handlers write each JSON
+// response in a single call, so every write is indented on its own. Bodies
+// of other content types, and any that aren't valid JSON, are passed through
+// unchanged.
+type prettyJSONWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *prettyJSONWriter) Write(data []byte) (int, error) {
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(data)
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", prettyIndent); err != nil {
+		return w.ResponseWriter.Write(data)
+	}
+
+	if _, err := w.ResponseWriter.Write(indented.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *prettyJSONWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Returns the wrapped writer so http.ResponseController can reach the
+// connection, e.g. for streaming handlers clearing their write deadline.
+func (w *prettyJSONWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// A middleware that pretty-prints every JSON response when the request has
+// ?pretty=true, which is handy when reading responses by hand. Responses are
+// compact by default, and invalid values get a 400.
+func prettyJSONMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.Query("pretty")
+		if raw == "" {
+			c.Next()
+			return
+		}
+
+		pretty, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(c, "pretty must be true or false"))
+			return
+		}
+
+		if pretty {
+			c.Writer = &prettyJSONWriter{ResponseWriter: c.Writer}
+		}
+
+		c.Next()
+	}
+}
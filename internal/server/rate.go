@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// How long the rate GET /events/rate computed for a window is reused for
+// before the events are counted again.
+const eventRateCacheTTL = 10 * time.Second
+
+// The rates GET /events/rate has recently computed, by window, so frequent
+// polling by alerting doesn't count the events on every request.
+type eventRateCache struct {
+	mu    sync.Mutex
+	rates map[time.Duration]cachedEventRate
+}
+
+// A computed rate and when it stops being reused.
+type cachedEventRate struct {
+	rate    database.EventRate
+	expires time.Time
+}
+
+// Creates an empty cache of event rates.
+func newEventRateCache() *eventRateCache {
+	return &eventRateCache{rates: map[time.Duration]cachedEventRate{}}
+}
+
+// Returns the rate cached for the window, if it hasn't expired yet.
+func (c *eventRateCache) get(window time.Duration) (database.EventRate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.rates[window]
+	if !ok || time.Now().After(cached.expires) {
+		return database.EventRate{}, false
+	}
+
+	return cached.rate, true
+}
+
+// Caches the rate computed for the window, dropping any that have expired so
+// clients asking for many different windows can't grow the cache forever.
+func (c *eventRateCache) put(window time.Duration, rate database.EventRate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for cachedWindow, cached := range c.rates {
+		if now.After(cached.expires) {
+			delete(c.rates, cachedWindow)
+		}
+	}
+
+	c.rates[window] = cachedEventRate{rate: rate, expires: now.Add(eventRateCacheTTL)}
+}
+
+// Handles requests to the GET /events/rate endpoint, which returns how many
+// events were created in the ?window= before now and the average rate they
+// were created at, e.g. so alerting can spot a spike in ingestion. The window
+// defaults to 5m and is a duration such as 1m, 5m or 1h, and a 400 is returned
+// for any other value. Each window's rate is cached for 10 seconds.
+func (s *Server) eventRateHandler(c *gin.Context) {
+	window, err := parseWindow("window", c.DefaultQuery("window", "5m"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	if rate, ok := s.eventRates.get(window); ok {
+		c.JSON(http.StatusOK, rate)
+		return
+	}
+
+	rate, err := s.dbFor(c).GetEventRate(window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	s.eventRates.put(window, rate)
+
+	c.JSON(http.StatusOK, rate)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// How long a client's limiter is kept after its last request.
+const rateLimiterIdleTTL = 3 * time.Minute
+
+// Tracks a token bucket rate limiter per client IP address.
+type ipRateLimiter struct {
+	mu sync.Mutex
+
+	// The number of requests per second each client is allowed to make, or
+	// zero when rate limiting is disabled.
+	rps rate.Limit
+
+	// The maximum number of requests a client can make in a single burst.
+	burst int
+
+	limiters map[string]*ipLimiter
+
+	// When idle limiters were last pruned.
+	lastSweep time.Time
+}
+
+// A single client's limiter and when it was last used.
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Creates a new ipRateLimiter allowing rps requests per second with bursts of
+// up to burst requests per client IP address. Rate limiting is disabled while
+// rps is zero.
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		rps:       rate.Limit(rps),
+		burst:     burst,
+		limiters:  make(map[string]*ipLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Changes the limits every client is held to, e.g. when the configuration is
+// reloaded. Each client starts over with a full bucket under the new limits.
+func (l *ipRateLimiter) set(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rps = rate.Limit(rps)
+	l.burst = burst
+	clear(l.limiters)
+}
+
+// Returns the limiter for the given IP address, creating it if needed, along
+// with the limits it was created with. Limiters that haven't been used
+// recently are pruned so the map can't grow forever. The limiter is nil when
+// rate limiting is disabled.
+func (l *ipRateLimiter) get(ip string, now time.Time) (*rate.Limiter, rate.Limit, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rps <= 0 {
+		return nil, 0, 0
+	}
+
+	if now.Sub(l.lastSweep) > rateLimiterIdleTTL {
+		for key, entry := range l.limiters {
+			if now.Sub(entry.lastSeen) > rateLimiterIdleTTL {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	entry, ok := l.limiters[ip]
+	if !ok {
+		entry = &ipLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.limiters[ip] = entry
+	}
+	entry.lastSeen = now
+
+	return entry.limiter, l.rps, l.burst
+}
+
+// Limits each client IP address to the configured number of requests per
+// second. Every response includes X-RateLimit-Limit, X-RateLimit-Remaining, and
+// X-RateLimit-Reset headers so clients can pace themselves before they're
+// blocked. Requests over the limit are rejected with 429 Too Many Requests and
+// a Retry-After header. Requests pass straight through while rate limiting is
+// disabled.
+func rateLimitMiddleware(limiter *ipRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		l, rps, burst := limiter.get(c.ClientIP(), now)
+		if l == nil {
+			c.Next()
+			return
+		}
+
+		allowed := l.AllowN(now, 1)
+		tokens := l.TokensAt(now)
+
+		// The bucket is full again once the missing tokens have been refilled.
+		missing := float64(burst) - tokens
+		reset := now.Add(time.Duration(missing / float64(rps) * float64(time.Second)))
+
+		c.Header("X-RateLimit-Limit", strconv.FormatFloat(float64(rps), 'f', -1, 64))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(reset.UnixNano())/float64(time.Second))), 10))
+
+		if !allowed {
+			retryAfter := math.Ceil((1 - tokens) / float64(rps))
+			c.Header("Retry-After", strconv.Itoa(int(math.Max(1, retryAfter))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorResponse(c, "rate limit exceeded"))
+			return
+		}
+
+		c.Next()
+	}
+}
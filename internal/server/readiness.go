@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// How long each readiness check may take before it counts as failed, so a
+// hanging dependency can't hold up the probe.
+const readinessCheckTimeout = time.Second
+
+// A check the server must pass to be ready for traffic, e.g. that the database
+// is reachable. Returns why the server isn't ready, or nil if the check
+// passes. The context is canceled after readinessCheckTimeout.
+type readinessCheck func(ctx context.Context) error
+
+// A readiness check along with the name it's reported under.
+type namedReadinessCheck struct {
+	name  string
+	check readinessCheck
+}
+
+// The result of a single readiness check.
+type ReadinessCheckResult struct {
+	// Either up or down.
+	Status string `json:"status"`
+
+	// Why the check failed, if it did.
+	Error string `json:"error,omitempty"`
+
+	// How long the check took.
+	Latency string `json:"latency"`
+}
+
+// The response body returned by the GET /health/readiness endpoint.
+type ReadinessResponse struct {
+	// Either ready or not_ready.
+	Status string `json:"status"`
+
+	// The result of every check, keyed by name.
+	Checks map[string]ReadinessCheckResult `json:"checks"`
+
+	// The names of the checks that failed, in the order they were registered.
+	Failing []string `json:"failing,omitempty"`
+}
+
+// Registers a check the readiness endpoint runs on every request, which fails
+// the probe with a 503 whenever it returns an error. Dependencies the server
+// can't serve traffic without, e.g. a message broker, register themselves
+// here when they're set up. Checks must be registered before the server
+// starts handling requests.
+func (s *Server) addReadinessCheck(name string, check readinessCheck) {
+	s.readinessChecks = append(s.readinessChecks, namedReadinessCheck{name: name, check: check})
+}
+
+// Registers the checks every server has: that it has started up, isn't
+// shutting down, and isn't in maintenance mode, that the database answers a ping, and that the database's
+// tables have been created.
+func (s *Server) registerReadinessChecks() {
+	s.addReadinessCheck("startup", func(ctx context.Context) error {
+		if !s.ready.Load() {
+			return errors.New("the database warm-up hasn't finished")
+		}
+
+		return nil
+	})
+
+	s.addReadinessCheck("shutdown", func(ctx context.Context) error {
+		if s.shuttingDown.Load() {
+			return errors.New("the server is shutting down")
+		}
+
+		return nil
+	})
+
+	s.addReadinessCheck("maintenance", func(ctx context.Context) error {
+		if s.maintenance.status().Enabled {
+			return errors.New("the server is in maintenance mode")
+		}
+
+		return nil
+	})
+
+	s.addReadinessCheck("database", func(ctx context.Context) error {
+		if s.db == nil {
+			return errors.New("the database isn't configured")
+		}
+
+		return s.db.WithContext(ctx).WithTimeout(readinessCheckTimeout).Ping()
+	})
+
+	s.addReadinessCheck("migrations", func(ctx context.Context) error {
+		if s.db == nil {
+			return errors.New("the database isn't configured")
+		}
+
+		return s.db.WithContext(ctx).WithTimeout(readinessCheckTimeout).CheckSchema()
+	})
+}
+
+// Handles requests to the GET /health/readiness endpoint, which runs every
+// readiness check at once and returns 200 if they all pass, or 503 listing the
+// ones that failed. The probe fails until the database warm-up has finished,
+// and again as soon as the server starts shutting down so load balancers stop
+// sending it traffic. Unlike /health/liveness, which only shows the process is
+// running, a failing readiness probe means the server can't serve requests
+// right now but may recover on its own.
+func (s *Server) readinessHandler(c *gin.Context) {
+	results := make([]ReadinessCheckResult, len(s.readinessChecks))
+
+	var wg sync.WaitGroup
+	for i, check := range s.readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runReadinessCheck(c.Request.Context(), check.check)
+		}()
+	}
+	wg.Wait()
+
+	resp := ReadinessResponse{Status: "ready", Checks: make(map[string]ReadinessCheckResult, len(results))}
+	for i, result := range results {
+		name := s.readinessChecks[i].name
+
+		resp.Checks[name] = result
+		if result.Status != database.HealthUp {
+			resp.Failing = append(resp.Failing, name)
+		}
+	}
+
+	if len(resp.Failing) > 0 {
+		resp.Status = "not_ready"
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Runs a single readiness check with its own timeout. A check that panics
+// fails rather than taking the probe down with it.
+func runReadinessCheck(ctx context.Context, check readinessCheck) (result ReadinessCheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = ReadinessCheckResult{Status: database.HealthDown, Error: "the check panicked"}
+		}
+		result.Latency = time.Since(start).String()
+	}()
+
+	if err := check(ctx); err != nil {
+		return ReadinessCheckResult{Status: database.HealthDown, Error: err.Error()}
+	}
+
+	return ReadinessCheckResult{Status: database.HealthUp}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The code error responses for recovered panics have, so clients can tell them
+// apart from errors the handlers report on purpose.
+const internalErrorCode = "internal"
+
+// A middleware that recovers from panics in the handlers after it, logging the
+// panic and its stack trace at the Error level with the request's ID. The
+// client gets a 500 with the standard error body and code internal, never the
+// panic value, which may contain internal details. When includeStack is true,
+// which it is while Gin runs in debug mode, the stack trace is included in the
+// body as well to make local debugging easier.
+//
+// If the handler had already started writing the response, the status can't be
+// changed, so the connection is aborted instead to keep the client from
+// mistaking the truncated response for a complete one.
+func recoveryMiddleware(logger *slog.Logger, includeStack bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// Aborting the connection on purpose isn't an error worth logging.
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			stack := string(debug.Stack())
+
+			logger.LogAttrs(c.Request.Context(), slog.LevelError, "Recovered from panic",
+				slog.String("method", c.Request.Method),
+				slog.String("path", c.Request.URL.Path),
+				slog.String("request_id", requestIDOf(c)),
+				slog.String("panic", fmt.Sprint(recovered)),
+				slog.String("stack", stack),
+			)
+
+			if c.Writer.Written() {
+				c.Abort()
+				panic(http.ErrAbortHandler)
+			}
+
+			body := errorResponse(c, "internal server error")
+			body["code"] = internalErrorCode
+			if includeStack {
+				body["stack"] = stack
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, body)
+		}()
+
+		c.Next()
+	}
+}
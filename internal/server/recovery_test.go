@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A buffer that's safe to write to from the handler's goroutine while the test
+// reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf.Reset()
+}
+
+// Waits until the buffer holds at least n lines and returns its contents,
+// failing the test if it doesn't within a few seconds. The request is logged
+// after the response has been written, so the client can see the response
+// first.
+func (b *lockedBuffer) awaitLines(t *testing.T, n int) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs := b.String()
+		if strings.Count(logs, "\n") >= n {
+			return logs
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d log lines, got %q", n, logs)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Returns a server with the same middleware as the API, logging as JSON to the
+// returned buffer, and test-only routes that panic: GET /panic before writing
+// anything, GET /partial after streaming part of its response, and GET /slow
+// behind timeoutMiddleware after buffering part of its response. Stack traces
+// are included in the responses when includeStack is true.
+func newPanickingServer(t *testing.T, includeStack bool) (*httptest.Server, *lockedBuffer) {
+	t.Helper()
+
+	logs := &lockedBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, nil))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestIDMiddleware(), requestLoggingMiddleware(logger), recoveryMiddleware(logger, includeStack))
+
+	r.GET("/panic", func(c *gin.Context) {
+		panic("secret connection string")
+	})
+
+	r.GET("/partial", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("first chunk")
+		c.Writer.Flush()
+		panic("halfway")
+	})
+
+	r.GET("/slow", timeoutMiddleware(time.Second), func(c *gin.Context) {
+		c.String(http.StatusOK, "buffered")
+		panic("after buffering")
+	})
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	return ts, logs
+}
+
+func TestRecoveryMiddlewareReturnsTheStandardError(t *testing.T) {
+	ts, logs := newPanickingServer(t, false)
+
+	for _, path := range []string{"/panic", "/slow"} {
+		logs.Reset()
+
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(requestIDHeader, "abc-123")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("%s: unexpected status code: got %v want %v", path, resp.StatusCode, http.StatusInternalServerError)
+		}
+
+		if want := `{"code":"internal","error":"internal server error","request_id":"abc-123"}`; string(body) != want {
+			t.Fatalf("%s: unexpected body: got %s want %s", path, body, want)
+		}
+
+		// The panic is logged with its stack and request ID, followed by the
+		// request itself.
+		decoder := json.NewDecoder(strings.NewReader(logs.awaitLines(t, 2)))
+
+		var panicked, request map[string]any
+		if err := decoder.Decode(&panicked); err != nil {
+			t.Fatalf("%s: expected the panic to be logged, got %q: %v", path, logs.String(), err)
+		}
+		if err := decoder.Decode(&request); err != nil {
+			t.Fatalf("%s: expected the request to be logged, got %q: %v", path, logs.String(), err)
+		}
+
+		stack, _ := panicked["stack"].(string)
+		if panicked["level"] != "ERROR" || panicked["request_id"] != "abc-123" || !strings.Contains(stack, "recovery_test.go") {
+			t.Errorf("%s: unexpected panic log line: %v", path, panicked)
+		}
+
+		if request["msg"] != "Request" || request["status"] != float64(http.StatusInternalServerError) {
+			t.Errorf("%s: unexpected request log line: %v", path, request)
+		}
+	}
+}
+
+func TestRecoveryMiddlewareIncludesTheStackInDebugMode(t *testing.T) {
+	ts, _ := newPanickingServer(t, true)
+
+	resp, err := http.Get(ts.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	stack, _ := body["stack"].(string)
+	if body["code"] != internalErrorCode || body["request_id"] == "" || !strings.Contains(stack, "recovery_test.go") {
+		t.Fatalf("unexpected body: %v", body)
+	}
+
+	// The panic value still isn't exposed, only where it happened.
+	if body["error"] != "internal server error" {
+		t.Fatalf("unexpected error: %v", body["error"])
+	}
+}
+
+func TestRecoveryMiddlewareAbortsPartialResponses(t *testing.T) {
+	ts, logs := newPanickingServer(t, false)
+
+	resp, err := http.Get(ts.URL + "/partial")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	if err == nil {
+		t.Fatal("expected the truncated response to fail to be read")
+	}
+
+	if !strings.Contains(logs.awaitLines(t, 1), `"panic":"halfway"`) {
+		t.Fatalf("expected the panic to be logged, got %q", logs.String())
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// The default Redis channel events are shared on.
+const defaultRedisChannel = "shion:events"
+
+// The number of events that can be waiting to be published to Redis before new
+// ones are dropped.
+const redisBufferSize = 1024
+
+// How long publishing a single event to Redis may take.
+const redisPublishTimeout = 2 * time.Second
+
+// The message published to the Redis channel for each event.
+type redisMessage struct {
+	// The instance that created the event, which ignores its own messages since
+	// it has already broadcast the event to its clients.
+	Origin string `json:"origin"`
+
+	Event database.EventEntry `json:"event"`
+}
+
+// A RedisFanout shares newly created events between every instance using the
+// same Redis channel, so WebSocket and streaming clients see events no matter
+// which instance they were created on. Each instance publishes the events it
+// creates and broadcasts the ones it receives from other instances to its own
+// clients.
+//
+// Redis is only used to reach other instances, so while it's unavailable each
+// instance keeps broadcasting its own events and the client keeps trying to
+// reconnect in the background.
+type RedisFanout struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+
+	channel string
+
+	// Identifies this instance in the messages it publishes.
+	origin string
+
+	// Receives the events published by other instances.
+	hub *Hub
+
+	// Events waiting to be published.
+	events chan database.EventEntry
+
+	// Closed when the fanout starts shutting down.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Closed once the publisher has published every buffered event and exited.
+	published chan struct{}
+
+	// Whether the last publish succeeded, so an outage is only logged once.
+	available atomic.Bool
+
+	// The number of events published to Redis.
+	sent atomic.Int64
+
+	// The number of events received from other instances.
+	received atomic.Int64
+
+	// The number of events that weren't published because the buffer was full
+	// or publishing failed.
+	dropped atomic.Int64
+}
+
+// Connects to the Redis server at the given URL, e.g. redis://localhost:6379/0,
+// and starts sharing events on the given channel. Events received from other
+// instances are broadcast through the hub. Returns an error if the URL is
+// invalid, but not if the server can't be reached.
+func NewRedisFanout(url, channel string, hub *Hub) (*RedisFanout, error) {
+	if channel == "" {
+		channel = defaultRedisChannel
+	}
+
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+
+	f := &RedisFanout{
+		client:    client,
+		pubsub:    client.Subscribe(context.Background(), channel),
+		channel:   channel,
+		origin:    uuid.NewString(),
+		hub:       hub,
+		events:    make(chan database.EventEntry, redisBufferSize),
+		closing:   make(chan struct{}),
+		published: make(chan struct{}),
+	}
+	f.available.Store(true)
+
+	go f.publish()
+	go f.receive()
+
+	return f, nil
+}
+
+// Queues the given events to be published to other instances without waiting
+// for them to be sent. Events are dropped and counted if the buffer is full or
+// the fanout is shutting down. Does nothing if the fanout is nil, i.e.
+// REDIS_URL isn't set.
+func (f *RedisFanout) Enqueue(events ...database.EventEntry) {
+	if f == nil {
+		return
+	}
+
+	for _, event := range events {
+		select {
+		case <-f.closing:
+			f.dropped.Add(1)
+			continue
+		default:
+		}
+
+		select {
+		case f.events <- event:
+		default:
+			f.dropped.Add(1)
+		}
+	}
+}
+
+// Returns whether Redis responds to a ping within the given timeout.
+func (f *RedisFanout) Connected(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return f.client.Ping(ctx).Err() == nil
+}
+
+// Returns the number of events published to Redis.
+func (f *RedisFanout) Sent() int64 {
+	return f.sent.Load()
+}
+
+// Returns the number of events received from other instances.
+func (f *RedisFanout) Received() int64 {
+	return f.received.Load()
+}
+
+// Returns the number of events that weren't published because the buffer was
+// full or publishing failed.
+func (f *RedisFanout) Dropped() int64 {
+	return f.dropped.Load()
+}
+
+// Publishes the events that are still buffered, then unsubscribes and closes
+// the connection to Redis. Returns the context's error if it's done first.
+// Does nothing if the fanout is nil.
+func (f *RedisFanout) Shutdown(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+
+	f.closeOnce.Do(func() { close(f.closing) })
+
+	var err error
+	select {
+	case <-f.published:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	f.pubsub.Close()
+	f.client.Close()
+
+	return err
+}
+
+// Publishes buffered events until the fanout shuts down, then publishes
+// whatever is left in the buffer.
+func (f *RedisFanout) publish() {
+	defer close(f.published)
+
+	for {
+		select {
+		case event := <-f.events:
+			f.send(event)
+		case <-f.closing:
+			for {
+				select {
+				case event := <-f.events:
+					f.send(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Publishes a single event to the channel. A failure is only logged when Redis
+// first becomes unavailable, and again once it's reachable.
+func (f *RedisFanout) send(event database.EventEntry) {
+	payload, err := json.Marshal(redisMessage{Origin: f.origin, Event: event})
+	if err != nil {
+		f.dropped.Add(1)
+		fmt.Println("[RedisFanout]: Error encoding event", event.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisPublishTimeout)
+	defer cancel()
+
+	if err := f.client.Publish(ctx, f.channel, payload).Err(); err != nil {
+		f.dropped.Add(1)
+		if f.available.Swap(false) {
+			fmt.Println("[RedisFanout]: Redis is unavailable, only broadcasting events locally:", err)
+		}
+		return
+	}
+
+	f.sent.Add(1)
+	if !f.available.Swap(true) {
+		fmt.Println("[RedisFanout]: Redis is available again, sharing events with other instances")
+	}
+}
+
+// Broadcasts the events published by other instances to this instance's
+// clients until the subscription is closed. The subscription reconnects and
+// resubscribes on its own whenever the connection is lost.
+func (f *RedisFanout) receive() {
+	for msg := range f.pubsub.Channel() {
+		var message redisMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			fmt.Println("[RedisFanout]: Error decoding message:", err)
+			continue
+		}
+
+		if message.Origin == f.origin {
+			continue
+		}
+
+		f.received.Add(1)
+		f.hub.Broadcast(message.Event)
+	}
+}
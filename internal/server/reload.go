@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// The settings that can be changed without a restart, named like
+// config.Changes names them.
+var reloadableSettings = []string{
+	"Log.Level",
+	"RateLimit.RPS",
+	"RateLimit.Burst",
+	"Retention.MaxAge",
+	"Retention.MaxAgeByType",
+	"Retention.MaxCount",
+	"Retention.Interval",
+	"Retention.BatchSize",
+}
+
+// The response of the POST /admin/reload endpoint.
+type ReloadResponse struct {
+	// The settings whose new values were applied.
+	Reloaded []string `json:"reloaded"`
+
+	// The settings that changed but can't be applied without a restart, e.g.
+	// Server.Port, so they're left as they were.
+	Unchanged []string `json:"unchanged"`
+}
+
+// Handles requests to the POST /admin/reload endpoint, which reads the
+// configuration from the environment and the .env file again and applies the
+// settings that can be changed while the server runs: the log level, the rate
+// limits, and the retention policy. The other settings that changed are listed
+// as unchanged, since they need a restart. Requires admin credentials.
+//
+// If any setting is invalid a 422 listing the problems is returned and nothing
+// is applied.
+func (s *Server) reloadHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "reloading the configuration requires admin credentials"))
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := config.Reload()
+	if err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusUnprocessableEntity, errorResponse(c, "invalid configuration: "+strings.Join(invalid.Problems, "; ")))
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	response := ReloadResponse{Reloaded: []string{}, Unchanged: []string{}}
+	for _, setting := range config.Changes(s.cfg, cfg) {
+		if slices.Contains(reloadableSettings, setting) {
+			response.Reloaded = append(response.Reloaded, setting)
+		} else {
+			response.Unchanged = append(response.Unchanged, setting)
+		}
+	}
+
+	s.applyConfig(cfg)
+
+	if len(response.Reloaded) > 0 {
+		fmt.Println("[Reload]: Applied new values of", strings.Join(response.Reloaded, ", "))
+	}
+	if len(response.Unchanged) > 0 {
+		fmt.Println("[Reload]: Restart to apply the new values of", strings.Join(response.Unchanged, ", "))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Applies the settings of the configuration that can be changed while the
+// server runs, and records them as the running configuration. The caller must
+// hold reloadMu.
+func (s *Server) applyConfig(cfg config.Config) {
+	s.logLevel.Set(cfg.Log.Level)
+	s.rateLimiter.set(cfg.RateLimit.RPS, max(1, cfg.RateLimit.Burst))
+	s.retention.SetPolicy(retentionPolicy(cfg.Retention))
+
+	s.cfg.Log.Level = cfg.Log.Level
+	s.cfg.RateLimit = cfg.RateLimit
+	s.cfg.Retention = cfg.Retention
+}
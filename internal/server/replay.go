@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+var (
+	// Returned by checkReplay for events whose timestamp is older than the
+	// replay window.
+	errReplayTooOld = errors.New("event timestamp is too old, possible replay attack")
+
+	// Returned by checkReplay for events whose ID was already seen within the
+	// replay window.
+	errReplayDuplicate = errors.New("event has already been received, possible replay attack")
+)
+
+// Rejects an event that may be a replay when REPLAY_WINDOW_SECONDS is set.
+// Events with a timestamp older than the window are rejected with a 400.
+// Events with an ID have it recorded as a nonce for the rest of the window,
+// and are rejected with a 409 if it was already recorded, since an exact
+// duplicate inside the window would otherwise pass the timestamp check.
+// Events without a timestamp or ID have them filled in by the server, so
+// they're not checked. Returns the status and error to respond with, or nil if
+// the event may be created. Call forgetReplayNonce if creating it then fails.
+func (s *Server) checkReplay(db database.TursoDB, event database.EventEntry) (int, error) {
+	if s.replayWindow <= 0 {
+		return 0, nil
+	}
+
+	timestamp := time.Now()
+	if event.Timestamp != "" {
+		parsed, err := database.ParseTimestamp(event.Timestamp, s.defaultTimezone)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		if time.Since(parsed) > s.replayWindow {
+			return http.StatusBadRequest, errReplayTooOld
+		}
+
+		timestamp = parsed
+	}
+
+	if event.ID == "" {
+		return 0, nil
+	}
+
+	recorded, err := db.RecordNonce(event.ID, timestamp.Add(s.replayWindow))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if !recorded {
+		return http.StatusConflict, errReplayDuplicate
+	}
+
+	return 0, nil
+}
+
+// Forgets the nonce checkReplay recorded for an event that couldn't be
+// created, so retrying it isn't rejected as a replay.
+func (s *Server) forgetReplayNonce(db database.TursoDB, event database.EventEntry) {
+	if s.replayWindow <= 0 || event.ID == "" {
+		return
+	}
+
+	if err := db.DeleteNonce(event.ID); err != nil {
+		fmt.Println("[checkReplay()]: Error forgetting nonce for event", event.ID, err)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// The header a request's ID is read from when the client sends one, and
+// returned in either way so the request can be found in the logs.
+const requestIDHeader = "X-Request-ID"
+
+// The longest request ID accepted from a client. Longer ones are replaced with
+// a generated ID.
+const maxRequestIDLength = 128
+
+// The characters a request ID sent by a client may contain, besides ASCII
+// letters and digits. IDs with any others are replaced with a generated ID so
+// they can't be used to forge log lines or headers.
+const requestIDPunctuation = "-_.:"
+
+// The gin context key the request's ID is stored under.
+const requestIDContextKey = "requestID"
+
+// The gin context key set on requests that are only logged at the Debug
+// level, e.g. health checks, which would otherwise drown out everything else.
+const debugLogContextKey = "debugLog"
+
+// Returns the logger the server writes its structured logs to, which writes
+// one JSON object per line to stdout and drops messages below the given level
+// (LOG_LEVEL).
+func newLogger(level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+// A middleware that gives every request an ID, which is the X-Request-ID
+// header the client sent if it's valid (see validRequestID) or otherwise a
+// generated UUID. The ID is stored in the gin context, returned in the
+// response's X-Request-ID header and error bodies, and added to the request's
+// context so the database can include it in its logs.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(database.WithRequestID(c.Request.Context(), id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Returns whether a request ID sent by a client can be used as is, which it
+// can if it's at most maxRequestIDLength characters of ASCII letters, digits,
+// and requestIDPunctuation.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune(requestIDPunctuation, r):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the request's ID, set by requestIDMiddleware.
+func requestIDOf(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// Returns the body of an error response with the given message, which
+// includes the request's ID, if it has one, so the error can be matched to its
+// log lines. In v1 of the API the message and ID are top-level fields, while
+// v2 nests them in an error object, e.g. {"error": {"message": "..."}}.
+func errorResponse(c *gin.Context, message string) gin.H {
+	if versionOf(c) == apiV2 {
+		detail := gin.H{"message": message}
+		if id := requestIDOf(c); id != "" {
+			detail["request_id"] = id
+		}
+
+		return gin.H{"error": detail}
+	}
+
+	body := gin.H{"error": message}
+	if id := requestIDOf(c); id != "" {
+		body["request_id"] = id
+	}
+
+	return body
+}
+
+// A middleware that logs one line per request once it's been handled, with its
+// method, path, status, latency, client IP, authenticated user, request ID,
+// response size, and the ID of the request's trace, if it has one. Requests are
+// logged at the Info level, or Error for 5xx responses, and routes marked with
+// debugLogMiddleware only at the Debug level.
+func requestLoggingMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		level := slog.LevelInfo
+		switch {
+		case c.GetBool(debugLogContextKey):
+			level = slog.LevelDebug
+		case c.Writer.Status() >= 500:
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user", c.GetString(gin.AuthUserKey)),
+			slog.String("request_id", requestIDOf(c)),
+			slog.Int("size", max(c.Writer.Size(), 0)),
+		}
+
+		if traceID := traceIDOf(c.Request.Context()); traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", traceID))
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "Request", attrs...)
+	}
+}
+
+// A middleware that marks the route's requests to be logged only at the Debug
+// level by requestLoggingMiddleware.
+func debugLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(debugLogContextKey, true)
+		c.Next()
+	}
+}
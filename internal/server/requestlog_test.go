@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Returns a router that logs its requests as JSON to the returned buffer,
+// dropping messages below the given level. It serves GET /test as the user
+// "shion" and GET /health the same, but marked to be logged at Debug.
+func newLoggedRouter(t *testing.T, level slog.Level) (*gin.Engine, *bytes.Buffer) {
+	t.Helper()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: level}))
+
+	handler := func(c *gin.Context) {
+		c.Set(gin.AuthUserKey, "shion")
+		c.String(http.StatusTeapot, "short and stout")
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestIDMiddleware(), requestLoggingMiddleware(logger))
+	r.GET("/test", handler)
+	r.GET("/health", debugLogMiddleware(), handler)
+
+	return r, &logs
+}
+
+func TestRequestLoggingMiddleware(t *testing.T) {
+	r, logs := newLoggedRouter(t, slog.LevelInfo)
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if id := rec.Header().Get(requestIDHeader); id != "abc-123" {
+		t.Errorf("expected the client's request ID to be returned, got %q", id)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", logs.String(), err)
+	}
+
+	for key, want := range map[string]any{
+		"level":      "INFO",
+		"method":     "GET",
+		"path":       "/test",
+		"status":     float64(http.StatusTeapot),
+		"client_ip":  "192.0.2.1",
+		"user":       "shion",
+		"request_id": "abc-123",
+		"size":       float64(len("short and stout")),
+	} {
+		if line[key] != want {
+			t.Errorf("unexpected %s: got %v want %v", key, line[key], want)
+		}
+	}
+
+	if _, ok := line["latency"].(float64); !ok {
+		t.Errorf("expected the latency to be logged, got %v", line["latency"])
+	}
+}
+
+func TestRequestLoggingMiddlewareGeneratesRequestIDs(t *testing.T) {
+	r, _ := newLoggedRouter(t, slog.LevelInfo)
+
+	ids := map[string]bool{}
+	for range 2 {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+		ids[rec.Header().Get(requestIDHeader)] = true
+	}
+
+	if len(ids) != 2 || ids[""] {
+		t.Fatalf("expected a different ID for each request, got %v", ids)
+	}
+}
+
+func TestRequestLoggingMiddlewareLogsHealthChecksAtDebug(t *testing.T) {
+	r, logs := newLoggedRouter(t, slog.LevelInfo)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	if logs.Len() != 0 {
+		t.Fatalf("expected health checks to be left out at the Info level, got %q", logs.String())
+	}
+
+	r, logs = newLoggedRouter(t, slog.LevelDebug)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil || line["level"] != "DEBUG" {
+		t.Fatalf("expected a Debug line, got %q: %v", logs.String(), err)
+	}
+}
+
+func TestRequestIDMiddlewareReplacesInvalidIDs(t *testing.T) {
+	r, _ := newLoggedRouter(t, slog.LevelInfo)
+
+	for _, id := range []string{"has spaces", "new\nline", "quote\"d", strings.Repeat("a", maxRequestIDLength+1)} {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set(requestIDHeader, id)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get(requestIDHeader); got == id || uuid.Validate(got) != nil {
+			t.Errorf("expected %q to be replaced with a generated ID, got %q", id, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(requestIDHeader, "trace:01HX-abc_def.1")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "trace:01HX-abc_def.1" {
+		t.Errorf("expected a valid ID to be kept, got %q", got)
+	}
+}
+
+func TestErrorResponseIncludesTheRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestIDMiddleware())
+	r.GET("/test", func(c *gin.Context) {
+		if got := database.RequestID(c.Request.Context()); got != "abc-123" {
+			t.Errorf("expected the request's context to carry its ID, got %q", got)
+		}
+
+		c.JSON(http.StatusNotFound, errorResponse(c, "not found"))
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if body := rec.Body.String(); body != `{"error":"not found","request_id":"abc-123"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestRequestLoggingMiddlewareIncludesTheTraceID(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestIDMiddleware(), tracingMiddleware(noop.NewTracerProvider().Tracer("test")), requestLoggingMiddleware(logger))
+	r.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", logs.String(), err)
+	}
+
+	if line["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the client's trace ID to be logged, got %v", line["trace_id"])
+	}
+
+	// Requests without a trace don't log an empty ID.
+	logs.Reset()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	if strings.Contains(logs.String(), "trace_id") {
+		t.Fatalf("expected no trace ID without a trace, got %q", logs.String())
+	}
+}
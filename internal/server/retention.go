@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Configures which events a RetentionCleaner deletes and how often. Zero
+// limits are disabled, and the other zero values are replaced with their
+// defaults.
+type RetentionPolicy struct {
+	// How old events may get before they're deleted, unless their type has its
+	// own entry in MaxAgeByType.
+	MaxAge time.Duration
+
+	// How old events of each type may get before they're deleted, overriding
+	// MaxAge.
+	MaxAgeByType map[database.EventType]time.Duration
+
+	// The most events that are kept. The oldest events beyond it are deleted.
+	MaxCount int
+
+	// How often expired events are deleted. Defaults to 1 hour.
+	Interval time.Duration
+
+	// The number of events deleted per transaction, so a large cleanup doesn't
+	// hold the write lock for long. Defaults to 1000.
+	BatchSize int
+}
+
+// Returns whether the policy limits the events that are kept at all.
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || len(p.MaxAgeByType) > 0 || p.MaxCount > 0
+}
+
+// Returns the policy with the defaults of its zero values applied.
+func (p RetentionPolicy) withDefaults() RetentionPolicy {
+	if p.Interval <= 0 {
+		p.Interval = time.Hour
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = 1000
+	}
+
+	return p
+}
+
+// A RetentionCleaner periodically deletes the events that have expired under a
+// RetentionPolicy. Events are deleted in small batches, each in its own
+// transaction, so requests can keep writing in between.
+type RetentionCleaner struct {
+	db database.TursoDB
+
+	// Guards policy, which can be changed while the cleaner runs.
+	mu     sync.Mutex
+	policy RetentionPolicy
+
+	// Receives a value when the policy changes, so the cleaner can pick up a
+	// new interval.
+	policyChanged chan struct{}
+
+	// Closed once the database is ready and the cleaner should start.
+	started   chan struct{}
+	startOnce sync.Once
+
+	// Closed when the cleaner starts shutting down.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// Closed once the cleaner has stopped.
+	done chan struct{}
+}
+
+// Creates a RetentionCleaner that deletes expired events from the given
+// database. The cleaner doesn't delete anything until it's started, nor while
+// the policy doesn't limit anything, though the policy can be changed later
+// with SetPolicy.
+func NewRetentionCleaner(db database.TursoDB, policy RetentionPolicy) *RetentionCleaner {
+	c := &RetentionCleaner{
+		db:            db,
+		policy:        policy.withDefaults(),
+		policyChanged: make(chan struct{}, 1),
+		started:       make(chan struct{}),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+// Starts deleting expired events, straight away and then every interval. Does
+// nothing if the cleaner is nil or has already started.
+func (c *RetentionCleaner) Start() {
+	if c == nil {
+		return
+	}
+
+	c.startOnce.Do(func() { close(c.started) })
+}
+
+// Replaces the policy, e.g. when the configuration is reloaded. Once the
+// cleaner has started, the events that expired under the new policy are
+// deleted straight away and then every new interval.
+func (c *RetentionCleaner) SetPolicy(policy RetentionPolicy) {
+	c.mu.Lock()
+	c.policy = policy.withDefaults()
+	c.mu.Unlock()
+
+	select {
+	case c.policyChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the current policy.
+func (c *RetentionCleaner) currentPolicy() RetentionPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.policy
+}
+
+// Stops the cleaner once the batch it's deleting, if any, has been committed.
+// Returns the context's error if it's done first. Does nothing if the cleaner
+// is nil.
+func (c *RetentionCleaner) Shutdown(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	c.closeOnce.Do(func() { close(c.closing) })
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cleans up every interval until the cleaner shuts down.
+func (c *RetentionCleaner) run() {
+	defer close(c.done)
+
+	select {
+	case <-c.started:
+	case <-c.closing:
+		return
+	}
+
+	ticker := time.NewTicker(c.currentPolicy().Interval)
+	defer ticker.Stop()
+
+	for {
+		if deleted := c.clean(c.currentPolicy()); deleted > 0 {
+			fmt.Printf("[RetentionCleaner]: Deleted %d expired event(s)\n", deleted)
+		}
+
+		select {
+		case <-c.closing:
+			return
+		case <-ticker.C:
+		case <-c.policyChanged:
+			// Events that expired under the new policy are deleted straight
+			// away, and then every new interval.
+			ticker.Reset(c.currentPolicy().Interval)
+		}
+	}
+}
+
+// Deletes every event that has expired under the policy, returning how many
+// were deleted. Nothing is deleted if the policy doesn't limit anything.
+func (c *RetentionCleaner) clean(policy RetentionPolicy) int64 {
+	if !policy.enabled() {
+		return 0
+	}
+
+	now := time.Now()
+	var deleted int64
+
+	excluded := make([]database.EventType, 0, len(policy.MaxAgeByType))
+	for eventType, maxAge := range policy.MaxAgeByType {
+		excluded = append(excluded, eventType)
+
+		deleted += c.deleteBatches(policy.BatchSize, func(limit int) (int64, error) {
+			return c.db.DeleteEventsBefore(now.Add(-maxAge), eventType, nil, limit)
+		})
+	}
+
+	if policy.MaxAge > 0 {
+		deleted += c.deleteBatches(policy.BatchSize, func(limit int) (int64, error) {
+			return c.db.DeleteEventsBefore(now.Add(-policy.MaxAge), "", excluded, limit)
+		})
+	}
+
+	if policy.MaxCount > 0 {
+		deleted += c.deleteBatches(policy.BatchSize, func(limit int) (int64, error) {
+			return c.db.DeleteEventsBeyond(policy.MaxCount, limit)
+		})
+	}
+
+	return deleted
+}
+
+// Calls deleteBatch with the batch size until it deletes less than a full
+// batch, fails, or the cleaner shuts down. Returns the total deleted.
+func (c *RetentionCleaner) deleteBatches(batchSize int, deleteBatch func(limit int) (int64, error)) int64 {
+	var total int64
+
+	for {
+		select {
+		case <-c.closing:
+			return total
+		default:
+		}
+
+		deleted, err := deleteBatch(batchSize)
+		total += deleted
+
+		if err != nil {
+			fmt.Println("[RetentionCleaner]: Error deleting expired events:", err)
+			return total
+		}
+
+		if deleted < int64(batchSize) {
+			return total
+		}
+	}
+}
+
+// Returns the retention policy described by the configuration.
+func retentionPolicy(cfg config.Retention) RetentionPolicy {
+	policy := RetentionPolicy{
+		MaxAge:       cfg.MaxAge,
+		MaxAgeByType: make(map[database.EventType]time.Duration, len(cfg.MaxAgeByType)),
+		MaxCount:     cfg.MaxCount,
+		Interval:     cfg.Interval,
+		BatchSize:    cfg.BatchSize,
+	}
+
+	for eventType, maxAge := range cfg.MaxAgeByType {
+		policy.MaxAgeByType[database.EventType(eventType)] = maxAge
+	}
+
+	return policy
+}
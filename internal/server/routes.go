@@ -1,10 +1,13 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/4lch4/shion-api/internal/database"
@@ -18,15 +21,54 @@ type EventResponse struct {
 
 	// The event entry/entries that were created/queried/etc.
 	EventEntry []database.EventEntry `json:"event_entry"`
+
+	// The server's DEFAULT_TIMEZONE, which timestamps sent without a UTC
+	// offset are taken to be in.
+	Timezone string `json:"timezone"`
 }
 
-var (
-	// The username to be used for basic authentication.
-	apiUsername = os.Getenv("API_USERNAME")
+// The path every route is registered under.
+const apiBasePath = "/api/v1"
+
+// The response body returned by the POST /events endpoint when some of the
+// events couldn't be created, which only happens when DB_BATCH_ROLLBACK is
+// chunk.
+type PartialEventsResponse struct {
+	// The events that were created.
+	Events []EventResponse `json:"events"`
+
+	// The chunks of events that weren't created and why. Each chunk's start is
+	// the index of its first event in the request.
+	Errors []database.ChunkError `json:"errors"`
+}
+
+// The exact value the ?confirm= query parameter must have to purge every event.
+const purgeConfirmPhrase = "yes-delete-all-events"
+
+// The window GET /events/recent covers when ?minutes= isn't given, and the
+// longest it can be asked to cover. Longer windows are capped, since the
+// response isn't limited to a number of events.
+const (
+	defaultRecentMinutes = 15
+	maxRecentMinutes     = 24 * 60
+)
 
-	// The password to be used for basic authentication.
-	apiPassword = os.Getenv("API_PASSWORD")
+// The most event types GET /events can be asked to filter by with ?type=.
+const maxEventTypeFilters = 20
 
+// The order GET /events returns events in when ?sort_by= and ?order= aren't
+// given, which is the latest events first.
+const (
+	defaultEventSortField = "timestamp"
+	defaultEventSortOrder = "desc"
+)
+
+// How long a POST /events request holds the lock on its Idempotency-Key. This
+// matches the server's write timeout, after which the request can't still be
+// running.
+const batchLockTTL = 30 * time.Second
+
+var (
 	// Upgrader is used to upgrade an HTTP connection to a WebSocket connection.
 	wsUpgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
@@ -34,83 +76,356 @@ var (
 	}
 )
 
+// Returns the router serving every route, after switching Gin to the
+// configured mode. Gin's own request logger isn't used in any mode, the
+// structured logs of requestLoggingMiddleware are written instead, and in test
+// mode the console output isn't colored.
 func (s *Server) RegisterRoutes() http.Handler {
-	r := gin.Default()
+	gin.SetMode(s.ginMode)
+	if s.ginMode == gin.TestMode {
+		gin.DisableConsoleColor()
+	}
 
-	// All routes are to be prefixed with /api/v1, e.g. /api/v1/event.
-	rootGroup := r.Group("/api/v1")
+	r := gin.New()
+	s.configureClientIP(r)
+	r.Use(requestIDMiddleware())
+	r.Use(tracingMiddleware(s.tracer))
+	r.Use(requestLoggingMiddleware(s.accessLogger))
+	r.Use(metricsMiddleware(s.metrics))
+	r.Use(recoveryMiddleware(s.logger, gin.IsDebugging()))
+	r.Use(headMiddleware())
+	r.Use(prettyJSONMiddleware())
 
-	// Apply the basicAuthMiddleware to all routes registered under the rootGroup.
-	rootGroup.Use(basicAuthMiddleware())
+	r.Use(rateLimitMiddleware(s.rateLimiter))
 
-	// All WebSocket routes are to be prefixed with /ws, e.g. /api/v1/ws/events.
-	wsGroup := rootGroup.Group("/ws")
+	if s.bodyLogger != nil {
+		r.Use(bodyLoggingMiddleware(s.bodyLogger))
+	}
 
-	rootGroup.GET("/health/db", s.dbHealthHandler)
-	rootGroup.GET("/health/liveness", basicHealthHandler)
-	rootGroup.GET("/health/readiness", basicHealthHandler)
+	// Every route is served under /api/v1, e.g. /api/v1/event, and again
+	// under /api/v2 for clients that want v2 of the API (see
+	// versionMiddleware). The handlers adapt their responses to the version,
+	// so v1 keeps its original shapes.
+	for _, basePath := range []string{apiBasePath, apiV2BasePath} {
+		s.registerAPIRoutes(r.Group(basePath))
+	}
 
-	rootGroup.GET("/event", s.getEventHandler)
-	rootGroup.POST("/event", s.incomingEventHandler)
+	// Clients can find out which versions are supported without credentials.
+	r.GET("/api/versions", s.apiVersionsHandler)
 
-	rootGroup.GET("/events", s.getEventsHandler)
-	rootGroup.POST("/events", s.incomingEventsHandler)
+	// Scrapers get the metrics from the root rather than under the API, as
+	// they usually expect, with credentials of their own when they're set.
+	r.GET("/metrics", s.metricsHandler())
 
-	wsGroup.GET("/events", s.wsEventHandler)
+	if s.debugEndpoints {
+		s.registerDebugRoutes(r)
+	}
 
 	return r
 }
 
+// Registers every route, along with the middleware they share, under the given
+// group.
+func (s *Server) registerAPIRoutes(rootGroup *gin.RouterGroup) {
+	// The version is picked first so even authentication errors have the
+	// version's shape.
+	rootGroup.Use(versionMiddleware())
+	rootGroup.Use(v1DeprecationMiddleware(s.apiV1Sunset))
+
+	// Apply the auth middleware to all routes registered under the rootGroup.
+	// Requests must be signed with the HMAC secret when one is configured,
+	// unless FEATURE_HMAC_AUTH is off, otherwise Basic Auth is used.
+	if s.hmacSecret != "" && s.features.HMACAuth {
+		rootGroup.Use(hmacAuthMiddleware(s.hmacSecret, s.hmacAdminSecret))
+	} else {
+		rootGroup.Use(basicAuthMiddleware(s.apiUsername, s.apiPassword, s.adminUsername, s.adminPassword))
+	}
+
+	rootGroup.Use(s.warmUpMiddleware())
+	rootGroup.Use(s.maintenanceMiddleware())
+	rootGroup.Use(s.dbTimeoutMiddleware())
+	rootGroup.Use(responseEnvelopeMiddleware())
+	rootGroup.Use(s.timezoneMiddleware())
+
+	// All WebSocket routes are to be prefixed with /ws, e.g. /api/v1/ws/events.
+	wsGroup := rootGroup.Group("/ws")
+
+	// Health checks time out quickly so they never hang behind a slow query,
+	// and are polled often enough that they're only logged at the Debug level.
+	healthGroup := rootGroup.Group("/health", debugLogMiddleware(), timeoutMiddleware(s.healthRequestTimeout))
+
+	healthGroup.GET("/db", s.dbHealthHandler)
+	healthGroup.GET("/liveness", basicHealthHandler)
+	healthGroup.GET("/readiness", s.readinessHandler)
+	healthGroup.GET("/ws", s.wsHealthHandler)
+	healthGroup.GET("/nats", s.natsHealthHandler)
+	healthGroup.GET("/kafka", s.kafkaHealthHandler)
+	healthGroup.GET("/redis", s.redisHealthHandler)
+
+	// Storing a batch of events gets its own, usually shorter, timeout.
+	rootGroup.POST("/events", timeoutMiddleware(s.ingestRequestTimeout), s.incomingEventsHandler)
+
+	// Streaming and long-running routes aren't timed, since their responses
+	// can't be buffered or legitimately take longer than REQUEST_TIMEOUT.
+	rootGroup.GET("/events/stream", s.streamEventsHandler)
+	rootGroup.GET("/events/poll", s.pollEventsHandler)
+	rootGroup.POST("/events/import", s.importEventsHandler)
+	rootGroup.POST("/admin/vacuum", s.vacuumHandler)
+	rootGroup.GET("/admin/backup", s.backupHandler)
+
+	// Every other route is answered with a 504 once it's taken longer than
+	// REQUEST_TIMEOUT, and its database queries are cancelled.
+	timedGroup := rootGroup.Group("", timeoutMiddleware(s.requestTimeout))
+
+	// Monitoring tools can check an event exists, or look at the headers of
+	// the events list, without fetching the body (see headMiddleware).
+	timedGroup.GET("/event/:id", fieldsMiddleware(), s.getEventHandler)
+	timedGroup.HEAD("/event/:id", fieldsMiddleware(), s.getEventHandler)
+	timedGroup.POST("/event", s.incomingEventHandler)
+	timedGroup.PATCH("/event/:id", s.patchEventHandler)
+	timedGroup.POST("/event/:id/annotations", s.addAnnotationHandler)
+	timedGroup.GET("/event/:id/annotations", s.getAnnotationsHandler)
+
+	timedGroup.GET("/events", fieldsMiddleware(), s.getEventsHandler)
+	timedGroup.HEAD("/events", fieldsMiddleware(), s.getEventsHandler)
+	timedGroup.GET("/events/oldest", fieldsMiddleware(), s.oldestEventHandler)
+	timedGroup.POST("/events/batch-get", s.batchGetEventsHandler)
+	timedGroup.DELETE("/events/all", s.purgeEventsHandler)
+
+	timedGroup.GET("/jobs/:id", s.getJobHandler)
+
+	timedGroup.GET("/admin/jobs/:id", s.getBackgroundJobHandler)
+	timedGroup.GET("/admin/outbox", s.listOutboxHandler)
+	timedGroup.GET("/admin/features", s.featuresHandler)
+	timedGroup.POST("/admin/reload", s.reloadHandler)
+	timedGroup.GET("/admin/maintenance", s.getMaintenanceHandler)
+	timedGroup.POST("/admin/maintenance", s.setMaintenanceHandler)
+	timedGroup.GET("/admin/ws/connections", s.wsConnectionsHandler)
+	timedGroup.POST("/admin/outbox/:id/requeue", s.requeueOutboxHandler)
+	timedGroup.GET("/events/timeseries", s.timeSeriesHandler)
+	timedGroup.GET("/events/values", s.distinctValuesHandler)
+	timedGroup.GET("/events/summary", s.eventSummaryHandler)
+	timedGroup.GET("/events/top-types", s.topEventTypesHandler)
+	timedGroup.GET("/events/rate", s.eventRateHandler)
+	timedGroup.GET("/events/recent", fieldsMiddleware(), s.recentEventsHandler)
+
+	timedGroup.GET("/feed/events", s.feedEventsHandler)
+
+	schemasGroup := timedGroup.Group("/schemas", featureFlagMiddleware(s.features.SchemaValidation, featureDisabledHandler("schema validation")))
+
+	schemasGroup.POST("/:type", s.registerSchemaHandler)
+	schemasGroup.GET("/:type", s.getSchemaHandler)
+	schemasGroup.DELETE("/:type", s.deleteSchemaHandler)
+
+	timedGroup.POST("/webhooks", s.createWebhookHandler)
+	timedGroup.GET("/webhooks", s.listWebhooksHandler)
+	timedGroup.GET("/webhooks/:id", s.getWebhookHandler)
+	timedGroup.PUT("/webhooks/:id", s.updateWebhookHandler)
+	timedGroup.DELETE("/webhooks/:id", s.deleteWebhookHandler)
+
+	wsGroup.GET("/events", s.wsEventHandler)
+}
+
 // A basic auth middleware function I got from Phind made for Gin. It checks the
 // request's basic auth credentials against the username and password provided
 // in the environment variables. If the credentials are correct, the request is
 // allowed to continue. If the credentials are incorrect, the request is aborted
 // and a 401 Unauthorized response is sent back to the client.
-func basicAuthMiddleware() gin.HandlerFunc {
+//
+// The admin credentials are accepted as well, if they're set, in which case the
+// request is marked as an admin request (see isAdmin).
+func basicAuthMiddleware(apiUsername, apiPassword, adminUsername, adminPassword string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, pass, hasAuth := c.Request.BasicAuth()
-		if !hasAuth || user != apiUsername || pass != apiPassword {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized"})
+
+		valid, admin := checkBasicAuth(user, pass, hasAuth, apiUsername, apiPassword, adminUsername, adminPassword)
+		if !valid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "Unauthorized", "request_id": requestIDOf(c)})
 			return
 		}
+
+		c.Set(gin.AuthUserKey, user)
+		if admin {
+			c.Set(adminContextKey, true)
+		}
 		c.Next()
 	}
 }
 
-// A simple WebSocket handler that sends a message every second for testing.
-// In the end, this endpoint will function similar to the createEvent endpoint,
-// but will be able to handle a WebSocket connection for faster, more efficient
-// communication.
-func (s *Server) wsEventHandler(c *gin.Context) {
-	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		fmt.Println("err:", err)
-		return
-	}
-	defer conn.Close()
-	for {
-		conn.WriteMessage(websocket.TextMessage, []byte("Hello, WebSocket!"))
-		time.Sleep(time.Second)
+// Checks Basic Auth credentials against the API credentials and, if they're
+// set, the admin credentials. Returns whether the credentials are valid and
+// whether they're the admin credentials. Shared by the HTTP middleware and the
+// gRPC interceptors.
+func checkBasicAuth(user, pass string, hasAuth bool, apiUsername, apiPassword, adminUsername, adminPassword string) (valid, admin bool) {
+	switch {
+	case hasAuth && adminUsername != "" && user == adminUsername && pass == adminPassword:
+		return true, true
+	case !hasAuth || user != apiUsername || pass != apiPassword:
+		return false, false
 	}
+
+	return true, false
+}
+
+// The gin context key set on requests authenticated with the admin credentials.
+const adminContextKey = "admin"
+
+// Returns whether the request was authenticated with the admin credentials.
+func isAdmin(c *gin.Context) bool {
+	return c.GetBool(adminContextKey)
 }
 
 // Handles requests to the GET /event/:id endpoint, which accepts a single event
-// ID and returns the event with that ID, or an error if the operation fails.
+// ID and returns the event with that ID, 404 if it doesn't exist, or an error
+// if the operation fails. The event's annotations are included as well when
+// ?include_annotations=true is set, and ?fields= limits which of the event's
+// fields are returned.
 func (s *Server) getEventHandler(c *gin.Context) {
 	eventId := c.Param("id")
 
-	event, err := s.db.GetEventByID(eventId)
+	event, err := s.dbFor(c).GetEventByID(eventId)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	if c.Query("include_annotations") == "true" {
+		annotations, err := s.dbFor(c).GetAnnotations(eventId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+			return
+		}
+
+		event = localizeEvent(c, event)
+
+		if fields := fieldsOf(c); len(fields) > 0 {
+			projected := selectFields(event, fields)
+			projected["annotations"] = annotations
+
+			c.JSON(http.StatusOK, projected)
+			return
+		}
+
+		c.JSON(http.StatusOK, AnnotatedEvent{EventEntry: event, Annotations: annotations})
+		return
+	}
+
+	c.JSON(http.StatusOK, projectEvent(c, event))
+}
+
+// Handles requests to the GET /events/oldest endpoint, which returns the event
+// with the earliest timestamp to show how far back the stored events go. Only
+// events of the given type are considered when ?type= is set. Returns 404 if
+// there are no events. ?fields= limits which of the event's fields are
+// returned.
+func (s *Server) oldestEventHandler(c *gin.Context) {
+	event, err := s.dbFor(c).GetOldestEvent(database.EventType(c.Query("type")))
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
 		return
 	}
 
-	c.JSON(http.StatusOK, event)
+	c.JSON(http.StatusOK, projectEvent(c, event))
+}
+
+// Handles requests to the PATCH /event/:id endpoint, which accepts a JSON object
+// containing only the fields to change and updates them without touching the
+// rest of the event. Returns the updated event, 404 if the event doesn't
+// exist, 422 for unknown field names, 400 for read-only fields and invalid
+// values, or 503 if the database stayed locked.
+//
+// Changing the type or data checks the patched event against the schema for
+// its type, the same as creating it would, and gets a 422 listing each
+// violation if it doesn't conform.
+func (s *Server) patchEventHandler(c *gin.Context) {
+	var fields map[string]interface{}
+
+	if err := c.ShouldBindJSON(&fields); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	_, patchesType := fields["type"]
+	_, patchesData := fields["data"]
+	if (patchesType || patchesData) && !s.checkPatchedEventSchema(c, fields) {
+		return
+	}
+
+	event, err := s.dbFor(c).PatchEvent(c.Param("id"), fields)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	case errors.Is(err, database.ErrUnknownField):
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(c, err.Error()))
+		return
+	case errors.Is(err, database.ErrReadOnlyField), errors.Is(err, database.ErrInvalidFieldValue):
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	case err != nil:
+		databaseErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, localizeEvent(c, event))
+}
+
+// Applies the type and data in the fields of a PATCH to the stored event and
+// checks the result with checkEventSchema. Values of the wrong kind are left
+// for PatchEvent to reject. Writes a 404 and returns false if the event
+// doesn't exist.
+func (s *Server) checkPatchedEventSchema(c *gin.Context, fields map[string]interface{}) bool {
+	event, err := s.dbFor(c).GetEventByID(c.Param("id"))
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return false
+	case err != nil:
+		databaseErrorResponse(c, err)
+		return false
+	}
+
+	if eventType, ok := fields["type"].(string); ok {
+		event.Type = database.EventType(eventType)
+	}
+	if data, ok := fields["data"].(string); ok {
+		event.Data = data
+	}
+
+	return s.checkEventSchema(c, event)
 }
 
 // Handles requests to the GET /events endpoint, which accepts a query parameter
 // for the maximum number of events to return. Returns a slice of the latest
 // events up to the maximum number specified, or an error if the operation fails.
+//
+// The max must be a positive integer, otherwise a 400 is returned. Requesting
+// more than the MAX_EVENTS_LIMIT ceiling silently returns at most the ceiling.
+// In v2 of the API the events are wrapped in an EventResponseV2 with the total
+// number of stored events, unless the client turned the envelope off.
+// ?fields= limits which of each event's fields are returned.
+//
+// Repeating ?type=, e.g. ?type=heartbeat&type=alert, only returns events of
+// those types, and the v2 total only counts them. At most 20 types can be
+// given, otherwise a 400 is returned.
+//
+// The events are the latest by timestamp, newest first, unless ?sort_by= and
+// ?order= ask for another order: sort_by is one of timestamp, the default,
+// type, or created_at (the order they were stored in), and order is asc or
+// desc, the default. Anything else gets a 400.
+//
+// ?min_priority= only returns events with at least that priority, from 1 to 5,
+// the most urgent first and the latest first within each priority. It can't
+// be combined with ?type= or ?sort_by=, and gets a 400 if it is or if it's out
+// of range.
 func (s *Server) getEventsHandler(c *gin.Context) {
 	maxStr := c.DefaultQuery("max", "50")
 	if maxStr == "" {
@@ -119,113 +434,466 @@ func (s *Server) getEventsHandler(c *gin.Context) {
 
 	max, err := strconv.Atoi(maxStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
 		return
 	}
 
-	events, err := s.db.GetLatestEvents(max)
+	if max <= 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "max must be a positive integer"))
+		return
+	}
+
+	if max > s.maxEventsLimit {
+		max = s.maxEventsLimit
+	}
+
+	types := eventTypeFilter(c.QueryArray("type"))
+	if len(types) > maxEventTypeFilters {
+		c.JSON(http.StatusBadRequest, errorResponse(c, fmt.Sprintf("too many types: got %d, the maximum is %d", len(types), maxEventTypeFilters)))
+		return
+	}
+
+	sortBy := c.DefaultQuery("sort_by", defaultEventSortField)
+	if !slices.Contains(database.EventSortFields, sortBy) {
+		c.JSON(http.StatusBadRequest, errorResponse(c, fmt.Sprintf("unknown sort_by %q, expected one of %s", sortBy, strings.Join(database.EventSortFields, ", "))))
+		return
+	}
+
+	order := c.DefaultQuery("order", defaultEventSortOrder)
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, errorResponse(c, fmt.Sprintf("unknown order %q, expected asc or desc", order)))
+		return
+	}
+
+	var minPriority int
+	if raw := c.Query("min_priority"); raw != "" {
+		minPriority, err = strconv.Atoi(raw)
+		if err != nil || minPriority < database.MinPriority || minPriority > database.MaxPriority {
+			c.JSON(http.StatusBadRequest, errorResponse(c, fmt.Sprintf("min_priority must be an integer from %d to %d", database.MinPriority, database.MaxPriority)))
+			return
+		}
+
+		if len(types) > 0 || c.Query("sort_by") != "" || c.Query("order") != "" {
+			c.JSON(http.StatusBadRequest, errorResponse(c, "min_priority can't be combined with type, sort_by, or order"))
+			return
+		}
+	}
+
+	var events []database.EventEntry
+	switch db := s.dbFor(c); {
+	case minPriority > 0:
+		events, err = db.GetTopPriorityEvents(minPriority, max)
+	case sortBy != defaultEventSortField || order != defaultEventSortOrder:
+		events, err = db.GetEventsSorted(sortBy, order, types, max)
+	case len(types) == 0:
+		events, err = db.GetLatestEvents(max)
+	case len(types) == 1:
+		events, err = db.GetLatestEventsByType(types[0], max)
+	default:
+		events, err = db.GetLatestEventsByTypes(types, max)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
 		return
 	}
 
 	if len(events) == 0 {
-		c.JSON(http.StatusOK, []database.EventEntry{})
+		events = []database.EventEntry{}
+	}
+
+	if versionOf(c) == apiV2 && wantsEnvelope(c) {
+		var total int64
+		switch {
+		case minPriority > 0:
+			total, err = s.dbFor(c).GetEventCountByMinPriority(minPriority)
+		case len(types) > 0:
+			total, err = s.dbFor(c).GetEventCountByTypes(types)
+		default:
+			total, err = s.dbFor(c).GetEventCount()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+			return
+		}
+
+		// The projected events replace the envelope's own.
+		c.JSON(http.StatusOK, struct {
+			EventResponseV2
+			EventEntry any `json:"event_entry"`
+		}{
+			EventResponseV2: EventResponseV2{
+				Timezone:   s.defaultTimezone.String(),
+				Total:      total,
+				Pagination: &Pagination{Max: max, Returned: len(events), HasMore: total > int64(len(events))},
+			},
+			EventEntry: projectEvents(c, events),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, projectEvents(c, events))
+}
+
+// Returns the distinct event types given by the ?type= query parameters,
+// ignoring empty ones.
+func eventTypeFilter(values []string) []database.EventType {
+	var types []database.EventType
+	for _, value := range values {
+		if eventType := database.EventType(value); value != "" && !slices.Contains(types, eventType) {
+			types = append(types, eventType)
+		}
+	}
+
+	return types
+}
+
+// Handles requests to the GET /events/recent endpoint, which returns the events
+// with timestamps in the last ?minutes= minutes, newest first. It's a shortcut
+// for checking recent activity without working out a time range.
+//
+// The minutes default to 15 and must be a positive integer, otherwise a 400 is
+// returned. Asking for more than a day silently returns the last day. ?fields=
+// limits which of each event's fields are returned.
+func (s *Server) recentEventsHandler(c *gin.Context) {
+	minutes := defaultRecentMinutes
+	if raw := c.Query("minutes"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, errorResponse(c, "minutes must be a positive integer"))
+			return
+		}
+
+		minutes = min(parsed, maxRecentMinutes)
+	}
+
+	events, err := s.dbFor(c).GetEventsSince(time.Now().Add(-time.Duration(minutes) * time.Minute))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
 		return
 	}
 
-	c.JSON(http.StatusOK, events)
+	c.JSON(http.StatusOK, projectEvents(c, events))
 }
 
 // Handles requests to the POST /event endpoint, which accepts a single Event
-// entry and inserts it into the database. Returns the event that was created if
-// successful, or an error if the operation fails.
+// entry and inserts it into the database. Returns a 201 with the event that was
+// created and a Location header pointing at it if successful, or an error if
+// the operation fails, which is a 503 with a Retry-After header if the database
+// stayed locked by another writer. The event is wrapped in an EventResponse, or an
+// EventResponseV2 in v2 of the API, unless the client turned the envelope off
+// (see responseEnvelopeMiddleware).
+//
+// If an event with the same unique_key already exists then nothing is created,
+// and the existing event is returned with a 409, or a 200 when
+// UNIQUE_KEY_RETURNS_EXISTING is set.
 func (s *Server) incomingEventHandler(c *gin.Context) {
 	var payload database.EventEntry
 
 	if err := c.ShouldBind(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	if err := payload.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
 		return
 	}
 
-	insertedEvent, err := s.db.CreateEvent(payload)
+	if !s.checkEventSchema(c, payload) {
+		return
+	}
+
+	if status, err := s.checkReplay(s.dbFor(c), payload); err != nil {
+		c.JSON(status, errorResponse(c, err.Error()))
+		return
+	}
+
+	insertedEvent, err := s.dbFor(c).CreateEvent(payload)
+	if errors.Is(err, database.ErrUniqueKeyExists) {
+		s.forgetReplayNonce(s.dbFor(c), payload)
+		s.existingEventResponse(c, insertedEvent)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.forgetReplayNonce(s.dbFor(c), payload)
+		databaseErrorResponse(c, err)
+		return
+	}
+
+	// A retry of an event that was already created has been published once.
+	if !insertedEvent.Duplicate {
+		s.forwarder.Enqueue(insertedEvent)
+
+		if err := s.publishAcked(c.Request.Context(), insertedEvent); err != nil {
+			c.JSON(http.StatusBadGateway, errorResponse(c, fmt.Sprintf("event %s was stored but Kafka didn't acknowledge it: %v", insertedEvent.ID, err)))
+			return
+		}
+	}
+
+	c.Header("Location", basePathOf(c)+"/event/"+url.PathEscape(insertedEvent.ID))
+
+	insertedEvent = localizeEvent(c, insertedEvent)
+
+	if !wantsEnvelope(c) {
+		c.JSON(http.StatusCreated, insertedEvent)
+		return
+	}
+
+	if versionOf(c) == apiV2 {
+		c.JSON(http.StatusCreated, EventResponseV2{
+			Message:    "Event successfully received!",
+			EventEntry: []database.EventEntry{insertedEvent},
+			Timezone:   s.defaultTimezone.String(),
+			Total:      1,
+		})
 		return
 	}
 
-	resp := EventResponse{
+	c.JSON(http.StatusCreated, EventResponse{
 		Message:    "Event successfully received!",
 		EventEntry: []database.EventEntry{insertedEvent},
+		Timezone:   s.defaultTimezone.String(),
+	})
+}
+
+// Responds to a POST /event that matched an existing event by its unique key
+// with that event, the same way a created one is returned, but with a 409, or
+// a 200 when UNIQUE_KEY_RETURNS_EXISTING is set.
+func (s *Server) existingEventResponse(c *gin.Context, event database.EventEntry) {
+	status := http.StatusConflict
+	if s.uniqueKeyReturnsExisting {
+		status = http.StatusOK
+	}
+
+	c.Header("Location", basePathOf(c)+"/event/"+url.PathEscape(event.ID))
+
+	event = localizeEvent(c, event)
+
+	if !wantsEnvelope(c) {
+		c.JSON(status, event)
+		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	message := fmt.Sprintf("An event with unique_key %q already exists.", event.UniqueKey)
+
+	if versionOf(c) == apiV2 {
+		c.JSON(status, EventResponseV2{
+			Message:    message,
+			EventEntry: []database.EventEntry{event},
+			Timezone:   s.defaultTimezone.String(),
+			Total:      1,
+		})
+		return
+	}
+
+	c.JSON(status, EventResponse{
+		Message:    message,
+		EventEntry: []database.EventEntry{event},
+		Timezone:   s.defaultTimezone.String(),
+	})
 }
 
 // Handles requests to the POST /events endpoint, which accepts an array of
 // Event entries and inserts them into the database. Returns a slice of the
 // events that were created if successful, or an error if the operation fails.
+// Each event is wrapped in an EventResponse unless the client turned the
+// envelope off, in which case the events are returned as a plain array. In v2
+// of the API they're all returned in a single EventResponseV2 instead. Partial
+// failures always get a PartialEventsResponse, since the errors need somewhere
+// to go.
+//
+// If the request has an Idempotency-Key header then a lock on that key is held
+// while the batch is created, so a client retrying mid-flight gets a 409
+// instead of racing the original request. The header is ignored when
+// FEATURE_DEDUP is off.
+//
+// With ?async=true the events are validated and then created in the
+// background instead, and a 202 is returned straight away with the job to poll
+// at GET /jobs/:id (see submitEventsJob).
 func (s *Server) incomingEventsHandler(c *gin.Context) {
 	var entries []database.EventEntry
 	var responses []EventResponse
 
 	if err := c.ShouldBind(&entries); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
 		return
 	}
 
 	for _, entry := range entries {
-		insertedEvent, err := s.db.CreateEvent(entry)
+		if err := entry.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+			return
+		}
+
+		if !s.checkEventSchema(c, entry) {
+			return
+		}
+	}
+
+	if c.Query("async") == "true" {
+		s.submitEventsJob(c, entries)
+		return
+	}
+
+	if key := c.GetHeader("Idempotency-Key"); key != "" && s.features.Dedup {
+		lockKey := "events:" + key
+
+		acquired, err := s.dbFor(c).AcquireLock(lockKey, batchLockTTL)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+			return
+		}
+
+		if !acquired {
+			c.JSON(http.StatusConflict, errorResponse(c, "a request with this Idempotency-Key is already in progress"))
 			return
 		}
+		defer s.dbFor(c).ReleaseLock(lockKey)
+	}
+
+	// When only the failed chunks are rolled back the rest of the events were
+	// still created, so they're published and returned alongside the errors.
+	var batchErr *database.BatchError
+
+	insertedEvents, err := s.dbFor(c).CreateEvents(entries)
+	if err != nil && !errors.As(err, &batchErr) {
+		databaseErrorResponse(c, err)
+		return
+	}
+
+	s.publish(database.Inserted(insertedEvents)...)
+
+	insertedEvents = localizeEvents(c, insertedEvents)
+
+	for _, insertedEvent := range insertedEvents {
+		message := "Event(s) successfully received!"
+		if insertedEvent.Duplicate {
+			message = "Event already exists."
+		}
 
 		responses = append(responses, EventResponse{
-			Message:    "Event(s) successfully received!",
+			Message:    message,
 			EventEntry: []database.EventEntry{insertedEvent},
+			Timezone:   s.defaultTimezone.String(),
 		})
 	}
 
+	if batchErr != nil {
+		c.JSON(http.StatusMultiStatus, PartialEventsResponse{Events: responses, Errors: batchErr.Chunks})
+		return
+	}
+
+	if !wantsEnvelope(c) {
+		c.JSON(http.StatusOK, insertedEvents)
+		return
+	}
+
+	if versionOf(c) == apiV2 {
+		c.JSON(http.StatusOK, EventResponseV2{
+			Message:    "Event(s) successfully received!",
+			EventEntry: insertedEvents,
+			Timezone:   s.defaultTimezone.String(),
+			Total:      int64(len(insertedEvents)),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, responses)
 }
 
+// Handles requests to the DELETE /events/all endpoint, which deletes every
+// event and returns how many were deleted. Both the ALLOW_PURGE environment
+// variable must be true and the ?confirm= query parameter must be exactly
+// purgeConfirmPhrase, otherwise a 403 is returned and nothing is deleted.
+func (s *Server) purgeEventsHandler(c *gin.Context) {
+	if !s.allowPurge {
+		c.JSON(http.StatusForbidden, errorResponse(c, "purging events is disabled, set ALLOW_PURGE=true to enable it"))
+		return
+	}
+
+	if c.Query("confirm") != purgeConfirmPhrase {
+		c.JSON(http.StatusForbidden, errorResponse(c, fmt.Sprintf("purging events requires ?confirm=%s", purgeConfirmPhrase)))
+		return
+	}
+
+	deleted, err := s.dbFor(c).PurgeEvents()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
 func (s *Server) dbHealthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, s.db.Health())
+	health := s.dbFor(c).Health()
+
+	switch health.Status {
+	case database.HealthUp:
+		c.JSON(http.StatusOK, health)
+	case database.HealthDegraded:
+		c.JSON(http.StatusMultiStatus, health)
+	default:
+		c.JSON(http.StatusServiceUnavailable, health)
+	}
+}
+
+func (s *Server) wsHealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"connections":          s.hub.Connections(),
+		"messages_sent":        s.hub.MessagesSent(),
+		"dropped_events":       s.hub.Dropped(),
+		"overflow_disconnects": s.hub.OverflowDisconnects(),
+	})
+}
+
+// Handles requests to the GET /health/nats endpoint, which reports whether
+// events are being mirrored to NATS and how many have been published or
+// dropped.
+func (s *Server) natsHealthHandler(c *gin.Context) {
+	if s.nats == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"connected": s.nats.Connected(),
+		"published": s.nats.Published(),
+		"dropped":   s.nats.Dropped(),
+	})
+}
+
+func (s *Server) kafkaHealthHandler(c *gin.Context) {
+	if s.kafka == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       true,
+		"delivery_mode": s.kafka.DeliveryMode(),
+		"produced":      s.kafka.Produced(),
+		"failed":        s.kafka.Failed(),
+		"dropped":       s.kafka.Dropped(),
+	})
+}
+
+func (s *Server) redisHealthHandler(c *gin.Context) {
+	if s.redis == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"connected": s.redis.Connected(time.Second),
+		"sent":      s.redis.Sent(),
+		"received":  s.redis.Received(),
+		"dropped":   s.redis.Dropped(),
+	})
 }
 
 func basicHealthHandler(c *gin.Context) {
 	c.String(http.StatusOK, "OK")
 }
-
-// A custom logger that outputs the client's IP address, the status code of the
-// response, the latency of the request, the request method, and the request
-// path. I've commented this out because I've realized this log message is
-// output as well as the default debug messages so it's causing some things to
-// be logged twice and very ugly. For example, the following is what appears
-// when I send a request to the /api/v1/health/db endpoint:
-// 2024/07/23 05:31:03 [GIN] 172.17.0.1 - 200 - 46.588µs - GET - /api/v1/health/db
-// [GIN] 2024/07/23 - 05:31:03 | 200 |     212.452µs |      172.17.0.1 | GET      "/api/v1/health/db"
-// func CustomLogger() gin.HandlerFunc {
-// 	return func(c *gin.Context) {
-// 		startTime := time.Now()
-//
-// 		// Process request
-// 		c.Next()
-//
-// 		// Calculate latency
-// 		latency := time.Since(startTime)
-//
-// 		// Get client's IP address
-// 		clientIP := c.ClientIP()
-//
-// 		// Log format: IP - StatusCode - Latency - Request Method - Request Path
-// 		log.Printf("[GIN] %s - %d - %s - %s - %s",
-// 			clientIP,
-// 			c.Writer.Status(),
-// 			latency,
-// 			c.Request.Method,
-// 			c.Request.URL.Path,
-// 		)
-// 	}
-// }
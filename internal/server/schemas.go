@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Handles requests to the POST /schemas/:type endpoint, which accepts a JSON
+// Schema as the request body and registers it for the given event type,
+// replacing any existing schema. Events of that type are then rejected unless
+// their data conforms to the schema. Returns 400 if the schema is invalid.
+func (s *Server) registerSchemaHandler(c *gin.Context) {
+	schema, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	eventType := c.Param("type")
+
+	err = s.dbFor(c).RegisterSchema(eventType, string(schema))
+	if errors.Is(err, database.ErrInvalidSchema) {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schema successfully registered!", "event_type": eventType})
+}
+
+// Handles requests to the GET /schemas/:type endpoint, which returns the JSON
+// Schema registered for the given event type, or 404 if there isn't one.
+func (s *Server) getSchemaHandler(c *gin.Context) {
+	schema, err := s.dbFor(c).GetSchema(c.Param("type"))
+	if errors.Is(err, database.ErrSchemaNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.Data(http.StatusOK, "application/schema+json", []byte(schema))
+}
+
+// Handles requests to the DELETE /schemas/:type endpoint, which removes the JSON
+// Schema registered for the given event type so its events are no longer
+// validated. Returns 404 if there isn't one.
+func (s *Server) deleteSchemaHandler(c *gin.Context) {
+	err := s.dbFor(c).DeleteSchema(c.Param("type"))
+	if errors.Is(err, database.ErrSchemaNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Returned by validateEventSchema when an event's data doesn't conform to the
+// schema registered for its type.
+type schemaError struct {
+	eventType database.EventType
+
+	// Each way the data doesn't conform to the schema.
+	violations []string
+}
+
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("data does not match the schema for event type %q", e.eventType)
+}
+
+// Checks the event's data against the schema registered for its type, if any,
+// unless FEATURE_SCHEMA_VALIDATION is off. Returns a *schemaError if the data
+// doesn't conform, or the error from the check itself if it fails. Every path
+// that stores events calls this, so none of them can skip the schemas.
+func (s *Server) validateEventSchema(db database.TursoDB, event database.EventEntry) error {
+	if !s.features.SchemaValidation {
+		return nil
+	}
+
+	valid, violations, err := db.ValidateEventData(string(event.Type), event.Data)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return &schemaError{eventType: event.Type, violations: violations}
+	}
+
+	return nil
+}
+
+// Checks the event against its schema with validateEventSchema. If the data
+// doesn't conform then a 422 listing each violation is written to the
+// response and false is returned, as it is if the check itself fails.
+func (s *Server) checkEventSchema(c *gin.Context, event database.EventEntry) bool {
+	err := s.validateEventSchema(s.dbFor(c), event)
+
+	var invalid *schemaError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":             invalid.Error(),
+			"validation_errors": invalid.violations,
+			"request_id":        requestIDOf(c),
+		})
+		return false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return false
+	}
+
+	return true
+}
@@ -1,39 +1,719 @@
 package server
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/4lch4/shion-api/internal/config"
 	"github.com/4lch4/shion-api/internal/database"
-
-	_ "github.com/joho/godotenv/autoload"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
 	port int
 
+	// The username to be used for basic authentication.
+	apiUsername string
+
+	// The password to be used for basic authentication.
+	apiPassword string
+
+	// The username of the admin user, who can also use admin-only features. Admin
+	// access is disabled when unset.
+	adminUsername string
+
+	// The password of the admin user.
+	adminPassword string
+
+	// The credentials GET /metrics requires instead of the API credentials, or
+	// empty if it uses the API credentials.
+	metricsUsername string
+	metricsPassword string
+
+	// The shared secret machine clients sign their requests with. When set,
+	// HMAC signatures are required instead of basic authentication.
+	hmacSecret string
+
+	// The shared secret admin requests are signed with, or empty if signed
+	// requests can't use the admin endpoints.
+	hmacAdminSecret string
+
+	db database.TursoDB
+
+	// Broadcasts newly created events to WebSocket clients.
+	hub *Hub
+
+	// Delivers newly created events to webhook subscriptions.
+	webhooks *WebhookDispatcher
+
+	// Creates the events submitted through POST /events?async=true in the
+	// background.
+	jobs *JobQueue
+
+	// Mirrors newly created events onto NATS, or nil if NATS_URL isn't set.
+	nats *NATSPublisher
+
+	// Produces newly created events to Kafka, or nil if KAFKA_BROKERS isn't set.
+	kafka *KafkaProducer
+
+	// Mirrors events created through POST /event to a secondary instance, or
+	// nil if FORWARD_URL isn't set.
+	forwarder *EventForwarder
+
+	// Shares newly created events with the other instances using the same
+	// Redis channel, or nil if REDIS_URL isn't set.
+	redis *RedisFanout
+
+	// Delivers newly created events to webhook subscriptions, NATS, and Kafka
+	// from the outbox instead of queueing them in memory, or nil unless
+	// OUTBOX_ENABLED is true.
+	outbox *OutboxRelay
+
+	// Deletes expired events in the background, whenever a retention limit is
+	// configured.
+	retention *RetentionCleaner
+
+	// Counts the events in the database for the metrics in the background.
+	eventCounts *eventCountPoller
+
+	// Whether the database warm-up has finished, before which requests are
+	// rejected with a 503.
+	ready atomic.Bool
+
+	// Whether Shutdown has been called, after which the readiness check fails
+	// so load balancers stop sending traffic.
+	shuttingDown atomic.Bool
+
+	// Whether the server is in maintenance mode, in which requests other than
+	// the liveness and readiness checks are rejected with a 503.
+	maintenance *maintenanceMode
+
+	// The checks GET /health/readiness runs, in the order they were
+	// registered.
+	readinessChecks []namedReadinessCheck
+
+	// How often WebSocket clients are pinged to check they're still alive.
+	wsPingInterval time.Duration
+
+	// How long to wait for a WebSocket client to answer a ping before the
+	// connection is closed.
+	wsPongTimeout time.Duration
+
+	// How long a single write to a WebSocket client may take.
+	wsWriteTimeout time.Duration
+
+	// How long a WebSocket connection may stay open before the server asks the
+	// client to reconnect, or 0 for no limit. This lets load balancers rotate
+	// long-lived connections between instances.
+	wsMaxLifetime time.Duration
+
+	// How long WebSocket clients have to close their connection once the server
+	// starts shutting down before they're force-closed.
+	wsShutdownGracePeriod time.Duration
+
+	// The largest message a WebSocket client may send in bytes.
+	wsMaxMessageBytes int64
+
+	// How often a comment is written to idle Server-Sent Events streams.
+	sseKeepaliveInterval time.Duration
+
+	// The longest a GET /events/poll request may wait for new events.
+	longPollMaxTimeout time.Duration
+
+	// The timezone event timestamps without a UTC offset are taken to be in,
+	// and the one timestamps are returned in unless the client sends
+	// X-Timezone.
+	defaultTimezone    *time.Location
+	returnTimestampsIn *time.Location
+
+	// How long a request may take before it's answered with a 504, or 0 for
+	// no limit. The health routes and POST /events have their own timeouts,
+	// and streaming routes have none.
+	requestTimeout       time.Duration
+	healthRequestTimeout time.Duration
+	ingestRequestTimeout time.Duration
+
+	// The most events a single GET /events request can return.
+	maxEventsLimit int
+
+	// Whether the DELETE /events/all endpoint is allowed to delete events.
+	allowPurge bool
+
+	// Whether POST /event returns a 200 rather than a 409 when an event with
+	// the same unique key already exists.
+	uniqueKeyReturnsExisting bool
+
+	// Whether the pprof and expvar endpoints are served under /debug.
+	debugEndpoints bool
+
+	// When v1 of the API stops being served, or the zero time if that hasn't
+	// been decided.
+	apiV1Sunset time.Time
+
+	// The proxies whose X-Forwarded-For headers are trusted to give the
+	// client's IP, and whether their X-Real-IP headers are too.
+	trustedProxies []string
+	trustXRealIP   bool
+
+	// The rates GET /events/rate recently computed.
+	eventRates *eventRateCache
+
+	// Held while POST /admin/vacuum is compacting the database.
+	vacuuming sync.Mutex
+
+	// Tracks the background jobs started by the admin endpoints, e.g. vacuums,
+	// which Shutdown waits for before closing the database.
+	backgroundJobs sync.WaitGroup
+
+	// How old an event created through POST /event may be before it's
+	// rejected as a possible replay, or 0 if replay protection is disabled.
+	replayWindow time.Duration
+
+	// Limits the number of requests per second from each client IP address,
+	// letting every request through while rate limiting is disabled.
+	rateLimiter *ipRateLimiter
+
+	// Logs at the level set by LOG_LEVEL.
+	logger *slog.Logger
+
+	// The level logger and accessLogger log at, which can be changed by
+	// reloading the configuration.
+	logLevel *slog.LevelVar
+
+	// The configuration the server is running with, which POST /admin/reload
+	// compares the reloaded configuration with, and the lock held while it's
+	// reloaded.
+	cfg      config.Config
+	reloadMu sync.Mutex
+
+	// Logs a line for every request, to ACCESS_LOG_FILE as well when it's set.
+	accessLogger *slog.Logger
+
+	// Logs request and response bodies, or nil unless DEBUG_LOG_BODIES is true.
+	bodyLogger *slog.Logger
+
+	// Which optional features are turned on.
+	features config.FeatureFlags
+
+	// The mode Gin runs in, which RegisterRoutes applies.
+	ginMode string
+
+	// The Prometheus metrics served at GET /metrics.
+	metrics *serverMetrics
+
+	// Creates the spans of requests and the database operations they run.
+	tracer trace.Tracer
+}
+
+// The default maximum number of concurrent streams allowed per HTTP/2
+// connection, matching the default used by the golang.org/x/net/http2 package.
+const defaultHTTP2MaxConcurrentStreams = 250
+
+// The default maximum number of events a single GET /events request can return.
+const defaultMaxEventsLimit = 1000
+
+// An http.Server whose Shutdown also gracefully closes the WebSocket and
+// streaming connections, which http.Server.Shutdown doesn't wait for.
+type HTTPServer struct {
+	*http.Server
+
+	hub *Hub
+
+	webhooks *WebhookDispatcher
+
+	jobs *JobQueue
+
+	nats *NATSPublisher
+
+	kafka *KafkaProducer
+
+	forwarder *EventForwarder
+
+	redis *RedisFanout
+
+	outbox *OutboxRelay
+
+	retention *RetentionCleaner
+
+	eventCounts *eventCountPoller
+
+	// Serves the gRPC EventService alongside the HTTP API.
+	grpc *grpc.Server
+
+	// The permissions the Unix domain socket is created with, when Addr is
+	// one.
+	socketMode os.FileMode
+
+	// The port the gRPC server listens on, or 0 if it's disabled.
+	grpcPort int
+
+	// Receives the result of the database warm-up once it finishes.
+	warmUpResult chan error
+
+	// The database, which is closed once everything else has shut down.
 	db database.TursoDB
+
+	// Which database the server connects to, for logging.
+	connection database.ConnectionInfo
+
+	// Set when Shutdown is called so the readiness check starts failing.
+	shuttingDown *atomic.Bool
+
+	// The background jobs started by the admin endpoints that are still
+	// running.
+	backgroundJobs *sync.WaitGroup
+
+	// The ACCESS_LOG_FILE the request log is written to, or nil if it isn't
+	// set. It's closed once the server has stopped handling requests.
+	accessLog *rotatingWriter
+
+	// Flushes the spans still waiting to be exported and stops the exporter.
+	shutdownTracing func(context.Context) error
+}
+
+// Returns a channel that receives nil once the database is reachable and the
+// server has started accepting requests, or an error if the database is still
+// unreachable after DB_WARMUP_ATTEMPTS attempts or DB_WARMUP_TIMEOUT.
+func (s *HTTPServer) WarmedUp() <-chan error {
+	return s.warmUpResult
+}
+
+// Registers a processor that validates or transforms every event of the given
+// type before it's stored, however it's created. Events it rejects are
+// answered with a 422. Processors should be registered before the server
+// starts accepting requests, and do nothing without a database.
+func (s *HTTPServer) RegisterProcessor(eventType database.EventType, processor database.EventProcessor) {
+	if s.db != nil {
+		s.db.RegisterProcessor(eventType, processor)
+	}
+}
+
+// Waits for the database warm-up to finish, which pings the database up to
+// DB_WARMUP_ATTEMPTS times with a backoff, then creates its tables. Once the
+// database is reachable and every table exists, logs the driver it resolved
+// to and its host, without any credentials. Returns an error if the database
+// is still unreachable once the warm-up gives up, in which case the server
+// should exit rather than serve requests it can't handle. It can only be
+// called once, instead of reading from WarmedUp.
+func (s *HTTPServer) Connect() error {
+	if err := <-s.warmUpResult; err != nil {
+		return fmt.Errorf("connecting to the %s database at %s: %w", s.connection.Dialect, s.connection.Host, err)
+	}
+
+	// The schema has no version of its own, the tables are what the service
+	// depends on.
+	schema := "every table exists"
+	if err := s.db.CheckSchema(); err != nil {
+		schema = err.Error()
+	}
+
+	fmt.Printf("[Connect()]: Connected to the %s database at %s with the %s driver, %s\n", s.connection.Dialect, s.connection.Host, s.connection.Driver, schema)
+	return nil
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("API_PORT"))
+// Gracefully shuts down the server the same as http.Server.Shutdown, and at
+// the same time stops accepting new WebSocket connections, sends every
+// connected client a going-away close frame, and waits for them to close.
+// Clients that don't close within WS_SHUTDOWN_GRACE_PERIOD are force-closed.
+// In-flight webhook deliveries are allowed to finish, but pending retries are
+// abandoned. Once every request has finished, events still waiting to be
+// published to NATS are flushed and the NATS connection is drained, and events
+// still waiting to be acknowledged by Kafka are flushed, as are events still
+// waiting to be forwarded to the secondary instance or shared with other
+// instances through Redis. The gRPC server is stopped at the same time as the
+// HTTP server, and gRPC subscriptions end along with the WebSocket clients.
+// Running ingestion jobs are allowed to finish, while queued ones are left
+// pending in the database to be resumed on the next start. The outbox relay
+// finishes the delivery it's working on and leaves the rest of the outbox for
+// the next start, the retention cleaner stops once its current batch is
+// committed, and the event count poller once its current count finishes.
+// Background jobs such as a vacuum started through POST /admin/vacuum are
+// allowed to finish. The readiness check fails from the moment Shutdown is
+// called, and the database is closed last, once nothing else is using it,
+// after which the remaining spans are exported. Returns once everything has
+// closed, or the context's error if it's done first.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	fmt.Println("[Shutdown()]: Stopped accepting connections, waiting for in-flight requests to finish")
+
+	wsErr := make(chan error, 1)
+	go func() {
+		wsErr <- s.hub.Shutdown(ctx)
+	}()
+
+	grpcErr := make(chan error, 1)
+	go func() {
+		grpcErr <- s.shutdownGRPC(ctx)
+	}()
+
+	webhooksErr := make(chan error, 1)
+	go func() {
+		webhooksErr <- s.webhooks.Shutdown(ctx)
+	}()
+
+	retentionErr := make(chan error, 1)
+	go func() {
+		retentionErr <- s.retention.Shutdown(ctx)
+	}()
+
+	eventCountsErr := make(chan error, 1)
+	go func() {
+		eventCountsErr <- s.eventCounts.Shutdown(ctx)
+	}()
+
+	// Events created by the calls and ingestion jobs still in progress have
+	// to be published, and the outbox relay has to stop using the publishers,
+	// before the publishers below are flushed.
+	err := errors.Join(s.Server.Shutdown(ctx), <-grpcErr, s.jobs.Shutdown(ctx), s.outbox.Shutdown(ctx))
+	fmt.Println("[Shutdown()]: In-flight requests and jobs finished, flushing publishers")
+
+	kafkaErr := make(chan error, 1)
+	go func() {
+		kafkaErr <- s.kafka.Shutdown(ctx)
+	}()
+
+	forwarderErr := make(chan error, 1)
+	go func() {
+		forwarderErr <- s.forwarder.Shutdown(ctx)
+	}()
+
+	redisErr := make(chan error, 1)
+	go func() {
+		redisErr <- s.redis.Shutdown(ctx)
+	}()
+
+	natsErr := s.nats.Shutdown(ctx)
+
+	err = errors.Join(err, <-wsErr, <-webhooksErr, <-retentionErr, <-eventCountsErr, natsErr, <-kafkaErr, <-forwarderErr, <-redisErr, s.waitForBackgroundJobs(ctx))
+	fmt.Println("[Shutdown()]: Publishers flushed and WebSocket clients closed, closing the database")
+
+	return errors.Join(err, s.db.Close(), s.shutdownTracing(ctx), s.accessLog.Close())
+}
+
+// Creates the server from the given configuration, which is normally loaded
+// by config.Load. The database warm-up starts in the background straight away.
+func NewServer(cfg config.Config) *HTTPServer {
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.Log.Level)
+
+	logger := newLogger(logLevel)
+	accessLogger, accessLog := newAccessLogger(cfg.Log, logLevel, logger)
+
 	NewServer := &Server{
-		port: port,
+		port: cfg.Server.Port,
+
+		apiUsername: cfg.Server.APIUsername,
+		apiPassword: cfg.Server.APIPassword,
+		hmacSecret:  cfg.Server.HMACSecret,
+
+		hmacAdminSecret: cfg.Server.HMACAdminSecret,
+
+		adminUsername: cfg.Server.AdminUsername,
+		adminPassword: cfg.Server.AdminPassword,
+
+		metricsUsername: cfg.Metrics.Username,
+		metricsPassword: cfg.Metrics.Password,
+
+		db: database.New(cfg.Database, logger),
+		hub: NewHub(HubConfig{
+			SendBufferSize:      cfg.WebSocket.SendBufferSize,
+			OverflowPolicy:      OverflowPolicy(cfg.WebSocket.OverflowPolicy),
+			MaxConnections:      cfg.WebSocket.MaxConnections,
+			MaxConnectionsPerIP: cfg.WebSocket.MaxConnectionsPerIP,
+		}),
+
+		wsPingInterval: cfg.WebSocket.PingInterval,
+		wsPongTimeout:  cfg.WebSocket.PongTimeout,
+		wsWriteTimeout: cfg.WebSocket.WriteTimeout,
+		wsMaxLifetime:  cfg.WebSocket.MaxLifetime,
+
+		wsShutdownGracePeriod: cfg.WebSocket.ShutdownGracePeriod,
+		wsMaxMessageBytes:     int64(cmp.Or(cfg.WebSocket.MaxMessageBytes, defaultWSMaxMessageBytes)),
+
+		sseKeepaliveInterval: cfg.Server.SSEKeepaliveInterval,
+		longPollMaxTimeout:   cfg.Server.LongPollMaxTimeout,
+		defaultTimezone:      cmp.Or(cfg.Database.DefaultTimezone, time.UTC),
+		returnTimestampsIn:   cmp.Or(cfg.Server.ReturnTimestampsIn, time.UTC),
+		requestTimeout:       cfg.Server.RequestTimeout,
+		healthRequestTimeout: cfg.Server.HealthRequestTimeout,
+		ingestRequestTimeout: cfg.Server.IngestRequestTimeout,
+
+		maxEventsLimit: cmp.Or(cfg.Server.MaxEventsLimit, defaultMaxEventsLimit),
+		allowPurge:     cfg.Server.AllowPurge,
+		debugEndpoints: cfg.Server.DebugEndpoints,
+		apiV1Sunset:    cfg.Server.APIV1Sunset,
+		replayWindow:   cfg.Server.ReplayWindow,
+		eventRates:     newEventRateCache(),
+		trustedProxies: cfg.Server.TrustedProxies,
+		trustXRealIP:   cfg.Server.TrustXRealIP,
+
+		uniqueKeyReturnsExisting: cfg.Server.UniqueKeyReturnsExisting,
+
+		features: cfg.Features,
+		ginMode:  cmp.Or(cfg.Server.GinMode, gin.ReleaseMode),
+
+		maintenance: newMaintenanceMode(cfg.Maintenance),
+
+		logger:       logger,
+		logLevel:     logLevel,
+		cfg:          cfg,
+		accessLogger: accessLogger,
+	}
+
+	if cfg.Server.DebugLogBodies {
+		NewServer.bodyLogger = newBodyLogger()
+	}
+
+	NewServer.metrics = newServerMetrics(NewServer.hub)
+
+	tracerProvider, shutdownTracing := newTracerProvider(cfg.Tracing)
+	NewServer.tracer = tracerProvider.Tracer(tracerName)
+
+	// Events are broadcast and counted once the transaction that created them
+	// commits, so subscribers never see an event that was rolled back.
+	if NewServer.db != nil {
+		NewServer.db = database.Observe(NewServer.db, NewServer.metrics)
+		NewServer.db = database.Observe(NewServer.db, tracingObserver{tracer: NewServer.tracer})
+		NewServer.db.AfterCommit(NewServer.hub.Broadcast)
+		NewServer.db.AfterCommit(NewServer.metrics.countIngested)
+	}
+
+	NewServer.webhooks = NewWebhookDispatcher(NewServer.db, WebhookConfig{
+		Workers:        cfg.Webhooks.Workers,
+		Timeout:        cfg.Webhooks.Timeout,
+		MaxAttempts:    cfg.Webhooks.MaxAttempts,
+		InitialBackoff: cfg.Webhooks.InitialBackoff,
+		MaxBackoff:     cfg.Webhooks.MaxBackoff,
+		MaxFailures:    cfg.Webhooks.MaxFailures,
+	})
+
+	NewServer.retention = NewRetentionCleaner(NewServer.db, retentionPolicy(cfg.Retention))
+	NewServer.eventCounts = newEventCountPoller(NewServer.db, NewServer.metrics, cfg.Metrics.DBPollInterval)
 
-		db: database.New(),
+	NewServer.jobs = NewJobQueue(NewServer.db, NewServer.publish, JobQueueConfig{
+		Workers:   cfg.Jobs.Workers,
+		QueueSize: cfg.Jobs.QueueSize,
+	})
+
+	if cfg.NATS.URL != "" {
+		publisher, err := NewNATSPublisher(cfg.NATS.URL, cfg.NATS.SubjectPrefix, cfg.NATS.BufferSize)
+		if err != nil {
+			fmt.Println("Error connecting to NATS:", err)
+		}
+
+		NewServer.nats = publisher
+	}
+
+	if len(cfg.Kafka.Brokers) > 0 {
+		producer, err := NewKafkaProducer(KafkaConfig{
+			Brokers:      cfg.Kafka.Brokers,
+			Topic:        cfg.Kafka.Topic,
+			BufferSize:   cfg.Kafka.BufferSize,
+			DeliveryMode: KafkaDeliveryMode(cfg.Kafka.DeliveryMode),
+			AckTimeout:   cfg.Kafka.AckTimeout,
+		})
+		if err != nil {
+			fmt.Println("Error creating Kafka producer:", err)
+		} else {
+			NewServer.kafka = producer
+		}
+	}
+
+	if cfg.Forward.URL != "" {
+		NewServer.forwarder = NewEventForwarder(ForwardConfig{
+			URL:          cfg.Forward.URL,
+			Username:     cfg.Forward.Username,
+			Password:     cfg.Forward.Password,
+			Workers:      cfg.Forward.Workers,
+			BufferSize:   cfg.Forward.BufferSize,
+			RetryBackoff: cfg.Forward.RetryBackoff,
+		})
+	}
+
+	if cfg.Database.Outbox {
+		NewServer.outbox = NewOutboxRelay(NewServer.db, NewServer.webhooks, NewServer.nats, NewServer.kafka, OutboxConfig{
+			PollInterval:   cfg.Outbox.PollInterval,
+			BatchSize:      cfg.Outbox.BatchSize,
+			Timeout:        cfg.Outbox.Timeout,
+			MaxAttempts:    cfg.Outbox.MaxAttempts,
+			InitialBackoff: cfg.Outbox.InitialBackoff,
+			MaxBackoff:     cfg.Outbox.MaxBackoff,
+		})
+	}
+
+	if cfg.Redis.URL != "" {
+		fanout, err := NewRedisFanout(cfg.Redis.URL, cfg.Redis.Channel, NewServer.hub)
+		if err != nil {
+			fmt.Println("Error configuring Redis:", err)
+		}
+
+		NewServer.redis = fanout
 	}
 
+	// Rate limiting is disabled unless RATE_LIMIT_RPS is set to a positive
+	// number, though the limiter is always created so reloading the
+	// configuration can turn it on.
+	NewServer.rateLimiter = newIPRateLimiter(cfg.RateLimit.RPS, max(1, cfg.RateLimit.Burst))
+
+	NewServer.registerReadinessChecks()
+
+	// The database may not be reachable yet, e.g. when it's started at the same
+	// time as the server, so it's pinged in the background while requests are
+	// rejected. Seeding needs the tables, so it waits for the warm-up.
+	warmUpResult := make(chan error, 1)
+	go func() {
+		err := NewServer.warmUp(warmUpConfig{
+			maxAttempts: cmp.Or(cfg.WarmUp.Attempts, defaultWarmUpAttempts),
+			interval:    cfg.WarmUp.Interval,
+			maxInterval: cfg.WarmUp.MaxInterval,
+			timeout:     cfg.WarmUp.Timeout,
+		})
+
+		if err == nil {
+			if cfg.Seed.Enabled {
+				seedDatabase(NewServer.db, cfg.Seed.File)
+			}
+
+			NewServer.ready.Store(true)
+
+			// Jobs interrupted by the last shutdown and events left in the
+			// outbox are picked up, and expired events deleted, once the
+			// tables are known to exist.
+			NewServer.outbox.Start()
+			NewServer.retention.Start()
+			NewServer.eventCounts.Start()
+
+			go func() {
+				if err := NewServer.jobs.Resume(); err != nil {
+					fmt.Println("Error resuming ingestion jobs:", err)
+				}
+			}()
+		}
+
+		warmUpResult <- err
+	}()
+
 	// Declare Server config
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Addr:              cmp.Or(cfg.Server.ListenAddr, fmt.Sprintf(":%d", NewServer.port)),
+		Handler:           NewServer.RegisterRoutes(),
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+
+	if cfg.Server.HTTP2Enabled {
+		h2s := &http2.Server{
+			MaxConcurrentStreams: cmp.Or(cfg.Server.HTTP2MaxConcurrentStreams, defaultHTTP2MaxConcurrentStreams),
+		}
+
+		// Configure HTTP/2 for TLS connections (negotiated via ALPN) and wrap the
+		// handler with h2c so cleartext HTTP/2 works as well, which is what most
+		// service meshes use between the sidecar and the application.
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			fmt.Println("Error configuring HTTP/2:", err)
+		}
+
+		server.Handler = h2c.NewHandler(server.Handler, h2s)
+	}
+
+	return &HTTPServer{
+		Server:       server,
+		hub:          NewServer.hub,
+		webhooks:     NewServer.webhooks,
+		jobs:         NewServer.jobs,
+		nats:         NewServer.nats,
+		kafka:        NewServer.kafka,
+		forwarder:    NewServer.forwarder,
+		redis:        NewServer.redis,
+		outbox:       NewServer.outbox,
+		retention:    NewServer.retention,
+		eventCounts:  NewServer.eventCounts,
+		grpc:         newGRPCServer(NewServer),
+		grpcPort:     cfg.Server.GRPCPort,
+		socketMode:   cmp.Or(cfg.Server.SocketMode, defaultSocketMode),
+		warmUpResult: warmUpResult,
+		db:           NewServer.db,
+		connection:   database.DescribeConnection(cfg.Database),
+		shuttingDown: &NewServer.shuttingDown,
+
+		backgroundJobs:  &NewServer.backgroundJobs,
+		accessLog:       accessLog,
+		shutdownTracing: shutdownTracing,
 	}
+}
+
+// Seeds the database from the given fixtures file if the Events table is
+// empty, printing how many events were seeded or why seeding failed.
+func seedDatabase(db database.TursoDB, path string) {
+	if db == nil || path == "" {
+		fmt.Println("[seedDatabase()]: SEED_ENABLED is set but SEED_FILE is empty or the database is unavailable, skipping")
+		return
+	}
+
+	seeded, err := database.SeedFromFile(db, path)
+	if err != nil {
+		fmt.Println("Error seeding database:", err)
+		return
+	}
+
+	if seeded == 0 {
+		fmt.Println("[seedDatabase()]: Events table is not empty, skipping seeding")
+		return
+	}
+
+	fmt.Printf("[seedDatabase()]: Seeded %d event(s) from %s\n", seeded, path)
+}
+
+// Queues newly created events for delivery to webhook subscriptions, mirrors
+// them onto NATS and Kafka, and shares them with other instances through
+// Redis. When the outbox is enabled the events are already in it, so the
+// outbox relay is woken up to deliver them to webhook subscriptions, NATS, and
+// Kafka instead.
+//
+// WebSocket and streaming clients aren't sent the events from here but by the
+// database's commit hook (see NewServer), so they only ever see events that
+// were committed.
+func (s *Server) publish(events ...database.EventEntry) {
+	if s.outbox != nil {
+		s.outbox.Notify()
+	} else {
+		s.webhooks.Enqueue(events...)
+		s.nats.Enqueue(events...)
+		s.kafka.Enqueue(events...)
+	}
+
+	s.redis.Enqueue(events...)
+}
+
+// Publishes newly created events the same as publish, except that when Kafka is
+// in blocking mode it waits until Kafka acknowledges them. Returns the error if
+// they weren't acknowledged, in which case they've still been published
+// everywhere else. With the outbox enabled the relay delivers them to Kafka
+// instead, so this doesn't wait.
+func (s *Server) publishAcked(ctx context.Context, events ...database.EventEntry) error {
+	if !s.kafka.Blocking() || s.outbox != nil {
+		s.publish(events...)
+		return nil
+	}
+
+	s.webhooks.Enqueue(events...)
+	s.nats.Enqueue(events...)
+	s.redis.Enqueue(events...)
 
-	return server
+	return s.kafka.Produce(ctx, events...)
 }
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Handles requests to the GET /events/stream endpoint, which streams newly
+// created events to the client as Server-Sent Events using the same Hub that
+// feeds WebSocket clients. Each event is sent as a frame whose `id:` is the
+// event ID, `event:` is the event type, and `data:` is the event JSON, so
+// clients that reconnect with a Last-Event-ID header are first sent the events
+// they missed. Accepts the same ?types= filter as the WebSocket endpoint. A
+// comment is sent every SSE_KEEPALIVE_INTERVAL so proxies don't close an idle
+// stream.
+//
+// A client that falls too far behind is sent a `gap` event with the number of
+// events it skipped, or has its stream ended under the disconnect overflow
+// policy. The stream also ends when the server shuts down.
+func (s *Server) streamEventsHandler(c *gin.Context) {
+	sub, replay, err := s.resumeSubscription(c.GetHeader("Last-Event-ID"), parseEventTypes(c.QueryArray("types")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+	defer s.hub.unsubscribe(sub)
+
+	// The stream is expected to stay open far longer than the server's write
+	// timeout allows for regular requests.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for _, event := range replay {
+		if err := writeSSEEvent(c.Writer, event); err != nil {
+			return
+		}
+	}
+
+	replayed := replayedIDs(replay)
+
+	keepalive := time.NewTicker(s.sseKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.overflowed:
+			return
+		case <-s.hub.closing:
+			return
+		case event := <-sub.events:
+			if _, ok := replayed[event.ID]; ok {
+				delete(replayed, event.ID)
+				continue
+			}
+
+			if skipped := sub.takeSkipped(); skipped > 0 {
+				if _, err := fmt.Fprintf(c.Writer, "event: gap\ndata: {\"skipped\":%d}\n\n", skipped); err != nil {
+					return
+				}
+			}
+
+			if err := writeSSEEvent(c.Writer, event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Strips line breaks from values written to single-line SSE fields.
+var sseFieldReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// Writes a single event as a Server-Sent Events frame and flushes it to the
+// client immediately.
+func writeSSEEvent(w gin.ResponseWriter, event database.EventEntry) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// A line break in the type would end the field early and let the rest of
+	// it be parsed as extra fields.
+	eventName := sseFieldReplacer.Replace(string(event.Type))
+
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, eventName, data); err != nil {
+		return err
+	}
+
+	w.Flush()
+	return nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handles requests to the GET /events/summary endpoint, which returns an
+// overview of every event in one call: the timestamps of the earliest and
+// latest events, which are null when there aren't any events, the number of
+// events, and the number of distinct event types.
+func (s *Server) eventSummaryHandler(c *gin.Context) {
+	summary, err := s.dbFor(c).GetEventSummary()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// The most event types GET /events/top-types returns. Asking for more is a
+// 400.
+const maxTopEventTypes = 100
+
+// Handles requests to the GET /events/top-types endpoint, which returns the ?n=
+// event types with the most events in the ?since= window, most events first,
+// e.g. to show which types are most active. The n defaults to 10 and must be
+// between 1 and 100. The window defaults to 24h and is a duration such as 90m
+// or 24h, or a number of days such as 7d. A 400 is returned for any other
+// value.
+func (s *Server) topEventTypesHandler(c *gin.Context) {
+	n, err := strconv.Atoi(c.DefaultQuery("n", "10"))
+	if err != nil || n <= 0 || n > maxTopEventTypes {
+		c.JSON(http.StatusBadRequest, errorResponse(c, fmt.Sprintf("n must be an integer between 1 and %d", maxTopEventTypes)))
+		return
+	}
+
+	window, err := parseWindow("since", c.DefaultQuery("since", "24h"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	counts, err := s.dbFor(c).GetTopEventTypes(n, time.Now().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// Parses the named query parameter as a positive window of time, either a
+// duration such as 24h or a number of days such as 7d, which
+// time.ParseDuration doesn't support.
+func parseWindow(param, raw string) (time.Duration, error) {
+	var window time.Duration
+
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a duration such as 24h or a number of days such as 7d, got %q", param, raw)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a duration such as 24h or a number of days such as 7d, got %q", param, raw)
+		}
+		window = parsed
+	}
+
+	if window <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", param, raw)
+	}
+
+	return window, nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A response writer that holds on to the response until the handler has
+// finished, so it can be replaced with a 504 if the handler ran out of time.
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	body   bytes.Buffer
+	status int
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.WriteString(s)
+}
+
+func (w *timeoutWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *timeoutWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	if w.status == 0 {
+		return -1
+	}
+
+	return w.body.Len()
+}
+
+// Buffered responses can't be flushed early.
+func (w *timeoutWriter) Flush() {}
+
+// A middleware that gives the request's context a deadline d from now, so one
+// slow route can't tie up the server. If the handler hasn't finished by the
+// deadline, whatever it responded with is discarded and a 504 is returned
+// instead. Handlers should watch c.Request.Context() to stop early, since the
+// 504 is only sent once they return. Responses are buffered, so it mustn't be
+// used on streaming routes. A d of 0 or less disables the timeout.
+func timeoutMiddleware(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+
+		// The writer is restored even if the handler panics, so the buffered
+		// response is dropped and the recovery middleware writes its own.
+		writer := c.Writer
+		buffered := &timeoutWriter{ResponseWriter: writer}
+		c.Writer = buffered
+		defer func() { c.Writer = writer }()
+
+		c.Next()
+
+		c.Writer = writer
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				c.AbortWithStatusJSON(http.StatusGatewayTimeout, errorResponse(c, "request timed out"))
+				return
+			}
+		default:
+		}
+
+		if !buffered.Written() {
+			return
+		}
+
+		writer.WriteHeader(buffered.status)
+		writer.WriteHeaderNow()
+		writer.Write(buffered.body.Bytes())
+	}
+}
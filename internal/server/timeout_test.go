@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Serves a request to a router with a single GET /test route behind
+// timeoutMiddleware(d), returning the response and how long it took.
+func serveWithTimeout(t *testing.T, d time.Duration, handler gin.HandlerFunc) (*httptest.ResponseRecorder, time.Duration) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/test", timeoutMiddleware(d), handler)
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+
+	return rec, time.Since(start)
+}
+
+func TestTimeoutMiddlewarePassesFastResponsesThrough(t *testing.T) {
+	rec, _ := serveWithTimeout(t, time.Second, func(c *gin.Context) {
+		c.Header("X-Test", "yes")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"ok":true}` || rec.Header().Get("X-Test") != "yes" {
+		t.Fatalf("unexpected response: %d %s %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	rec, _ = serveWithTimeout(t, time.Second, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutMiddlewareReturns504AtTheDeadline(t *testing.T) {
+	rec, elapsed := serveWithTimeout(t, 50*time.Millisecond, func(c *gin.Context) {
+		select {
+		case <-time.After(5 * time.Second):
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		case <-c.Request.Context().Done():
+		}
+	})
+
+	if rec.Code != http.StatusGatewayTimeout || rec.Body.String() != `{"error":"request timed out"}` {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected the 504 at the deadline, got it after %v", elapsed)
+	}
+}
+
+func TestTimeoutMiddlewareDiscardsLateResponses(t *testing.T) {
+	rec, _ := serveWithTimeout(t, 20*time.Millisecond, func(c *gin.Context) {
+		time.Sleep(60 * time.Millisecond)
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	if rec.Code != http.StatusGatewayTimeout || rec.Body.String() != `{"error":"request timed out"}` {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutMiddlewareDisabled(t *testing.T) {
+	rec, _ := serveWithTimeout(t, 0, func(c *gin.Context) {
+		if _, ok := c.Request.Context().Deadline(); ok {
+			t.Error("expected no deadline when the timeout is disabled")
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"ok":true}` {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// A database whose queries for the latest events never finish on their own,
+// only returning once their context is cancelled.
+type slowDB struct {
+	database.TursoDB
+
+	ctx context.Context
+
+	// Receives the context's error when a query is cancelled.
+	cancelled chan error
+}
+
+func (db *slowDB) WithContext(ctx context.Context) database.TursoDB {
+	return &slowDB{ctx: ctx, cancelled: db.cancelled}
+}
+
+func (db *slowDB) GetLatestEvents(limit int) ([]database.EventEntry, error) {
+	<-db.ctx.Done()
+	db.cancelled <- db.ctx.Err()
+
+	return nil, db.ctx.Err()
+}
+
+func TestTimeoutMiddlewareCancelsDatabaseQueries(t *testing.T) {
+	db := &slowDB{ctx: context.Background(), cancelled: make(chan error, 1)}
+	s := &Server{db: db, maxEventsLimit: 10}
+
+	rec, elapsed := serveWithTimeout(t, 50*time.Millisecond, s.getEventsHandler)
+
+	if rec.Code != http.StatusGatewayTimeout || rec.Body.String() != `{"error":"request timed out"}` {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if elapsed > time.Second {
+		t.Fatalf("expected the query to stop at the deadline, got the 504 after %v", elapsed)
+	}
+
+	select {
+	case err := <-db.cancelled:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected the query to be cancelled by the deadline, got %v", err)
+		}
+	default:
+		t.Fatal("expected the query to be cancelled")
+	}
+}
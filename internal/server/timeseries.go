@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The longest time range that can be requested with minute buckets, which
+// would otherwise return an enormous number of buckets.
+const maxMinuteBucketRange = 90 * 24 * time.Hour
+
+// The time range used when the client doesn't give a ?from=.
+const defaultTimeSeriesRange = 24 * time.Hour
+
+// Handles requests to the GET /events/timeseries endpoint, which returns the
+// number of events per ?bucket= (minute, hour, or day) between the ?from= and
+// ?to= RFC 3339 timestamps. The range defaults to the last 24 hours, and can't
+// be longer than 90 days for minute buckets. Buckets without events are left
+// out.
+func (s *Server) timeSeriesHandler(c *gin.Context) {
+	bucket := c.DefaultQuery("bucket", "hour")
+
+	// Timestamps are rounded to the nearest millisecond when they're compared,
+	// so the default end is a millisecond from now to include events created
+	// within the current one.
+	end := time.Now().Add(time.Millisecond)
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(c, "to must be an RFC 3339 timestamp"))
+			return
+		}
+
+		end = parsed
+	}
+
+	start := end.Add(-defaultTimeSeriesRange)
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(c, "from must be an RFC 3339 timestamp"))
+			return
+		}
+
+		start = parsed
+	}
+
+	if !end.After(start) {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "to must be after from"))
+		return
+	}
+
+	if bucket == "minute" && end.Sub(start) > maxMinuteBucketRange {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "the time range can't be longer than 90 days for minute buckets"))
+		return
+	}
+
+	buckets, err := s.dbFor(c).GetEventTimeSeries(start, end, bucket)
+	if errors.Is(err, database.ErrInvalidBucket) {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, buckets)
+}
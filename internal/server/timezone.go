@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The header clients send to have event timestamps returned in a timezone from
+// the IANA database, e.g. America/Chicago, instead of RETURN_TIMESTAMPS_IN.
+const timezoneHeader = "X-Timezone"
+
+// The gin context key the timezone timestamps are returned in is stored under.
+const timezoneContextKey = "timezone"
+
+// A middleware that picks the timezone event timestamps are returned in, which
+// is the one the client sent in the X-Timezone header or otherwise
+// RETURN_TIMESTAMPS_IN. Responds with a 400 if the header isn't a timezone.
+func (s *Server) timezoneMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc := s.returnTimestampsIn
+
+		if name := c.GetHeader(timezoneHeader); name != "" {
+			requested, err := time.LoadLocation(name)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(c, timezoneHeader+" must be a timezone, e.g. America/Chicago, got "+name))
+				return
+			}
+
+			loc = requested
+		}
+
+		c.Set(timezoneContextKey, loc)
+		c.Next()
+	}
+}
+
+// Returns the timezone event timestamps are returned in, set by
+// timezoneMiddleware, or UTC if it isn't set.
+func timezoneOf(c *gin.Context) *time.Location {
+	if loc, ok := c.Get(timezoneContextKey); ok && loc != nil {
+		return loc.(*time.Location)
+	}
+
+	return time.UTC
+}
+
+// Returns the event with its timestamp in the timezone the client asked for.
+func localizeEvent(c *gin.Context, event database.EventEntry) database.EventEntry {
+	event.Timestamp = database.FormatTimestamp(event.Timestamp, timezoneOf(c))
+	return event
+}
+
+// Returns copies of the events with their timestamps in the timezone the client
+// asked for. The events themselves aren't changed, since they may be shared,
+// e.g. with other subscribers.
+func localizeEvents(c *gin.Context, events []database.EventEntry) []database.EventEntry {
+	if events == nil || timezoneOf(c) == time.UTC {
+		return events
+	}
+
+	localized := make([]database.EventEntry, len(events))
+	for i, event := range events {
+		localized[i] = localizeEvent(c, event)
+	}
+
+	return localized
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name the server's spans are created under.
+const tracerName = "github.com/4lch4/shion-api/internal/server"
+
+// The service name traces are exported with, unless OTEL_SERVICE_NAME is set.
+const defaultServiceName = "shion-api"
+
+// Returns the tracer provider the server's spans are created with, and a
+// function that flushes and stops it. When an OTLP endpoint is configured,
+// spans are exported to it in batches and the provider is also made the global
+// one. Otherwise the global provider is used as is, which doesn't record
+// anything unless something else, e.g. a test, has replaced it.
+func newTracerProvider(cfg config.Tracing) (trace.TracerProvider, func(context.Context) error) {
+	noShutdown := func(context.Context) error { return nil }
+
+	if cfg.Endpoint == "" {
+		return otel.GetTracerProvider(), noShutdown
+	}
+
+	// The exporter reads the endpoint, headers, and the rest of its settings
+	// from the OTEL_EXPORTER_OTLP_* variables.
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		fmt.Println("[newTracerProvider()]: Error creating the OTLP exporter, traces won't be exported:", err)
+		return otel.GetTracerProvider(), noShutdown
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName(defaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		fmt.Println("[newTracerProvider()]: Error detecting the trace resource:", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider, provider.Shutdown
+}
+
+// The propagator incoming trace context is read with, which understands the
+// W3C traceparent and baggage headers.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// A middleware that creates a span for every request, named after its method
+// and the route it matched, e.g. POST /api/v1/event, continuing the trace of
+// the traceparent header the client sent, if any. The span is added to the
+// request's context so the database operations the handler runs become its
+// children, and records the request's ID so it can be matched to its log lines.
+func tracingMiddleware(tracer trace.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		name := c.Request.Method
+		attributes := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.URLPath(c.Request.URL.Path),
+			attribute.String("request_id", requestIDOf(c)),
+		}
+
+		if route := c.FullPath(); route != "" {
+			name += " " + route
+			attributes = append(attributes, semconv.HTTPRoute(route))
+		}
+
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// Returns the ID of the trace the context's span belongs to, or an empty
+// string if it doesn't have one.
+func traceIDOf(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
+
+// Creates a span for every database operation, as a child of the span of the
+// request that ran it, if any. Implements database.Observer.
+type tracingObserver struct {
+	tracer trace.Tracer
+}
+
+// Starts the operation's span, which records the number of rows it returned or
+// wrote, and its error if it failed.
+func (o tracingObserver) StartOperation(ctx context.Context, operation string) (context.Context, database.OperationDone) {
+	ctx, span := o.tracer.Start(ctx, "db."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBOperationName(operation)),
+	)
+
+	return ctx, func(elapsed time.Duration, rows int, err error) {
+		span.SetAttributes(attribute.Int("db.rows", rows))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
+
+// Records the size of a batch of events on the span of the CreateEvents call.
+func (o tracingObserver) ObserveBatchSize(ctx context.Context, size int) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("db.batch_size", size))
+}
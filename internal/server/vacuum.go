@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The type of the background jobs that vacuum the database.
+const vacuumJobType = "vacuum"
+
+// Handles requests to the POST /admin/vacuum endpoint, which compacts a SQLite
+// database in the background. The vacuum is recorded as a background job and
+// a 202 is returned straight away with its ID, which GET /admin/jobs/:id
+// reports the progress of, including the database's size in bytes before and
+// after once it's finished. Other databases aren't touched and the result has
+// skipped set to true.
+//
+// With ?async=false the vacuum runs before the response is sent instead, and
+// its result is returned with a 200. Requires the admin credentials, otherwise
+// a 403 is returned. Only one vacuum runs at a time and a 409 is returned while
+// one is in progress.
+//
+// Writes wait while the database is being compacted and fail once they've
+// waited longer than DB_BUSY_TIMEOUT_MS, so it's best run while traffic is low.
+func (s *Server) vacuumHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "vacuuming the database requires admin credentials"))
+		return
+	}
+
+	async := true
+	if value := c.Query("async"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(c, "async must be true or false"))
+			return
+		}
+
+		async = parsed
+	}
+
+	if !s.vacuuming.TryLock() {
+		c.JSON(http.StatusConflict, errorResponse(c, "the database is already being vacuumed"))
+		return
+	}
+
+	db := s.dbFor(c)
+
+	if !async {
+		defer s.vacuuming.Unlock()
+
+		// Compacting a large database can take far longer than the server's
+		// write timeout allows for regular requests.
+		http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+		result, err := db.Vacuum()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	job, err := db.StartBackgroundJob(vacuumJobType)
+	if err != nil {
+		s.vacuuming.Unlock()
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	s.backgroundJobs.Add(1)
+	go func() {
+		defer s.backgroundJobs.Done()
+		defer s.vacuuming.Unlock()
+
+		result, err := db.Vacuum()
+		if err := db.FinishBackgroundJob(job.ID, result, err); err != nil {
+			fmt.Println("[vacuumHandler]: Error recording the result of vacuum job", job.ID+":", err)
+		}
+	}()
+
+	c.Header("Location", basePathOf(c)+"/admin/jobs/"+url.PathEscape(job.ID))
+	c.JSON(http.StatusAccepted, gin.H{"status": "vacuum started", "job_id": job.ID})
+}
+
+// Handles requests to the GET /admin/jobs/:id endpoint, which returns a
+// background job started by one of the admin endpoints, e.g. POST
+// /admin/vacuum, with its status and, once it's finished, its result. Requires
+// the admin credentials, otherwise a 403 is returned, and a 404 is returned if
+// the job doesn't exist.
+func (s *Server) getBackgroundJobHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "background jobs require admin credentials"))
+		return
+	}
+
+	job, err := s.dbFor(c).GetBackgroundJob(c.Param("id"))
+	if errors.Is(err, database.ErrBackgroundJobNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Waits for the background jobs started by the admin endpoints to finish.
+// Returns the context's error if it's done first.
+func (s *HTTPServer) waitForBackgroundJobs(ctx context.Context) error {
+	if s.backgroundJobs == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.backgroundJobs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The most distinct values GET /events/values returns, however large a ?max=
+// the client asks for.
+const maxDistinctValuesLimit = 1000
+
+// The response of the GET /events/values endpoint.
+type DistinctValuesResponse struct {
+	Type  database.EventType `json:"type"`
+	Field string             `json:"field"`
+
+	// The distinct values, most common first.
+	Values []database.DistinctValue `json:"values"`
+
+	// Whether there were more distinct values than were returned.
+	Truncated bool `json:"truncated"`
+}
+
+// Handles requests to the GET /events/values endpoint, which returns the
+// distinct values of the ?field= of the events of the ?type=, with the number
+// of events having each, e.g. to build a filter UI. The only field is data,
+// the default. The most common values come first, and at most ?max= are
+// returned, 100 by default and never more than 1000, with truncated set when
+// there were more. A 400 is returned if the type is missing or the field isn't
+// supported.
+func (s *Server) distinctValuesHandler(c *gin.Context) {
+	eventType := database.EventType(c.Query("type"))
+	if eventType == "" {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "type is required"))
+		return
+	}
+
+	field := c.DefaultQuery("field", "data")
+
+	max, err := strconv.Atoi(c.DefaultQuery("max", "100"))
+	if err != nil || max <= 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "max must be a positive integer"))
+		return
+	}
+
+	values, truncated, err := s.dbFor(c).GetDistinctValues(eventType, field, min(max, maxDistinctValuesLimit))
+	if errors.Is(err, database.ErrInvalidValueField) {
+		c.JSON(http.StatusBadRequest, errorResponse(c, "field must be one of "+strings.Join(database.EventValueFields, ", ")))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, DistinctValuesResponse{
+		Type:      eventType,
+		Field:     field,
+		Values:    values,
+		Truncated: truncated,
+	})
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// A version of the API's request and response shapes.
+type apiVersion string
+
+const (
+	// The original API, served by default.
+	apiV1 apiVersion = "v1"
+
+	// Wraps the events GET /events, POST /event, and POST /events return in
+	// an EventResponseV2 envelope with the total number of events.
+	apiV2 apiVersion = "v2"
+)
+
+// The path every route is registered under a second time to serve v2 of the
+// API, regardless of the Accept header.
+const apiV2BasePath = "/api/v2"
+
+// The gin context key the API version the request asked for is stored under.
+const versionContextKey = "apiVersion"
+
+// Matches the vendor media types clients can ask for a version of the API
+// with, e.g. application/vnd.shion.v2+json.
+var versionMediaType = regexp.MustCompile(`^application/vnd\.shion\.(v[0-9]+)\+json$`)
+
+// The response body returned by v2 of GET /events, POST /event, and POST
+// /events, which adds the total number of events to EventResponse.
+type EventResponseV2 struct {
+	// An optional message to be sent back to the client.
+	Message string `json:"message"`
+
+	// The event entry/entries that were created/queried/etc.
+	EventEntry []database.EventEntry `json:"event_entry"`
+
+	// The server's DEFAULT_TIMEZONE, which timestamps sent without a UTC
+	// offset are taken to be in.
+	Timezone string `json:"timezone"`
+
+	// The total number of events, which for GET /events is every stored event
+	// rather than only the ones returned, and otherwise is the number created.
+	Total int64 `json:"total"`
+
+	// Describes which page of the events was returned, or omitted when the
+	// response isn't a page of a larger list.
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Describes the page of events a list endpoint returned.
+type Pagination struct {
+	// The most events that could be returned.
+	Max int `json:"max"`
+
+	// The number of events that were returned.
+	Returned int `json:"returned"`
+
+	// Whether there are more events than were returned.
+	HasMore bool `json:"has_more"`
+}
+
+// A middleware that records which version of the API the request asked for.
+// Routes under /api/v2 always use v2. Otherwise the version is taken from an
+// Accept header such as application/vnd.shion.v2+json, and a missing or
+// unsupported version falls back to v1 so existing clients keep working.
+// Handlers check the version with versionOf.
+func versionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version := apiV1
+		if strings.HasPrefix(c.FullPath(), apiV2BasePath) {
+			version = apiV2
+		} else if accepted, ok := acceptedVersion(c.GetHeader("Accept")); ok {
+			version = accepted
+		}
+
+		c.Set(versionContextKey, version)
+		c.Next()
+	}
+}
+
+// Returns the first supported API version named by a vendor media type in the
+// given Accept header, or false if there isn't one.
+func acceptedVersion(accept string) (apiVersion, bool) {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		match := versionMediaType.FindStringSubmatch(mediaType)
+		if match == nil {
+			continue
+		}
+
+		switch version := apiVersion(match[1]); version {
+		case apiV1, apiV2:
+			return version, true
+		}
+	}
+
+	return "", false
+}
+
+// Returns the version of the API the request is served with, which is v1
+// unless the client asked for another (see versionMiddleware). Requests that
+// haven't reached versionMiddleware yet, e.g. ones a router-wide middleware
+// rejects, are only v2 under /api/v2.
+func versionOf(c *gin.Context) apiVersion {
+	if version, ok := c.Get(versionContextKey); ok {
+		return version.(apiVersion)
+	}
+
+	if strings.HasPrefix(c.FullPath(), apiV2BasePath) {
+		return apiV2
+	}
+
+	return apiV1
+}
+
+// The Warning header v1 responses carry now that v2 is live.
+const v1DeprecationWarning = `299 - "v1 of the API is deprecated, use /api/v2 instead"`
+
+// A middleware that marks v1 responses as deprecated with the Deprecation and
+// Warning headers and a Link header pointing at /api/v2, along with a Sunset
+// header saying when v1 stops being served if API_V1_SUNSET is set. Requests
+// served with v2, whether under /api/v2 or through the Accept header, are left
+// alone. Must come after versionMiddleware.
+func v1DeprecationMiddleware(sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if versionOf(c) == apiV1 {
+			c.Header("Deprecation", "true")
+			c.Header("Warning", v1DeprecationWarning)
+			c.Header("Link", "<"+apiV2BasePath+`>; rel="successor-version"`)
+			if !sunset.IsZero() {
+				c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// Describes a version of the API in the GET /api/versions response.
+type APIVersionInfo struct {
+	// The version's name, e.g. v2.
+	Version string `json:"version"`
+
+	// Either current or deprecated.
+	Status string `json:"status"`
+
+	// The path the version's routes are served under.
+	BasePath string `json:"base_path"`
+
+	// When the version stops being served, as an HTTP date, if it's been
+	// decided.
+	Sunset string `json:"sunset,omitempty"`
+}
+
+// The response body returned by the GET /api/versions endpoint.
+type APIVersionsResponse struct {
+	// The version new clients should use.
+	Current string `json:"current"`
+
+	// Every version the server supports, oldest first.
+	Versions []APIVersionInfo `json:"versions"`
+}
+
+// Handles requests to the GET /api/versions endpoint, which lists the
+// versions of the API the server supports and whether each is current or
+// deprecated, so clients can discover v2 without credentials.
+func (s *Server) apiVersionsHandler(c *gin.Context) {
+	v1 := APIVersionInfo{Version: string(apiV1), Status: "deprecated", BasePath: apiBasePath}
+	if !s.apiV1Sunset.IsZero() {
+		v1.Sunset = s.apiV1Sunset.UTC().Format(http.TimeFormat)
+	}
+
+	c.JSON(http.StatusOK, APIVersionsResponse{
+		Current: string(apiV2),
+		Versions: []APIVersionInfo{
+			v1,
+			{Version: string(apiV2), Status: "current", BasePath: apiV2BasePath},
+		},
+	})
+}
+
+// Returns the path the request's route was registered under, i.e. /api/v1 or
+// /api/v2, so links in responses point at the same version.
+func basePathOf(c *gin.Context) string {
+	if strings.HasPrefix(c.FullPath(), apiV2BasePath) {
+		return apiV2BasePath
+	}
+
+	return apiBasePath
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Configures how long the server waits for the database to become reachable
+// at startup.
+type warmUpConfig struct {
+	// The most times the database is pinged.
+	maxAttempts int
+
+	// How long to wait after the first failed ping, which doubles after each
+	// attempt up to maxInterval.
+	interval time.Duration
+
+	// The longest wait between pings.
+	maxInterval time.Duration
+
+	// How long to keep trying before giving up, regardless of attempts left.
+	timeout time.Duration
+}
+
+// The default number of times the database is pinged at startup.
+const defaultWarmUpAttempts = 30
+
+// Pings the database until it's reachable, backing off between attempts, then
+// creates its tables. Returns an error if the database still isn't reachable
+// once the attempts or timeout run out.
+func (s *Server) warmUp(config warmUpConfig) error {
+	if s.db == nil {
+		return errors.New("the database isn't configured")
+	}
+
+	deadline := time.Now().Add(config.timeout)
+	interval := config.interval
+
+	for attempt := 1; ; attempt++ {
+		err := s.db.Ping()
+		if err == nil {
+			err = s.db.CreateTables()
+		}
+
+		if err == nil {
+			fmt.Printf("[warmUp()]: Database is ready after %d attempt(s)\n", attempt)
+			return nil
+		}
+
+		if attempt >= config.maxAttempts || time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("database still unreachable after %d attempt(s): %w", attempt, err)
+		}
+
+		fmt.Printf("[warmUp()]: Database isn't ready (attempt %d of %d), retrying in %s: %s\n", attempt, config.maxAttempts, interval, err)
+		time.Sleep(interval)
+
+		interval = min(interval*2, config.maxInterval)
+	}
+}
+
+// Rejects requests with a 503 until the database warm-up has finished, so
+// clients and load balancers don't see errors while the database is still
+// starting. Liveness and readiness checks are always allowed through.
+func (s *Server) warmUpMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.ready.Load() {
+			c.Next()
+			return
+		}
+
+		switch basePath := basePathOf(c); c.FullPath() {
+		case basePath + "/health/liveness", basePath + "/health/readiness":
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, errorResponse(c, "the server is starting up, try again shortly"))
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// The request body of the POST /webhooks and PUT /webhooks/:id endpoints.
+type WebhookRequest struct {
+	// The http or https URL events are POSTed to.
+	URL string `json:"url"`
+
+	// Only events of this type are delivered, or every event when empty.
+	Type database.EventType `json:"type"`
+
+	// The secret each delivery is signed with.
+	Secret string `json:"secret"`
+
+	// Whether events are delivered to the subscription. Defaults to true.
+	Enabled *bool `json:"enabled"`
+}
+
+// Checks that the request has the fields required for a subscription,
+// returning an error describing the first problem found.
+func (r WebhookRequest) Validate() error {
+	parsed, err := url.Parse(r.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+
+	if r.Secret == "" {
+		return errors.New("secret is required to sign deliveries")
+	}
+
+	return nil
+}
+
+// Returns the subscription described by the request.
+func (r WebhookRequest) webhook() database.Webhook {
+	return database.Webhook{
+		URL:     r.URL,
+		Type:    r.Type,
+		Secret:  r.Secret,
+		Enabled: r.Enabled == nil || *r.Enabled,
+	}
+}
+
+// Handles requests to the POST /webhooks endpoint, which creates a webhook
+// subscription that newly created events are POSTed to. Each delivery is
+// signed with the subscription's secret in the X-Shion-Signature header.
+func (s *Server) createWebhookHandler(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	webhook, err := s.dbFor(c).CreateWebhook(req.webhook())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// Handles requests to the GET /webhooks endpoint, which returns every webhook
+// subscription along with the status of its latest delivery.
+func (s *Server) listWebhooksHandler(c *gin.Context) {
+	webhooks, err := s.dbFor(c).ListWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// Handles requests to the GET /webhooks/:id endpoint, which returns the webhook
+// subscription along with the status of its latest delivery, or 404 if it
+// doesn't exist.
+func (s *Server) getWebhookHandler(c *gin.Context) {
+	webhook, err := s.dbFor(c).GetWebhook(c.Param("id"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// Handles requests to the PUT /webhooks/:id endpoint, which replaces the
+// subscription's URL, type filter, secret, and enabled flag. Re-enabling a
+// subscription that was disabled after repeated failures resets its failure
+// count. Returns 404 if it doesn't exist.
+func (s *Server) updateWebhookHandler(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(c, err.Error()))
+		return
+	}
+
+	update := req.webhook()
+	update.ID = c.Param("id")
+
+	webhook, err := s.dbFor(c).UpdateWebhook(update)
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// Handles requests to the DELETE /webhooks/:id endpoint, which removes the
+// webhook subscription so no more events are delivered to it. Returns 404 if
+// it doesn't exist.
+func (s *Server) deleteWebhookHandler(c *gin.Context) {
+	err := s.dbFor(c).DeleteWebhook(c.Param("id"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, errorResponse(c, err.Error()))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,709 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// The actions a WebSocket client can send to the server.
+const (
+	// Adds the given event types to the client's filter.
+	wsActionSubscribe = "subscribe"
+
+	// Removes the given event types from the client's filter, or clears the
+	// filter entirely if no types are given.
+	wsActionUnsubscribe = "unsubscribe"
+
+	// Creates the given event(s), the same as POST /event and POST /events.
+	wsActionPublish = "publish"
+
+	// Returns the latest max events, newest first, the same as GET /events,
+	// optionally limited to a single event type.
+	wsActionGetLatest = "get_latest"
+
+	// Returns the event with the given ID, the same as GET /event/:id.
+	wsActionGetEvent = "get_event"
+)
+
+// The largest message a WebSocket client may send when WS_MAX_MESSAGE_BYTES
+// isn't set.
+const defaultWSMaxMessageBytes = 64 << 10
+
+// The number of events get_latest returns when the client doesn't give a max,
+// matching GET /events.
+const wsDefaultLatestMax = 50
+
+// The types of frames the server sends to a WebSocket client.
+const (
+	wsFrameEvent = "event"
+	wsFrameAck   = "ack"
+	wsFrameError = "error"
+
+	// Answers a query action such as get_latest with the matching events.
+	wsFrameResult = "result"
+
+	// Tells the client how many events it skipped because it fell too far
+	// behind, sent right before the next event it receives.
+	wsFrameGap = "gap"
+)
+
+// The close code sent to a client that's disconnected because it fell too far
+// behind under the disconnect overflow policy. Clients should reconnect with
+// ?last_event_id= to backfill the events they missed.
+const wsCloseSendBufferOverflow = 4001
+
+// A message sent from a WebSocket client to the server.
+type wsRequest struct {
+	// The action to perform, e.g. subscribe or unsubscribe. A message without
+	// an action is taken to be a single event to publish, e.g.
+	// {"type": "deploy", "data": "..."}.
+	Action string `json:"action"`
+
+	// A client-supplied identifier echoed back on the ack or error frame for
+	// this message so the client can correlate the reply.
+	MsgID string `json:"msg_id"`
+
+	// The event types the action applies to.
+	Types []database.EventType `json:"types"`
+
+	// The event(s) to create for the publish action, either a single event
+	// object or an array of events.
+	Events json.RawMessage `json:"events"`
+
+	// The most events the get_latest action returns. Defaults to 50 and is
+	// capped at MAX_EVENTS_LIMIT.
+	Max int `json:"max"`
+
+	// The ID of the event the get_event action returns.
+	ID string `json:"id"`
+}
+
+// A frame sent from the server to a WebSocket client.
+type wsFrame struct {
+	// The kind of frame, e.g. event, ack, or error.
+	Type string `json:"type"`
+
+	// The action being acknowledged, only set on ack frames.
+	Action string `json:"action,omitempty"`
+
+	// The msg_id of the client message this frame is replying to, if any.
+	MsgID string `json:"msg_id,omitempty"`
+
+	// The ID of the event being delivered, only set on event frames. Clients
+	// can persist it and reconnect with ?last_event_id= to resume from there.
+	ID string `json:"id,omitempty"`
+
+	// The client's active filter, only set on ack frames. An empty list means
+	// the client receives every event.
+	Types []database.EventType `json:"types,omitempty"`
+
+	// The event being delivered, only set on event frames.
+	Event *database.EventEntry `json:"event,omitempty"`
+
+	// The IDs of the events created by a publish action, only set on ack frames.
+	IDs []string `json:"ids,omitempty"`
+
+	// A description of what went wrong, only set on error frames.
+	Error string `json:"error,omitempty"`
+
+	// Each way a published event's data doesn't conform to the schema for its
+	// type, only set on error frames.
+	ValidationErrors []string `json:"validation_errors,omitempty"`
+
+	// The number of events the client skipped, only set on gap frames.
+	Skipped int64 `json:"skipped,omitempty"`
+
+	// The events a query action matched, only set on result frames. It's
+	// omitted when nothing matched.
+	Events []database.EventEntry `json:"events,omitempty"`
+}
+
+// A single WebSocket connection subscribed to the Hub.
+type wsClient struct {
+	conn *websocket.Conn
+
+	// The ID the Hub tracks the connection under.
+	id string
+
+	// The client's IP address, which its connection slot is reserved under.
+	ip string
+
+	db database.TursoDB
+
+	hub *Hub
+
+	// Broadcasts events the client publishes the same way as events created
+	// over HTTP, i.e. to subscribers, webhooks, and NATS.
+	broadcast func(events ...database.EventEntry)
+
+	// Checks a published event against the schema for its type, the same as
+	// events created over HTTP.
+	validateSchema func(db database.TursoDB, event database.EventEntry) error
+
+	// The most events a single get_latest action can return.
+	maxEventsLimit int
+
+	sub *subscriber
+
+	// Events the client missed while disconnected, which are written before
+	// any live events.
+	replay []database.EventEntry
+
+	// Frames that need to be written to the connection other than events, e.g.
+	// acknowledgements and errors.
+	replies chan wsFrame
+
+	// Closed once the write pump exits, after which no more frames are written.
+	done chan struct{}
+
+	// How often the server pings the client.
+	pingInterval time.Duration
+
+	// How long the server waits for a pong before assuming the connection is
+	// dead.
+	pongTimeout time.Duration
+
+	// How long a single write may take before the connection is considered
+	// wedged.
+	writeTimeout time.Duration
+
+	// How long the connection may stay open before the client is asked to
+	// reconnect, or 0 for no limit.
+	maxLifetime time.Duration
+
+	// How long the client has to close the connection after being told the
+	// server is shutting down before it's force-closed.
+	shutdownGracePeriod time.Duration
+
+	// The largest message the client may send in bytes. The connection is
+	// closed with 1009 (message too big) if it sends a larger one.
+	maxMessageBytes int64
+
+	// Logs the connection's lifecycle, with the client's IP address, the ID of
+	// the request that opened the connection, and the connection's ID.
+	logger *slog.Logger
+
+	// When the connection was opened.
+	connectedAt time.Time
+
+	// Why the server closed the connection, set by the write pump so the read
+	// pump can log it once the connection is gone. It's nil if the client
+	// closed the connection or the read failed.
+	closeReason atomic.Pointer[string]
+}
+
+// Handles requests to the /ws/events endpoint, upgrading the connection to a
+// WebSocket and streaming newly created events to the client. The client can
+// limit which event types it receives with the ?types= query parameter at
+// connect time, or by sending subscribe/unsubscribe messages at any point.
+//
+// Clients can also query stored events over the same connection with the
+// get_latest and get_event actions, which are answered with a result frame
+// carrying the request's msg_id.
+//
+// A client reconnecting after a dropped connection can pass the ID of the last
+// event it saw with the ?last_event_id= query parameter (or Last-Event-ID
+// header) to first receive every event it missed, in order, before switching
+// to the live stream without gaps or duplicates.
+//
+// Connections are refused with a 503 once the server has WS_MAX_CONNECTIONS
+// open connections, or with a 429 once the client's IP address has
+// WS_MAX_CONNECTIONS_PER_IP open connections.
+func (s *Server) wsEventHandler(c *gin.Context) {
+	ip := c.ClientIP()
+	if err := s.hub.connect(ip); err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, errTooManyConnectionsFromIP) {
+			status = http.StatusTooManyRequests
+		}
+
+		c.JSON(status, errorResponse(c, err.Error()))
+		return
+	}
+
+	lastEventID := c.Query("last_event_id")
+	if lastEventID == "" {
+		lastEventID = c.GetHeader("Last-Event-ID")
+	}
+
+	sub, replay, err := s.resumeSubscription(lastEventID, parseEventTypes(c.QueryArray("types")))
+	if err != nil {
+		s.hub.disconnect(ip)
+		c.JSON(http.StatusInternalServerError, errorResponse(c, err.Error()))
+		return
+	}
+
+	logger := s.logger.With("client_ip", ip, "request_id", requestIDOf(c))
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		s.hub.unsubscribe(sub)
+		s.hub.disconnect(ip)
+		logger.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	id := s.hub.register(c.Request.RemoteAddr, ip, sub)
+
+	client := &wsClient{
+		conn:      conn,
+		id:        id,
+		ip:        ip,
+		db:        s.db,
+		hub:       s.hub,
+		broadcast: s.publish,
+		sub:       sub,
+		replay:    replay,
+		replies:   make(chan wsFrame, 16),
+		done:      make(chan struct{}),
+
+		validateSchema: s.validateEventSchema,
+
+		pingInterval: s.wsPingInterval,
+		pongTimeout:  s.wsPongTimeout,
+		writeTimeout: s.wsWriteTimeout,
+		maxLifetime:  s.wsMaxLifetime,
+
+		shutdownGracePeriod: s.wsShutdownGracePeriod,
+
+		maxMessageBytes: s.wsMaxMessageBytes,
+		maxEventsLimit:  s.maxEventsLimit,
+
+		logger:      logger.With("connection_id", id),
+		connectedAt: time.Now(),
+	}
+
+	client.logger.Info("WebSocket connected", "types", sub.activeTypes(), "last_event_id", lastEventID, "replayed", len(replay))
+
+	client.replies <- client.ack(wsRequest{})
+
+	go client.writePump()
+	client.readPump()
+}
+
+// Reads messages from the client until the connection is closed, applying any
+// subscription changes, creating any published events, and answering any
+// queries. Malformed messages are answered with an error frame rather than
+// closing the connection. If the client doesn't answer a ping within the pong
+// timeout then the read fails and the connection is treated as dead. Once the
+// connection is closed the disconnect is logged and the client is removed from
+// the Hub.
+func (c *wsClient) readPump() {
+	var readErr error
+
+	defer func() {
+		c.logDisconnect(readErr)
+
+		c.hub.unsubscribe(c.sub)
+		c.hub.unregister(c.id)
+		c.hub.disconnect(c.ip)
+		close(c.replies)
+	}()
+
+	c.conn.SetReadLimit(c.maxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(c.pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.pongTimeout))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			readErr = err
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			c.reply(wsFrame{Type: wsFrameError, Error: "invalid message: " + err.Error()})
+			continue
+		}
+
+		switch req.Action {
+		case "":
+			c.reply(c.publish(wsRequest{Action: wsActionPublish, Events: message}))
+		case wsActionSubscribe:
+			c.sub.addTypes(req.Types)
+			c.logSubscription(req)
+			c.reply(c.ack(req))
+		case wsActionUnsubscribe:
+			c.sub.removeTypes(req.Types)
+			c.logSubscription(req)
+			c.reply(c.ack(req))
+		case wsActionPublish:
+			c.reply(c.publish(req))
+		case wsActionGetLatest, wsActionGetEvent:
+			c.reply(c.query(req))
+		default:
+			c.reply(wsFrame{Type: wsFrameError, MsgID: req.MsgID, Error: fmt.Sprintf("unknown action: %q", req.Action)})
+		}
+	}
+}
+
+// Writes events, replies, and periodic pings to the connection until the read
+// pump shuts down, the client overflows its send buffer under the disconnect
+// policy, the connection reaches its max lifetime, or the server shuts down,
+// at which point the connection is closed. Every write has a deadline so a
+// wedged TCP connection can't block the pump forever; closing the connection
+// on a failed write also unblocks the read pump.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer func() {
+		ticker.Stop()
+		close(c.done)
+		c.conn.Close()
+	}()
+
+	// A nil channel never fires, so connections live forever without a max
+	// lifetime.
+	var expired <-chan time.Time
+	if c.maxLifetime > 0 {
+		timer := time.NewTimer(c.maxLifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for _, event := range c.replay {
+		if err := c.writeEvent(event); err != nil {
+			c.setCloseReason("write failed: " + err.Error())
+			return
+		}
+	}
+
+	replayed := replayedIDs(c.replay)
+
+	for {
+		select {
+		case event := <-c.sub.events:
+			if _, ok := replayed[event.ID]; ok {
+				delete(replayed, event.ID)
+				continue
+			}
+
+			if skipped := c.sub.takeSkipped(); skipped > 0 {
+				if err := c.writeJSON(wsFrame{Type: wsFrameGap, Skipped: skipped}); err != nil {
+					c.setCloseReason("write failed: " + err.Error())
+					return
+				}
+			}
+
+			if err := c.writeEvent(event); err != nil {
+				c.setCloseReason("write failed: " + err.Error())
+				return
+			}
+		case <-c.hub.closing:
+			c.setCloseReason("server shutting down")
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			c.awaitClose()
+			return
+		case <-expired:
+			c.setCloseReason("connection lifetime exceeded")
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "connection lifetime exceeded"))
+			return
+		case <-c.sub.overflowed:
+			c.setCloseReason("send buffer overflow")
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(wsCloseSendBufferOverflow, "send buffer overflow"))
+			return
+		case reply, ok := <-c.replies:
+			if !ok {
+				c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+
+			if err := c.writeJSON(reply); err != nil {
+				c.setCloseReason("write failed: " + err.Error())
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.setCloseReason("ping failed: " + err.Error())
+				return
+			}
+		}
+	}
+}
+
+// Waits for the client to answer a close frame, which ends the read pump, or
+// for the shutdown grace period to pass, whichever comes first. Replies queued
+// in the meantime are discarded.
+func (c *wsClient) awaitClose() {
+	timer := time.NewTimer(c.shutdownGracePeriod)
+	defer timer.Stop()
+
+	for {
+		select {
+		case _, ok := <-c.replies:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// Writes a single event frame to the connection.
+func (c *wsClient) writeEvent(event database.EventEntry) error {
+	return c.writeJSON(wsFrame{Type: wsFrameEvent, ID: event.ID, Event: &event})
+}
+
+// Writes a single JSON frame to the connection within the write timeout.
+func (c *wsClient) writeJSON(frame wsFrame) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	if err := c.conn.WriteJSON(frame); err != nil {
+		return err
+	}
+
+	c.hub.IncrementSent(c.id)
+	return nil
+}
+
+// Queues a frame to be written to the client, discarding it if the write pump
+// has already exited. Error frames are logged too.
+func (c *wsClient) reply(frame wsFrame) {
+	if frame.Type == wsFrameError {
+		c.logger.Warn("WebSocket message rejected", "action", frame.Action, "msg_id", frame.MsgID, "error", frame.Error)
+	}
+
+	select {
+	case c.replies <- frame:
+	case <-c.done:
+	}
+}
+
+// Records why the server is closing the connection. Only the first reason is
+// kept, since that's what caused the close.
+func (c *wsClient) setCloseReason(reason string) {
+	c.closeReason.CompareAndSwap(nil, &reason)
+}
+
+// Logs that the client changed its subscription, with its new filter.
+func (c *wsClient) logSubscription(req wsRequest) {
+	c.logger.Info("WebSocket subscription changed", "action", req.Action, "requested_types", req.Types, "types", c.sub.activeTypes())
+}
+
+// Logs that the connection was closed, with how long it was open and why. The
+// reason is the one the write pump recorded, if it closed the connection, or
+// otherwise comes from the error that ended the read pump. The close code is
+// included when the client sent a close frame.
+func (c *wsClient) logDisconnect(readErr error) {
+	attrs := []slog.Attr{
+		slog.Duration("duration", time.Since(c.connectedAt)),
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(readErr, &closeErr) {
+		attrs = append(attrs, slog.Int("close_code", closeErr.Code))
+	}
+
+	var netErr net.Error
+	reason := c.closeReason.Load()
+	switch {
+	case reason != nil:
+		attrs = append(attrs, slog.String("reason", *reason))
+	case closeErr != nil:
+		attrs = append(attrs, slog.String("reason", "closed by client"))
+	case errors.Is(readErr, websocket.ErrReadLimit):
+		attrs = append(attrs, slog.String("reason", "message too big"))
+	case errors.As(readErr, &netErr) && netErr.Timeout():
+		attrs = append(attrs, slog.String("reason", "pong timeout"))
+	case readErr != nil:
+		attrs = append(attrs, slog.String("reason", "read failed: "+readErr.Error()))
+	}
+
+	c.logger.LogAttrs(context.Background(), slog.LevelInfo, "WebSocket disconnected", attrs...)
+}
+
+// Returns an acknowledgement frame for the given request containing the
+// client's active filter.
+func (c *wsClient) ack(req wsRequest) wsFrame {
+	return wsFrame{Type: wsFrameAck, Action: req.Action, MsgID: req.MsgID, Types: c.sub.activeTypes()}
+}
+
+// Validates and creates the event(s) contained in a publish request, then
+// broadcasts them to every subscriber. Returns an ack frame with the IDs of
+// the created events, or an error frame if the events are invalid or couldn't
+// be created.
+func (c *wsClient) publish(req wsRequest) wsFrame {
+	fail := func(err error) wsFrame {
+		return wsFrame{Type: wsFrameError, Action: req.Action, MsgID: req.MsgID, Error: err.Error()}
+	}
+
+	events, err := decodeEvents(req.Events)
+	if err != nil {
+		return fail(err)
+	}
+
+	for i, event := range events {
+		err := c.validateSchema(c.db, event)
+
+		var invalid *schemaError
+		if errors.As(err, &invalid) {
+			frame := fail(fmt.Errorf("event %d: %w", i, err))
+			frame.ValidationErrors = invalid.violations
+			return frame
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	created, err := c.db.CreateEvents(events)
+	if err != nil {
+		return fail(err)
+	}
+
+	c.broadcast(database.Inserted(created)...)
+
+	ids := make([]string, 0, len(created))
+	for _, event := range created {
+		ids = append(ids, event.ID)
+	}
+
+	return wsFrame{Type: wsFrameAck, Action: req.Action, MsgID: req.MsgID, IDs: ids}
+}
+
+// Runs a query action and returns a result frame with the matching events, or
+// an error frame if the request is invalid or the query fails. Queries run on
+// the same connection as the live stream, so a client doesn't need HTTP as
+// well.
+func (c *wsClient) query(req wsRequest) wsFrame {
+	fail := func(err error) wsFrame {
+		return wsFrame{Type: wsFrameError, Action: req.Action, MsgID: req.MsgID, Error: err.Error()}
+	}
+
+	var events []database.EventEntry
+
+	switch req.Action {
+	case wsActionGetLatest:
+		max := req.Max
+		if max == 0 {
+			max = wsDefaultLatestMax
+		}
+		if max < 0 {
+			return fail(errors.New("max must be a positive integer"))
+		}
+		max = min(max, c.maxEventsLimit)
+
+		var err error
+		switch len(req.Types) {
+		case 0:
+			events, err = c.db.GetLatestEvents(max)
+		case 1:
+			events, err = c.db.GetLatestEventsByType(req.Types[0], max)
+		default:
+			return fail(errors.New("get_latest accepts at most one type"))
+		}
+		if err != nil {
+			return fail(err)
+		}
+	case wsActionGetEvent:
+		if req.ID == "" {
+			return fail(errors.New("id is required"))
+		}
+
+		event, err := c.db.GetEventByID(req.ID)
+		if err != nil {
+			return fail(err)
+		}
+
+		events = []database.EventEntry{event}
+	}
+
+	return wsFrame{Type: wsFrameResult, Action: req.Action, MsgID: req.MsgID, Events: events}
+}
+
+// Decodes either a single event object or an array of events, validating that
+// there is at least one event and that every event is valid.
+func decodeEvents(raw json.RawMessage) ([]database.EventEntry, error) {
+	var events []database.EventEntry
+
+	trimmed := bytes.TrimSpace(raw)
+	switch {
+	case len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")):
+		return nil, errors.New("no events provided")
+	case trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			return nil, fmt.Errorf("invalid events: %w", err)
+		}
+	default:
+		var event database.EventEntry
+		if err := json.Unmarshal(trimmed, &event); err != nil {
+			return nil, fmt.Errorf("invalid event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return nil, errors.New("no events provided")
+	}
+
+	for i, event := range events {
+		if err := event.Validate(); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+
+	return events, nil
+}
+
+// Parses event types from query parameter values, which may be repeated and/or
+// comma-separated, e.g. ?types=deploy,rollback&types=alert.
+func parseEventTypes(values []string) []database.EventType {
+	var types []database.EventType
+
+	for _, value := range values {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, database.EventType(t))
+			}
+		}
+	}
+
+	return types
+}
+
+// The response of GET /admin/ws/connections.
+type WSConnectionsResponse struct {
+	// The open WebSocket connections, oldest first.
+	Connections []ConnectionInfo `json:"connections"`
+
+	// The total number of WebSocket connections opened since the server
+	// started, including those that have since closed.
+	TotalConnections int64 `json:"total_connections"`
+}
+
+// Handles requests to the GET /admin/ws/connections endpoint, which lists the
+// open WebSocket connections with where they came from, when they connected,
+// their event type filter, and how many frames they've been sent, along with
+// the number of connections opened since the server started. Requires admin
+// credentials.
+func (s *Server) wsConnectionsHandler(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(http.StatusForbidden, errorResponse(c, "listing WebSocket connections requires admin credentials"))
+		return
+	}
+
+	c.JSON(http.StatusOK, WSConnectionsResponse{
+		Connections:      s.hub.Clients(),
+		TotalConnections: s.hub.TotalConnections(),
+	})
+}
@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	t.Setenv("ACCESS_LOG_FILE", path)
+	t.Setenv("ACCESS_LOG_STDOUT", "false")
+
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/events/summary", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := <-shutdownAsync(srv); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected JSON lines, got %q: %v", line, err)
+		}
+
+		if entry["path"] == "/api/v1/events/summary" && entry["status"] == float64(http.StatusOK) {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("expected the request in the access log, got %q", contents)
+	}
+}
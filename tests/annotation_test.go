@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+func TestAddAnnotations(t *testing.T) {
+	ts := newTestServer(t)
+	event := postEvent(t, ts, database.EventEntry{Type: "incident", Data: "db down"})
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event/"+event.ID+"/annotations", server.AnnotationRequest{Note: "failed over to replica", Author: "oncall"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	// The author defaults to the Basic Auth username.
+	doRequest(t, ts, "POST", "/api/v1/event/"+event.ID+"/annotations", server.AnnotationRequest{Note: "resolved"})
+
+	resp = doRequest(t, ts, "GET", "/api/v1/event/"+event.ID+"/annotations", nil)
+	var annotations []database.Annotation
+	if err := json.NewDecoder(resp.Body).Decode(&annotations); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %+v", annotations)
+	}
+
+	if a := annotations[0]; a.EventID != event.ID || a.Note != "failed over to replica" || a.Author != "oncall" {
+		t.Errorf("unexpected first annotation: %+v", a)
+	}
+
+	if a := annotations[1]; a.Note != "resolved" || a.Author != testUsername {
+		t.Errorf("unexpected second annotation: %+v", a)
+	}
+
+	// The event itself is unchanged, and only includes annotations when asked.
+	resp = doRequest(t, ts, "GET", "/api/v1/event/"+event.ID, nil)
+	var plain map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plain["annotations"]; ok || plain["data"] != "db down" {
+		t.Errorf("unexpected event without annotations: %+v", plain)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/event/"+event.ID+"?include_annotations=true", nil)
+	var annotated server.AnnotatedEvent
+	if err := json.NewDecoder(resp.Body).Decode(&annotated); err != nil {
+		t.Fatal(err)
+	}
+	if annotated.EventEntry != event || len(annotated.Annotations) != 2 {
+		t.Errorf("unexpected annotated event: %+v", annotated)
+	}
+}
+
+func TestAnnotationsOfMissingEvent(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event/missing/annotations", server.AnnotationRequest{Note: "hello"})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status code adding: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/event/missing/annotations", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status code listing: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAnnotationRequiresNote(t *testing.T) {
+	ts := newTestServer(t)
+	event := postEvent(t, ts, database.EventEntry{Type: "incident", Data: "db down"})
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event/"+event.ID+"/annotations", server.AnnotationRequest{Note: "  "})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
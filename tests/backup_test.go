@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestBackupRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/admin/backup", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a non-admin: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestBackupDownload(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	doRequest(t, ts, "POST", "/api/v1/events", seqEvents(25))
+
+	resp := doAdminRequest(t, ts, "GET", "/api/v1/admin/backup")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/gzip" {
+		t.Errorf("unexpected content type: %q", contentType)
+	}
+
+	disposition := resp.Header.Get("Content-Disposition")
+	if !regexp.MustCompile(`^attachment; filename="shion-backup-\d{8}T\d{6}Z\.db\.gz"$`).MatchString(disposition) {
+		t.Errorf("unexpected content disposition: %q", disposition)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.Copy(file, gz); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var integrity string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil || integrity != "ok" {
+		t.Fatalf("expected a valid database, got %q, %v", integrity, err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Events").Scan(&count); err != nil || count != 25 {
+		t.Fatalf("expected the backup to hold 25 events, got %d, %v", count, err)
+	}
+
+	// The server keeps working after the backup.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Starts a test server whose standard output is captured, returning the server
+// and a function that returns everything written to it so far. The server's
+// loggers are created with os.Stdout, so it only needs replacing while the
+// server is created.
+func newTestServerCapturingStdout(t *testing.T) (*httptest.Server, func() string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&output, r)
+		close(copied)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	ts := newTestServer(t)
+	os.Stdout = stdout
+
+	t.Cleanup(func() { w.Close() })
+
+	return ts, func() string {
+		// Closing the pipe lets the copy finish, so the output is complete.
+		ts.Close()
+		w.Close()
+		<-copied
+
+		return output.String()
+	}
+}
+
+func TestBodyLoggingOffByDefault(t *testing.T) {
+	ts, output := newTestServerCapturingStdout(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "body-logging-canary"})
+
+	if logs := output(); strings.Contains(logs, "body-logging-canary") {
+		t.Fatalf("expected bodies not to be logged by default, got:\n%s", logs)
+	}
+}
+
+func TestBodyLoggingWhenEnabled(t *testing.T) {
+	t.Setenv("DEBUG_LOG_BODIES", "true")
+	ts, output := newTestServerCapturingStdout(t)
+
+	// The handler can still parse the body after it has been logged.
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "body-logging-canary"})
+	if created.Data != "body-logging-canary" {
+		t.Fatalf("unexpected event: %+v", created)
+	}
+
+	logs := output()
+
+	for _, want := range []string{`msg="Request body"`, `msg="Response body"`, "status=201", created.ID} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("expected the logs to contain %q, got:\n%s", want, logs)
+		}
+	}
+}
+
+func TestBodyLoggingTruncatesLargeBodies(t *testing.T) {
+	t.Setenv("DEBUG_LOG_BODIES", "true")
+	ts, output := newTestServerCapturingStdout(t)
+
+	data := strings.Repeat("x", 4096)
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: data})
+
+	logs := output()
+
+	if strings.Contains(logs, data) {
+		t.Error("expected the logged bodies to be truncated")
+	}
+
+	if !strings.Contains(logs, "truncated=true") {
+		t.Errorf("expected the logs to mark the bodies as truncated, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Takes the write lock on the SQLite database at the given path from a
+// connection of its own, the same as another process writing to it would,
+// returning a function that releases it. The lock is released when the test
+// finishes if it hasn't been already.
+func lockDatabase(t *testing.T, path string) func() {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+		t.Fatal(err)
+	}
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			conn.Close()
+		}
+	}
+	t.Cleanup(release)
+
+	return release
+}
+
+func TestCreateEventWhileTheDatabaseStaysLocked(t *testing.T) {
+	t.Setenv("DB_BUSY_TIMEOUT_MS", "10")
+	t.Setenv("DB_BUSY_RETRIES", "2")
+	t.Setenv("DB_BUSY_RETRY_BACKOFF_MS", "10")
+
+	path := filepath.Join(t.TempDir(), "shion.db")
+	ts := newTestServerWithDB(t, "file:"+path)
+
+	lockDatabase(t, path)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v1"})
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("unexpected Retry-After: got %q want 1", retryAfter)
+	}
+}
+
+func TestCreateEventRetriesUntilTheDatabaseIsUnlocked(t *testing.T) {
+	t.Setenv("DB_BUSY_TIMEOUT_MS", "10")
+	t.Setenv("DB_BUSY_RETRIES", "5")
+	t.Setenv("DB_BUSY_RETRY_BACKOFF_MS", "50")
+
+	path := filepath.Join(t.TempDir(), "shion.db")
+	ts := newTestServerWithDB(t, "file:"+path)
+
+	// The lock is released after the first attempt has given up, so the
+	// event is only created if the write is retried.
+	release := lockDatabase(t, path)
+	time.AfterFunc(100*time.Millisecond, release)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v1"})
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 1 {
+		t.Errorf("expected the event to be created once, got %d events", len(events))
+	}
+}
+
+func TestCreateEventsWhileTheDatabaseStaysLocked(t *testing.T) {
+	t.Setenv("DB_BUSY_TIMEOUT_MS", "10")
+	t.Setenv("DB_BUSY_RETRIES", "1")
+	t.Setenv("DB_BUSY_RETRY_BACKOFF_MS", "10")
+
+	path := filepath.Join(t.TempDir(), "shion.db")
+	ts := newTestServerWithDB(t, "file:"+path)
+
+	lockDatabase(t, path)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/events", seqEvents(3))
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	testAdminUsername = "admin"
+	testAdminPassword = "correct-horse"
+)
+
+// Sends a GET /events request with the given credentials and X-DB-Timeout-MS
+// header, returning the response status code.
+func getEventsWithDBTimeout(t *testing.T, url, username, password, timeout string) int {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url+"/api/v1/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(username, password)
+	req.Header.Set("X-DB-Timeout-MS", timeout)
+
+	return sendRequest(t, req)
+}
+
+func TestDBTimeoutOverrideRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	if status := getEventsWithDBTimeout(t, ts.URL, testUsername, testPassword, "5000"); status != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a non-admin: got %v want %v", status, http.StatusForbidden)
+	}
+
+	if status := getEventsWithDBTimeout(t, ts.URL, testAdminUsername, testAdminPassword, "5000"); status != http.StatusOK {
+		t.Fatalf("unexpected status code for an admin: got %v want %v", status, http.StatusOK)
+	}
+
+	// Values above the cap are accepted and capped rather than rejected.
+	if status := getEventsWithDBTimeout(t, ts.URL, testAdminUsername, testAdminPassword, "600000"); status != http.StatusOK {
+		t.Fatalf("unexpected status code above the cap: got %v want %v", status, http.StatusOK)
+	}
+
+	for _, timeout := range []string{"0", "-5", "soon"} {
+		if status := getEventsWithDBTimeout(t, ts.URL, testAdminUsername, testAdminPassword, timeout); status != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", timeout, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAdminCredentialsDisabledByDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	if status := getEventsWithDBTimeout(t, ts.URL, "", "", "5000"); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code with empty admin credentials: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// Turns on the debug endpoints along with the admin credentials they require.
+func enableTestDebugEndpoints(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("ENABLE_DEBUG_ENDPOINTS", "true")
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+}
+
+// Fails the test unless the response holds a gzipped pprof profile.
+func expectProfile(t *testing.T, resp *http.Response) {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("unexpected status code: got %v want %v: %s", resp.StatusCode, http.StatusOK, body)
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("expected a gzipped profile: %v", err)
+	}
+
+	profile, err := io.ReadAll(reader)
+	if err != nil || len(profile) == 0 {
+		t.Fatalf("expected a non-empty profile, got %d bytes: %v", len(profile), err)
+	}
+}
+
+func TestDebugHeapProfile(t *testing.T) {
+	enableTestDebugEndpoints(t)
+	ts := newTestServer(t)
+
+	expectProfile(t, doAdminRequest(t, ts, "GET", "/debug/pprof/heap"))
+
+	if resp := doAdminRequest(t, ts, "GET", "/debug/vars"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code for the expvar variables: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	// The API credentials aren't enough.
+	if resp := doRequest(t, ts, "GET", "/debug/pprof/heap", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code with the API credentials: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestDebugEndpointsAreOffByDefault(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	if resp := doAdminRequest(t, ts, "GET", "/debug/pprof/heap"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestDebugCPUProfileOutlivesWriteTimeout(t *testing.T) {
+	enableTestDebugEndpoints(t)
+	t.Setenv("HTTP_WRITE_TIMEOUT", "100ms")
+	srv, _ := newTestHTTPServer(t, newTestDBURL(t))
+
+	// Serve on a real listener so the server's write timeout applies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.Serve(ln)
+
+	req, err := http.NewRequest("GET", "http://"+ln.Addr().String()+"/debug/pprof/profile?seconds=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testAdminUsername, testAdminPassword)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	expectProfile(t, resp)
+}
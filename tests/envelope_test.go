@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Sends an authenticated request with the given X-Response-Envelope header,
+// failing the test if it can't be sent.
+func doRequestWithEnvelopeHeader(t *testing.T, ts *httptest.Server, method, path, envelope string, body any) *http.Response {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Response-Envelope", envelope)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// Decodes a response body into v, failing the test unless the response has the
+// given status code and the body is exactly one JSON value of v's shape.
+func decodeStrict(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostEventEnvelope(t *testing.T) {
+	ts := newTestServer(t)
+
+	var wrapped server.EventResponse
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v1"}), http.StatusCreated, &wrapped)
+	if wrapped.Message == "" || len(wrapped.EventEntry) != 1 || wrapped.EventEntry[0].Data != "v1" {
+		t.Fatalf("unexpected enveloped response: %+v", wrapped)
+	}
+
+	var raw database.EventEntry
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/event?envelope=false", database.EventEntry{Type: "deploy", Data: "v2"}), http.StatusCreated, &raw)
+	if raw.ID == "" || raw.Data != "v2" {
+		t.Fatalf("unexpected raw response: %+v", raw)
+	}
+
+	raw = database.EventEntry{}
+	decodeStrict(t, doRequestWithEnvelopeHeader(t, ts, "POST", "/api/v1/event", "false", database.EventEntry{Type: "deploy", Data: "v3"}), http.StatusCreated, &raw)
+	if raw.ID == "" || raw.Data != "v3" {
+		t.Fatalf("unexpected raw response: %+v", raw)
+	}
+}
+
+func TestPostEventsEnvelope(t *testing.T) {
+	ts := newTestServer(t)
+	events := []database.EventEntry{{Type: "seq", Data: "0"}, {Type: "seq", Data: "1"}}
+
+	var wrapped []server.EventResponse
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/events", events), http.StatusOK, &wrapped)
+	if len(wrapped) != 2 || wrapped[1].EventEntry[0].Data != "1" {
+		t.Fatalf("unexpected enveloped response: %+v", wrapped)
+	}
+
+	var raw []database.EventEntry
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/events?envelope=false", events), http.StatusOK, &raw)
+	if len(raw) != 2 || raw[0].ID == "" || raw[1].Data != "1" {
+		t.Fatalf("unexpected raw response: %+v", raw)
+	}
+
+	raw = nil
+	decodeStrict(t, doRequestWithEnvelopeHeader(t, ts, "POST", "/api/v1/events", "false", events), http.StatusOK, &raw)
+	if len(raw) != 2 || raw[0].ID == "" {
+		t.Fatalf("unexpected raw response: %+v", raw)
+	}
+}
+
+func TestGetEventsWithoutEnvelope(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	// The read endpoints return bare events either way.
+	for _, query := range []string{"", "?envelope=false"} {
+		var events []database.EventEntry
+		decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/events"+query, nil), http.StatusOK, &events)
+		if len(events) != 1 || events[0].ID != created.ID {
+			t.Fatalf("%q: unexpected events: %+v", query, events)
+		}
+
+		var event database.EventEntry
+		decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/event/"+created.ID+query, nil), http.StatusOK, &event)
+		if event.ID != created.ID || event.Data != "v1" {
+			t.Fatalf("%q: unexpected event: %+v", query, event)
+		}
+	}
+}
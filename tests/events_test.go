@@ -0,0 +1,594 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+const clientEventID = "6f1c1e5e-8a7b-4d1f-9a43-2f4a5c7d9b10"
+
+func TestCreateEventWithClientSuppliedID(t *testing.T) {
+	ts := newTestServer(t)
+
+	created := postEvent(t, ts, database.EventEntry{ID: clientEventID, Type: "deploy", Data: "v1"})
+	if created.ID != clientEventID {
+		t.Fatalf("expected the client-supplied ID to be kept, got %q", created.ID)
+	}
+}
+
+func TestCreateEventGeneratesIDWhenAbsent(t *testing.T) {
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	second := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+
+	if first.ID == "" || second.ID == "" || first.ID == second.ID {
+		t.Fatalf("expected unique generated IDs, got %q and %q", first.ID, second.ID)
+	}
+}
+
+func TestCreateEventReturnsLocation(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{ID: clientEventID, Type: "deploy", Data: "v1"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	location := resp.Header.Get("Location")
+	if location != "/api/v1/event/"+clientEventID {
+		t.Fatalf("unexpected Location header: got %q want %q", location, "/api/v1/event/"+clientEventID)
+	}
+
+	var body server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.EventEntry) != 1 || body.EventEntry[0].ID != clientEventID {
+		t.Fatalf("expected the created event in the body, got %+v", body)
+	}
+
+	if resp := doRequest(t, ts, "GET", location, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code fetching the Location: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestCreateEventConflictingResubmitReturnsStoredEvent(t *testing.T) {
+	ts := newTestServer(t)
+
+	original := postEvent(t, ts, database.EventEntry{ID: clientEventID, Type: "deploy", Data: "v1"})
+	retried := postEvent(t, ts, database.EventEntry{ID: clientEventID, Type: "deploy", Data: "v2"})
+
+	if !retried.Duplicate {
+		t.Fatal("expected the stored event to be marked as a duplicate")
+	}
+	if retried.Duplicate = false; retried != original {
+		t.Fatalf("expected the stored event to be returned, got %+v want %+v", retried, original)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 1 {
+		t.Fatalf("expected a single stored event, got %d", len(events))
+	}
+}
+
+func TestCreateEventRejectsMalformedID(t *testing.T) {
+	ts := newTestServer(t)
+
+	// A short prefix of a short UUID decodes but isn't one.
+	for _, id := range []string{"not-a-uuid", "abc"} {
+		resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{ID: id, Type: "deploy"})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", id, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCreateEventWithGeneratedStyleID(t *testing.T) {
+	ts := newTestServer(t)
+	generated := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	// An event copied from another instance keeps its short UUID.
+	other := newTestServer(t)
+	copied := postEvent(t, other, generated)
+	if copied != generated {
+		t.Fatalf("expected the copied event to be unchanged, got %+v want %+v", copied, generated)
+	}
+}
+
+// Sends a PATCH /event/:id request and decodes the response into the returned
+// event when it succeeds.
+func patchEvent(t *testing.T, ts *httptest.Server, id string, fields map[string]any) (int, database.EventEntry) {
+	t.Helper()
+
+	resp := doRequest(t, ts, "PATCH", "/api/v1/event/"+id, fields)
+
+	var event database.EventEntry
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode, event
+}
+
+func TestPatchEventSingleField(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	status, patched := patchEvent(t, ts, created.ID, map[string]any{"data": "v2"})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	want := created
+	want.Data = "v2"
+	if patched != want {
+		t.Fatalf("unexpected patched event: got %+v want %+v", patched, want)
+	}
+}
+
+func TestPatchEventMultipleFields(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	status, patched := patchEvent(t, ts, created.ID, map[string]any{
+		"type":      "rollback",
+		"timestamp": "2024-05-06T07:08:09+02:00",
+	})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	want := database.EventEntry{ID: created.ID, Type: "rollback", Data: "v1", Timestamp: "2024-05-06T05:08:09Z", Priority: database.DefaultPriority}
+	if patched != want {
+		t.Fatalf("unexpected patched event: got %+v want %+v", patched, want)
+	}
+}
+
+func TestPatchEventRejectsReadOnlyFields(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for _, field := range []string{"id", "created_at", "content_hash"} {
+		status, _ := patchEvent(t, ts, created.ID, map[string]any{field: "x", "data": "v2"})
+		if status != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", field, status, http.StatusBadRequest)
+		}
+	}
+
+	if events := getEvents(t, ts, ""); events[0] != created {
+		t.Fatalf("expected the event to be unchanged, got %+v", events[0])
+	}
+}
+
+func TestPatchEventRejectsUnknownFields(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	if status, _ := patchEvent(t, ts, created.ID, map[string]any{"colour": "blue"}); status != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestPatchEventUnknownID(t *testing.T) {
+	ts := newTestServer(t)
+
+	if status, _ := patchEvent(t, ts, clientEventID, map[string]any{"data": "v2"}); status != http.StatusNotFound {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+// Sends a POST /events/batch-get request for the given IDs and decodes the
+// response when it succeeds.
+func batchGetEvents(t *testing.T, ts *httptest.Server, ids []string) (int, server.BatchGetResponse) {
+	t.Helper()
+
+	resp := doRequest(t, ts, "POST", "/api/v1/events/batch-get", map[string]any{"ids": ids})
+
+	var body server.BatchGetResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode, body
+}
+
+func TestBatchGetEventsReturnsFoundAndMissing(t *testing.T) {
+	ts := newTestServer(t)
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	second := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+
+	status, body := batchGetEvents(t, ts, []string{second.ID, "missing-1", first.ID, second.ID, "missing-2"})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if !slices.Equal(body.Events, []database.EventEntry{second, first}) {
+		t.Fatalf("unexpected events: %+v", body.Events)
+	}
+
+	if !slices.Equal(body.Missing, []string{"missing-1", "missing-2"}) {
+		t.Fatalf("unexpected missing IDs: %v", body.Missing)
+	}
+}
+
+func TestBatchGetEventsAllMissing(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, body := batchGetEvents(t, ts, []string{"nope"})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if body.Events == nil || len(body.Events) != 0 || !slices.Equal(body.Missing, []string{"nope"}) {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+}
+
+func TestBatchGetEventsRejectsInvalidIDLists(t *testing.T) {
+	ts := newTestServer(t)
+
+	tooMany := make([]string, 101)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i)
+	}
+
+	for name, ids := range map[string][]string{"empty": {}, "too many": tooMany} {
+		if status, _ := batchGetEvents(t, ts, ids); status != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", name, status, http.StatusBadRequest)
+		}
+	}
+}
+
+// Sends a POST /events request with the given Idempotency-Key header and
+// returns the response status code.
+func postEventsWithKey(t *testing.T, ts *httptest.Server, key string, events []database.EventEntry) int {
+	t.Helper()
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/events", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestPostEventsIdempotencyKeyConflict(t *testing.T) {
+	dbURL := newTestDBURL(t)
+	ts := newTestServerWithDB(t, dbURL)
+
+	// Hold the lock from another connection to the same database, as a
+	// concurrent request for the same key would.
+	db := database.New(config.Database{URL: dbURL}, nil)
+	t.Cleanup(func() { db.Close() })
+
+	if acquired, err := db.AcquireLock("events:retry-me", time.Minute); err != nil || !acquired {
+		t.Fatalf("expected to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	events := []database.EventEntry{{Type: "deploy", Data: "v1"}}
+	if status := postEventsWithKey(t, ts, "retry-me", events); status != http.StatusConflict {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusConflict)
+	}
+
+	if err := db.ReleaseLock("events:retry-me"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := postEventsWithKey(t, ts, "retry-me", events); status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// The lock is released once the request finishes, so sequential requests
+	// with the same key aren't rejected.
+	if status := postEventsWithKey(t, ts, "retry-me", events); status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestPostEventsInChunks(t *testing.T) {
+	t.Setenv("DB_BATCH_CHUNK_SIZE", "2")
+	ts := newTestServer(t)
+
+	events := make([]database.EventEntry, 5)
+	for i := range events {
+		events[i] = database.EventEntry{Type: "seq", Data: strconv.Itoa(i)}
+	}
+
+	resp := doRequest(t, ts, "POST", "/api/v1/events", events)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var responses []server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(responses) != len(events) {
+		t.Fatalf("expected %d events in the response, got %d", len(events), len(responses))
+	}
+
+	if stored := getEvents(t, ts, ""); len(stored) != len(events) {
+		t.Fatalf("expected %d events to be stored, got %d", len(events), len(stored))
+	}
+}
+
+func TestGetEventsMaxClampedToLimit(t *testing.T) {
+	t.Setenv("MAX_EVENTS_LIMIT", "3")
+	ts := newTestServer(t)
+
+	for i := range 5 {
+		postEvent(t, ts, database.EventEntry{Type: "seq", Data: strconv.Itoa(i)})
+	}
+
+	for max, want := range map[string]int{"2": 2, "3": 3, "4": 3, "1000000": 3} {
+		if got := len(getEvents(t, ts, "?max="+max)); got != want {
+			t.Errorf("max=%s: unexpected number of events: got %d want %d", max, got, want)
+		}
+	}
+}
+
+func TestGetEventsRejectsInvalidMax(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, max := range []string{"0", "-1", "lots"} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events?max="+max, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("max=%s: unexpected status code: got %v want %v", max, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+// Returns the types of the given events in order.
+func typesOf(events []database.EventEntry) []database.EventType {
+	types := make([]database.EventType, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+
+	return types
+}
+
+func TestGetEventsFilteredByType(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, eventType := range []database.EventType{"heartbeat", "alert", "deploy", "heartbeat", "alert"} {
+		postEvent(t, ts, database.EventEntry{Type: eventType, Data: "x"})
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	for query, want := range map[string][]database.EventType{
+		"":                                 {"alert", "heartbeat", "deploy", "alert", "heartbeat"},
+		"?type=":                           {"alert", "heartbeat", "deploy", "alert", "heartbeat"},
+		"?type=deploy":                     {"deploy"},
+		"?type=heartbeat&type=alert":       {"alert", "heartbeat", "alert", "heartbeat"},
+		"?type=alert&type=alert":           {"alert", "alert"},
+		"?type=heartbeat&type=missing":     {"heartbeat", "heartbeat"},
+		"?type=heartbeat&type=alert&max=3": {"alert", "heartbeat", "alert"},
+		"?type=missing":                    {},
+	} {
+		if got := typesOf(getEvents(t, ts, query)); !slices.Equal(got, want) {
+			t.Errorf("%q: unexpected types: got %v want %v", query, got, want)
+		}
+	}
+
+	// The v2 total only counts the matching events.
+	var wrapped struct {
+		Total int64 `json:"total"`
+	}
+	resp := doRequest(t, ts, "GET", "/api/v2/events?type=heartbeat&type=deploy&max=1", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&wrapped); err != nil || wrapped.Total != 3 {
+		t.Fatalf("expected a total of 3, got %d, %v", wrapped.Total, err)
+	}
+}
+
+func TestGetEventsRejectsTooManyTypes(t *testing.T) {
+	ts := newTestServer(t)
+
+	query := ""
+	for i := range 20 {
+		query += "&type=t" + strconv.Itoa(i)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/events?"+query[1:], nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code for 20 types: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/events?"+query[1:]+"&type=t20", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code for 21 types: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestGetEventsSorted(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Stored out of timestamp order, so created_at and timestamp differ.
+	for _, event := range []database.EventEntry{
+		{Type: "b", Data: "2", Timestamp: "2024-01-02T00:00:00Z"},
+		{Type: "c", Data: "1", Timestamp: "2024-01-01T00:00:00Z"},
+		{Type: "a", Data: "3", Timestamp: "2024-01-03T00:00:00Z"},
+	} {
+		postEvent(t, ts, event)
+	}
+
+	for query, want := range map[string][]string{
+		"":                                      {"3", "2", "1"},
+		"?sort_by=timestamp&order=desc":         {"3", "2", "1"},
+		"?sort_by=timestamp&order=asc":          {"1", "2", "3"},
+		"?order=asc":                            {"1", "2", "3"},
+		"?sort_by=type&order=asc":               {"3", "2", "1"},
+		"?sort_by=type&order=desc":              {"1", "2", "3"},
+		"?sort_by=type":                         {"1", "2", "3"},
+		"?sort_by=created_at&order=asc":         {"2", "1", "3"},
+		"?sort_by=created_at&order=desc":        {"3", "1", "2"},
+		"?sort_by=timestamp&order=asc&max=2":    {"1", "2"},
+		"?sort_by=type&order=asc&type=b&type=c": {"2", "1"},
+	} {
+		var got []string
+		for _, event := range getEvents(t, ts, query) {
+			got = append(got, event.Data)
+		}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: unexpected order: got %v want %v", query, got, want)
+		}
+	}
+}
+
+func TestGetEventsRejectsUnknownSorts(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, query := range []string{
+		"sort_by=source",
+		"sort_by=severity",
+		"sort_by=Data",
+		"sort_by=timestamp%3B%20DROP%20TABLE%20Events",
+		"sort_by=(SELECT%201)",
+		"order=up",
+		"order=desc%2C%20ID",
+	} {
+		if resp := doRequest(t, ts, "GET", "/api/v1/events?"+query, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// The table is still there.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+}
+
+func TestPurgeEventsRequiresBothSafeguards(t *testing.T) {
+	for name, tc := range map[string]struct {
+		allowPurge string
+		confirm    string
+	}{
+		"neither":        {},
+		"env flag only":  {allowPurge: "true"},
+		"confirm only":   {confirm: "yes-delete-all-events"},
+		"wrong phrase":   {allowPurge: "true", confirm: "yes"},
+		"env flag false": {allowPurge: "false", confirm: "yes-delete-all-events"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("ALLOW_PURGE", tc.allowPurge)
+			ts := newTestServer(t)
+			postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "keep me"})
+
+			resp := doRequest(t, ts, "DELETE", "/api/v1/events/all?confirm="+tc.confirm, nil)
+			if resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusForbidden)
+			}
+
+			if events := getEvents(t, ts, ""); len(events) != 1 {
+				t.Fatalf("expected the event to survive, got %d events", len(events))
+			}
+		})
+	}
+}
+
+func TestPurgeEventsEmptiesTable(t *testing.T) {
+	t.Setenv("ALLOW_PURGE", "true")
+	ts := newTestServer(t)
+
+	for i := range 3 {
+		postEvent(t, ts, database.EventEntry{Type: "seq", Data: strconv.Itoa(i)})
+	}
+
+	resp := doRequest(t, ts, "DELETE", "/api/v1/events/all?confirm=yes-delete-all-events", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var body struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Deleted != 3 {
+		t.Fatalf("unexpected number of deleted events: got %d want 3", body.Deleted)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 0 {
+		t.Fatalf("expected no events after purging, got %d", len(events))
+	}
+}
+
+func TestGetEventByID(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	resp := doRequest(t, ts, "GET", "/api/v1/event/"+created.ID, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var found database.EventEntry
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if found != created {
+		t.Fatalf("unexpected event: got %+v want %+v", found, created)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/event/missing", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code for a missing event: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestGetOldestEvent(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/oldest", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code for an empty table: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+
+	postEvent(t, ts, database.EventEntry{Type: "seq", Data: "newer", Timestamp: "2024-01-02T00:00:00Z"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "newest", Timestamp: "2024-01-03T00:00:00Z"})
+	oldest := postEvent(t, ts, database.EventEntry{Type: "seq", Data: "oldest", Timestamp: "2024-01-01T00:00:00Z"})
+
+	var found database.EventEntry
+	decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/events/oldest", nil), http.StatusOK, &found)
+	if found != oldest {
+		t.Fatalf("unexpected event: got %+v want %+v", found, oldest)
+	}
+
+	decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/events/oldest?type=deploy", nil), http.StatusOK, &found)
+	if found.Data != "newest" {
+		t.Fatalf("unexpected event for the deploy type: %+v", found)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/events/oldest?type=missing", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code for a type without events: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
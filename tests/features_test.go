@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/client"
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestFeatureFlagsEndpoint(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	t.Setenv("FEATURE_DEDUP", "false")
+	ts := newTestServer(t)
+
+	var flags config.FeatureFlags
+	decodeStrict(t, doAdminRequest(t, ts, "GET", "/api/v1/admin/features"), http.StatusOK, &flags)
+
+	if flags != (config.FeatureFlags{Dedup: false, HMACAuth: true, SchemaValidation: true}) {
+		t.Fatalf("unexpected feature flags: %+v", flags)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/admin/features", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code without admin credentials: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestSchemaValidationFeatureDisabled(t *testing.T) {
+	dbURL := newTestDBURL(t)
+
+	// Register the schema while the feature is on, then restart with it off.
+	ts := newTestServerWithDB(t, dbURL)
+	if resp := doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code registering: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	t.Setenv("FEATURE_SCHEMA_VALIDATION", "false")
+	ts = newTestServerWithDB(t, dbURL)
+
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		if resp := doRequest(t, ts, method, "/api/v1/schemas/deploy", deploySchema); resp.StatusCode != http.StatusNotImplemented {
+			t.Fatalf("%s: unexpected status code: got %v want %v", method, resp.StatusCode, http.StatusNotImplemented)
+		}
+	}
+
+	// Data that doesn't match the registered schema is accepted.
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: `{"version":1}`})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code creating an event: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+}
+
+func TestDedupFeatureDisabled(t *testing.T) {
+	t.Setenv("FEATURE_DEDUP", "false")
+	dbURL := newTestDBURL(t)
+	ts := newTestServerWithDB(t, dbURL)
+
+	// A held lock on the key no longer rejects the request.
+	db := database.New(config.Database{URL: dbURL}, nil)
+	t.Cleanup(func() { db.Close() })
+
+	if acquired, err := db.AcquireLock("events:retry-me", time.Minute); err != nil || !acquired {
+		t.Fatalf("expected to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	events := []database.EventEntry{{Type: "deploy", Data: "v1"}}
+	if status := postEventsWithKey(t, ts, "retry-me", events); status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestHMACAuthFeatureDisabled(t *testing.T) {
+	t.Setenv("FEATURE_HMAC_AUTH", "false")
+	ts := newHMACTestServer(t)
+
+	// Basic Auth is used instead of signatures.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	req := newEventRequest(t, ts, `{"type":"deploy","data":"v2"}`)
+	if err := client.SignRequest(req, testHMACSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := sendRequest(t, req); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code for a signed request: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+func TestFeedEvents(t *testing.T) {
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1.0.0"})
+	second := postEvent(t, ts, database.EventEntry{Type: "alert", Data: "cpu high"})
+
+	resp := doRequest(t, ts, "GET", "/api/v1/feed/events", nil)
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/atom+xml") {
+		t.Fatalf("unexpected content type: %q", contentType)
+	}
+
+	var feed server.AtomFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Link.Rel != "self" || feed.Link.Href != ts.URL+"/api/v1/feed/events" {
+		t.Errorf("unexpected self link: %+v", feed.Link)
+	}
+
+	if len(feed.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(feed.Entries))
+	}
+
+	// Entries are ordered newest first.
+	for i, event := range []database.EventEntry{second, first} {
+		entry := feed.Entries[i]
+
+		if entry.ID != "urn:shion:event:"+event.ID {
+			t.Errorf("entry %d: unexpected id %q", i, entry.ID)
+		}
+		if entry.Category.Term != string(event.Type) {
+			t.Errorf("entry %d: unexpected category %q", i, entry.Category.Term)
+		}
+		if entry.Content.Body != event.Data {
+			t.Errorf("entry %d: unexpected content %q", i, entry.Content.Body)
+		}
+		if entry.Updated != event.Timestamp {
+			t.Errorf("entry %d: unexpected updated %q", i, entry.Updated)
+		}
+	}
+}
+
+func TestFeedEventsFilteredByType(t *testing.T) {
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1.0.0"})
+	alert := postEvent(t, ts, database.EventEntry{Type: "alert", Data: "cpu high"})
+
+	resp := doRequest(t, ts, "GET", "/api/v1/feed/events?type=alert", nil)
+
+	var feed server.AtomFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Link.Href != ts.URL+"/api/v1/feed/events?type=alert" {
+		t.Errorf("expected the self link to keep the filter, got %q", feed.Link.Href)
+	}
+
+	if len(feed.Entries) != 1 || feed.Entries[0].ID != "urn:shion:event:"+alert.ID {
+		t.Fatalf("expected only the alert event, got %+v", feed.Entries)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Decodes a response into generic JSON objects so the fields that are present
+// can be checked, failing the test unless it has the given status code.
+func decodeObjects(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetEventsFields(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	var events []map[string]any
+	decodeObjects(t, doRequest(t, ts, "GET", "/api/v1/events?fields=id,type", nil), http.StatusOK, &events)
+
+	want := []map[string]any{{"id": created.ID, "type": "deploy"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("unexpected events: got %v want %v", events, want)
+	}
+
+	// The v2 envelope is kept around the projected events.
+	var wrapped struct {
+		EventEntry []map[string]any `json:"event_entry"`
+		Total      int64            `json:"total"`
+	}
+	decodeObjects(t, doRequest(t, ts, "GET", "/api/v2/events?fields=data", nil), http.StatusOK, &wrapped)
+
+	if wrapped.Total != 1 || !reflect.DeepEqual(wrapped.EventEntry, []map[string]any{{"data": "v1"}}) {
+		t.Fatalf("unexpected enveloped events: %+v", wrapped)
+	}
+}
+
+func TestGetEventFields(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	var event map[string]any
+	decodeObjects(t, doRequest(t, ts, "GET", "/api/v1/event/"+created.ID+"?fields=timestamp", nil), http.StatusOK, &event)
+
+	if len(event) != 1 || event["timestamp"] != created.Timestamp {
+		t.Fatalf("unexpected event: %v", event)
+	}
+
+	// Every field is returned without ?fields=.
+	var full database.EventEntry
+	decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/event/"+created.ID+"?fields=", nil), http.StatusOK, &full)
+
+	if full != created {
+		t.Fatalf("unexpected event: got %+v want %+v", full, created)
+	}
+}
+
+func TestFieldsRejectsUnknownNames(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for _, path := range []string{
+		"/api/v1/events?fields=id,password",
+		"/api/v1/events?fields=ID",
+		"/api/v1/event/" + created.ID + "?fields=type,DROP%20TABLE%20Events",
+		"/api/v1/events/recent?fields=rowid",
+	} {
+		if resp := doRequest(t, ts, "GET", path, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", path, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// A request received by a forward target.
+type forwardedRequest struct {
+	path     string
+	username string
+	password string
+	event    database.EventEntry
+}
+
+// Starts a forward target that fails the first failures requests with a 500
+// and accepts the rest, sending each request it receives on the returned
+// channel.
+func newForwardTarget(t *testing.T, failures int32) (*httptest.Server, chan forwardedRequest) {
+	t.Helper()
+
+	requests := make(chan forwardedRequest, 16)
+	var received atomic.Int32
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		request := forwardedRequest{path: r.URL.Path, username: username, password: password}
+
+		if err := json.NewDecoder(r.Body).Decode(&request.event); err != nil {
+			t.Error(err)
+		}
+		requests <- request
+
+		if received.Add(1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(target.Close)
+
+	return target, requests
+}
+
+// Waits for the next forwarded request, failing the test if none arrives within
+// a few seconds.
+func awaitForwardedRequest(t *testing.T, requests chan forwardedRequest) forwardedRequest {
+	t.Helper()
+
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a forwarded event")
+	}
+
+	return forwardedRequest{}
+}
+
+func TestForwardCreatedEvents(t *testing.T) {
+	target, requests := newForwardTarget(t, 0)
+
+	t.Setenv("FORWARD_URL", target.URL)
+	t.Setenv("FORWARD_USERNAME", "standby")
+	t.Setenv("FORWARD_PASSWORD", "standby-password")
+	ts := newTestServer(t)
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	request := awaitForwardedRequest(t, requests)
+
+	if request.path != "/api/v1/event" {
+		t.Errorf("unexpected path: got %q want %q", request.path, "/api/v1/event")
+	}
+
+	if request.username != "standby" || request.password != "standby-password" {
+		t.Errorf("unexpected credentials: got %q:%q", request.username, request.password)
+	}
+
+	if request.event != created {
+		t.Errorf("unexpected event: got %+v want %+v", request.event, created)
+	}
+}
+
+func TestForwardRetriesFailedForwards(t *testing.T) {
+	target, requests := newForwardTarget(t, 2)
+
+	t.Setenv("FORWARD_URL", target.URL)
+	t.Setenv("FORWARD_RETRY_BACKOFF", "10ms")
+	ts := newTestServer(t)
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	// Two failed attempts followed by the one that's accepted.
+	for i := 0; i < 3; i++ {
+		if request := awaitForwardedRequest(t, requests); request.event.ID != created.ID {
+			t.Fatalf("unexpected event on attempt %d: got %q want %q", i+1, request.event.ID, created.ID)
+		}
+	}
+
+	select {
+	case request := <-requests:
+		t.Fatalf("unexpected attempt after the event was accepted: %+v", request)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestForwardGivesUpAfterMaxRetries(t *testing.T) {
+	target, requests := newForwardTarget(t, 100)
+
+	t.Setenv("FORWARD_URL", target.URL)
+	t.Setenv("FORWARD_RETRY_BACKOFF", "10ms")
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	// The first attempt and 3 retries.
+	for i := 0; i < 4; i++ {
+		awaitForwardedRequest(t, requests)
+	}
+
+	select {
+	case request := <-requests:
+		t.Fatalf("unexpected attempt after the last retry: %+v", request)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// The local event is kept.
+	resp := doRequest(t, ts, "GET", "/api/v1/events", nil)
+	var events []database.EventEntry
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Errorf("expected the event to be kept locally, got %d events", len(events))
+	}
+}
+
+func TestForwardToSecondaryInstance(t *testing.T) {
+	secondary := newTestServer(t)
+
+	t.Setenv("FORWARD_URL", secondary.URL)
+	t.Setenv("FORWARD_USERNAME", testUsername)
+	t.Setenv("FORWARD_PASSWORD", testPassword)
+	srv, primary := newTestHTTPServer(t, newTestDBURL(t))
+
+	created := postEvent(t, primary, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	// Shutting down waits for buffered events to be forwarded.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	resp := doRequest(t, secondary, "GET", "/api/v1/event/"+created.ID, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code from the secondary: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var forwarded database.EventEntry
+	if err := json.NewDecoder(resp.Body).Decode(&forwarded); err != nil {
+		t.Fatal(err)
+	}
+
+	if forwarded.ID != created.ID || forwarded.Type != created.Type || forwarded.Data != created.Data {
+		t.Errorf("unexpected event on the secondary: got %+v want %+v", forwarded, created)
+	}
+}
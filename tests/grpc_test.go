@@ -0,0 +1,277 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/client"
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+	shionv1 "github.com/4lch4/shion-api/proto/shion/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Starts a test server serving gRPC on a random local port alongside the HTTP
+// API, returning the server, the HTTP test server, and the gRPC address.
+func newTestGRPCServer(t *testing.T) (*server.HTTPServer, *httptest.Server, string) {
+	t.Helper()
+
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.ServeGRPC(lis)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return srv, ts, lis.Addr().String()
+}
+
+// Connects to the gRPC server at the given address with the given options,
+// closing the connection when the test finishes.
+func dialGRPC(t *testing.T, addr string, opts ...grpc.DialOption) shionv1.EventServiceClient {
+	t.Helper()
+
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return shionv1.NewEventServiceClient(conn)
+}
+
+// Connects to the gRPC server at the given address with the test credentials.
+func newTestGRPCClient(t *testing.T, addr string) shionv1.EventServiceClient {
+	t.Helper()
+
+	return dialGRPC(t, addr, grpc.WithPerRPCCredentials(client.BasicAuth(testUsername, testPassword, true)))
+}
+
+// Fails the test unless err is a gRPC status with the given code.
+func expectGRPCCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+
+	if got := status.Code(err); got != want {
+		t.Fatalf("unexpected status code: got %v want %v (%v)", got, want, err)
+	}
+}
+
+func TestGRPCRequiresCredentials(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", "admin")
+	t.Setenv("ADMIN_PASSWORD", "admin-password")
+	_, _, addr := newTestGRPCServer(t)
+	ctx := context.Background()
+
+	_, err := dialGRPC(t, addr).GetEvent(ctx, &shionv1.GetEventRequest{Id: clientEventID})
+	expectGRPCCode(t, err, codes.Unauthenticated)
+
+	wrong := dialGRPC(t, addr, grpc.WithPerRPCCredentials(client.BasicAuth(testUsername, "wrong", true)))
+	_, err = wrong.GetEvent(ctx, &shionv1.GetEventRequest{Id: clientEventID})
+	expectGRPCCode(t, err, codes.Unauthenticated)
+
+	// Streaming RPCs are guarded the same way.
+	stream, err := wrong.Subscribe(ctx, &shionv1.SubscribeRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	expectGRPCCode(t, err, codes.Unauthenticated)
+
+	// The API and admin credentials are both accepted.
+	_, err = newTestGRPCClient(t, addr).GetEvent(ctx, &shionv1.GetEventRequest{Id: clientEventID})
+	expectGRPCCode(t, err, codes.NotFound)
+
+	admin := dialGRPC(t, addr, grpc.WithPerRPCCredentials(client.BasicAuth("admin", "admin-password", true)))
+	_, err = admin.GetEvent(ctx, &shionv1.GetEventRequest{Id: clientEventID})
+	expectGRPCCode(t, err, codes.NotFound)
+}
+
+func TestGRPCCreateAndGetEvent(t *testing.T) {
+	_, ts, addr := newTestGRPCServer(t)
+	rpc := newTestGRPCClient(t, addr)
+	ctx := context.Background()
+
+	created, err := rpc.CreateEvent(ctx, &shionv1.CreateEventRequest{Event: &shionv1.Event{Type: "deploy", Data: "v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.GetId() == "" || created.GetTimestamp() == "" {
+		t.Fatalf("expected the ID and timestamp to be filled in, got %+v", created)
+	}
+
+	got, err := rpc.GetEvent(ctx, &shionv1.GetEventRequest{Id: created.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.GetType() != "deploy" || got.GetData() != "v1" || got.GetTimestamp() != created.GetTimestamp() {
+		t.Errorf("unexpected event: got %+v want %+v", got, created)
+	}
+
+	// The event is stored in the same database as the HTTP API.
+	if events := getEvents(t, ts, ""); len(events) != 1 || events[0].ID != created.GetId() {
+		t.Errorf("expected the event to be visible over HTTP, got %+v", events)
+	}
+
+	_, err = rpc.CreateEvent(ctx, &shionv1.CreateEventRequest{Event: &shionv1.Event{Data: "no type"}})
+	expectGRPCCode(t, err, codes.InvalidArgument)
+}
+
+func TestGRPCCreateEventsStream(t *testing.T) {
+	_, ts, addr := newTestGRPCServer(t)
+	rpc := newTestGRPCClient(t, addr)
+
+	stream, err := rpc.CreateEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"0", "1", "2"} {
+		if err := stream.Send(&shionv1.CreateEventsRequest{Event: &shionv1.Event{Type: "seq", Data: data}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.GetEvents()) != 3 || len(resp.GetFailedChunks()) != 0 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 3 {
+		t.Fatalf("expected 3 events to be stored, got %d", len(events))
+	}
+
+	// Nothing is created if any event in the stream is invalid.
+	stream, err = rpc.CreateEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Send(&shionv1.CreateEventsRequest{Event: &shionv1.Event{Type: "seq", Data: "3"}})
+	stream.Send(&shionv1.CreateEventsRequest{Event: &shionv1.Event{Data: "no type"}})
+
+	_, err = stream.CloseAndRecv()
+	expectGRPCCode(t, err, codes.InvalidArgument)
+
+	if events := getEvents(t, ts, ""); len(events) != 3 {
+		t.Fatalf("expected the invalid stream not to create events, got %d", len(events))
+	}
+}
+
+func TestGRPCListEvents(t *testing.T) {
+	_, ts, addr := newTestGRPCServer(t)
+	rpc := newTestGRPCClient(t, addr)
+	ctx := context.Background()
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	postEvent(t, ts, database.EventEntry{Type: "build", Data: "b1"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+
+	tests := []struct {
+		name string
+		req  *shionv1.ListEventsRequest
+		want []string
+	}{
+		{"latest", &shionv1.ListEventsRequest{}, []string{"v2", "b1", "v1"}},
+		{"max", &shionv1.ListEventsRequest{Max: 2}, []string{"v2", "b1"}},
+		{"type", &shionv1.ListEventsRequest{Type: "deploy"}, []string{"v2", "v1"}},
+		{"after", &shionv1.ListEventsRequest{AfterId: first.ID}, []string{"b1", "v2"}},
+		{"after and type", &shionv1.ListEventsRequest{AfterId: first.ID, Type: "deploy"}, []string{"v2"}},
+	}
+
+	for _, test := range tests {
+		resp, err := rpc.ListEvents(ctx, test.req)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		var got []string
+		for _, event := range resp.GetEvents() {
+			got = append(got, event.GetData())
+		}
+
+		if len(got) != len(test.want) {
+			t.Errorf("%s: unexpected events: got %v want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: unexpected events: got %v want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+
+	_, err := rpc.ListEvents(ctx, &shionv1.ListEventsRequest{Max: -1})
+	expectGRPCCode(t, err, codes.InvalidArgument)
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	srv, ts, addr := newTestGRPCServer(t)
+	rpc := newTestGRPCClient(t, addr)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	postEvent(t, ts, database.EventEntry{Type: "build", Data: "b1"})
+	missed := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := rpc.Subscribe(ctx, &shionv1.SubscribeRequest{Types: []string{"deploy"}, LastEventId: first.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The missed event is replayed once the subscription is registered, so
+	// events created after it are streamed live.
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetEvent().GetId() != missed.ID {
+		t.Fatalf("unexpected replayed event: got %+v want %+v", resp.GetEvent(), missed)
+	}
+
+	postEvent(t, ts, database.EventEntry{Type: "build", Data: "b2"})
+	live, err := newTestGRPCClient(t, addr).CreateEvent(ctx, &shionv1.CreateEventRequest{Event: &shionv1.Event{Type: "deploy", Data: "v3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetEvent().GetId() != live.GetId() {
+		t.Fatalf("unexpected live event: got %+v want %+v", resp.GetEvent(), live)
+	}
+
+	// Shutting down ends the stream and waits for it to close.
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	_, err = stream.Recv()
+	expectGRPCCode(t, err, codes.Unavailable)
+}
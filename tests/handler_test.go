@@ -6,28 +6,30 @@ import (
 	"testing"
 
 	"github.com/4lch4/shion-api/internal/server"
-	"github.com/gin-gonic/gin"
 )
 
-func TestHelloWorldHandler(t *testing.T) {
-	s := &server.Server{}
-	r := gin.New()
-	r.GET("/", s.HelloWorldHandler)
+func TestLivenessHandler(t *testing.T) {
+	t.Setenv("API_USERNAME", testUsername)
+	t.Setenv("API_PASSWORD", testPassword)
+	t.Setenv("TURSO_DATABASE_URL", newTestDBURL(t))
+
+	s := server.NewServer(newTestConfig(t))
 	// Create a test HTTP request
-	req, err := http.NewRequest("GET", "/", nil)
+	req, err := http.NewRequest("GET", "/api/v1/health/liveness", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.SetBasicAuth(testUsername, testPassword)
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
 	// Serve the HTTP request
-	r.ServeHTTP(rr, req)
+	s.Handler.ServeHTTP(rr, req)
 	// Check the status code
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 	// Check the response body
-	expected := "{\"message\":\"Hello World\"}"
+	expected := "OK"
 	if rr.Body.String() != expected {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
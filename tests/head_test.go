@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestHeadRequests(t *testing.T) {
+	ts := newTestServer(t)
+	event := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/api/v1/event/" + event.ID, http.StatusOK},
+		{"/api/v1/event/missing", http.StatusNotFound},
+		{"/api/v1/events", http.StatusOK},
+		{"/api/v1/events?pretty=true", http.StatusOK},
+		{"/api/v2/event/" + event.ID, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			status, body := getBody(t, ts.URL+tt.path)
+			if status != tt.wantStatus {
+				t.Fatalf("unexpected GET status code: got %v want %v", status, tt.wantStatus)
+			}
+
+			resp := doRequest(t, ts, http.MethodHead, tt.path, nil)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("unexpected HEAD status code: got %v want %v", resp.StatusCode, tt.wantStatus)
+			}
+
+			if got := resp.Header.Get("Content-Type"); got != "application/json; charset=utf-8" {
+				t.Errorf("unexpected Content-Type: %q", got)
+			}
+
+			if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(body)) {
+				t.Errorf("expected a Content-Length of %d to match the GET body, got %q", len(body), got)
+			}
+
+			head, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(head) != 0 {
+				t.Errorf("expected no body, got %q", head)
+			}
+		})
+	}
+}
+
+func TestHeadRequiresAuth(t *testing.T) {
+	ts := newTestServer(t)
+
+	req, err := http.NewRequest(http.MethodHead, ts.URL+"/api/v1/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
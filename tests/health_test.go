@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// The GET /health/db response body. Latencies are left as the strings the
+// server writes them as.
+type dbHealthResponse struct {
+	Status     string `json:"status"`
+	Components map[string]struct {
+		Status  string `json:"status"`
+		Latency string `json:"latency"`
+	} `json:"components"`
+}
+
+// Sends a GET /health/db request and decodes the response, returning the
+// status code along with it.
+func getDBHealth(t *testing.T, url string) (int, dbHealthResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url+"/api/v1/health/db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var health dbHealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, health
+}
+
+func TestDBHealthReportsComponents(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, health := getDBHealth(t, ts.URL)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if health.Status != database.HealthUp {
+		t.Fatalf("unexpected status: got %q want %q", health.Status, database.HealthUp)
+	}
+
+	for _, name := range []string{"database", "schema"} {
+		if component, ok := health.Components[name]; !ok || component.Status != database.HealthUp {
+			t.Errorf("expected the %s component to be up, got %+v", name, component)
+		}
+	}
+}
+
+func TestDBHealthDownWhenTableIsMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shion.db")
+	ts := newTestServerWithDB(t, "file:"+path)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DROP TABLE annotations"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, health := getDBHealth(t, ts.URL)
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	if health.Status != database.HealthDown || health.Components["schema"].Status != database.HealthDown {
+		t.Fatalf("expected the schema component to be down, got %+v", health)
+	}
+
+	if health.Components["database"].Status != database.HealthUp {
+		t.Errorf("expected the database component to still be up, got %+v", health.Components["database"])
+	}
+}
@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// The basic auth credentials used by every test server.
+const (
+	testUsername = "shion"
+	testPassword = "hunter2"
+)
+
+// Starts a new test server backed by a fresh SQLite database in a temporary
+// directory. The server is closed automatically when the test finishes.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return newTestServerWithDB(t, newTestDBURL(t))
+}
+
+// Returns the URL of a fresh SQLite database in a temporary directory.
+func newTestDBURL(t *testing.T) string {
+	t.Helper()
+
+	return "file:" + filepath.Join(t.TempDir(), "shion.db")
+}
+
+// Loads the configuration from the environment the test has set up, failing
+// the test if it's invalid.
+func newTestConfig(t *testing.T) config.Config {
+	t.Helper()
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+// Starts a new test server backed by the database at the given URL, which
+// allows multiple servers to share a database. The server is closed
+// automatically when the test finishes.
+func newTestServerWithDB(t *testing.T, dbURL string) *httptest.Server {
+	t.Helper()
+
+	_, ts := newTestHTTPServer(t, dbURL)
+
+	return ts
+}
+
+// Starts a new test server backed by the database at the given URL the same as
+// newTestServerWithDB, and also returns the underlying server so tests can
+// shut it down. Returns once the server is ready to accept requests.
+func newTestHTTPServer(t *testing.T, dbURL string) (*server.HTTPServer, *httptest.Server) {
+	t.Helper()
+
+	t.Setenv("API_USERNAME", testUsername)
+	t.Setenv("API_PASSWORD", testPassword)
+	t.Setenv("TURSO_DATABASE_URL", dbURL)
+
+	srv := server.NewServer(newTestConfig(t))
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	if err := <-srv.WarmedUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, ts
+}
+
+// Sends an authenticated request to the test server, failing the test if the
+// request cannot be sent.
+func doRequest(t *testing.T, ts *httptest.Server, method, path string, body any) *http.Response {
+	t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, &reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// Creates an event through the POST /event endpoint, failing the test if the
+// event isn't created.
+func postEvent(t *testing.T, ts *httptest.Server, event database.EventEntry) database.EventEntry {
+	t.Helper()
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", event)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code creating event: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	var body server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.EventEntry[0]
+}
+
+// Opens an authenticated WebSocket connection to the given path on the test
+// server. The connection is closed automatically when the test finishes.
+func dialWS(t *testing.T, ts *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := tryDialWS(t, ts, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+// Opens an authenticated WebSocket connection to the test server the same as
+// dialWS, but returns the handshake response and error instead of failing the
+// test so refused connections can be inspected.
+func tryDialWS(t *testing.T, ts *httptest.Server, path string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+
+	header := http.Header{}
+	req := &http.Request{Header: header}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, header)
+	if err != nil {
+		return nil, resp, err
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, resp, nil
+}
+
+// Reads the next JSON frame from the WebSocket connection into v, failing the
+// test if nothing arrives within a few seconds.
+func readWSFrame(t *testing.T, conn *websocket.Conn, v any) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Fetches the latest events through the GET /events endpoint, failing the
+// test if the request fails.
+func getEvents(t *testing.T, ts *httptest.Server, query string) []database.EventEntry {
+	t.Helper()
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events"+query, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code listing events: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var events []database.EventEntry
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/client"
+)
+
+const testHMACSecret = "machine-secret"
+
+// Starts a test server that requires HMAC-signed requests.
+func newHMACTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	t.Setenv("HMAC_SECRET", testHMACSecret)
+
+	return newTestServer(t)
+}
+
+// Builds an unsigned POST /event request with the given body.
+func newEventRequest(t *testing.T, ts *httptest.Server, body string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/event", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req
+}
+
+// Sends the request, failing the test if it can't be sent, and returns the
+// response status code.
+func sendRequest(t *testing.T, req *http.Request) int {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestHMACValidSignature(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	req := newEventRequest(t, ts, `{"type":"deploy","data":"v1"}`)
+	if err := client.SignRequest(req, testHMACSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := sendRequest(t, req); status != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusCreated)
+	}
+}
+
+func TestHMACTamperedBodyRejected(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	signed := newEventRequest(t, ts, `{"type":"deploy","data":"v1"}`)
+	if err := client.SignRequest(signed, testHMACSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	req := newEventRequest(t, ts, `{"type":"deploy","data":"v2"}`)
+	req.Header.Set(client.TimestampHeader, signed.Header.Get(client.TimestampHeader))
+	req.Header.Set(client.SignatureHeader, signed.Header.Get(client.SignatureHeader))
+
+	if status := sendRequest(t, req); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestHMACSignatureReplayedOnAnotherPathRejected(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	signed := newEventRequest(t, ts, `{"type":"deploy","data":"v1"}`)
+	if err := client.SignRequest(signed, testHMACSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same method and body sent to another endpoint, and to the same
+	// endpoint with a different query.
+	for _, target := range []string{"/api/v1/events/import", "/api/v1/event?unique=1"} {
+		req, err := http.NewRequest("POST", ts.URL+target, bytes.NewBufferString(`{"type":"deploy","data":"v1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(client.TimestampHeader, signed.Header.Get(client.TimestampHeader))
+		req.Header.Set(client.SignatureHeader, signed.Header.Get(client.SignatureHeader))
+
+		if status := sendRequest(t, req); status != http.StatusUnauthorized {
+			t.Errorf("%s: unexpected status code: got %v want %v", target, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestHMACWrongSecretRejected(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	req := newEventRequest(t, ts, `{"type":"deploy","data":"v1"}`)
+	if err := client.SignRequest(req, "not-the-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := sendRequest(t, req); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestHMACExpiredTimestampRejected(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	body := `{"type":"deploy","data":"v1"}`
+	timestamp := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	req := newEventRequest(t, ts, body)
+	req.Header.Set(client.TimestampHeader, timestamp)
+	req.Header.Set(client.SignatureHeader, "sha256="+client.Signature(testHMACSecret, timestamp, req.Method, req.URL.RequestURI(), []byte(body)))
+
+	if status := sendRequest(t, req); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestHMACReplacesBasicAuth(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected Basic Auth to be rejected, got %v", resp.StatusCode)
+	}
+}
+
+func TestHMACAdminSecret(t *testing.T) {
+	t.Setenv("HMAC_ADMIN_SECRET", "admin-secret")
+	ts := newHMACTestServer(t)
+
+	for secret, want := range map[string]int{
+		testHMACSecret: http.StatusForbidden,
+		"admin-secret": http.StatusOK,
+	} {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/admin/features", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SignRequest(req, secret); err != nil {
+			t.Fatal(err)
+		}
+
+		if status := sendRequest(t, req); status != want {
+			t.Errorf("signed with %q: unexpected status code: got %v want %v", secret, status, want)
+		}
+	}
+
+	// Events can be created with either secret.
+	req := newEventRequest(t, ts, `{"type":"deploy","data":"v1"}`)
+	if err := client.SignRequest(req, "admin-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if status := sendRequest(t, req); status != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusCreated)
+	}
+}
+
+func TestHMACRejectsOversizedBodies(t *testing.T) {
+	ts := newHMACTestServer(t)
+
+	req := newEventRequest(t, ts, strings.Repeat("x", 32<<20+1))
+	req.Header.Set(client.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(client.SignatureHeader, "sha256=00")
+
+	if status := sendRequest(t, req); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// Returns an HTTP/2 client that speaks cleartext HTTP/2 (h2c) with prior
+// knowledge, i.e. without attempting an HTTP/1.1 upgrade first.
+func newH2CClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func TestHTTP2CleartextNegotiation(t *testing.T) {
+	ts := newTestServer(t)
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/health/liveness", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	resp, err := newH2CClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("unexpected protocol: got %v want HTTP/2.0", resp.Proto)
+	}
+}
+
+func TestHTTP2Disabled(t *testing.T) {
+	t.Setenv("HTTP2_ENABLED", "false")
+	ts := newTestServer(t)
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/health/liveness", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	if _, err := newH2CClient().Do(req); err == nil {
+		t.Error("expected HTTP/2 prior knowledge request to fail when HTTP2_ENABLED=false")
+	}
+}
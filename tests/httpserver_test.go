@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHTTPServerSettingsFromConfig(t *testing.T) {
+	t.Setenv("HTTP_READ_TIMEOUT", "2m")
+	t.Setenv("HTTP_READ_HEADER_TIMEOUT", "5s")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "0")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "3m")
+	t.Setenv("HTTP_MAX_HEADER_BYTES", "4096")
+	t.Setenv("APP_ENV", "development")
+	srv, _ := newTestHTTPServer(t, newTestDBURL(t))
+
+	if srv.ReadTimeout != 2*time.Minute || srv.ReadHeaderTimeout != 5*time.Second || srv.WriteTimeout != 0 || srv.IdleTimeout != 3*time.Minute || srv.MaxHeaderBytes != 4096 {
+		t.Fatalf("unexpected server settings: read %s, read header %s, write %s, idle %s, max header bytes %d",
+			srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout, srv.MaxHeaderBytes)
+	}
+
+	if gin.Mode() != gin.DebugMode {
+		t.Fatalf("unexpected gin mode: %s", gin.Mode())
+	}
+
+	t.Setenv("APP_ENV", "")
+	newTestHTTPServer(t, newTestDBURL(t))
+
+	if gin.Mode() != gin.ReleaseMode {
+		t.Fatalf("expected release mode by default, got %s", gin.Mode())
+	}
+}
+
+func TestGinModeFromEnv(t *testing.T) {
+	for _, test := range []struct {
+		ginMode, appEnv, want string
+	}{
+		{"", "production", gin.ReleaseMode},
+		{"", "development", gin.DebugMode},
+		{gin.DebugMode, "production", gin.DebugMode},
+		{gin.ReleaseMode, "dev", gin.ReleaseMode},
+		{gin.TestMode, "", gin.TestMode},
+	} {
+		t.Setenv("GIN_MODE", test.ginMode)
+		t.Setenv("APP_ENV", test.appEnv)
+		newTestHTTPServer(t, newTestDBURL(t))
+
+		if gin.Mode() != test.want {
+			t.Errorf("unexpected gin mode with GIN_MODE=%q and APP_ENV=%q: got %s want %s", test.ginMode, test.appEnv, gin.Mode(), test.want)
+		}
+	}
+}
+
+func TestGinModeOverride(t *testing.T) {
+	t.Setenv("GIN_MODE", "debug")
+	t.Setenv("APP_ENV", "development")
+
+	for _, mode := range []string{gin.TestMode, gin.ReleaseMode, gin.DebugMode} {
+		t.Setenv("GIN_MODE_OVERRIDE", mode)
+		newTestHTTPServer(t, newTestDBURL(t))
+
+		if gin.Mode() != mode {
+			t.Fatalf("unexpected gin mode with GIN_MODE_OVERRIDE=%s: got %s", mode, gin.Mode())
+		}
+	}
+}
+
+func TestSSEStreamOutlivesWriteTimeout(t *testing.T) {
+	t.Setenv("HTTP_WRITE_TIMEOUT", "100ms")
+	t.Setenv("SSE_KEEPALIVE_INTERVAL", "50ms")
+
+	// Wrapping the response writer mustn't stop the stream from clearing its
+	// write deadline.
+	t.Setenv("DEBUG_LOG_BODIES", "true")
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	// Serve on a real listener so the server's write timeout applies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.Serve(ln)
+
+	req, err := http.NewRequest("GET", "http://"+ln.Addr().String()+"/api/v1/events/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	stream := bufio.NewReader(resp.Body)
+
+	time.Sleep(300 * time.Millisecond)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "late"})
+
+	if frame := readSSEFrame(t, stream); frame.ID != created.ID {
+		t.Fatalf("unexpected frame: %+v", frame)
+	}
+}
@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Uploads the given file contents to the POST /events/import endpoint and
+// returns the decoded response.
+func importEvents(t *testing.T, ts *httptest.Server, contents string) server.ImportResponse {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", "events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(contents))
+	form.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/events/import", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var result server.ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestImportJSONArray(t *testing.T) {
+	ts := newTestServer(t)
+
+	result := importEvents(t, ts, `[
+		{"type": "key-down", "data": "a", "timestamp": "2023-01-02T03:04:05Z"},
+		{"type": "key-up", "data": "a", "timestamp": "2023-01-02T03:04:06Z"}
+	]`)
+
+	if result.Imported != 2 || result.Skipped != 0 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+
+	events := getEvents(t, ts, "")
+	if len(events) != 2 || events[0].Timestamp != "2023-01-02T03:04:06Z" {
+		t.Fatalf("expected the historical timestamps to be kept, got %+v", events)
+	}
+}
+
+func TestImportSkipsExistingEvents(t *testing.T) {
+	ts := newTestServer(t)
+
+	file := `[
+		{"id": "6f1c1e5e-8a7b-4d1f-9a43-2f4a5c7d9b10", "type": "key-down", "data": "a"},
+		{"type": "key-up", "data": "a", "unique_key": "key-up-a"},
+		{"type": "key-up", "data": "b", "unique_key": "key-up-b"}
+	]`
+
+	if result := importEvents(t, ts, file); result.Imported != 3 || result.Skipped != 0 {
+		t.Fatalf("unexpected result for the first import: %+v", result)
+	}
+
+	result := importEvents(t, ts, file)
+	if result.Imported != 0 || result.Skipped != 3 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected result for the second import: %+v", result)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 3 {
+		t.Fatalf("expected the events to be stored once, got %d", len(events))
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	ts := newTestServer(t)
+
+	result := importEvents(t, ts, `{"type": "key-down", "data": "a"}
+{"type": "key-up", "data": "a"}
+
+{"type": "mouse-click", "data": "left"}
+`)
+
+	if result.Imported != 3 || len(result.Errors) != 0 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 3 {
+		t.Fatalf("expected 3 imported events, got %d", len(events))
+	}
+}
+
+func TestImportReportsInvalidRecords(t *testing.T) {
+	ts := newTestServer(t)
+
+	result := importEvents(t, ts, `{"type": "key-down", "data": "a"}
+{"data": "missing type"}
+{"type": "key-up", "data": "a", "timestamp": "yesterday"}
+not json at all
+{"type": "mouse-click", "data": "left"}
+`)
+
+	if result.Imported != 2 {
+		t.Errorf("expected 2 imported events, got %d", result.Imported)
+	}
+
+	if len(result.Errors) != 3 {
+		t.Fatalf("expected 3 errors, got %+v", result.Errors)
+	}
+
+	for i, record := range []int{2, 3, 4} {
+		if result.Errors[i].Record != record {
+			t.Errorf("expected error %d to be for record %d, got %+v", i, record, result.Errors[i])
+		}
+	}
+}
+
+func TestImportReportsFailedChunksAtTheirRecord(t *testing.T) {
+	t.Setenv("DB_BATCH_CHUNK_SIZE", "2")
+	t.Setenv("DB_BATCH_ROLLBACK", "chunk")
+	dbURL := newTestDBURL(t)
+	ts := newTestServerWithDB(t, dbURL)
+
+	// Make inserting an event with the data "boom" fail, which rolls back the
+	// chunk it's in.
+	db, err := sql.Open("sqlite3", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TRIGGER fail_insert BEFORE INSERT ON Events WHEN NEW.Data = 'boom' BEGIN
+		SELECT RAISE(ABORT, 'boom');
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The malformed third record is skipped, so the failing second chunk holds
+	// the fourth and fifth records.
+	result := importEvents(t, ts, `{"type": "seq", "data": "1"}
+{"type": "seq", "data": "2"}
+not json at all
+{"type": "seq", "data": "4"}
+{"type": "seq", "data": "boom"}
+`)
+
+	if result.Imported != 2 {
+		t.Errorf("expected 2 imported events, got %d", result.Imported)
+	}
+
+	if len(result.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got %+v", result.Errors)
+	}
+
+	for i, record := range []int{3, 4} {
+		if result.Errors[i].Record != record {
+			t.Errorf("expected error %d to be for record %d, got %+v", i, record, result.Errors[i])
+		}
+	}
+}
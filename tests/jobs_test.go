@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Submits the events with POST /events?async=true, returning the response.
+func postEventsAsync(t *testing.T, ts *httptest.Server, events []database.EventEntry) *http.Response {
+	t.Helper()
+
+	return doRequest(t, ts, "POST", "/api/v1/events?async=true", events)
+}
+
+// Decodes the job in a response body.
+func decodeJob(t *testing.T, resp *http.Response) database.Job {
+	t.Helper()
+
+	var job database.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	return job
+}
+
+// Polls GET /jobs/:id until the job has finished, failing the test if it's
+// still pending or running after a few seconds.
+func awaitJob(t *testing.T, ts *httptest.Server, id string) database.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := doRequest(t, ts, "GET", "/api/v1/jobs/"+id, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+
+		job := decodeJob(t, resp)
+		if job.Status == database.JobCompleted || job.Status == database.JobFailed {
+			return job
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for job %s, last status %q", id, job.Status)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Returns the given number of sequential events.
+func seqEvents(n int) []database.EventEntry {
+	events := make([]database.EventEntry, n)
+	for i := range events {
+		events[i] = database.EventEntry{Type: "seq", Data: strconv.Itoa(i)}
+	}
+
+	return events
+}
+
+func TestAsyncEventsJobCompletes(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := postEventsAsync(t, ts, seqEvents(5))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusAccepted)
+	}
+
+	submitted := decodeJob(t, resp)
+	if submitted.ID == "" || submitted.Total != 5 {
+		t.Fatalf("unexpected job: %+v", submitted)
+	}
+
+	if location := resp.Header.Get("Location"); location != "/api/v1/jobs/"+submitted.ID {
+		t.Errorf("unexpected Location header: %q", location)
+	}
+
+	job := awaitJob(t, ts, submitted.ID)
+	if job.Status != database.JobCompleted || job.Created != 5 || job.Failed != 0 {
+		t.Fatalf("unexpected finished job: %+v", job)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 5 {
+		t.Fatalf("expected 5 events to be stored, got %d", len(events))
+	}
+}
+
+func TestAsyncEventsValidatedBeforeQueueing(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := postEventsAsync(t, ts, []database.EventEntry{{Type: "seq"}, {Data: "no type"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestGetJobUnknownID(t *testing.T) {
+	ts := newTestServer(t)
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/jobs/missing", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAsyncEventsQueueFull(t *testing.T) {
+	t.Setenv("JOB_WORKERS", "1")
+	t.Setenv("JOB_QUEUE_SIZE", "1")
+	t.Setenv("DB_BATCH_WRITE_TIMEOUT_MS", "500")
+
+	path := filepath.Join(t.TempDir(), "shion.db")
+	ts := newTestServerWithDB(t, "file:"+path)
+
+	// Make every insert run a query that never finishes on its own, so the
+	// first job holds the only slot until it times out.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TRIGGER slow_insert BEFORE INSERT ON Events BEGIN
+		SELECT count(*) FROM (WITH RECURSIVE forever(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM forever) SELECT x FROM forever);
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := postEventsAsync(t, ts, seqEvents(1))
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code for the first job: got %v want %v", resp.StatusCode, http.StatusAccepted)
+	}
+	first := decodeJob(t, resp)
+
+	resp = postEventsAsync(t, ts, seqEvents(1))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code with a full queue: got %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	job := awaitJob(t, ts, first.ID)
+	if job.Status != database.JobFailed || job.Failed != 1 || job.Error == "" {
+		t.Fatalf("expected the timed out job to fail, got %+v", job)
+	}
+
+	// The slot is freed once the job finishes.
+	if _, err := db.Exec("DROP TRIGGER slow_insert"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := postEventsAsync(t, ts, seqEvents(1)); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code after the queue drained: got %v want %v", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+func TestIncompleteJobsResumedAtStartup(t *testing.T) {
+	dbURL := newTestDBURL(t)
+	t.Setenv("TURSO_DATABASE_URL", dbURL)
+
+	// Leave a job running and another pending, as a crash would.
+	db := database.New(config.Database{URL: dbURL}, nil)
+	t.Cleanup(func() { db.Close() })
+
+	running, err := db.CreateJob(seqEvents(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StartJob(running.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := db.CreateJob(seqEvents(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServerWithDB(t, dbURL)
+
+	for _, submitted := range []database.Job{running, pending} {
+		job := awaitJob(t, ts, submitted.ID)
+		if job.Status != database.JobCompleted || job.Created != submitted.Total {
+			t.Fatalf("unexpected resumed job: %+v", job)
+		}
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 5 {
+		t.Fatalf("expected 5 events to be stored, got %d", len(events))
+	}
+}
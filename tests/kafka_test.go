@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The topic events are produced to when KAFKA_TOPIC isn't set.
+const testKafkaTopic = "shion.events"
+
+// Starts an in-memory Kafka cluster with a three partition events topic, which
+// is shut down when the test finishes.
+func newKafkaCluster(t *testing.T) *kfake.Cluster {
+	t.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(3, testKafkaTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	return cluster
+}
+
+// Consumes the given number of records from the events topic, failing the test
+// if they don't all arrive within a few seconds.
+func consumeKafkaRecords(t *testing.T, cluster *kfake.Cluster, count int) []*kgo.Record {
+	t.Helper()
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cluster.ListenAddrs()...),
+		kgo.ConsumeTopics(testKafkaTopic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var records []*kgo.Record
+	for len(records) < count {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			t.Fatalf("timed out waiting for Kafka records: got %d want %d", len(records), count)
+		}
+
+		records = append(records, fetches.Records()...)
+	}
+
+	return records
+}
+
+// Returns the decoded GET /health/kafka response.
+func getKafkaHealth(t *testing.T, ts *httptest.Server) map[string]any {
+	t.Helper()
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/kafka", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var health map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	return health
+}
+
+func TestKafkaProducesCreatedEventsKeyedByType(t *testing.T) {
+	cluster := newKafkaCluster(t)
+
+	t.Setenv("KAFKA_BROKERS", cluster.ListenAddrs()[0])
+	ts := newTestServer(t)
+
+	var created []database.EventEntry
+	for _, event := range []database.EventEntry{
+		{Type: "deploy", Data: "v1"},
+		{Type: "build", Data: "1"},
+		{Type: "deploy", Data: "v2"},
+		{Type: "deploy", Data: "v3"},
+	} {
+		created = append(created, postEvent(t, ts, event))
+	}
+
+	records := consumeKafkaRecords(t, cluster, len(created))
+
+	byID := map[string]database.EventEntry{}
+	for _, event := range created {
+		byID[event.ID] = event
+	}
+
+	var deploys []string
+	deployPartition := int32(-1)
+
+	for _, record := range records {
+		var payload database.EventEntry
+		if err := json.Unmarshal(record.Value, &payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, ok := byID[payload.ID]; !ok || payload != want {
+			t.Errorf("unexpected payload: got %+v want %+v", payload, want)
+		}
+
+		if string(record.Key) != string(payload.Type) {
+			t.Errorf("unexpected key: got %q want %q", record.Key, payload.Type)
+		}
+
+		if payload.Type == "deploy" {
+			if deployPartition != -1 && record.Partition != deployPartition {
+				t.Errorf("expected every deploy event on partition %d, got %d", deployPartition, record.Partition)
+			}
+
+			deployPartition = record.Partition
+			deploys = append(deploys, payload.Data)
+		}
+	}
+
+	if len(deploys) != 3 || deploys[0] != "v1" || deploys[1] != "v2" || deploys[2] != "v3" {
+		t.Errorf("expected deploy events in the order they were created, got %v", deploys)
+	}
+
+	health := getKafkaHealth(t, ts)
+
+	if health["enabled"] != true || health["delivery_mode"] != "best-effort" || health["produced"] != float64(4) || health["failed"] != float64(0) {
+		t.Errorf("unexpected Kafka health: %+v", health)
+	}
+}
+
+func TestKafkaBlockingModeWaitsForAck(t *testing.T) {
+	cluster := newKafkaCluster(t)
+
+	t.Setenv("KAFKA_BROKERS", cluster.ListenAddrs()[0])
+	t.Setenv("KAFKA_DELIVERY_MODE", "blocking")
+	t.Setenv("KAFKA_ACK_TIMEOUT", "500ms")
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	// The event was acknowledged before the response was sent.
+	health := getKafkaHealth(t, ts)
+
+	if health["delivery_mode"] != "blocking" || health["produced"] != float64(1) {
+		t.Errorf("unexpected Kafka health: %+v", health)
+	}
+
+	// Without a cluster the event can't be acknowledged, but it's still stored.
+	cluster.Close()
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v2"})
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadGateway)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/events", nil)
+	var events []database.EventEntry
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Errorf("expected both events to be stored, got %d", len(events))
+	}
+}
+
+func TestKafkaFlushesEventsOnShutdown(t *testing.T) {
+	cluster := newKafkaCluster(t)
+
+	t.Setenv("KAFKA_BROKERS", cluster.ListenAddrs()[0])
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	records := consumeKafkaRecords(t, cluster, 1)
+
+	var payload database.EventEntry
+	if err := json.Unmarshal(records[0].Value, &payload); err != nil || payload.ID != created.ID {
+		t.Errorf("unexpected payload: %s, %v", records[0].Value, err)
+	}
+}
+
+func TestKafkaDisabledByDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	if health := getKafkaHealth(t, ts); health["enabled"] != false {
+		t.Errorf("expected Kafka to be disabled, got %+v", health)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns an HTTP client that sends every request over the Unix domain socket
+// at the given path.
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestListenOnUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "shion.sock")
+	t.Setenv("LISTEN_ADDR", "unix://"+socket)
+	t.Setenv("LISTEN_SOCKET_MODE", "0600")
+
+	// A socket left behind by a server that didn't shut down cleanly.
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv, _ := newTestHTTPServer(t, newTestDBURL(t))
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	client := unixSocketClient(socket)
+
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req, err := http.NewRequest("GET", "http://shion/api/v1/health/liveness", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(testUsername, testPassword)
+
+		resp, err = client.Do(req)
+		if err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("couldn't reach the server over the socket: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected socket permissions: got %o want %o", info.Mode().Perm(), 0o600)
+	}
+
+	// A second server can't take over the socket while it's in use.
+	if _, err := srv.Listen(); err == nil {
+		t.Fatal("expected listening on a socket that's in use to fail")
+	}
+
+	if err := <-shutdownAsync(srv); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("unexpected error from the server: %v", err)
+	}
+
+	if _, err := os.Lstat(socket); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the socket to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestListenRefusesToReplaceOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shion.sock")
+	if err := os.WriteFile(path, []byte("not a socket"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_ADDR", "unix://"+path)
+
+	srv, _ := newTestHTTPServer(t, newTestDBURL(t))
+
+	if _, err := srv.Listen(); err == nil {
+		t.Fatal("expected listening on a regular file to fail")
+	}
+
+	if contents, err := os.ReadFile(path); err != nil || string(contents) != "not a socket" {
+		t.Fatalf("expected the file to be left alone, got %q, %v", contents, err)
+	}
+}
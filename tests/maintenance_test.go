@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Starts a test server that accepts the admin credentials, which changing
+// maintenance mode requires.
+func newMaintenanceTestServer(t *testing.T) (*server.HTTPServer, *httptest.Server) {
+	t.Helper()
+
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+
+	return newTestHTTPServer(t, newTestDBURL(t))
+}
+
+// Turns maintenance mode on or off through POST /admin/maintenance, failing
+// the test unless it succeeds.
+func setMaintenance(t *testing.T, ts *httptest.Server, body map[string]any) server.MaintenanceStatus {
+	t.Helper()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/maintenance", bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(testAdminUsername, testAdminPassword)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code changing maintenance mode: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var status server.MaintenanceStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	return status
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	srv, ts := newMaintenanceTestServer(t)
+
+	status := setMaintenance(t, ts, map[string]any{"enabled": true, "message": "migrating the database"})
+	if !status.Enabled || status.Message != "migrating the database" || status.RetryAfter != 60 || status.Since == nil {
+		t.Fatalf("unexpected status after turning maintenance mode on: %+v", status)
+	}
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "60" {
+		t.Fatalf("unexpected response during maintenance: %v with Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "migrating the database" {
+		t.Errorf("expected the maintenance message, got %v", body)
+	}
+
+	if _, resp, err := tryDialWS(t, ts, "/api/v1/ws/events"); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected WebSocket connections to be refused with a 503, got %v", err)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected liveness status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	code, readiness := getReadiness(t, srv.Handler)
+	if code != http.StatusServiceUnavailable || !slices.Equal(readiness.Failing, []string{"maintenance"}) {
+		t.Errorf("expected only the maintenance readiness check to fail, got %v %+v", code, readiness)
+	}
+
+	resp = doAdminRequest(t, ts, "GET", "/api/v1/admin/maintenance")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Message != "migrating the database" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	status = setMaintenance(t, ts, map[string]any{"enabled": false})
+	if status.Enabled || status.Since != nil || status.Message != "the API is down for maintenance, try again later" {
+		t.Fatalf("unexpected status after turning maintenance mode off: %+v", status)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/events", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code after maintenance: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	if code, _ := getReadiness(t, srv.Handler); code != http.StatusOK {
+		t.Errorf("expected the server to be ready after maintenance, got %v", code)
+	}
+}
+
+func TestMaintenanceModeFromEnv(t *testing.T) {
+	t.Setenv("MAINTENANCE_MODE", "true")
+	t.Setenv("MAINTENANCE_MESSAGE", "back soon")
+	t.Setenv("MAINTENANCE_RETRY_AFTER", "90s")
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", map[string]any{"type": "deploy", "data": "v1"})
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "90" {
+		t.Fatalf("unexpected response during maintenance: %v with Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "back soon" {
+		t.Errorf("expected the configured message, got %v", body)
+	}
+}
+
+func TestMaintenanceModeLetsInFlightRequestsFinish(t *testing.T) {
+	t.Setenv("SSE_KEEPALIVE_INTERVAL", "50ms")
+	_, ts := newMaintenanceTestServer(t)
+
+	// The stream was opened before maintenance mode was turned on, so it keeps
+	// being served.
+	stream := openSSEStream(t, ts, "/api/v1/events/stream", "")
+	setMaintenance(t, ts, map[string]any{"enabled": true})
+
+	keepalive := make(chan error, 1)
+	go func() {
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil || strings.HasPrefix(line, ":") {
+				keepalive <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-keepalive:
+		if err != nil {
+			t.Fatalf("expected the stream to stay open during maintenance: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a keepalive comment")
+	}
+}
+
+func TestMaintenanceRequiresAdmin(t *testing.T) {
+	_, ts := newMaintenanceTestServer(t)
+
+	for _, method := range []string{"GET", "POST"} {
+		if resp := doRequest(t, ts, method, "/api/v1/admin/maintenance", map[string]any{"enabled": true}); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: unexpected status code: got %v want %v", method, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	if resp := doAdminRequest(t, ts, "POST", "/api/v1/admin/maintenance"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code without a body: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Scrapes GET /metrics with the given credentials, returning the status code
+// and body.
+func scrapeMetrics(t *testing.T, ts *httptest.Server, username, password string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", ts.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsAfterTraffic(t *testing.T) {
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	doRequest(t, ts, "POST", "/api/v1/events", seqEvents(3))
+	getEvents(t, ts, "")
+	doRequest(t, ts, "GET", "/api/v1/no-such-route", nil)
+	dialWS(t, ts, "/api/v1/ws/events")
+
+	status, body := scrapeMetrics(t, ts, testUsername, testPassword)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	for _, want := range []string{
+		`shion_http_requests_total{method="POST",route="/api/v1/event",status="201"} 1`,
+		`shion_http_request_duration_seconds_bucket{method="GET",route="/api/v1/events",status="200",le="+Inf"} 1`,
+		`shion_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`shion_events_ingested_total{type="deploy"} 1`,
+		`shion_events_ingested_total{type="seq"} 3`,
+		`shion_event_batch_size_sum 3`,
+		`shion_db_operation_duration_seconds_count{operation="create_event",status="ok"} 1`,
+		`shion_db_operation_duration_seconds_count{operation="get_latest_events",status="ok"} 1`,
+		`shion_websocket_connections 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the metrics to contain %q", want)
+		}
+	}
+
+	if t.Failed() {
+		t.Logf("metrics:\n%s", body)
+	}
+}
+
+func TestMetricsRequireCredentials(t *testing.T) {
+	ts := newTestServer(t)
+
+	if status, _ := scrapeMetrics(t, ts, "", ""); status != http.StatusUnauthorized {
+		t.Errorf("unexpected status code without credentials: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	if status, _ := scrapeMetrics(t, ts, testUsername, "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("unexpected status code with the wrong password: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestMetricsWithCredentialsOfTheirOwn(t *testing.T) {
+	t.Setenv("METRICS_USERNAME", "prometheus")
+	t.Setenv("METRICS_PASSWORD", "scrape")
+
+	ts := newTestServer(t)
+
+	if status, _ := scrapeMetrics(t, ts, "prometheus", "scrape"); status != http.StatusOK {
+		t.Errorf("unexpected status code with the metrics credentials: got %v want %v", status, http.StatusOK)
+	}
+
+	// The API credentials can't be used to scrape once the metrics have
+	// credentials of their own.
+	if status, _ := scrapeMetrics(t, ts, testUsername, testPassword); status != http.StatusUnauthorized {
+		t.Errorf("unexpected status code with the API credentials: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestMetricsEventCountGauges(t *testing.T) {
+	t.Setenv("METRICS_DB_POLL_INTERVAL_SECONDS", "1")
+
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+	postEvent(t, ts, database.EventEntry{Type: "alert", Data: "cpu"})
+
+	want := []string{
+		`shion_events_total_gauge 3`,
+		`shion_events_by_type_gauge{event_type="deploy"} 2`,
+		`shion_events_by_type_gauge{event_type="alert"} 1`,
+	}
+
+	// The events are counted again every second, so the gauges catch up
+	// within a couple of polls.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body := scrapeMetrics(t, ts, testUsername, testPassword)
+
+		missing := ""
+		for _, line := range want {
+			if !strings.Contains(body, line) {
+				missing = line
+				break
+			}
+		}
+
+		if missing == "" {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the metrics to contain %q, got:\n%s", missing, body)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// Starts an embedded NATS server on a random port, which is shut down when the
+// test finishes.
+func newNATSServer(t *testing.T) *natsserver.Server {
+	t.Helper()
+
+	ns, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server didn't start")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	return ns
+}
+
+// Subscribes to every event subject on the NATS server.
+func subscribeNATSEvents(t *testing.T, ns *natsserver.Server) chan *nats.Msg {
+	t.Helper()
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+
+	msgs := make(chan *nats.Msg, 16)
+	if _, err := conn.ChanSubscribe("shion.events.>", msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return msgs
+}
+
+// Waits for the next NATS message, failing the test if none arrives within a
+// few seconds.
+func awaitNATSMsg(t *testing.T, msgs chan *nats.Msg) *nats.Msg {
+	t.Helper()
+
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a NATS message")
+	}
+
+	return nil
+}
+
+func TestNATSPublishesCreatedEvents(t *testing.T) {
+	ns := newNATSServer(t)
+	msgs := subscribeNATSEvents(t, ns)
+
+	t.Setenv("NATS_URL", ns.ClientURL())
+	ts := newTestServer(t)
+
+	for _, event := range []database.EventEntry{
+		{Type: "deploy", Data: "v1"},
+		// Characters that aren't allowed in a subject token are replaced.
+		{Type: "key.down", Data: "a"},
+	} {
+		created := postEvent(t, ts, event)
+		msg := awaitNATSMsg(t, msgs)
+
+		want := map[database.EventType]string{"deploy": "shion.events.deploy", "key.down": "shion.events.key_down"}[event.Type]
+		if msg.Subject != want {
+			t.Errorf("unexpected subject: got %q want %q", msg.Subject, want)
+		}
+
+		var payload database.EventEntry
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if payload != created {
+			t.Errorf("unexpected payload: got %+v want %+v", payload, created)
+		}
+	}
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/nats", nil)
+	var health map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	if health["connected"] != true || health["published"] != float64(2) || health["dropped"] != float64(0) {
+		t.Errorf("unexpected NATS health: %+v", health)
+	}
+}
+
+func TestNATSFlushesEventsOnShutdown(t *testing.T) {
+	ns := newNATSServer(t)
+	msgs := subscribeNATSEvents(t, ns)
+
+	t.Setenv("NATS_URL", ns.ClientURL())
+	t.Setenv("NATS_SUBJECT_PREFIX", "shion.events.custom")
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	msg := awaitNATSMsg(t, msgs)
+	if msg.Subject != "shion.events.custom.deploy" {
+		t.Errorf("unexpected subject: %q", msg.Subject)
+	}
+
+	var payload database.EventEntry
+	if err := json.Unmarshal(msg.Data, &payload); err != nil || payload.ID != created.ID {
+		t.Errorf("unexpected payload: %s, %v", msg.Data, err)
+	}
+}
+
+func TestNATSDisabledByDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/nats", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var health map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	if health["enabled"] != false {
+		t.Errorf("expected NATS to be disabled, got %+v", health)
+	}
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/config"
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Enables the outbox with short poll and retry intervals, along with the admin
+// credentials needed to inspect it.
+func enableTestOutbox(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("OUTBOX_ENABLED", "true")
+	t.Setenv("OUTBOX_POLL_INTERVAL", "20ms")
+	t.Setenv("OUTBOX_INITIAL_BACKOFF", "20ms")
+	t.Setenv("OUTBOX_MAX_BACKOFF", "50ms")
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+}
+
+// Sends a request to the test server with the admin credentials, returning the
+// response.
+func doAdminRequest(t *testing.T, ts *httptest.Server, method, path string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testAdminUsername, testAdminPassword)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// Lists the outbox entries with the given status through GET /admin/outbox.
+func listOutbox(t *testing.T, ts *httptest.Server, status database.OutboxStatus) []database.OutboxEntry {
+	t.Helper()
+
+	resp := doAdminRequest(t, ts, "GET", "/api/v1/admin/outbox?status="+string(status))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code listing the outbox: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var entries []database.OutboxEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+// Polls the outbox entries with the given status until done returns true,
+// failing the test if it doesn't within a few seconds.
+func awaitOutbox(t *testing.T, ts *httptest.Server, status database.OutboxStatus, done func([]database.OutboxEntry) bool) []database.OutboxEntry {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries := listOutbox(t, ts, status)
+		if done(entries) {
+			return entries
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the %s outbox entries, last saw %+v", status, entries)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOutboxRetriesUntilTheSinkRecovers(t *testing.T) {
+	enableTestOutbox(t)
+	ts := newTestServer(t)
+
+	receiver, receipts := newWebhookReceiver(t, func(attempt int) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+
+	event := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for _, want := range []string{"1", "2", "3"} {
+		receipt := awaitReceipt(t, receipts)
+		if receipt.event.ID != event.ID || receipt.attempt != want || !receipt.valid {
+			t.Fatalf("unexpected delivery: %+v, want attempt %s", receipt, want)
+		}
+	}
+
+	awaitOutbox(t, ts, database.OutboxPending, func(entries []database.OutboxEntry) bool { return len(entries) == 0 })
+}
+
+func TestOutboxDeliversEventsLeftFromBeforeARestart(t *testing.T) {
+	enableTestOutbox(t)
+	dbURL := newTestDBURL(t)
+	t.Setenv("TURSO_DATABASE_URL", dbURL)
+
+	receiver, receipts := newWebhookReceiver(t, func(int) int { return http.StatusOK })
+
+	// Store an event as if the process stopped before it could be delivered.
+	db := database.New(config.Database{URL: dbURL, Outbox: true}, nil)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.CreateWebhook(database.Webhook{URL: receiver.URL, Secret: testWebhookSecret, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := db.CreateEvent(database.EventEntry{Type: "deploy", Data: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServerWithDB(t, dbURL)
+
+	if receipt := awaitReceipt(t, receipts); receipt.event.ID != event.ID {
+		t.Fatalf("unexpected delivery: %+v", receipt)
+	}
+
+	awaitOutbox(t, ts, database.OutboxPending, func(entries []database.OutboxEntry) bool { return len(entries) == 0 })
+}
+
+func TestOutboxRetriesFailedDeliveriesAfterARestart(t *testing.T) {
+	enableTestOutbox(t)
+	t.Setenv("OUTBOX_INITIAL_BACKOFF", "200ms")
+	t.Setenv("OUTBOX_MAX_BACKOFF", "200ms")
+	dbURL := newTestDBURL(t)
+
+	var healthy atomic.Bool
+	receiver, receipts := newWebhookReceiver(t, func(int) int {
+		if healthy.Load() {
+			return http.StatusOK
+		}
+		return http.StatusServiceUnavailable
+	})
+
+	srv, ts := newTestHTTPServer(t, dbURL)
+	createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+
+	event := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	awaitOutbox(t, ts, database.OutboxPending, func(entries []database.OutboxEntry) bool {
+		return len(entries) == 1 && entries[0].Attempts > 0
+	})
+
+	// Stop the server as if it crashed while the sink was down.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	ts.Close()
+
+	for len(receipts) > 0 {
+		<-receipts
+	}
+
+	healthy.Store(true)
+	ts = newTestServerWithDB(t, dbURL)
+
+	receipt := awaitReceipt(t, receipts)
+	if receipt.event.ID != event.ID || receipt.attempt == "1" || !receipt.valid {
+		t.Fatalf("expected the failed delivery to be retried, got %+v", receipt)
+	}
+
+	awaitOutbox(t, ts, database.OutboxPending, func(entries []database.OutboxEntry) bool { return len(entries) == 0 })
+}
+
+func TestOutboxDeadLetterRequeue(t *testing.T) {
+	enableTestOutbox(t)
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "2")
+	ts := newTestServer(t)
+
+	var healthy atomic.Bool
+	receiver, receipts := newWebhookReceiver(t, func(int) int {
+		if healthy.Load() {
+			return http.StatusOK
+		}
+		return http.StatusInternalServerError
+	})
+	createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+
+	event := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	dead := awaitOutbox(t, ts, database.OutboxDead, func(entries []database.OutboxEntry) bool { return len(entries) == 1 })
+	if dead[0].EventID != event.ID || dead[0].Attempts != 2 || dead[0].LastError == "" || dead[0].Event.Data != "v1" {
+		t.Fatalf("unexpected dead-lettered entry: %+v", dead[0])
+	}
+
+	for range 2 {
+		awaitReceipt(t, receipts)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/admin/outbox", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a non-admin: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+
+	if resp := doAdminRequest(t, ts, "POST", "/api/v1/admin/outbox/missing/requeue"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code requeueing an unknown entry: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+
+	healthy.Store(true)
+
+	if resp := doAdminRequest(t, ts, "POST", "/api/v1/admin/outbox/"+event.ID+"/requeue"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status code requeueing: got %v want %v", resp.StatusCode, http.StatusNoContent)
+	}
+
+	if receipt := awaitReceipt(t, receipts); receipt.event.ID != event.ID || receipt.attempt != "1" {
+		t.Fatalf("unexpected delivery after requeueing: %+v", receipt)
+	}
+
+	awaitOutbox(t, ts, database.OutboxPending, func(entries []database.OutboxEntry) bool { return len(entries) == 0 })
+
+	if entries := listOutbox(t, ts, database.OutboxDead); len(entries) != 0 {
+		t.Fatalf("expected no dead-lettered entries, got %+v", entries)
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// The result of a GET /events/poll request.
+type pollResult struct {
+	events  []database.EventEntry
+	elapsed time.Duration
+}
+
+// Sends a GET /events/poll request with the given query in the background,
+// returning a channel that receives the events once the request finishes.
+func pollEvents(t *testing.T, ts *httptest.Server, query string) <-chan pollResult {
+	t.Helper()
+
+	results := make(chan pollResult, 1)
+	go func() {
+		start := time.Now()
+
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/events/poll"+query, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.SetBasicAuth(testUsername, testPassword)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+			return
+		}
+
+		var events []database.EventEntry
+		if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+			t.Error(err)
+			return
+		}
+
+		results <- pollResult{events: events, elapsed: time.Since(start)}
+	}()
+
+	return results
+}
+
+// Waits for the result of a poll, failing the test if it doesn't arrive within
+// a few seconds.
+func awaitPoll(t *testing.T, results <-chan pollResult) pollResult {
+	t.Helper()
+
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the poll to return")
+	}
+
+	return pollResult{}
+}
+
+func TestPollReturnsMissedEventsImmediately(t *testing.T) {
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "1"})
+	second := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "2"})
+	third := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "3"})
+
+	result := awaitPoll(t, pollEvents(t, ts, "?after="+first.ID+"&timeout=10s"))
+	if len(result.events) != 2 || result.events[0] != second || result.events[1] != third {
+		t.Fatalf("unexpected events: %+v", result.events)
+	}
+
+	if result.elapsed > 2*time.Second {
+		t.Fatalf("expected the poll to return immediately, took %s", result.elapsed)
+	}
+}
+
+func TestPollWakesOnNewEvent(t *testing.T) {
+	ts := newTestServer(t)
+	latest := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "seen"})
+
+	results := pollEvents(t, ts, "?after="+latest.ID+"&timeout=10s")
+
+	// Give the poll a moment to start waiting before publishing.
+	time.Sleep(100 * time.Millisecond)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "new"})
+
+	result := awaitPoll(t, results)
+	if len(result.events) != 1 || result.events[0] != created {
+		t.Fatalf("unexpected events: %+v", result.events)
+	}
+}
+
+func TestPollTimesOutWithEmptyArray(t *testing.T) {
+	ts := newTestServer(t)
+
+	result := awaitPoll(t, pollEvents(t, ts, "?timeout=100ms"))
+	if result.events == nil || len(result.events) != 0 {
+		t.Fatalf("expected an empty array, got %+v", result.events)
+	}
+
+	if result.elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the poll to wait for the timeout, took %s", result.elapsed)
+	}
+}
+
+func TestPollTimeoutCappedByServer(t *testing.T) {
+	t.Setenv("LONG_POLL_MAX_TIMEOUT", "100ms")
+	ts := newTestServer(t)
+
+	result := awaitPoll(t, pollEvents(t, ts, "?timeout=1h"))
+	if len(result.events) != 0 || result.elapsed > 2*time.Second {
+		t.Fatalf("expected the poll to be capped at the max timeout, got %d events after %s", len(result.events), result.elapsed)
+	}
+}
+
+func TestPollRejectsInvalidTimeout(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/poll?timeout=forever", nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Returns the status code and body of an authenticated GET request to the test
+// server.
+func getBody(t *testing.T, url string) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, body
+}
+
+func TestPrettyPrintedResponses(t *testing.T) {
+	ts := newTestServer(t)
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for _, path := range []string{"/api/v1/events", "/api/v1/event/missing"} {
+		status, compact := getBody(t, ts.URL+path)
+
+		if bytes.Contains(compact, []byte("\n")) {
+			t.Errorf("%s: expected a compact body by default, got %s", path, compact)
+		}
+
+		prettyStatus, pretty := getBody(t, ts.URL+path+"?pretty=true")
+		if prettyStatus != status {
+			t.Errorf("%s: unexpected status code: got %v want %v", path, prettyStatus, status)
+		}
+
+		// Error bodies differ in their request IDs, so the pretty body is
+		// compared with itself indented and the compact body once compacted.
+		var indented, compacted bytes.Buffer
+		if err := json.Indent(&indented, pretty, "", "    "); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Compact(&compacted, pretty); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Contains(pretty, []byte("\n    ")) || !bytes.Equal(pretty, indented.Bytes()) || len(compacted.Bytes()) != len(compact) {
+			t.Errorf("%s: expected an indented version of %s, got %s", path, compact, pretty)
+		}
+	}
+
+	if status, _ := getBody(t, ts.URL+"/api/v1/events?pretty=false"); status != http.StatusOK {
+		t.Errorf("unexpected status code for pretty=false: got %v want %v", status, http.StatusOK)
+	}
+
+	if status, _ := getBody(t, ts.URL+"/api/v1/events?pretty=very"); status != http.StatusBadRequest {
+		t.Errorf("unexpected status code for an invalid value: got %v want %v", status, http.StatusBadRequest)
+	}
+}
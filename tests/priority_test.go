@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestEventPriorityDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	if created.Priority != database.DefaultPriority {
+		t.Fatalf("expected the default priority, got %d", created.Priority)
+	}
+
+	events := getEvents(t, ts, "")
+	if len(events) != 1 || events[0].Priority != database.DefaultPriority {
+		t.Fatalf("expected the stored event to have the default priority, got %+v", events)
+	}
+}
+
+func TestEventPriorityOutOfRange(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, priority := range []int{-1, 6, 100} {
+		resp := doRequest(t, ts, "POST", "/api/v1/event", map[string]any{"type": "deploy", "data": "v1", "priority": priority})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("priority %d: unexpected status code: got %v want %v", priority, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 0 {
+		t.Fatalf("expected no events to be created, got %d", len(events))
+	}
+}
+
+func TestEventsMinPriority(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, event := range []database.EventEntry{
+		{Type: "deploy", Data: "urgent", Priority: 5},
+		{Type: "deploy", Data: "low", Priority: 1},
+		{Type: "deploy", Data: "default"},
+		{Type: "deploy", Data: "high", Priority: 4},
+	} {
+		postEvent(t, ts, event)
+	}
+
+	var got []string
+	for _, event := range getEvents(t, ts, "?min_priority=4") {
+		got = append(got, event.Data)
+	}
+	if !slices.Equal(got, []string{"urgent", "high"}) {
+		t.Fatalf("unexpected events with a priority of at least 4: %v", got)
+	}
+
+	for _, query := range []string{"min_priority=0", "min_priority=6", "min_priority=high", "min_priority=4&type=deploy", "min_priority=4&sort_by=type"} {
+		if resp := doRequest(t, ts, "GET", "/api/v1/events?"+query, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	_, body := scrapeMetrics(t, ts, testUsername, testPassword)
+	if !strings.Contains(body, "shion_high_priority_events_total 2") {
+		t.Errorf("expected 2 high priority events to be counted, got metrics:\n%s", body)
+	}
+}
+
+func TestPatchEventPriority(t *testing.T) {
+	ts := newTestServer(t)
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	status, patched := patchEvent(t, ts, created.ID, map[string]any{"priority": 5})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusOK)
+	}
+
+	want := created
+	want.Priority = 5
+	if patched != want {
+		t.Fatalf("unexpected patched event: got %+v want %+v", patched, want)
+	}
+
+	for _, priority := range []any{0, 6, 2.5, "high"} {
+		if status, _ := patchEvent(t, ts, created.ID, map[string]any{"priority": priority}); status != http.StatusBadRequest {
+			t.Errorf("priority %v: unexpected status code: got %v want %v", priority, status, http.StatusBadRequest)
+		}
+	}
+
+	if events := getEvents(t, ts, ""); events[0].Priority != 5 {
+		t.Fatalf("expected the invalid priorities to be rejected, got %d", events[0].Priority)
+	}
+}
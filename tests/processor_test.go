@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestEventProcessorRewritesData(t *testing.T) {
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	srv.RegisterProcessor("redacted", func(e database.EventEntry) (database.EventEntry, error) {
+		e.Data = strings.Repeat("*", len(e.Data))
+		return e, nil
+	})
+
+	event := postEvent(t, ts, database.EventEntry{Type: "redacted", Data: "secret"})
+	if event.Data != "******" {
+		t.Errorf("unexpected data in the response: got %q want %q", event.Data, "******")
+	}
+
+	plain := postEvent(t, ts, database.EventEntry{Type: "plain", Data: "secret"})
+	if plain.Data != "secret" {
+		t.Errorf("unexpected data for an unprocessed type: got %q want %q", plain.Data, "secret")
+	}
+
+	events := getEvents(t, ts, "?type=redacted")
+	if len(events) != 1 || events[0].Data != "******" {
+		t.Errorf("unexpected stored events: %+v", events)
+	}
+}
+
+func TestEventProcessorRejectsEvents(t *testing.T) {
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	srv.RegisterProcessor("guarded", func(e database.EventEntry) (database.EventEntry, error) {
+		if e.Data == "bad" {
+			return database.EventEntry{}, errors.New("bad data")
+		}
+		return e, nil
+	})
+
+	var body map[string]any
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "guarded", Data: "bad"}), http.StatusUnprocessableEntity, &body)
+
+	if message, _ := body["error"].(string); !strings.Contains(message, "bad data") {
+		t.Errorf("expected the processor's error in the response, got %v", body)
+	}
+
+	resp := doRequest(t, ts, "POST", "/api/v1/events", []database.EventEntry{{Type: "guarded", Data: "good"}, {Type: "guarded", Data: "bad"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("unexpected status code for a batch: got %v want %v", resp.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 0 {
+		t.Errorf("expected no events to be stored, got %+v", events)
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHeadersOnAllowedRequests(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "1")
+	t.Setenv("RATE_LIMIT_BURST", "3")
+	ts := newTestServer(t)
+
+	for want := 2; want >= 0; want-- {
+		resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+
+		if limit := resp.Header.Get("X-RateLimit-Limit"); limit != "1" {
+			t.Errorf("unexpected X-RateLimit-Limit: %q", limit)
+		}
+
+		if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != strconv.Itoa(want) {
+			t.Errorf("unexpected X-RateLimit-Remaining: got %q want %d", remaining, want)
+		}
+
+		reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset < time.Now().Unix() {
+			t.Errorf("unexpected X-RateLimit-Reset: %q", resp.Header.Get("X-RateLimit-Reset"))
+		}
+	}
+}
+
+func TestRateLimitRejectsWithRetryAfter(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.5")
+	t.Setenv("RATE_LIMIT_BURST", "1")
+	ts := newTestServer(t)
+
+	doRequest(t, ts, "GET", "/api/v1/health/liveness", nil)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("unexpected Retry-After: got %q want %q", retryAfter, "2")
+	}
+
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("unexpected X-RateLimit-Remaining: %q", remaining)
+	}
+}
+
+func TestRateLimitDisabledByDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil)
+	if limit := resp.Header.Get("X-RateLimit-Limit"); limit != "" {
+		t.Errorf("expected no rate limit headers, got X-RateLimit-Limit: %q", limit)
+	}
+}
+
+// Sends a liveness request claiming to be forwarded for the given client.
+func requestForwardedFor(t *testing.T, ts *httptest.Server, client string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/health/liveness", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("X-Forwarded-For", client)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestRateLimitIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.5")
+	t.Setenv("RATE_LIMIT_BURST", "1")
+	ts := newTestServer(t)
+
+	requestForwardedFor(t, ts, "198.51.100.1")
+
+	// Claiming to be another client doesn't get a new limit.
+	if resp := requestForwardedFor(t, ts, "198.51.100.2"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitPerClientBehindTrustedProxy(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.5")
+	t.Setenv("RATE_LIMIT_BURST", "1")
+	t.Setenv("TRUSTED_PROXIES", "127.0.0.1/32, ::1")
+	ts := newTestServer(t)
+
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		if resp := requestForwardedFor(t, ts, client); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status code: got %v want %v", client, resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	if resp := requestForwardedFor(t, ts, "198.51.100.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code for a repeat client: got %v want %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+}
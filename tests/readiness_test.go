@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Sends a GET /health/readiness request straight to the server's handler and
+// decodes the response, returning the status code along with it.
+func getReadiness(t *testing.T, handler http.Handler) (int, server.ReadinessResponse) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/health/readiness", nil)
+	req.SetBasicAuth(testUsername, testPassword)
+	handler.ServeHTTP(rec, req)
+
+	var readiness server.ReadinessResponse
+	if err := json.NewDecoder(rec.Body).Decode(&readiness); err != nil {
+		t.Fatalf("decoding the readiness response %q: %v", rec.Body.String(), err)
+	}
+
+	return rec.Code, readiness
+}
+
+func TestReadinessRunsEveryCheck(t *testing.T) {
+	srv, _ := newTestHTTPServer(t, newTestDBURL(t))
+
+	status, readiness := getReadiness(t, srv.Handler)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v: %+v", status, http.StatusOK, readiness)
+	}
+
+	if readiness.Status != "ready" || len(readiness.Failing) != 0 {
+		t.Errorf("expected the server to be ready, got %+v", readiness)
+	}
+
+	for _, name := range []string{"startup", "shutdown", "maintenance", "database", "migrations"} {
+		if check, ok := readiness.Checks[name]; !ok || check.Status != database.HealthUp {
+			t.Errorf("expected the %s check to pass, got %+v", name, check)
+		}
+	}
+}
+
+func TestReadinessFailsWhenATableIsMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shion.db")
+	srv, _ := newTestHTTPServer(t, "file:"+path)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DROP TABLE webhooks"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, readiness := getReadiness(t, srv.Handler)
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	if readiness.Status != "not_ready" || !slices.Equal(readiness.Failing, []string{"migrations"}) {
+		t.Fatalf("expected only the migrations check to fail, got %+v", readiness)
+	}
+
+	if readiness.Checks["migrations"].Error == "" {
+		t.Error("expected the failing check to say why")
+	}
+
+	// Liveness doesn't depend on the database.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/health/liveness", nil)
+	req.SetBasicAuth(testUsername, testPassword)
+	srv.Handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("unexpected liveness status code: got %v want %v", rec.Code, http.StatusOK)
+	}
+}
+
+func TestReadinessFailsOnceShutdownBegins(t *testing.T) {
+	srv, _ := newTestHTTPServer(t, newTestDBURL(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	status, readiness := getReadiness(t, srv.Handler)
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	if !slices.Contains(readiness.Failing, "shutdown") {
+		t.Fatalf("expected the shutdown check to fail, got %+v", readiness)
+	}
+}
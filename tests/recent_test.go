@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Requests GET /events/recent with the given query, failing the test unless it
+// responds with a 200, and returns the data of each event in order.
+func getRecentEventData(t *testing.T, ts *httptest.Server, query string) []string {
+	t.Helper()
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/recent"+query, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var events []database.EventEntry
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []string{}
+	for _, event := range events {
+		data = append(data, event.Data)
+	}
+
+	return data
+}
+
+func TestRecentEventsWindow(t *testing.T) {
+	ts := newTestServer(t)
+
+	now := time.Now().UTC()
+	for _, seeded := range []struct {
+		data string
+		age  time.Duration
+	}{
+		{"2h", 2 * time.Hour},
+		{"20m", 20 * time.Minute},
+		{"10m", 10 * time.Minute},
+		{"1m", time.Minute},
+		{"2d", 48 * time.Hour},
+	} {
+		postEvent(t, ts, database.EventEntry{Type: "seq", Data: seeded.data, Timestamp: now.Add(-seeded.age).Format(time.RFC3339Nano)})
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"1m", "10m"}},
+		{"?minutes=5", []string{"1m"}},
+		{"?minutes=30", []string{"1m", "10m", "20m"}},
+		{"?minutes=180", []string{"1m", "10m", "20m", "2h"}},
+
+		// Windows longer than a day are capped.
+		{"?minutes=10000", []string{"1m", "10m", "20m", "2h"}},
+	}
+
+	for _, test := range tests {
+		got := getRecentEventData(t, ts, test.query)
+		if len(got) != len(test.want) {
+			t.Errorf("%q: unexpected events: got %v want %v", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: unexpected events: got %v want %v", test.query, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRecentEventsEmpty(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/recent", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "[]" {
+		t.Fatalf("expected an empty array, got %s", body)
+	}
+}
+
+func TestRecentEventsRejectsInvalidMinutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, minutes := range []string{"0", "-5", "abc", "1.5"} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events/recent?minutes="+minutes, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("minutes=%s: unexpected status code: got %v want %v", minutes, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
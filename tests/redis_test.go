@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+)
+
+// Starts two test servers with separate databases that share events through
+// the given Redis server.
+func newRedisTestServers(t *testing.T, mr *miniredis.Miniredis) (*httptest.Server, *httptest.Server) {
+	t.Helper()
+
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+	return newTestServer(t), newTestServer(t)
+}
+
+// Waits until both instances are subscribed to the events channel, so nothing
+// published before then is missed.
+func waitForRedisSubscribers(t *testing.T, mr *miniredis.Miniredis, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub("shion:events")["shion:events"] < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d Redis subscribers", want)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Opens a WebSocket connection to the server's event stream and waits for the
+// subscription to be acknowledged.
+func dialEventStream(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	return conn
+}
+
+// Returns the decoded GET /health/redis response.
+func redisHealth(t *testing.T, ts *httptest.Server) map[string]any {
+	t.Helper()
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/redis", nil)
+
+	var health map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	return health
+}
+
+// Fails the test if the connection receives an event frame within a short
+// time.
+func expectNoWSEvent(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	var frame wsFrame
+	if err := conn.ReadJSON(&frame); err == nil {
+		t.Fatalf("unexpected frame: %+v", frame)
+	}
+}
+
+func TestRedisSharesEventsAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, b := newRedisTestServers(t, mr)
+	waitForRedisSubscribers(t, mr, 2)
+
+	connA := dialEventStream(t, a)
+	connB := dialEventStream(t, b)
+
+	created := postEvent(t, a, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for name, conn := range map[string]*websocket.Conn{"a": connA, "b": connB} {
+		frame := readWSFrameOfType(t, conn, "event")
+		if frame.Event == nil || *frame.Event != created {
+			t.Errorf("%s: expected the created event, got %+v", name, frame)
+		}
+	}
+
+	// Instance a ignores its own event when it comes back from Redis.
+	expectNoWSEvent(t, connA)
+
+	if health := redisHealth(t, b); health["connected"] != true || health["received"] != float64(1) || health["sent"] != float64(0) {
+		t.Errorf("unexpected Redis health: %+v", health)
+	}
+}
+
+func TestRedisOutageFallsBackToLocalBroadcasts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, b := newRedisTestServers(t, mr)
+	waitForRedisSubscribers(t, mr, 2)
+
+	connA := dialEventStream(t, a)
+	connB := dialEventStream(t, b)
+
+	mr.Close()
+
+	// Creating events still works, and they still reach local clients.
+	local := postEvent(t, a, database.EventEntry{Type: "deploy", Data: "v1"})
+	if frame := readWSFrameOfType(t, connA, "event"); frame.Event == nil || frame.Event.ID != local.ID {
+		t.Fatalf("expected the local event, got %+v", frame)
+	}
+
+	// The event couldn't be shared while Redis was down.
+	deadline := time.Now().Add(5 * time.Second)
+	for redisHealth(t, a)["dropped"] != float64(1) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the event to be dropped, got %+v", redisHealth(t, a))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once Redis is back both instances resubscribe and share events again.
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitForRedisSubscribers(t, mr, 2)
+
+	shared := postEvent(t, a, database.EventEntry{Type: "deploy", Data: "v2"})
+	if frame := readWSFrameOfType(t, connB, "event"); frame.Event == nil || frame.Event.ID != shared.ID {
+		t.Fatalf("expected the shared event, got %+v", frame)
+	}
+}
+
+func TestRedisDisabledByDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	if health := redisHealth(t, ts); health["enabled"] != false {
+		t.Errorf("expected Redis to be disabled, got %+v", health)
+	}
+}
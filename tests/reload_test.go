@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Starts a test server that accepts the admin credentials, which reloading the
+// configuration requires.
+func newReloadTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+
+	return newTestServer(t)
+}
+
+// Reloads the configuration through POST /admin/reload, failing the test unless
+// it succeeds.
+func reloadConfig(t *testing.T, ts *httptest.Server) server.ReloadResponse {
+	t.Helper()
+
+	resp := doAdminRequest(t, ts, "POST", "/api/v1/admin/reload")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code reloading the configuration: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var reloaded server.ReloadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+
+	return reloaded
+}
+
+func TestReloadRateLimit(t *testing.T) {
+	ts := newReloadTestServer(t)
+
+	for range 3 {
+		if resp := doRequest(t, ts, "GET", "/api/v1/events", nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected rate limiting to be disabled, got %v", resp.StatusCode)
+		}
+	}
+
+	t.Setenv("RATE_LIMIT_RPS", "0.01")
+	t.Setenv("RATE_LIMIT_BURST", "2")
+
+	reloaded := reloadConfig(t, ts)
+	if !slices.Equal(reloaded.Reloaded, []string{"RateLimit.RPS", "RateLimit.Burst"}) || len(reloaded.Unchanged) != 0 {
+		t.Fatalf("unexpected reload response: %+v", reloaded)
+	}
+
+	for range 2 {
+		if resp := doRequest(t, ts, "GET", "/api/v1/events", nil); resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Limit") != "0.01" {
+			t.Fatalf("expected the burst to be allowed under the new limit, got %v with limit %q", resp.StatusCode, resp.Header.Get("X-RateLimit-Limit"))
+		}
+	}
+	if resp := doRequest(t, ts, "GET", "/api/v1/events", nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the request after the burst to be rate limited, got %v", resp.StatusCode)
+	}
+}
+
+func TestReloadRetention(t *testing.T) {
+	ts := newReloadTestServer(t)
+
+	postEventAged(t, ts, "deploy", "old", 2*time.Hour)
+	postEventAged(t, ts, "deploy", "recent", 10*time.Minute)
+
+	t.Setenv("RETENTION_MAX_AGE", "1h")
+	t.Setenv("RETENTION_INTERVAL", "20ms")
+
+	reloaded := reloadConfig(t, ts)
+	if !slices.Equal(reloaded.Reloaded, []string{"Retention.MaxAge", "Retention.Interval"}) {
+		t.Fatalf("unexpected reload response: %+v", reloaded)
+	}
+
+	awaitEventData(t, ts, "recent")
+}
+
+func TestReloadReportsSettingsNeedingRestart(t *testing.T) {
+	ts := newReloadTestServer(t)
+
+	t.Setenv("API_PORT", "9999")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	reloaded := reloadConfig(t, ts)
+	if !slices.Equal(reloaded.Reloaded, []string{"Log.Level"}) || !slices.Equal(reloaded.Unchanged, []string{"Server.Port", "Server.ListenAddr"}) {
+		t.Fatalf("unexpected reload response: %+v", reloaded)
+	}
+
+	// Settings needing a restart keep being reported until it happens.
+	reloaded = reloadConfig(t, ts)
+	if len(reloaded.Reloaded) != 0 || !slices.Equal(reloaded.Unchanged, []string{"Server.Port", "Server.ListenAddr"}) {
+		t.Fatalf("unexpected response reloading again: %+v", reloaded)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	ts := newReloadTestServer(t)
+
+	t.Setenv("RATE_LIMIT_RPS", "1")
+	t.Setenv("RETENTION_MAX_AGE", "forever")
+
+	if resp := doAdminRequest(t, ts, "POST", "/api/v1/admin/reload"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	// Nothing is applied, including the valid settings.
+	for range 3 {
+		if resp := doRequest(t, ts, "GET", "/api/v1/events", nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected rate limiting to stay disabled, got %v", resp.StatusCode)
+		}
+	}
+}
+
+func TestReloadRequiresAdmin(t *testing.T) {
+	ts := newReloadTestServer(t)
+
+	if resp := doRequest(t, ts, "POST", "/api/v1/admin/reload", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Returns the given time as an RFC 3339 timestamp.
+func timestampAt(at time.Time) string {
+	return at.UTC().Format(time.RFC3339Nano)
+}
+
+func TestReplayProtectionDisabledByDefault(t *testing.T) {
+	ts := newTestServer(t)
+
+	old := database.EventEntry{ID: clientEventID, Type: "deploy", Timestamp: timestampAt(time.Now().Add(-24 * time.Hour))}
+	postEvent(t, ts, old)
+
+	// Resubmitting returns the stored event as usual.
+	postEvent(t, ts, old)
+}
+
+func TestReplayProtectionAcceptsEventsWithinWindow(t *testing.T) {
+	t.Setenv("REPLAY_WINDOW_SECONDS", "60")
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{ID: clientEventID, Type: "deploy", Timestamp: timestampAt(time.Now().Add(-10 * time.Second))})
+
+	// Events without an ID or timestamp have them filled in by the server.
+	postEvent(t, ts, database.EventEntry{Type: "deploy"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy"})
+}
+
+func TestReplayProtectionRejectsEventsOutsideWindow(t *testing.T) {
+	t.Setenv("REPLAY_WINDOW_SECONDS", "60")
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{
+		Type:      "deploy",
+		Timestamp: timestampAt(time.Now().Add(-2 * time.Minute)),
+	})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "event timestamp is too old, possible replay attack"; body.Error != want {
+		t.Errorf("unexpected error: got %q want %q", body.Error, want)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 0 {
+		t.Fatalf("expected the event not to be stored, got %d", len(events))
+	}
+}
+
+func TestReplayProtectionRejectsDuplicateNonce(t *testing.T) {
+	t.Setenv("REPLAY_WINDOW_SECONDS", "60")
+	ts := newTestServer(t)
+
+	event := database.EventEntry{ID: clientEventID, Type: "deploy", Timestamp: timestampAt(time.Now())}
+	postEvent(t, ts, event)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", event)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("unexpected status code for a duplicate: got %v want %v", resp.StatusCode, http.StatusConflict)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 1 {
+		t.Fatalf("expected a single stored event, got %d", len(events))
+	}
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRequestIDRoundTrips(t *testing.T) {
+	ts := newTestServer(t)
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/event/missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("X-Request-ID", "support-ticket-42")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+
+	if id := resp.Header.Get("X-Request-ID"); id != "support-ticket-42" {
+		t.Errorf("expected the request ID to be echoed, got %q", id)
+	}
+
+	var body struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Error == "" || body.RequestID != "support-ticket-42" {
+		t.Fatalf("expected the error body to carry the request ID, got %+v", body)
+	}
+}
+
+func TestRequestIDIsGeneratedForErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Requests without credentials are rejected before reaching a handler.
+	resp, err := http.Get(ts.URL + "/api/v1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := resp.Header.Get("X-Request-ID"); id == "" || body.RequestID != id {
+		t.Fatalf("expected the generated ID in the header and body, got %q and %q", id, body.RequestID)
+	}
+}
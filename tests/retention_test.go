@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Creates an event of the given type whose timestamp is the given age.
+func postEventAged(t *testing.T, ts *httptest.Server, eventType database.EventType, data string, age time.Duration) {
+	t.Helper()
+
+	postEvent(t, ts, database.EventEntry{Type: eventType, Data: data, Timestamp: time.Now().Add(-age).UTC().Format(time.RFC3339Nano)})
+}
+
+// Polls GET /events until the stored events' data, newest first, matches
+// want, failing the test if it doesn't within a few seconds.
+func awaitEventData(t *testing.T, ts *httptest.Server, want ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got []string
+		for _, event := range getEvents(t, ts, "") {
+			got = append(got, event.Data)
+		}
+
+		if slices.Equal(got, want) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for events: got %v want %v", got, want)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRetentionDeletesOldEvents(t *testing.T) {
+	t.Setenv("RETENTION_MAX_AGE", "1h")
+	t.Setenv("RETENTION_INTERVAL", "20ms")
+	t.Setenv("RETENTION_BATCH_SIZE", "2")
+	ts := newTestServer(t)
+
+	for _, data := range []string{"old-1", "old-2", "old-3"} {
+		postEventAged(t, ts, "deploy", data, 2*time.Hour)
+	}
+	postEventAged(t, ts, "deploy", "recent", 10*time.Minute)
+
+	awaitEventData(t, ts, "recent")
+
+	// Events keep being cleaned up after the first run.
+	postEventAged(t, ts, "deploy", "late", 3*time.Hour)
+	awaitEventData(t, ts, "recent")
+}
+
+func TestRetentionPerTypeMaxAge(t *testing.T) {
+	t.Setenv("RETENTION_MAX_AGE", "24h")
+	t.Setenv("RETENTION_MAX_AGE_BY_TYPE", "debug=10m, audit=720h")
+	t.Setenv("RETENTION_INTERVAL", "20ms")
+	ts := newTestServer(t)
+
+	postEventAged(t, ts, "audit", "audit-48h", 48*time.Hour)
+	postEventAged(t, ts, "deploy", "deploy-48h", 48*time.Hour)
+	postEventAged(t, ts, "debug", "debug-20m", 20*time.Minute)
+	postEventAged(t, ts, "deploy", "deploy-20m", 20*time.Minute)
+	postEventAged(t, ts, "debug", "debug-1m", time.Minute)
+
+	awaitEventData(t, ts, "debug-1m", "deploy-20m", "audit-48h")
+}
+
+func TestRetentionMaxCount(t *testing.T) {
+	t.Setenv("RETENTION_MAX_COUNT", "2")
+	t.Setenv("RETENTION_INTERVAL", "20ms")
+	t.Setenv("RETENTION_BATCH_SIZE", "1")
+	ts := newTestServer(t)
+
+	for i, data := range []string{"1", "2", "3", "4"} {
+		postEventAged(t, ts, "deploy", data, time.Duration(4-i)*time.Minute)
+	}
+
+	awaitEventData(t, ts, "4", "3")
+}
+
+func TestRetentionDisabledByDefault(t *testing.T) {
+	t.Setenv("RETENTION_INTERVAL", "20ms")
+	ts := newTestServer(t)
+
+	postEventAged(t, ts, "deploy", "ancient", 10*365*24*time.Hour)
+	time.Sleep(100 * time.Millisecond)
+
+	awaitEventData(t, ts, "ancient")
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// A schema requiring deploy events to carry a string version and an integer
+// number of replicas.
+var deploySchema = map[string]any{
+	"type":     "object",
+	"required": []string{"version", "replicas"},
+	"properties": map[string]any{
+		"version":  map[string]any{"type": "string"},
+		"replicas": map[string]any{"type": "integer", "minimum": 1},
+	},
+}
+
+func TestRegisterAndGetSchema(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code registering: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/schemas/deploy", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code getting: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var got map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["type"] != "object" || got["required"] == nil {
+		t.Fatalf("unexpected schema: %v", got)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/schemas/unknown", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code for an unregistered type: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestRegisterInvalidSchemaRejected(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/schemas/deploy", map[string]any{"type": 42})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestEventDataValidatedAgainstSchema(t *testing.T) {
+	ts := newTestServer(t)
+	doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema)
+
+	// Conforming data and events of other types pass through untouched.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: `{"version":"v1.2.3","replicas":3}`})
+	postEvent(t, ts, database.EventEntry{Type: "heartbeat", Data: "not even JSON"})
+
+	for name, data := range map[string]string{
+		"missing field": `{"version":"v1.2.3"}`,
+		"wrong type":    `{"version":"v1.2.3","replicas":"three"}`,
+		"not JSON":      `version=v1.2.3`,
+	} {
+		resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: data})
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: unexpected status code: got %v want %v", name, resp.StatusCode, http.StatusUnprocessableEntity)
+			continue
+		}
+
+		var body struct {
+			Error            string   `json:"error"`
+			ValidationErrors []string `json:"validation_errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Error == "" || len(body.ValidationErrors) == 0 {
+			t.Errorf("%s: expected validation errors, got %+v", name, body)
+		}
+	}
+
+	// Batches are rejected as a whole if any event doesn't conform.
+	resp := doRequest(t, ts, "POST", "/api/v1/events", []database.EventEntry{
+		{Type: "deploy", Data: `{"version":"v2","replicas":1}`},
+		{Type: "deploy", Data: `{"version":"v3","replicas":0}`},
+	})
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status code for a batch: got %v want %v", resp.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 2 {
+		t.Fatalf("expected only the conforming events to be created, got %d", len(events))
+	}
+}
+
+// Checks the response is a 422 listing the ways the data doesn't conform to
+// its schema.
+func expectSchemaViolations(t *testing.T, resp *http.Response) {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	var body struct {
+		Error            string   `json:"error"`
+		ValidationErrors []string `json:"validation_errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error == "" || len(body.ValidationErrors) == 0 {
+		t.Fatalf("expected validation errors, got %+v", body)
+	}
+}
+
+func TestPatchedEventDataValidatedAgainstSchema(t *testing.T) {
+	ts := newTestServer(t)
+	doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema)
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: `{"version":"v1","replicas":1}`})
+	other := postEvent(t, ts, database.EventEntry{Type: "heartbeat", Data: "not even JSON"})
+
+	resp := doRequest(t, ts, "PATCH", "/api/v1/event/"+created.ID, map[string]any{"data": `{"version":"v2"}`})
+	expectSchemaViolations(t, resp)
+
+	// Changing the type checks the existing data against the new type's schema.
+	resp = doRequest(t, ts, "PATCH", "/api/v1/event/"+other.ID, map[string]any{"type": "deploy"})
+	expectSchemaViolations(t, resp)
+
+	if status, _ := patchEvent(t, ts, created.ID, map[string]any{"data": `{"version":"v2","replicas":2}`}); status != http.StatusOK {
+		t.Fatalf("unexpected status code for conforming data: got %v want %v", status, http.StatusOK)
+	}
+	if status, _ := patchEvent(t, ts, created.ID, map[string]any{"priority": 2}); status != http.StatusOK {
+		t.Fatalf("unexpected status code patching another field: got %v want %v", status, http.StatusOK)
+	}
+
+	events := getEvents(t, ts, "")
+	for _, event := range events {
+		if event.ID == other.ID && event.Type != "heartbeat" {
+			t.Fatalf("expected the rejected patch not to be applied, got %+v", event)
+		}
+	}
+}
+
+func TestImportedEventDataValidatedAgainstSchema(t *testing.T) {
+	ts := newTestServer(t)
+	doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema)
+
+	result := importEvents(t, ts, `[
+		{"type": "deploy", "data": "{\"version\":\"v1\",\"replicas\":1}"},
+		{"type": "deploy", "data": "{\"version\":\"v2\"}"},
+		{"type": "heartbeat", "data": "not even JSON"}
+	]`)
+
+	if result.Imported != 2 || len(result.Errors) != 1 {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+	if failed := result.Errors[0]; failed.Record != 2 || len(failed.ValidationErrors) == 0 {
+		t.Fatalf("expected the second record to be reported with its violations, got %+v", failed)
+	}
+}
+
+func TestPublishedEventDataValidatedAgainstSchema(t *testing.T) {
+	ts := newTestServer(t)
+	doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema)
+
+	conn := dialWS(t, ts, "/api/v1/ws/events?types=none")
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{
+		"action": "publish",
+		"msg_id": "invalid",
+		"events": []map[string]any{
+			{"type": "deploy", "data": `{"version":"v1","replicas":1}`},
+			{"type": "deploy", "data": `{"version":"v2"}`},
+		},
+	})
+	frame := readWSFrameOfType(t, conn, "error")
+	if frame.MsgID != "invalid" || len(frame.ValidationErrors) == 0 {
+		t.Fatalf("expected an error listing the violations, got %+v", frame)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 0 {
+		t.Fatalf("expected the batch to be rejected as a whole, got %+v", events)
+	}
+}
+
+func TestDeleteSchemaStopsValidation(t *testing.T) {
+	ts := newTestServer(t)
+	doRequest(t, ts, "POST", "/api/v1/schemas/deploy", deploySchema)
+
+	resp := doRequest(t, ts, "DELETE", "/api/v1/schemas/deploy", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("unexpected status code: got %v want %v: %s", resp.StatusCode, http.StatusNoContent, body)
+	}
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "anything goes"})
+
+	resp = doRequest(t, ts, "DELETE", "/api/v1/schemas/deploy", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code deleting again: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Writes the given contents to a fixtures file in a temporary directory and
+// enables seeding from it.
+func enableSeeding(t *testing.T, contents string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SEED_ENABLED", "true")
+	t.Setenv("SEED_FILE", path)
+}
+
+func TestSeedEmptyDatabaseFromJSONArray(t *testing.T) {
+	enableSeeding(t, `[
+		{"type": "mouse-click", "data": "left"},
+		{"type": "key-down", "data": "a"}
+	]`)
+	ts := newTestServer(t)
+
+	if events := getEvents(t, ts, ""); len(events) != 2 {
+		t.Fatalf("expected 2 seeded events, got %d", len(events))
+	}
+}
+
+func TestSeedEmptyDatabaseFromNDJSON(t *testing.T) {
+	enableSeeding(t, `{"type": "mouse-click", "data": "left"}
+{"type": "key-down", "data": "a"}
+{"type": "key-up", "data": "a"}
+`)
+	ts := newTestServer(t)
+
+	if events := getEvents(t, ts, ""); len(events) != 3 {
+		t.Fatalf("expected 3 seeded events, got %d", len(events))
+	}
+}
+
+func TestSeedSkippedOnPopulatedDatabase(t *testing.T) {
+	dbURL := newTestDBURL(t)
+
+	ts := newTestServerWithDB(t, dbURL)
+	postEvent(t, ts, database.EventEntry{Type: "key-down", Data: "existing"})
+
+	enableSeeding(t, `[{"type": "mouse-click", "data": "left"}]`)
+	ts = newTestServerWithDB(t, dbURL)
+
+	events := getEvents(t, ts, "")
+	if len(events) != 1 || events[0].Data != "existing" {
+		t.Fatalf("expected only the existing event, got %+v", events)
+	}
+}
+
+func TestSeedDisabledByDefault(t *testing.T) {
+	enableSeeding(t, `[{"type": "mouse-click", "data": "left"}]`)
+	t.Setenv("SEED_ENABLED", "")
+	ts := newTestServer(t)
+
+	if events := getEvents(t, ts, ""); len(events) != 0 {
+		t.Fatalf("expected no seeded events, got %d", len(events))
+	}
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// Shuts down the server in the background, returning a channel that receives
+// the result.
+func shutdownAsync(srv *server.HTTPServer) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		done <- srv.Shutdown(ctx)
+	}()
+
+	return done
+}
+
+func TestShutdownSendsGoingAwayToWSClients(t *testing.T) {
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	done := shutdownAsync(srv)
+
+	// Reading the close frame answers it, letting the server finish shutting
+	// down without waiting out the grace period.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok || closeErr.Code != websocket.CloseGoingAway || closeErr.Text == "" {
+		t.Fatalf("expected a going-away close with a message, got %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected shutdown error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected shutdown to finish once the client closed")
+	}
+
+	// New upgrades are refused once the server is shutting down.
+	_, resp, err := tryDialWS(t, ts, "/api/v1/ws/events")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected new connections to be refused with a 503, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestShutdownForceClosesUnresponsiveWSClients(t *testing.T) {
+	t.Setenv("WS_SHUTDOWN_GRACE_PERIOD", "200ms")
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	// The client never reads, so it never answers the close frame.
+	dialWS(t, ts, "/api/v1/ws/events")
+	waitForWSConnections(t, ts, 1)
+
+	start := time.Now()
+	if err := <-shutdownAsync(srv); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("expected the client to be force-closed after the grace period, took %s", elapsed)
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shion.db")
+	srv, _ := newTestHTTPServer(t, "file:"+path)
+
+	// Serve on a real listener so shutting down stops accepting connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	url := "http://" + ln.Addr().String()
+
+	// Make inserts take a moment so the request is still in flight when the
+	// server starts shutting down.
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TRIGGER slow_insert BEFORE INSERT ON Events BEGIN
+		SELECT count(*) FROM (WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 3000000) SELECT x FROM n);
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inFlight := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest("POST", url+"/api/v1/event", strings.NewReader(`{"type":"deploy","data":"v1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(testUsername, testPassword)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+
+	// Give the request time to reach the handler.
+	time.Sleep(100 * time.Millisecond)
+	done := shutdownAsync(srv)
+
+	// The readiness check fails as soon as shutdown begins.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/health/readiness", nil)
+		req.SetBasicAuth(testUsername, testPassword)
+		srv.Handler.ServeHTTP(rec, req)
+
+		if rec.Code == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the readiness check to fail during shutdown, got %v", rec.Code)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// New connections are refused.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for {
+		resp, err := client.Get(url + "/api/v1/health/liveness")
+		if err != nil {
+			break
+		}
+		resp.Body.Close()
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected new connections to be refused during shutdown")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	select {
+	case status := <-inFlight:
+		if status != http.StatusCreated {
+			t.Fatalf("unexpected status code for the in-flight request: got %v want %v", status, http.StatusCreated)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the in-flight request to finish")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Events").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected the in-flight event to be stored, got %d, %v", count, err)
+	}
+}
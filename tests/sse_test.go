@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// A single frame read from a Server-Sent Events stream.
+type sseFrame struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// Opens the SSE stream at the given path, sending the Last-Event-ID header if
+// lastEventID isn't empty. The stream is closed when the test finishes.
+func openSSEStream(t *testing.T, ts *httptest.Server, path, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("unexpected content type: %q", contentType)
+	}
+
+	return bufio.NewReader(resp.Body)
+}
+
+// Reads the next frame from an SSE stream, skipping comments, failing the test
+// if nothing arrives within a few seconds.
+func readSSEFrame(t *testing.T, r *bufio.Reader) sseFrame {
+	t.Helper()
+
+	frames := make(chan sseFrame, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		var frame sseFrame
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if frame != (sseFrame{}) {
+					frames <- frame
+					return
+				}
+			case strings.HasPrefix(line, "id: "):
+				frame.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				frame.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				frame.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	select {
+	case frame := <-frames:
+		return frame
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an SSE frame")
+	}
+
+	return sseFrame{}
+}
+
+// Decodes the event carried by an SSE frame.
+func sseFrameEvent(t *testing.T, frame sseFrame) database.EventEntry {
+	t.Helper()
+
+	var event database.EventEntry
+	if err := json.Unmarshal([]byte(frame.Data), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	return event
+}
+
+func TestSSEStreamDeliversNewEvents(t *testing.T) {
+	ts := newTestServer(t)
+	stream := openSSEStream(t, ts, "/api/v1/events/stream", "")
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1.0.0"})
+
+	frame := readSSEFrame(t, stream)
+	if frame.ID != created.ID {
+		t.Errorf("unexpected frame id: got %q want %q", frame.ID, created.ID)
+	}
+
+	if frame.Event != "deploy" {
+		t.Errorf("unexpected frame event: got %q want %q", frame.Event, "deploy")
+	}
+
+	if event := sseFrameEvent(t, frame); event != created {
+		t.Errorf("unexpected event: got %+v want %+v", event, created)
+	}
+}
+
+func TestSSEStreamResumesFromLastEventID(t *testing.T) {
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "1"})
+	second := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "2"})
+	third := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "3"})
+
+	stream := openSSEStream(t, ts, "/api/v1/events/stream", first.ID)
+	fourth := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "4"})
+
+	for _, want := range []database.EventEntry{second, third, fourth} {
+		if frame := readSSEFrame(t, stream); frame.ID != want.ID {
+			t.Fatalf("unexpected frame id: got %q want %q", frame.ID, want.ID)
+		}
+	}
+}
+
+func TestSSEStreamSendsKeepalives(t *testing.T) {
+	t.Setenv("SSE_KEEPALIVE_INTERVAL", "50ms")
+	ts := newTestServer(t)
+	stream := openSSEStream(t, ts, "/api/v1/events/stream", "")
+
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(lines)
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			select {
+			case lines <- line:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before a keepalive was sent")
+			}
+			if strings.HasPrefix(line, ":") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a keepalive comment")
+		}
+	}
+}
+
+func TestSSEStreamEndsOnShutdown(t *testing.T) {
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+	stream := openSSEStream(t, ts, "/api/v1/events/stream", "")
+
+	done := shutdownAsync(srv)
+
+	ended := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, stream)
+		ended <- err
+	}()
+
+	select {
+	case err := <-ended:
+		if err != nil {
+			t.Fatalf("expected the stream to end cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to end when the server shuts down")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+}
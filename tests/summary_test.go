@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestEventSummaryWithoutEvents(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/summary", nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	// The timestamps are null rather than missing or empty strings.
+	for _, key := range []string{"earliest", "latest"} {
+		if value, ok := body[key]; !ok || value != nil {
+			t.Errorf("expected %s to be null, got %v", key, value)
+		}
+	}
+
+	if body["count"] != 0.0 || body["types"] != 0.0 {
+		t.Errorf("expected zero counts, got %v", body)
+	}
+}
+
+func TestEventSummary(t *testing.T) {
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", Timestamp: "2024-05-02T10:00:00Z"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2", Timestamp: "2024-05-01T09:00:00Z"})
+	postEvent(t, ts, database.EventEntry{Type: "alert", Data: "cpu", Timestamp: "2024-05-03T08:00:00Z"})
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/summary", nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var summary database.EventSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Earliest == nil || *summary.Earliest != "2024-05-01T09:00:00Z" {
+		t.Errorf("unexpected earliest timestamp: got %v", summary.Earliest)
+	}
+
+	if summary.Latest == nil || *summary.Latest != "2024-05-03T08:00:00Z" {
+		t.Errorf("unexpected latest timestamp: got %v", summary.Latest)
+	}
+
+	if summary.Count != 3 || summary.Types != 2 {
+		t.Errorf("unexpected counts: got %d events of %d types want 3 of 2", summary.Count, summary.Types)
+	}
+}
+
+func TestTopEventTypes(t *testing.T) {
+	ts := newTestServer(t)
+
+	recent := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	old := time.Now().Add(-10 * 24 * time.Hour).UTC().Format(time.RFC3339)
+
+	var events []database.EventEntry
+	for eventType, count := range map[database.EventType]int{"click": 5, "key": 3, "scroll": 1} {
+		for range count {
+			events = append(events, database.EventEntry{Type: eventType, Data: "x", Timestamp: recent})
+		}
+	}
+	for range 6 {
+		events = append(events, database.EventEntry{Type: "resize", Data: "x", Timestamp: old})
+	}
+
+	if resp := doRequest(t, ts, "POST", "/api/v1/events", events); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code creating events: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	for query, want := range map[string][]database.EventTypeCount{
+		"":               {{Type: "click", Count: 5}, {Type: "key", Count: 3}, {Type: "scroll", Count: 1}},
+		"?n=2&since=24h": {{Type: "click", Count: 5}, {Type: "key", Count: 3}},
+		"?since=30d":     {{Type: "resize", Count: 6}, {Type: "click", Count: 5}, {Type: "key", Count: 3}, {Type: "scroll", Count: 1}},
+		"?n=1&since=7d":  {{Type: "click", Count: 5}},
+		"?since=1h":      {},
+	} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events/top-types"+query, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%q: unexpected status code: got %v want %v", query, resp.StatusCode, http.StatusOK)
+		}
+
+		var counts []database.EventTypeCount
+		if err := json.NewDecoder(resp.Body).Decode(&counts); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if !reflect.DeepEqual(counts, want) {
+			t.Errorf("%q: unexpected top types: got %+v want %+v", query, counts, want)
+		}
+	}
+}
+
+func TestTopEventTypesRejectsBadQueries(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, query := range []string{"?n=101", "?n=0", "?n=ten", "?since=forever", "?since=-24h", "?since=0d"} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events/top-types"+query, nil)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: unexpected status code: got %v want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestEventRate(t *testing.T) {
+	ts := newTestServer(t)
+
+	now := time.Now().UTC()
+
+	// 3 events in the last minute, 2 more in the last 5 minutes, and 1 more
+	// in the last hour.
+	var events []database.EventEntry
+	for age, count := range map[time.Duration]int{20 * time.Second: 3, 2 * time.Minute: 2, 20 * time.Minute: 1} {
+		for range count {
+			events = append(events, database.EventEntry{Type: "seq", Data: "x", Timestamp: now.Add(-age).Format(time.RFC3339Nano)})
+		}
+	}
+
+	if resp := doRequest(t, ts, "POST", "/api/v1/events", events); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code creating events: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	getRate := func(query string) database.EventRate {
+		t.Helper()
+
+		var rate database.EventRate
+		decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/events/rate"+query, nil), http.StatusOK, &rate)
+
+		return rate
+	}
+
+	for query, want := range map[string]database.EventRate{
+		"?window=1m": {WindowSeconds: 60, TotalEvents: 3, EventsPerSecond: 0.05, EventsPerMinute: 3},
+		"":           {WindowSeconds: 300, TotalEvents: 5, EventsPerSecond: 5.0 / 300, EventsPerMinute: 1},
+		"?window=1h": {WindowSeconds: 3600, TotalEvents: 6, EventsPerSecond: 6.0 / 3600, EventsPerMinute: 0.1},
+	} {
+		if rate := getRate(query); rate != want {
+			t.Errorf("%q: unexpected rate: got %+v want %+v", query, rate, want)
+		}
+	}
+
+	// The rate is cached, so a new event isn't counted straight away.
+	postEvent(t, ts, database.EventEntry{Type: "seq", Data: "x"})
+
+	if rate := getRate("?window=1m"); rate.TotalEvents != 3 {
+		t.Errorf("expected the cached rate, got %+v", rate)
+	}
+}
+
+func TestEventRateRejectsBadWindows(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, window := range []string{"soon", "0s", "-5m"} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events/rate?window="+window, nil)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: unexpected status code: got %v want %v", window, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "50ms")
+	srv, ts := newTestHTTPServer(t, newTestDBURL(t))
+
+	srv.RegisterProcessor("slow", func(e database.EventEntry) (database.EventEntry, error) {
+		time.Sleep(150 * time.Millisecond)
+		return e, nil
+	})
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "slow", Data: "v1"})
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+
+	// Routes with their own timeout aren't affected.
+	if resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected health status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRequestTimeoutExemptsStreamingRoutes(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "50ms")
+	ts := newTestServer(t)
+
+	result := awaitPoll(t, pollEvents(t, ts, "?timeout=200ms"))
+	if result.events == nil || len(result.events) != 0 {
+		t.Fatalf("expected an empty array, got %+v", result.events)
+	}
+
+	if result.elapsed < 200*time.Millisecond {
+		t.Fatalf("expected the poll to outlast the request timeout, took %s", result.elapsed)
+	}
+}
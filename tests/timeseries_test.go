@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+func TestTimeSeriesCountsRecentEvents(t *testing.T) {
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "1"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "2"})
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/timeseries?bucket=day", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var buckets []database.TimeSeriesBucket
+	if err := json.NewDecoder(resp.Body).Decode(&buckets); err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	for _, bucket := range buckets {
+		total += bucket.Count
+	}
+
+	if total != 2 {
+		t.Fatalf("expected 2 events across %+v, got %d", buckets, total)
+	}
+}
+
+func TestTimeSeriesEmptyRange(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/timeseries?bucket=hour&from=2024-01-01T00:00:00Z&to=2024-01-02T00:00:00Z", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "[]" {
+		t.Fatalf("expected an empty array, got %s", body)
+	}
+}
+
+func TestTimeSeriesRejectsInvalidQueries(t *testing.T) {
+	ts := newTestServer(t)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tooLong := from.Add(91 * 24 * time.Hour)
+
+	for _, query := range []string{
+		"?bucket=week",
+		"?from=yesterday",
+		"?from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z",
+		"?bucket=minute&from=" + from.Format(time.RFC3339) + "&to=" + tooLong.Format(time.RFC3339),
+	} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events/timeseries"+query, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status code: got %v want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// Hour buckets aren't limited to 90 days.
+	resp := doRequest(t, ts, "GET", "/api/v1/events/timeseries?bucket=hour&from="+from.Format(time.RFC3339)+"&to="+tooLong.Format(time.RFC3339), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Fetches the event with the given ID, asking for its timestamp in the given
+// timezone with the X-Timezone header unless it's empty. Returns the status
+// code and the event.
+func getEventInTimezone(t *testing.T, ts *httptest.Server, id, timezone string) (int, database.EventEntry) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/event/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	if timezone != "" {
+		req.Header.Set("X-Timezone", timezone)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var event database.EventEntry
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode, event
+}
+
+func TestTimestampsWithoutOffsetUseDefaultTimezone(t *testing.T) {
+	t.Setenv("DEFAULT_TIMEZONE", "America/Chicago")
+	path := filepath.Join(t.TempDir(), "shion.db")
+	ts := newTestServerWithDB(t, "file:"+path)
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "local", Timestamp: "2024-01-15T09:30:00"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	var created server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Timezone != "America/Chicago" {
+		t.Errorf("expected the response to name the default timezone, got %q", created.Timezone)
+	}
+	if len(created.EventEntry) != 1 || created.EventEntry[0].Timestamp != "2024-01-15T15:30:00Z" {
+		t.Fatalf("expected the timestamp to be read as Chicago time, got %+v", created.EventEntry)
+	}
+
+	// Timestamps with an offset aren't affected.
+	offset := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "offset", Timestamp: "2024-01-15T09:30:00+01:00"})
+	if offset.Timestamp != "2024-01-15T08:30:00Z" {
+		t.Errorf("unexpected timestamp for an event with an offset: %q", offset.Timestamp)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var stored string
+	if err := db.QueryRow("SELECT Timestamp FROM Events WHERE ID = ?", created.EventEntry[0].ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "2024-01-15T15:30:00Z" {
+		t.Errorf("expected the timestamp to be stored in UTC, got %q", stored)
+	}
+
+	status, patched := patchEvent(t, ts, offset.ID, map[string]any{"timestamp": "2024-07-01T12:00:00.5"})
+	if status != http.StatusOK || patched.Timestamp != "2024-07-01T17:00:00.5Z" {
+		t.Errorf("expected a patched timestamp to be read as Chicago daylight time, got %v %q", status, patched.Timestamp)
+	}
+
+	if resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "bad", Timestamp: "15/01/2024 09:30"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code for an invalid timestamp: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestTimestampsReturnedInRequestedTimezone(t *testing.T) {
+	ts := newTestServer(t)
+
+	event := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", Timestamp: "2024-01-15T15:30:00Z"})
+
+	tests := []struct {
+		timezone   string
+		wantStatus int
+		want       string
+	}{
+		{"", http.StatusOK, "2024-01-15T15:30:00Z"},
+		{"Asia/Tokyo", http.StatusOK, "2024-01-16T00:30:00+09:00"},
+		{"America/New_York", http.StatusOK, "2024-01-15T10:30:00-05:00"},
+		{"Mars/Olympus_Mons", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		status, got := getEventInTimezone(t, ts, event.ID, tt.timezone)
+		if status != tt.wantStatus || got.Timestamp != tt.want {
+			t.Errorf("%q: got %v %q want %v %q", tt.timezone, status, got.Timestamp, tt.wantStatus, tt.want)
+		}
+	}
+}
+
+func TestReturnTimestampsIn(t *testing.T) {
+	t.Setenv("RETURN_TIMESTAMPS_IN", "Asia/Tokyo")
+	ts := newTestServer(t)
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", Timestamp: "2024-01-15T15:30:00Z"})
+	if created.Timestamp != "2024-01-16T00:30:00+09:00" {
+		t.Errorf("expected the created event's timestamp in Tokyo time, got %q", created.Timestamp)
+	}
+
+	events := getEvents(t, ts, "")
+	if len(events) != 1 || events[0].Timestamp != "2024-01-16T00:30:00+09:00" {
+		t.Errorf("expected the listed events' timestamps in Tokyo time, got %+v", events)
+	}
+
+	// The header takes precedence over the configured timezone.
+	if _, event := getEventInTimezone(t, ts, created.ID, "UTC"); event.Timestamp != "2024-01-15T15:30:00Z" {
+		t.Errorf("expected the timestamp in UTC, got %q", event.Timestamp)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Records the spans of the servers the test starts afterwards in memory. The
+// global tracer provider is reset when the test finishes.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		provider.Shutdown(context.Background())
+	})
+
+	return recorder
+}
+
+// Returns the value of the span's attribute with the given key, or an invalid
+// value if it doesn't have one.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+func TestTracingPostEvent(t *testing.T) {
+	recorder := recordSpans(t)
+	ts := newTestServer(t)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	body, err := json.Marshal(database.EventEntry{Type: "deploy", Data: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/event", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-ID", "trace-test")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	var request sdktrace.ReadOnlySpan
+	children := map[string]sdktrace.ReadOnlySpan{}
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == "POST /api/v1/event" {
+			request = span
+		} else {
+			children[span.Name()] = span
+		}
+	}
+
+	if request == nil {
+		t.Fatalf("expected a span for the request, got %d other spans", len(children))
+	}
+
+	// The request continues the client's trace.
+	if request.SpanContext().TraceID().String() != traceID || request.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the request's span to continue the client's trace, got trace %s with parent %s",
+			request.SpanContext().TraceID(), request.Parent().SpanID())
+	}
+
+	if request.SpanKind() != trace.SpanKindServer || spanAttribute(request, "request_id").AsString() != "trace-test" || spanAttribute(request, "http.response.status_code").AsInt64() != http.StatusCreated {
+		t.Errorf("unexpected request span: kind %s, attributes %v", request.SpanKind(), request.Attributes())
+	}
+
+	// Creating the event is a child of the request's span.
+	created, ok := children["db.create_event"]
+	if !ok {
+		t.Fatalf("expected a span for creating the event, got %v", children)
+	}
+
+	if created.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("expected creating the event to be a child of the request, got parent %s", created.Parent().SpanID())
+	}
+
+	if spanAttribute(created, "db.operation.name").AsString() != "create_event" || spanAttribute(created, "db.rows").AsInt64() != 1 {
+		t.Errorf("unexpected database span attributes: %v", created.Attributes())
+	}
+}
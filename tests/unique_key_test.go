@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Checks the response to a POST /event that matched an existing event by its
+// unique key has the given status code, and returns the event in it.
+func existingEventOf(t *testing.T, resp *http.Response, want int) database.EventEntry {
+	t.Helper()
+
+	if resp.StatusCode != want {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, want)
+	}
+
+	var body server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.EventEntry) != 1 {
+		t.Fatalf("expected the existing event in the response, got %+v", body)
+	}
+	if !strings.Contains(body.Message, "already exists") {
+		t.Errorf("unexpected message: %q", body.Message)
+	}
+
+	return body.EventEntry[0]
+}
+
+func TestUniqueKeyConflict(t *testing.T) {
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", UniqueKey: "deploy-42"})
+	if first.UniqueKey != "deploy-42" {
+		t.Fatalf("expected the unique key to be returned, got %q", first.UniqueKey)
+	}
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v2", UniqueKey: "deploy-42"})
+	existing := existingEventOf(t, resp, http.StatusConflict)
+	if existing.ID != first.ID || existing.Data != "v1" {
+		t.Fatalf("expected the first event to be returned, got %+v", existing)
+	}
+	if got, want := resp.Header.Get("Location"), "/api/v1/event/"+first.ID; got != want {
+		t.Errorf("unexpected Location header: got %q want %q", got, want)
+	}
+
+	events := getEvents(t, ts, "")
+	if len(events) != 1 || events[0].ID != first.ID {
+		t.Fatalf("expected only the first event to be stored, got %+v", events)
+	}
+}
+
+func TestUniqueKeyReturnsExisting(t *testing.T) {
+	t.Setenv("UNIQUE_KEY_RETURNS_EXISTING", "true")
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", UniqueKey: "deploy-42"})
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v2", UniqueKey: "deploy-42"})
+	existing := existingEventOf(t, resp, http.StatusOK)
+	if existing.ID != first.ID {
+		t.Fatalf("expected the first event to be returned, got %+v", existing)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 1 {
+		t.Fatalf("expected only the first event to be stored, got %d", len(events))
+	}
+}
+
+func TestUniqueKeyOptional(t *testing.T) {
+	ts := newTestServer(t)
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v3", UniqueKey: "a"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v4", UniqueKey: "b"})
+
+	if events := getEvents(t, ts, ""); len(events) != 4 {
+		t.Fatalf("expected every event to be stored, got %d", len(events))
+	}
+
+	resp := doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", UniqueKey: strings.Repeat("k", database.MaxUniqueKeyLength+1)})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code for a long unique key: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestUniqueKeyIsReadOnly(t *testing.T) {
+	ts := newTestServer(t)
+
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", UniqueKey: "deploy-42"})
+
+	resp := doRequest(t, ts, "PATCH", "/api/v1/event/"+created.ID, map[string]any{"unique_key": "deploy-43"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestDuplicateEventsAreNotPublishedAgain(t *testing.T) {
+	ts := newTestServer(t)
+	receiver, receipts := newWebhookReceiver(t, func(int) int { return http.StatusOK })
+	createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1", UniqueKey: "deploy-1"})
+	awaitReceipt(t, receipts)
+
+	// A retry of the same event is returned, marked as a duplicate, but not
+	// delivered again.
+	resp := doRequest(t, ts, "POST", "/api/v1/event", first)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code retrying an event: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	var retried server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&retried); err != nil {
+		t.Fatal(err)
+	}
+	if len(retried.EventEntry) != 1 || !retried.EventEntry[0].Duplicate {
+		t.Fatalf("expected the retried event to be marked as a duplicate, got %+v", retried)
+	}
+
+	resp = doRequest(t, ts, "POST", "/api/v1/events", []database.EventEntry{
+		{Type: "deploy", Data: "v1 again", UniqueKey: "deploy-1"},
+		{Type: "deploy", Data: "v2", UniqueKey: "deploy-2"},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code creating events: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var batch []server.EventResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || !batch[0].EventEntry[0].Duplicate || batch[1].EventEntry[0].Duplicate {
+		t.Fatalf("expected only the first event to be marked as a duplicate, got %+v", batch)
+	}
+	if batch[0].Message != "Event already exists." {
+		t.Errorf("unexpected message for the duplicate: %q", batch[0].Message)
+	}
+
+	if receipt := awaitReceipt(t, receipts); receipt.event.UniqueKey != "deploy-2" {
+		t.Fatalf("expected only the new event to be delivered, got %+v", receipt.event)
+	}
+
+	select {
+	case extra := <-receipts:
+		t.Fatalf("unexpected extra delivery: %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+)
+
+// Sends a POST request to the given /admin/vacuum path with the given
+// credentials, returning the response.
+func vacuum(t *testing.T, url, path, username, password string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestVacuumRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	if resp := vacuum(t, ts.URL, "/api/v1/admin/vacuum", testUsername, testPassword); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a non-admin: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/admin/jobs/missing", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code for a non-admin's job lookup: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestVacuumReportsSizes(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	t.Setenv("ALLOW_PURGE", "true")
+	ts := newTestServer(t)
+
+	// Deleting a batch of large events leaves free pages behind.
+	events := make([]database.EventEntry, 50)
+	for i := range events {
+		events[i] = database.EventEntry{Type: "seq", Data: strconv.Itoa(i) + strings.Repeat("x", 4096)}
+	}
+	doRequest(t, ts, "POST", "/api/v1/events", events)
+	doRequest(t, ts, "DELETE", "/api/v1/events/all?confirm=yes-delete-all-events", nil)
+
+	resp := vacuum(t, ts.URL, "/api/v1/admin/vacuum?async=false", testAdminUsername, testAdminPassword)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var result database.VacuumResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Skipped || result.SizeBefore <= 0 || result.SizeAfter <= 0 {
+		t.Fatalf("expected the sizes to be reported, got %+v", result)
+	}
+
+	if result.SizeAfter >= result.SizeBefore {
+		t.Errorf("expected the database to shrink, got %+v", result)
+	}
+
+	// The database is still usable afterwards.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+}
+
+// Polls GET /admin/jobs/:id until the background job has finished, failing the
+// test if it takes longer than 5 seconds.
+func waitForBackgroundJob(t *testing.T, ts *httptest.Server, id string) database.BackgroundJob {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := doAdminRequest(t, ts, "GET", "/api/v1/admin/jobs/"+id)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code for the job: got %v want %v", resp.StatusCode, http.StatusOK)
+		}
+
+		var job database.BackgroundJob
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status != database.JobRunning {
+			return job
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for job %s, last seen %+v", id, job)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVacuumRunsInTheBackground(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	doRequest(t, ts, "POST", "/api/v1/events", seqEvents(10))
+
+	resp := vacuum(t, ts.URL, "/api/v1/admin/vacuum", testAdminUsername, testAdminPassword)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusAccepted)
+	}
+
+	var started struct {
+		Status string `json:"status"`
+		JobID  string `json:"job_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+
+	if started.Status != "vacuum started" || started.JobID == "" {
+		t.Fatalf("unexpected response: %+v", started)
+	}
+
+	if location := resp.Header.Get("Location"); location != "/api/v1/admin/jobs/"+started.JobID {
+		t.Errorf("unexpected location: %q", location)
+	}
+
+	job := waitForBackgroundJob(t, ts, started.JobID)
+	if job.Status != database.JobCompleted || job.Type != "vacuum" || job.FinishedAt == "" {
+		t.Fatalf("expected a completed vacuum job, got %+v", job)
+	}
+
+	var result database.VacuumResult
+	if err := json.Unmarshal(job.Result, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Skipped || result.SizeAfter <= 0 {
+		t.Fatalf("expected the job's result to report the sizes, got %s", job.Result)
+	}
+
+	// Another vacuum can be started once the first has finished.
+	resp = vacuum(t, ts.URL, "/api/v1/admin/vacuum", testAdminUsername, testAdminPassword)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code for the second vacuum: got %v want %v", resp.StatusCode, http.StatusAccepted)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	waitForBackgroundJob(t, ts, started.JobID)
+
+	// The Location points at the same version of the API.
+	resp = vacuum(t, ts.URL, "/api/v2/admin/vacuum", testAdminUsername, testAdminPassword)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code for the v2 vacuum: got %v want %v", resp.StatusCode, http.StatusAccepted)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	if location := resp.Header.Get("Location"); location != "/api/v2/admin/jobs/"+started.JobID {
+		t.Errorf("unexpected v2 location: %q", location)
+	}
+	waitForBackgroundJob(t, ts, started.JobID)
+}
+
+func TestBackgroundJobNotFound(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	if resp := doAdminRequest(t, ts, "GET", "/api/v1/admin/jobs/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
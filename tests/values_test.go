@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+func TestGetDistinctValues(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, data := range []string{"v1", "v2", "v1", "v3", "v1", "v2"} {
+		postEvent(t, ts, database.EventEntry{Type: "deploy", Data: data})
+	}
+	postEvent(t, ts, database.EventEntry{Type: "alert", Data: "v3"})
+
+	resp := doRequest(t, ts, "GET", "/api/v1/events/values?type=deploy&field=data", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var body server.DistinctValuesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	want := server.DistinctValuesResponse{
+		Type:   "deploy",
+		Field:  "data",
+		Values: []database.DistinctValue{{Value: "v1", Count: 3}, {Value: "v2", Count: 2}, {Value: "v3", Count: 1}},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Fatalf("unexpected values: got %+v want %+v", body, want)
+	}
+
+	// Capping the values says they were truncated.
+	resp = doRequest(t, ts, "GET", "/api/v1/events/values?type=deploy&max=1", nil)
+
+	body = server.DistinctValuesResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Values) != 1 || body.Values[0].Value != "v1" || !body.Truncated {
+		t.Fatalf("expected only the most common value and truncated, got %+v", body)
+	}
+}
+
+func TestGetDistinctValuesRejectsBadQueries(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, query := range []string{"", "?field=data", "?type=deploy&field=timestamp", "?type=deploy&max=0"} {
+		resp := doRequest(t, ts, "GET", "/api/v1/events/values"+query, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: unexpected status code: got %v want %v", query, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
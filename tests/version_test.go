@@ -0,0 +1,290 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// Sends an authenticated request to the test server with the given Accept
+// header, failing the test if the request cannot be sent.
+func doVersionedRequest(t *testing.T, ts *httptest.Server, method, path, accept string, body any) *http.Response {
+	t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, &reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(testUsername, testPassword)
+	req.Header.Set("Content-Type", "application/json")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestGetEventsV2IncludesTotalAndPagination(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, data := range []string{"1", "2", "3"} {
+		postEvent(t, ts, database.EventEntry{Type: "deploy", Data: data})
+	}
+
+	for name, req := range map[string]struct{ path, accept string }{
+		"path":   {"/api/v2/events?max=2", ""},
+		"accept": {"/api/v1/events?max=2", "application/vnd.shion.v2+json"},
+	} {
+		resp := doVersionedRequest(t, ts, "GET", req.path, req.accept, nil)
+
+		var body server.EventResponseV2
+		decodeStrict(t, resp, http.StatusOK, &body)
+
+		if body.Total != 3 || len(body.EventEntry) != 2 || body.EventEntry[0].Data != "3" {
+			t.Fatalf("%s: unexpected response: %+v", name, body)
+		}
+
+		if body.Pagination == nil || *body.Pagination != (server.Pagination{Max: 2, Returned: 2, HasMore: true}) {
+			t.Fatalf("%s: unexpected pagination: %+v", name, body.Pagination)
+		}
+	}
+
+	// Turning the envelope off still returns a plain array.
+	resp := doVersionedRequest(t, ts, "GET", "/api/v2/events?envelope=false", "", nil)
+
+	var events []database.EventEntry
+	decodeStrict(t, resp, http.StatusOK, &events)
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %+v", events)
+	}
+}
+
+func TestGetEventsDefaultsToV1(t *testing.T) {
+	ts := newTestServer(t)
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for _, accept := range []string{
+		"",
+		"application/json",
+		"application/vnd.shion.v1+json",
+		"application/vnd.shion.v9+json",
+		"application/vnd.shion.latest+json",
+		"not a media type",
+	} {
+		resp := doVersionedRequest(t, ts, "GET", "/api/v1/events", accept, nil)
+
+		var events []database.EventEntry
+		decodeStrict(t, resp, http.StatusOK, &events)
+
+		if len(events) != 1 || events[0].Data != "v1" {
+			t.Fatalf("%q: unexpected events: %+v", accept, events)
+		}
+	}
+}
+
+func TestPostEventV2(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doVersionedRequest(t, ts, "POST", "/api/v2/event", "", database.EventEntry{Type: "deploy", Data: "v2"})
+
+	var body server.EventResponseV2
+	decodeStrict(t, resp, http.StatusCreated, &body)
+
+	if body.Total != 1 || len(body.EventEntry) != 1 || body.EventEntry[0].Data != "v2" || body.Pagination != nil {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+
+	if location := resp.Header.Get("Location"); location != "/api/v2/event/"+body.EventEntry[0].ID {
+		t.Fatalf("unexpected Location header: %q", location)
+	}
+
+	// v1 keeps the original envelope, even when asked for explicitly.
+	resp = doVersionedRequest(t, ts, "POST", "/api/v1/event", "application/vnd.shion.v1+json", database.EventEntry{Type: "deploy", Data: "v1"})
+
+	var v1 server.EventResponse
+	decodeStrict(t, resp, http.StatusCreated, &v1)
+
+	if len(v1.EventEntry) != 1 || v1.EventEntry[0].Data != "v1" {
+		t.Fatalf("unexpected v1 response: %+v", v1)
+	}
+}
+
+func TestPostEventsV2(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := doVersionedRequest(t, ts, "POST", "/api/v1/events", "application/json, application/vnd.shion.v2+json; q=0.9", seqEvents(3))
+
+	var body server.EventResponseV2
+	decodeStrict(t, resp, http.StatusOK, &body)
+
+	if body.Total != 3 || len(body.EventEntry) != 3 {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+
+	resp = doVersionedRequest(t, ts, "POST", "/api/v1/events", "", seqEvents(2))
+
+	var v1 []server.EventResponse
+	decodeStrict(t, resp, http.StatusOK, &v1)
+
+	if len(v1) != 2 {
+		t.Fatalf("unexpected v1 response: %+v", v1)
+	}
+}
+
+// Returns the sorted keys of a JSON object.
+func jsonKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// Pins the shapes of v1 responses, which existing clients depend on, so
+// changes made for v2 can't leak into them.
+func TestV1ResponseShapes(t *testing.T) {
+	ts := newTestServer(t)
+
+	eventKeys := []string{"data", "id", "priority", "timestamp", "type"}
+
+	var created map[string]any
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/event", database.EventEntry{Type: "deploy", Data: "v1"}), http.StatusCreated, &created)
+
+	if keys := jsonKeys(created); !slices.Equal(keys, []string{"event_entry", "message", "timezone"}) {
+		t.Errorf("unexpected POST /event keys: %v", keys)
+	}
+	entries, _ := created["event_entry"].([]any)
+	if len(entries) != 1 || !slices.Equal(jsonKeys(entries[0].(map[string]any)), eventKeys) {
+		t.Errorf("unexpected POST /event entries: %v", created["event_entry"])
+	}
+	id := entries[0].(map[string]any)["id"].(string)
+
+	var batch []map[string]any
+	decodeStrict(t, doRequest(t, ts, "POST", "/api/v1/events", seqEvents(2)), http.StatusOK, &batch)
+
+	if len(batch) != 2 || !slices.Equal(jsonKeys(batch[0]), []string{"event_entry", "message", "timezone"}) {
+		t.Errorf("unexpected POST /events response: %v", batch)
+	}
+
+	var events []map[string]any
+	decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/events", nil), http.StatusOK, &events)
+
+	if len(events) != 3 || !slices.Equal(jsonKeys(events[0]), eventKeys) {
+		t.Errorf("unexpected GET /events response: %v", events)
+	}
+
+	var event map[string]any
+	decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/event/"+id, nil), http.StatusOK, &event)
+
+	if !slices.Equal(jsonKeys(event), eventKeys) {
+		t.Errorf("unexpected GET /event/:id response: %v", event)
+	}
+
+	// Errors have the message as a top-level string.
+	var notFound map[string]any
+	decodeStrict(t, doRequest(t, ts, "GET", "/api/v1/event/missing", nil), http.StatusNotFound, &notFound)
+
+	if _, ok := notFound["error"].(string); !ok || !slices.Equal(jsonKeys(notFound), []string{"error", "request_id"}) {
+		t.Errorf("unexpected v1 error response: %v", notFound)
+	}
+}
+
+func TestV2ErrorEnvelope(t *testing.T) {
+	ts := newTestServer(t)
+
+	for name, req := range map[string]struct{ path, accept string }{
+		"path":   {"/api/v2/event/missing", ""},
+		"accept": {"/api/v1/event/missing", "application/vnd.shion.v2+json"},
+	} {
+		var body map[string]any
+		decodeStrict(t, doVersionedRequest(t, ts, "GET", req.path, req.accept, nil), http.StatusNotFound, &body)
+
+		detail, ok := body["error"].(map[string]any)
+		if !ok || !slices.Equal(jsonKeys(body), []string{"error"}) || !slices.Equal(jsonKeys(detail), []string{"message", "request_id"}) {
+			t.Errorf("%s: unexpected v2 error response: %v", name, body)
+		}
+	}
+}
+
+func TestV1DeprecationHeaders(t *testing.T) {
+	t.Setenv("API_V1_SUNSET", "2027-01-01")
+	ts := newTestServer(t)
+
+	resp := doVersionedRequest(t, ts, "GET", "/api/v1/events", "", nil)
+
+	for header, want := range map[string]string{
+		"Deprecation": "true",
+		"Sunset":      "Fri, 01 Jan 2027 00:00:00 GMT",
+		"Link":        `</api/v2>; rel="successor-version"`,
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("unexpected %s header: got %q want %q", header, got, want)
+		}
+	}
+
+	if warning := resp.Header.Get("Warning"); !strings.HasPrefix(warning, "299 ") {
+		t.Errorf("expected a deprecation warning, got %q", warning)
+	}
+
+	// v2 isn't deprecated, however it's asked for.
+	for _, resp := range []*http.Response{
+		doVersionedRequest(t, ts, "GET", "/api/v2/events", "", nil),
+		doVersionedRequest(t, ts, "GET", "/api/v1/events", "application/vnd.shion.v2+json", nil),
+	} {
+		for _, header := range []string{"Deprecation", "Sunset", "Warning"} {
+			if got := resp.Header.Get(header); got != "" {
+				t.Errorf("%s: unexpected %s header on a v2 response: %q", resp.Request.URL.Path, header, got)
+			}
+		}
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	t.Setenv("API_V1_SUNSET", "2027-01-01")
+	ts := newTestServer(t)
+
+	// No credentials are needed.
+	resp, err := http.Get(ts.URL + "/api/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body server.APIVersionsResponse
+	decodeStrict(t, resp, http.StatusOK, &body)
+
+	want := server.APIVersionsResponse{
+		Current: "v2",
+		Versions: []server.APIVersionInfo{
+			{Version: "v1", Status: "deprecated", BasePath: "/api/v1", Sunset: "Fri, 01 Jan 2027 00:00:00 GMT"},
+			{Version: "v2", Status: "current", BasePath: "/api/v2"},
+		},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Fatalf("unexpected versions: got %+v want %+v", body, want)
+	}
+}
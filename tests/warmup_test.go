@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+func TestWarmUpWaitsForDatabase(t *testing.T) {
+	// The database can't be opened until its directory exists, which stands in
+	// for a database server that's still starting.
+	dir := filepath.Join(t.TempDir(), "later")
+
+	t.Setenv("API_USERNAME", testUsername)
+	t.Setenv("API_PASSWORD", testPassword)
+	t.Setenv("TURSO_DATABASE_URL", "file:"+filepath.Join(dir, "shion.db"))
+	t.Setenv("DB_WARMUP_INTERVAL", "20ms")
+	t.Setenv("DB_WARMUP_MAX_INTERVAL", "50ms")
+
+	srv := server.NewServer(newTestConfig(t))
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/health/readiness", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected readiness status code: got %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/events", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code before warm-up: got %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/health/liveness", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected liveness status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-srv.WarmedUp():
+		if err != nil {
+			t.Fatalf("unexpected warm-up error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the warm-up")
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/health/readiness", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected readiness status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	// The tables are created once the database is reachable.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+}
+
+func TestWarmUpGivesUp(t *testing.T) {
+	t.Setenv("API_USERNAME", testUsername)
+	t.Setenv("API_PASSWORD", testPassword)
+	t.Setenv("TURSO_DATABASE_URL", "file:"+filepath.Join(t.TempDir(), "missing", "shion.db"))
+	t.Setenv("DB_WARMUP_ATTEMPTS", "3")
+	t.Setenv("DB_WARMUP_INTERVAL", "10ms")
+
+	srv := server.NewServer(newTestConfig(t))
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	select {
+	case err := <-srv.WarmedUp():
+		if err == nil {
+			t.Fatal("expected the warm-up to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the warm-up")
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/health/readiness", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected readiness status code: got %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestConnectWaitsForTheWarmUp(t *testing.T) {
+	t.Setenv("API_USERNAME", testUsername)
+	t.Setenv("API_PASSWORD", testPassword)
+	t.Setenv("TURSO_DATABASE_URL", newTestDBURL(t))
+
+	srv := server.NewServer(newTestConfig(t))
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	if err := srv.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %v", err)
+	}
+}
+
+func TestConnectFailsWhenTheDatabaseIsUnreachable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "shion.db")
+
+	t.Setenv("API_USERNAME", testUsername)
+	t.Setenv("API_PASSWORD", testPassword)
+	t.Setenv("TURSO_DATABASE_URL", "file:"+path)
+	t.Setenv("DB_WARMUP_ATTEMPTS", "3")
+	t.Setenv("DB_WARMUP_INTERVAL", "10ms")
+
+	srv := server.NewServer(newTestConfig(t))
+
+	err := srv.Connect()
+	if err == nil {
+		t.Fatal("expected connecting to fail")
+	}
+
+	if !strings.Contains(err.Error(), "sqlite database at "+path) || !strings.Contains(err.Error(), "after 3 attempt(s)") {
+		t.Errorf("expected the error to name the database and the attempts, got %q", err)
+	}
+}
@@ -0,0 +1,275 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/client"
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+)
+
+// The secret test webhook subscriptions sign their deliveries with.
+const testWebhookSecret = "webhook-secret"
+
+// A webhook delivery received by a test receiver.
+type webhookReceipt struct {
+	event   database.EventEntry
+	attempt string
+	valid   bool
+}
+
+// Starts an HTTP server that receives webhook deliveries, responding with the
+// status code returned by respond for each one, and sends every delivery it
+// receives on the returned channel.
+func newWebhookReceiver(t *testing.T, respond func(attempt int) int) (*httptest.Server, <-chan webhookReceipt) {
+	t.Helper()
+
+	receipts := make(chan webhookReceipt, 100)
+	var attempts atomic.Int64
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var event database.EventEntry
+		json.Unmarshal(body, &event)
+
+		receipts <- webhookReceipt{
+			event:   event,
+			attempt: r.Header.Get("X-Shion-Delivery-Attempt"),
+			valid:   client.VerifySignature(testWebhookSecret, r.Header.Get(client.TimestampHeader), r.Method, r.URL.RequestURI(), body, r.Header.Get(client.SignatureHeader)),
+		}
+
+		w.WriteHeader(respond(int(attempts.Add(1))))
+	}))
+	t.Cleanup(receiver.Close)
+
+	return receiver, receipts
+}
+
+// Creates a webhook subscription through the POST /webhooks endpoint, failing
+// the test if it isn't created.
+func createWebhook(t *testing.T, ts *httptest.Server, req server.WebhookRequest) database.Webhook {
+	t.Helper()
+
+	resp := doRequest(t, ts, "POST", "/api/v1/webhooks", req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code creating webhook: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+
+	var webhook database.Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	return webhook
+}
+
+// Fetches a webhook subscription through the GET /webhooks/:id endpoint.
+func getWebhook(t *testing.T, ts *httptest.Server, id string) database.Webhook {
+	t.Helper()
+
+	resp := doRequest(t, ts, "GET", "/api/v1/webhooks/"+id, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code getting webhook: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	var webhook database.Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	return webhook
+}
+
+// Waits for the next webhook delivery, failing the test if none arrives within
+// a few seconds.
+func awaitReceipt(t *testing.T, receipts <-chan webhookReceipt) webhookReceipt {
+	t.Helper()
+
+	select {
+	case receipt := <-receipts:
+		return receipt
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a webhook delivery")
+	}
+
+	return webhookReceipt{}
+}
+
+// Polls the webhook subscription until done returns true, failing the test if
+// it doesn't within a few seconds.
+func awaitWebhook(t *testing.T, ts *httptest.Server, id string, done func(database.Webhook) bool) database.Webhook {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		webhook := getWebhook(t, ts, id)
+		if done(webhook) {
+			return webhook
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for webhook, last saw %+v", webhook)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookCRUD(t *testing.T) {
+	ts := newTestServer(t)
+
+	created := createWebhook(t, ts, server.WebhookRequest{URL: "https://example.com/hook", Type: "deploy", Secret: testWebhookSecret})
+	if !created.Enabled || created.Type != "deploy" {
+		t.Fatalf("unexpected webhook: %+v", created)
+	}
+
+	resp := doRequest(t, ts, "GET", "/api/v1/webhooks/"+created.ID, nil)
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(body), testWebhookSecret) {
+		t.Fatalf("expected the secret to be left out of responses, got %s", body)
+	}
+
+	disabled := false
+	resp = doRequest(t, ts, "PUT", "/api/v1/webhooks/"+created.ID, server.WebhookRequest{URL: "https://example.com/other", Secret: "new-secret", Enabled: &disabled})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code updating webhook: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	if updated := getWebhook(t, ts, created.ID); updated.URL != "https://example.com/other" || updated.Type != "" || updated.Enabled {
+		t.Fatalf("unexpected updated webhook: %+v", updated)
+	}
+
+	resp = doRequest(t, ts, "GET", "/api/v1/webhooks", nil)
+	var webhooks []database.Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&webhooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != created.ID {
+		t.Fatalf("unexpected webhooks: %+v", webhooks)
+	}
+
+	if resp := doRequest(t, ts, "DELETE", "/api/v1/webhooks/"+created.ID, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status code deleting webhook: got %v want %v", resp.StatusCode, http.StatusNoContent)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/webhooks/"+created.ID, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code after delete: got %v want %v", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestWebhookRejectsInvalidSubscriptions(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, req := range []server.WebhookRequest{
+		{URL: "not a url", Secret: testWebhookSecret},
+		{URL: "ftp://example.com/hook", Secret: testWebhookSecret},
+		{URL: "https://example.com/hook"},
+	} {
+		if resp := doRequest(t, ts, "POST", "/api/v1/webhooks", req); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%+v: unexpected status code: got %v want %v", req, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestWebhookDeliversSignedEvents(t *testing.T) {
+	ts := newTestServer(t)
+	receiver, receipts := newWebhookReceiver(t, func(int) int { return http.StatusOK })
+
+	webhook := createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Type: "deploy", Secret: testWebhookSecret})
+
+	// Only events matching the type filter are delivered.
+	postEvent(t, ts, database.EventEntry{Type: "build", Data: "skipped"})
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	receipt := awaitReceipt(t, receipts)
+	if receipt.event != created {
+		t.Fatalf("unexpected event delivered: got %+v want %+v", receipt.event, created)
+	}
+
+	if !receipt.valid {
+		t.Fatal("expected the delivery to have a valid signature")
+	}
+
+	awaitWebhook(t, ts, webhook.ID, func(w database.Webhook) bool {
+		return w.LastStatus == database.WebhookDeliverySucceeded
+	})
+
+	select {
+	case extra := <-receipts:
+		t.Fatalf("unexpected extra delivery: %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookRetriesFailedDeliveries(t *testing.T) {
+	t.Setenv("WEBHOOK_INITIAL_BACKOFF", "10ms")
+	ts := newTestServer(t)
+
+	receiver, receipts := newWebhookReceiver(t, func(attempt int) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	webhook := createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	for _, want := range []string{"1", "2", "3"} {
+		receipt := awaitReceipt(t, receipts)
+		if receipt.attempt != want || receipt.event.ID != created.ID {
+			t.Fatalf("unexpected delivery: got attempt %s of %s, want attempt %s of %s", receipt.attempt, receipt.event.ID, want, created.ID)
+		}
+	}
+
+	awaitWebhook(t, ts, webhook.ID, func(w database.Webhook) bool {
+		return w.LastStatus == database.WebhookDeliverySucceeded && w.ConsecutiveFailures == 0
+	})
+}
+
+func TestWebhookDisabledAfterRepeatedFailures(t *testing.T) {
+	t.Setenv("WEBHOOK_INITIAL_BACKOFF", "10ms")
+	t.Setenv("WEBHOOK_MAX_FAILURES", "3")
+	ts := newTestServer(t)
+
+	receiver, _ := newWebhookReceiver(t, func(int) int { return http.StatusInternalServerError })
+
+	webhook := createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	disabled := awaitWebhook(t, ts, webhook.ID, func(w database.Webhook) bool { return !w.Enabled })
+
+	if disabled.ConsecutiveFailures != 3 || disabled.LastStatus != database.WebhookDeliveryFailed || !strings.Contains(disabled.LastError, "500") {
+		t.Fatalf("unexpected webhook status: %+v", disabled)
+	}
+}
+
+func TestWebhookDeliveryDoesNotDelayCreate(t *testing.T) {
+	ts := newTestServer(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(receiver.Close)
+
+	createWebhook(t, ts, server.WebhookRequest{URL: receiver.URL, Secret: testWebhookSecret})
+
+	start := time.Now()
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected creating events not to wait for the webhook, took %s", elapsed)
+	}
+}
@@ -0,0 +1,888 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4lch4/shion-api/internal/database"
+	"github.com/4lch4/shion-api/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// Mirrors the frames sent by the server over the /ws/events endpoint.
+type wsFrame struct {
+	Type    string                `json:"type"`
+	Action  string                `json:"action"`
+	Types   []database.EventType  `json:"types"`
+	MsgID   string                `json:"msg_id"`
+	ID      string                `json:"id"`
+	Event   *database.EventEntry  `json:"event"`
+	IDs     []string              `json:"ids"`
+	Error   string                `json:"error"`
+	Skipped int64                 `json:"skipped"`
+	Events  []database.EventEntry `json:"events"`
+
+	ValidationErrors []string `json:"validation_errors"`
+}
+
+func TestWSEventsFilteredByQueryTypes(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events?types=deploy,rollback")
+
+	var ack wsFrame
+	readWSFrame(t, conn, &ack)
+	if ack.Type != "ack" || !slices.Equal(ack.Types, []database.EventType{"deploy", "rollback"}) {
+		t.Fatalf("unexpected ack frame: %+v", ack)
+	}
+
+	postEvent(t, ts, database.EventEntry{Type: "heartbeat", Data: "ignored"})
+	deploy := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1.2.3"})
+
+	var frame wsFrame
+	readWSFrame(t, conn, &frame)
+	if frame.Type != "event" || frame.Event == nil || frame.Event.ID != deploy.ID {
+		t.Fatalf("expected the deploy event, got %+v", frame)
+	}
+}
+
+func TestWSRolledBackEventsAreNotBroadcast(t *testing.T) {
+	t.Setenv("DB_BATCH_CHUNK_SIZE", "2")
+	dbURL := newTestDBURL(t)
+	ts := newTestServerWithDB(t, dbURL)
+
+	// Make inserting an event with the data "boom" fail, which rolls back the
+	// whole batch it's in.
+	db, err := sql.Open("sqlite3", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TRIGGER fail_insert BEFORE INSERT ON Events WHEN NEW.Data = 'boom' BEGIN
+		SELECT RAISE(ABORT, 'boom');
+	END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+
+	var ack wsFrame
+	readWSFrame(t, conn, &ack)
+
+	events := []database.EventEntry{{Type: "seq", Data: "1"}, {Type: "seq", Data: "2"}, {Type: "seq", Data: "boom"}}
+	if resp := doRequest(t, ts, "POST", "/api/v1/events", events); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	// Events are delivered in order, so the next frame being this event proves
+	// none of the rolled back ones were sent.
+	committed := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+
+	var frame wsFrame
+	readWSFrame(t, conn, &frame)
+	if frame.Event == nil || frame.Event.ID != committed.ID {
+		t.Fatalf("expected only the committed event, got %+v", frame)
+	}
+}
+
+func TestWSSubscribeAndUnsubscribe(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+
+	var ack wsFrame
+	readWSFrame(t, conn, &ack)
+	if ack.Type != "ack" || len(ack.Types) != 0 {
+		t.Fatalf("expected an ack with no filter, got %+v", ack)
+	}
+
+	conn.WriteJSON(map[string]any{"action": "subscribe", "types": []string{"alert"}})
+	readWSFrame(t, conn, &ack)
+	if ack.Action != "subscribe" || !slices.Equal(ack.Types, []database.EventType{"alert"}) {
+		t.Fatalf("unexpected subscribe ack: %+v", ack)
+	}
+
+	// Events are delivered in order, so receiving the alert proves the deploy
+	// event before it was filtered out.
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "filtered"})
+	alert := postEvent(t, ts, database.EventEntry{Type: "alert", Data: "delivered"})
+
+	var frame wsFrame
+	readWSFrame(t, conn, &frame)
+	if frame.Event == nil || frame.Event.ID != alert.ID {
+		t.Fatalf("expected the alert event, got %+v", frame)
+	}
+
+	conn.WriteJSON(map[string]any{"action": "unsubscribe"})
+	ack = wsFrame{}
+	readWSFrame(t, conn, &ack)
+	if ack.Action != "unsubscribe" || len(ack.Types) != 0 {
+		t.Fatalf("unexpected unsubscribe ack: %+v", ack)
+	}
+
+	deploy := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "delivered"})
+
+	frame = wsFrame{}
+	readWSFrame(t, conn, &frame)
+	if frame.Event == nil || frame.Event.ID != deploy.ID {
+		t.Fatalf("expected the deploy event once the filter was cleared, got %+v", frame)
+	}
+}
+
+func TestWSUnknownActionReturnsError(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+
+	var frame wsFrame
+	readWSFrame(t, conn, &frame)
+
+	conn.WriteJSON(map[string]any{"action": "explode"})
+	readWSFrame(t, conn, &frame)
+	if frame.Type != "error" {
+		t.Fatalf("expected an error frame, got %+v", frame)
+	}
+}
+
+// Reads frames from the connection until one of the given type arrives,
+// skipping any others (e.g. broadcast events interleaved with replies).
+func readWSFrameOfType(t *testing.T, conn *websocket.Conn, frameType string) wsFrame {
+	t.Helper()
+
+	for {
+		var frame wsFrame
+		readWSFrame(t, conn, &frame)
+		if frame.Type == frameType {
+			return frame
+		}
+	}
+}
+
+func TestWSPublishEvents(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events?types=none")
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{
+		"action": "publish",
+		"msg_id": "single",
+		"events": map[string]any{"type": "key-down", "data": "a"},
+	})
+	ack := readWSFrameOfType(t, conn, "ack")
+	if ack.MsgID != "single" || len(ack.IDs) != 1 {
+		t.Fatalf("unexpected ack for a single event: %+v", ack)
+	}
+
+	conn.WriteJSON(map[string]any{
+		"action": "publish",
+		"msg_id": "batch",
+		"events": []map[string]any{
+			{"type": "key-down", "data": "b"},
+			{"type": "key-up", "data": "b"},
+		},
+	})
+	ack = readWSFrameOfType(t, conn, "ack")
+	if ack.MsgID != "batch" || len(ack.IDs) != 2 {
+		t.Fatalf("unexpected ack for a batch of events: %+v", ack)
+	}
+
+	if events := getEvents(t, ts, ""); len(events) != 3 {
+		t.Fatalf("expected 3 persisted events, got %d", len(events))
+	}
+}
+
+func TestWSPublishInvalidFramesKeepConnectionOpen(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events?types=none")
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteMessage(websocket.TextMessage, []byte("{not json"))
+	if frame := readWSFrameOfType(t, conn, "error"); frame.Error == "" {
+		t.Fatalf("expected an error message for a malformed frame, got %+v", frame)
+	}
+
+	conn.WriteJSON(map[string]any{
+		"action": "publish",
+		"msg_id": "missing-type",
+		"events": map[string]any{"data": "no type"},
+	})
+	if frame := readWSFrameOfType(t, conn, "error"); frame.MsgID != "missing-type" {
+		t.Fatalf("expected an error correlated with the invalid message, got %+v", frame)
+	}
+
+	conn.WriteJSON(map[string]any{
+		"action": "publish",
+		"msg_id": "valid",
+		"events": map[string]any{"type": "key-down", "data": "a"},
+	})
+	if ack := readWSFrameOfType(t, conn, "ack"); ack.MsgID != "valid" || len(ack.IDs) != 1 {
+		t.Fatalf("expected the connection to keep working after invalid frames, got %+v", ack)
+	}
+}
+
+func TestWSPublishBareEvent(t *testing.T) {
+	ts := newTestServer(t)
+	sender := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, sender, "ack")
+	other := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, other, "ack")
+
+	sender.WriteJSON(map[string]any{"type": "key-down", "data": "a"})
+
+	ack := readWSFrameOfType(t, sender, "ack")
+	if len(ack.IDs) != 1 {
+		t.Fatalf("unexpected ack for a bare event: %+v", ack)
+	}
+
+	// The event is broadcast to every client, the sender included.
+	for name, conn := range map[string]*websocket.Conn{"sender": sender, "other": other} {
+		frame := readWSFrameOfType(t, conn, "event")
+		if frame.Event == nil || frame.Event.ID != ack.IDs[0] || frame.Event.Data != "a" {
+			t.Fatalf("expected the %s to receive the published event, got %+v", name, frame)
+		}
+	}
+
+	events := getEvents(t, ts, "")
+	if len(events) != 1 || events[0].ID != ack.IDs[0] {
+		t.Fatalf("expected the published event to be persisted, got %+v", events)
+	}
+
+	// A bare event that fails validation is only reported to the sender.
+	sender.WriteJSON(map[string]any{"data": "no type"})
+	if frame := readWSFrameOfType(t, sender, "error"); frame.Error == "" {
+		t.Fatalf("expected an error message for an invalid event, got %+v", frame)
+	}
+}
+
+func TestWSMaxMessageBytes(t *testing.T) {
+	t.Setenv("WS_MAX_MESSAGE_BYTES", "256")
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{"type": "key-down", "data": "small"})
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{"type": "key-down", "data": strings.Repeat("x", 512)})
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Fatalf("expected a message-too-big close, got %v", err)
+			}
+			break
+		}
+	}
+
+	waitForWSConnections(t, ts, 0)
+
+	if events := getEvents(t, ts, ""); len(events) != 1 {
+		t.Fatalf("expected only the small event to be persisted, got %d", len(events))
+	}
+}
+
+// Mirrors the response of the /health/ws endpoint.
+type wsHealthStats struct {
+	Connections         int64 `json:"connections"`
+	MessagesSent        int64 `json:"messages_sent"`
+	DroppedEvents       int64 `json:"dropped_events"`
+	OverflowDisconnects int64 `json:"overflow_disconnects"`
+}
+
+// Returns the WebSocket stats reported by the server.
+func wsHealth(t *testing.T, ts *httptest.Server) wsHealthStats {
+	t.Helper()
+
+	var health wsHealthStats
+
+	resp := doRequest(t, ts, "GET", "/api/v1/health/ws", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+
+	return health
+}
+
+// Returns the number of open WebSocket connections reported by the server.
+func wsConnections(t *testing.T, ts *httptest.Server) int64 {
+	t.Helper()
+
+	return wsHealth(t, ts).Connections
+}
+
+// Polls the server until it reports the given number of open WebSocket
+// connections, failing the test if it doesn't within a few seconds.
+func waitForWSConnections(t *testing.T, ts *httptest.Server, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := wsConnections(t, ts)
+		if got == want {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected number of WebSocket connections: got %d want %d", got, want)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWSUnresponsiveClientIsDisconnected(t *testing.T) {
+	t.Setenv("WS_PING_INTERVAL", "50ms")
+	t.Setenv("WS_PONG_TIMEOUT", "200ms")
+	ts := newTestServer(t)
+
+	// A responsive client answers pings because it keeps reading.
+	responsive := dialWS(t, ts, "/api/v1/ws/events")
+	go func() {
+		for {
+			if _, _, err := responsive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// An unresponsive client never reads, so it never answers a ping, just like
+	// a peer that vanished behind a NAT without sending a close frame.
+	dialWS(t, ts, "/api/v1/ws/events")
+
+	waitForWSConnections(t, ts, 2)
+	waitForWSConnections(t, ts, 1)
+
+	// The responsive client outlives several pong timeouts.
+	time.Sleep(500 * time.Millisecond)
+	if got := wsConnections(t, ts); got != 1 {
+		t.Fatalf("expected the responsive client to stay connected, got %d connections", got)
+	}
+}
+
+func TestWSReconnectBackfillsMissedEvents(t *testing.T) {
+	ts := newTestServer(t)
+
+	var sent []database.EventEntry
+	publish := func() {
+		sent = append(sent, postEvent(t, ts, database.EventEntry{Type: "seq", Data: strconv.Itoa(len(sent))}))
+	}
+
+	var received []string
+	receive := func(conn *websocket.Conn) {
+		frame := readWSFrameOfType(t, conn, "event")
+		if frame.ID == "" || frame.ID != frame.Event.ID {
+			t.Fatalf("expected the frame to carry the event ID, got %+v", frame)
+		}
+		received = append(received, frame.ID)
+	}
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	for range 3 {
+		publish()
+		receive(conn)
+	}
+
+	// Drop the connection mid-stream and keep publishing while disconnected.
+	conn.Close()
+	for range 3 {
+		publish()
+	}
+
+	conn = dialWS(t, ts, "/api/v1/ws/events?last_event_id="+received[len(received)-1])
+	publish()
+	for range 4 {
+		receive(conn)
+	}
+
+	if len(received) != len(sent) {
+		t.Fatalf("expected %d events, got %d", len(sent), len(received))
+	}
+
+	for i, event := range sent {
+		if received[i] != event.ID {
+			t.Fatalf("event %d: got %q want %q", i, received[i], event.ID)
+		}
+	}
+
+	// A further live event must be the very next frame, proving nothing at the
+	// replay boundary was delivered twice.
+	publish()
+	receive(conn)
+	if received[len(received)-1] != sent[len(sent)-1].ID {
+		t.Fatal("expected no duplicate events after the replay")
+	}
+}
+
+// The number of events published while a slow client isn't reading, each large
+// enough that together they overflow the TCP buffers between the server and
+// the client and back up into the client's send buffer.
+const slowClientEvents = 128
+
+// Connects a client that doesn't read anything while slowClientEvents large
+// events are published, failing the test if publishing ever blocks on the slow
+// client. Returns the connection and the published events.
+func floodSlowClient(t *testing.T, ts *httptest.Server) (*websocket.Conn, []database.EventEntry) {
+	t.Helper()
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	data := strings.Repeat("x", 256*1024)
+
+	var sent []database.EventEntry
+	for range slowClientEvents {
+		start := time.Now()
+		sent = append(sent, postEvent(t, ts, database.EventEntry{Type: "bulk", Data: data}))
+
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("publishing blocked on the slow client for %s", elapsed)
+		}
+	}
+
+	return conn, sent
+}
+
+func TestWSSlowClientDropsOldestWithGapFrame(t *testing.T) {
+	t.Setenv("WS_SEND_BUFFER_SIZE", "4")
+	t.Setenv("WS_WRITE_TIMEOUT", "30s")
+	ts := newTestServer(t)
+
+	conn, sent := floodSlowClient(t, ts)
+
+	// Every event is either delivered or accounted for by a gap frame, and the
+	// newest event is never the one dropped.
+	var delivered, skipped int64
+	for {
+		var frame wsFrame
+		readWSFrame(t, conn, &frame)
+
+		switch frame.Type {
+		case "gap":
+			if frame.Skipped <= 0 {
+				t.Fatalf("expected a positive skipped count, got %+v", frame)
+			}
+			skipped += frame.Skipped
+		case "event":
+			delivered++
+		}
+
+		if frame.Type == "event" && frame.ID == sent[len(sent)-1].ID {
+			break
+		}
+	}
+
+	if skipped == 0 {
+		t.Fatal("expected the slow client to skip some events")
+	}
+
+	if delivered+skipped != slowClientEvents {
+		t.Fatalf("expected %d events to be delivered or skipped, got %d delivered and %d skipped", slowClientEvents, delivered, skipped)
+	}
+
+	if health := wsHealth(t, ts); health.DroppedEvents != skipped || health.Connections != 1 {
+		t.Fatalf("unexpected WebSocket stats: %+v", health)
+	}
+}
+
+func TestWSSlowClientDisconnectedOnOverflow(t *testing.T) {
+	t.Setenv("WS_SEND_BUFFER_SIZE", "4")
+	t.Setenv("WS_WRITE_TIMEOUT", "30s")
+	t.Setenv("WS_OVERFLOW_POLICY", "disconnect")
+	ts := newTestServer(t)
+
+	conn, _ := floodSlowClient(t, ts)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, 4001) {
+				t.Fatalf("expected close code 4001, got %v", err)
+			}
+			break
+		}
+	}
+
+	waitForWSConnections(t, ts, 0)
+
+	if health := wsHealth(t, ts); health.OverflowDisconnects != 1 || health.DroppedEvents == 0 {
+		t.Fatalf("unexpected WebSocket stats: %+v", health)
+	}
+}
+
+func TestWSSlowClientDoesNotHoldUpFastClients(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+
+	// The buffer fits every event the fast client is sent, so it never
+	// overflows however far behind it falls, e.g. under the race detector.
+	t.Setenv("WS_SEND_BUFFER_SIZE", strconv.Itoa(slowClientEvents))
+	t.Setenv("WS_WRITE_TIMEOUT", "30s")
+	ts := newTestServer(t)
+
+	// The fast client only subscribes to the small events, and reads every
+	// one as soon as it arrives.
+	fast := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, fast, "ack")
+	fast.WriteJSON(map[string]any{"action": "subscribe", "types": []string{"small"}})
+	readWSFrameOfType(t, fast, "ack")
+
+	// Publishing takes a while under the race detector, so the reader mustn't
+	// time out before it's done.
+	fast.SetReadDeadline(time.Time{})
+
+	received := make(chan wsFrame, slowClientEvents)
+	go func() {
+		for {
+			var frame wsFrame
+			if err := fast.ReadJSON(&frame); err != nil {
+				return
+			}
+
+			if frame.Type == "event" || frame.Type == "gap" {
+				received <- frame
+			}
+		}
+	}()
+
+	// The slow client gets the large events as well, which back up into its
+	// buffer and overflow it.
+	slow := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, slow, "ack")
+
+	large := strings.Repeat("x", 256*1024)
+
+	var sent []database.EventEntry
+	for range slowClientEvents {
+		start := time.Now()
+		postEvent(t, ts, database.EventEntry{Type: "bulk", Data: large})
+		sent = append(sent, postEvent(t, ts, database.EventEntry{Type: "small", Data: "x"}))
+
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("publishing blocked on the slow client for %s", elapsed)
+		}
+	}
+
+	for i, event := range sent {
+		select {
+		case frame := <-received:
+			if frame.Type != "event" || frame.ID != event.ID {
+				t.Fatalf("unexpected frame %d for the fast client: got %+v want event %q", i, frame, event.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the fast client only received %d of %d events", i, len(sent))
+		}
+	}
+
+	for _, connection := range listWSConnections(t, ts).Connections {
+		switch connection.RemoteAddr {
+		case fast.LocalAddr().String():
+			if connection.MessagesDropped != 0 {
+				t.Errorf("expected the fast client to miss nothing, got %+v", connection)
+			}
+		case slow.LocalAddr().String():
+			if connection.MessagesDropped == 0 {
+				t.Errorf("expected the slow client to miss some events, got %+v", connection)
+			}
+		}
+	}
+}
+
+// Dials the WebSocket endpoint expecting the connection to be refused with the
+// given status code and a JSON error body.
+func expectWSRefused(t *testing.T, ts *httptest.Server, wantStatus int) {
+	t.Helper()
+
+	_, resp, err := tryDialWS(t, ts, "/api/v1/ws/events")
+	if err == nil || resp == nil {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("unexpected status code: got %v want %v", resp.StatusCode, wantStatus)
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		t.Fatalf("expected a JSON error body, got %+v, %v", body, err)
+	}
+}
+
+func TestWSMaxConnections(t *testing.T) {
+	t.Setenv("WS_MAX_CONNECTIONS", "2")
+	ts := newTestServer(t)
+
+	first := dialWS(t, ts, "/api/v1/ws/events")
+	dialWS(t, ts, "/api/v1/ws/events")
+
+	expectWSRefused(t, ts, http.StatusServiceUnavailable)
+
+	// Closing a connection frees its slot.
+	first.Close()
+	waitForWSConnections(t, ts, 1)
+	dialWS(t, ts, "/api/v1/ws/events")
+}
+
+func TestWSMaxConnectionsPerIP(t *testing.T) {
+	t.Setenv("WS_MAX_CONNECTIONS_PER_IP", "1")
+	ts := newTestServer(t)
+
+	dialWS(t, ts, "/api/v1/ws/events")
+
+	expectWSRefused(t, ts, http.StatusTooManyRequests)
+}
+
+func TestWSMaxLifetimeSendsGoingAway(t *testing.T) {
+	t.Setenv("WS_MAX_LIFETIME", "200ms")
+	ts := newTestServer(t)
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Fatalf("expected a going-away close, got %v", err)
+			}
+			break
+		}
+	}
+
+	waitForWSConnections(t, ts, 0)
+}
+
+func TestWSMessagesSentCounter(t *testing.T) {
+	ts := newTestServer(t)
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	readWSFrameOfType(t, conn, "event")
+
+	// The counter is bumped after each write returns, which may be just after
+	// the client has read the frame.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sent := wsHealth(t, ts).MessagesSent
+		if sent == 2 {
+			break
+		}
+
+		if sent > 2 || time.Now().After(deadline) {
+			t.Fatalf("unexpected number of messages sent: got %d want 2", sent)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWSQueryCommands(t *testing.T) {
+	ts := newTestServer(t)
+
+	first := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v1"})
+	postEvent(t, ts, database.EventEntry{Type: "alert", Data: "cpu"})
+	postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v2"})
+
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{"action": "get_latest", "msg_id": "latest", "max": 2})
+	result := readWSFrameOfType(t, conn, "result")
+	if result.MsgID != "latest" || result.Action != "get_latest" || len(result.Events) != 2 || result.Events[0].Data != "v2" || result.Events[1].Data != "cpu" {
+		t.Fatalf("unexpected get_latest result: %+v", result)
+	}
+
+	conn.WriteJSON(map[string]any{"action": "get_latest", "msg_id": "deploys", "types": []string{"deploy"}})
+	result = readWSFrameOfType(t, conn, "result")
+	if result.MsgID != "deploys" || len(result.Events) != 2 || result.Events[0].Data != "v2" || result.Events[1].Data != "v1" {
+		t.Fatalf("unexpected get_latest result for a type: %+v", result)
+	}
+
+	conn.WriteJSON(map[string]any{"action": "get_event", "msg_id": "one", "id": first.ID})
+	result = readWSFrameOfType(t, conn, "result")
+	if result.MsgID != "one" || len(result.Events) != 1 || result.Events[0].ID != first.ID {
+		t.Fatalf("unexpected get_event result: %+v", result)
+	}
+
+	// The live stream carries on alongside the queries.
+	created := postEvent(t, ts, database.EventEntry{Type: "deploy", Data: "v3"})
+	if event := readWSFrameOfType(t, conn, "event"); event.ID != created.ID {
+		t.Fatalf("unexpected event frame: %+v", event)
+	}
+}
+
+func TestWSQueryCommandErrors(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialWS(t, ts, "/api/v1/ws/events")
+	readWSFrameOfType(t, conn, "ack")
+
+	for _, req := range []map[string]any{
+		{"action": "get_event", "msg_id": "missing", "id": "does-not-exist"},
+		{"action": "get_event", "msg_id": "no-id"},
+		{"action": "get_latest", "msg_id": "negative", "max": -1},
+		{"action": "get_latest", "msg_id": "two-types", "types": []string{"a", "b"}},
+	} {
+		conn.WriteJSON(req)
+
+		frame := readWSFrameOfType(t, conn, "error")
+		if frame.MsgID != req["msg_id"] || frame.Action != req["action"] || frame.Error == "" {
+			t.Fatalf("unexpected error frame for %v: %+v", req, frame)
+		}
+	}
+
+	// An empty result is still answered.
+	conn.WriteJSON(map[string]any{"action": "get_latest", "msg_id": "empty"})
+	if result := readWSFrameOfType(t, conn, "result"); result.MsgID != "empty" || len(result.Events) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+// Lists the open WebSocket connections through GET /admin/ws/connections.
+func listWSConnections(t *testing.T, ts *httptest.Server) server.WSConnectionsResponse {
+	t.Helper()
+
+	var body server.WSConnectionsResponse
+	decodeStrict(t, doAdminRequest(t, ts, "GET", "/api/v1/admin/ws/connections"), http.StatusOK, &body)
+
+	return body
+}
+
+func TestWSConnectionsEndpoint(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", testAdminUsername)
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	ts := newTestServer(t)
+
+	if body := listWSConnections(t, ts); len(body.Connections) != 0 || body.TotalConnections != 0 {
+		t.Fatalf("expected no connections yet, got %+v", body)
+	}
+
+	// Each client reads its initial ack so it's been sent exactly one frame.
+	all := dialWS(t, ts, "/api/v1/ws/events")
+	filtered := dialWS(t, ts, "/api/v1/ws/events?types=deploy,alert")
+	for _, conn := range []*websocket.Conn{all, filtered} {
+		var ack wsFrame
+		readWSFrame(t, conn, &ack)
+	}
+
+	body := listWSConnections(t, ts)
+	if len(body.Connections) != 2 || body.TotalConnections != 2 {
+		t.Fatalf("expected 2 connections, got %+v", body)
+	}
+
+	want := map[string]string{
+		all.LocalAddr().String():      "",
+		filtered.LocalAddr().String(): "alert,deploy",
+	}
+	for _, connection := range body.Connections {
+		filter, ok := want[connection.RemoteAddr]
+		if !ok {
+			t.Errorf("unexpected remote address %q, want one of %v", connection.RemoteAddr, want)
+			continue
+		}
+		delete(want, connection.RemoteAddr)
+
+		if connection.EventTypeFilter != filter || connection.MessagesSent != 1 || connection.ClientIP != "127.0.0.1" || connection.ConnectedAt.IsZero() {
+			t.Errorf("unexpected connection: %+v", connection)
+		}
+	}
+
+	// Closed connections are no longer listed but still count towards the
+	// total.
+	filtered.Close()
+	waitForWSConnections(t, ts, 1)
+
+	body = listWSConnections(t, ts)
+	if len(body.Connections) != 1 || body.Connections[0].RemoteAddr != all.LocalAddr().String() || body.TotalConnections != 2 {
+		t.Fatalf("expected only the open connection, got %+v", body)
+	}
+
+	if resp := doRequest(t, ts, "GET", "/api/v1/admin/ws/connections", nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code without admin credentials: got %v want %v", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+// Returns the JSON log lines in the output with the given message, skipping
+// any output that isn't JSON.
+func logLinesWithMessage(output, msg string) []map[string]any {
+	var lines []map[string]any
+	for _, text := range strings.Split(output, "\n") {
+		var line map[string]any
+		if err := json.Unmarshal([]byte(text), &line); err == nil && line["msg"] == msg {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func TestWSLifecycleIsLogged(t *testing.T) {
+	ts, output := newTestServerCapturingStdout(t)
+
+	header := http.Header{"X-Request-ID": {"ws-lifecycle"}}
+	req := &http.Request{Header: header}
+	req.SetBasicAuth(testUsername, testPassword)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/v1/ws/events?types=deploy", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{"action": "subscribe", "types": []string{"release"}})
+	readWSFrameOfType(t, conn, "ack")
+
+	conn.WriteJSON(map[string]any{"action": "rewind", "msg_id": "bad"})
+	readWSFrameOfType(t, conn, "error")
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	waitForWSConnections(t, ts, 0)
+
+	logs := output()
+
+	expectLine := func(msg string, want map[string]any) map[string]any {
+		t.Helper()
+
+		lines := logLinesWithMessage(logs, msg)
+		if len(lines) != 1 {
+			t.Fatalf("expected one %q log line, got %d in:\n%s", msg, len(lines), logs)
+		}
+
+		for key, value := range want {
+			if lines[0][key] != value {
+				t.Errorf("%q: unexpected %s: got %v want %v", msg, key, lines[0][key], value)
+			}
+		}
+
+		return lines[0]
+	}
+
+	correlation := map[string]any{"client_ip": "127.0.0.1", "request_id": "ws-lifecycle"}
+
+	connected := expectLine("WebSocket connected", correlation)
+	id, _ := connected["connection_id"].(string)
+	if id == "" {
+		t.Fatalf("expected the connection's ID to be logged, got %v", connected)
+	}
+	correlation["connection_id"] = id
+
+	expectLine("WebSocket subscription changed", correlation)
+	expectLine("WebSocket message rejected", map[string]any{"connection_id": id, "msg_id": "bad", "level": "WARN"})
+
+	disconnected := expectLine("WebSocket disconnected", correlation)
+	if disconnected["reason"] != "closed by client" || disconnected["close_code"] != float64(websocket.CloseNormalClosure) {
+		t.Errorf("unexpected reason for the disconnect: %v", disconnected)
+	}
+	if duration, ok := disconnected["duration"].(float64); !ok || duration <= 0 {
+		t.Errorf("expected the connection's duration to be logged, got %v", disconnected["duration"])
+	}
+}